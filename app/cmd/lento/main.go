@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"rag_app/internal/config"
+	"rag_app/internal/demo"
+	"rag_app/internal/gateway"
+	"rag_app/internal/ingest"
+	"rag_app/internal/logging"
+	"rag_app/internal/mockbackend"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 停止服务时等待进行中请求结束的最长时间
+const shutdownTimeout = 30 * time.Second
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	provider.SetUpstreamHeaders(cfg.UpstreamHeaders, cfg.UpstreamUserAgent)
+	err = logging.Configure(cfg.LogLevel, cfg.LogDebugSampleRate)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = provider.SetFaults(&provider.Faults{
+		Targets:     cfg.Fault.Targets,
+		ErrorRate:   cfg.Fault.ErrorRate,
+		ErrorStatus: cfg.Fault.ErrorStatus,
+		DelayRate:   cfg.Fault.DelayRate,
+		Delay:       cfg.Fault.Delay,
+	})
+	if err != nil {
+		log.Fatalf("FAULT_*: %v\n", err)
+	}
+
+	// 第一个参数是选项时视为 serve 命令的选项，如 lento --demo
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		serve(cfg, args)
+	case "migrate":
+		migrate(cfg, args)
+	case "topics":
+		topics(cfg, args)
+	case "index":
+		indexCommand(cfg, args)
+	case "bench":
+		benchCommand(cfg, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\nusage: lento [serve|migrate|topics|index|bench]\n", cmd)
+		os.Exit(2)
+	}
+}
+
+// 启动网关服务
+func serve(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	demoMode := fs.Bool("demo", false, "serve a generated synthetic corpus, using a stub backend unless LLM_BASE_URL/EMB_BASE_URL are set")
+	demoDocs := fs.Int("demo-docs", demo.MaxDocs(), "number of synthetic documents in demo mode")
+	fs.Parse(args)
+
+	if *demoMode {
+		stop := setupDemo(cfg, *demoDocs)
+		defer stop()
+	}
+	fmt.Println("config:", cfg)
+
+	// 配置了快照存储时，先下载其他实例发布的最新快照，未变化的文档无需重新向量化
+	snapshots, err := retrieval.OpenSnapshotStore(cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if snapshots != nil {
+		_, err = retrieval.PullSnapshots(context.Background(), snapshots, cfg.IndexSnapshot)
+		if err != nil {
+			fmt.Printf("pull snapshots from %s: %v\n", snapshots, err)
+		}
+	}
+
+	// 首次建立索引可能很慢，定期打印进度和剩余时间
+	tracker := retrieval.NewProgressTracker("index")
+	ctx := retrieval.WithProgress(context.Background(), func(event retrieval.ProgressEvent) {
+		tracker.Observe(event)
+	})
+	// 快速启动时只用快照中的向量建立索引，缺少的文档由网关在后台补齐
+	if cfg.IndexWarmStart {
+		ctx = retrieval.WithSnapshotOnly(ctx)
+	}
+	pipeline, err := retrieval.NewFromConfig(ctx, cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	server, err := gateway.New(cfg, pipeline)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// 收到 SIGINT 或 SIGTERM 时停止接收新请求，等待进行中的请求结束，写入尚未保存的用量后退出
+	httpServer := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: server.Router()}
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln(err)
+		}
+	}()
+	<-stopped.Done()
+	stop()
+
+	logging.Infof("shutting down\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = httpServer.Shutdown(shutdownCtx)
+	if err != nil {
+		logging.Warnf("shutdown: %v\n", err)
+	}
+	err = server.Close()
+	if err != nil {
+		logging.Errorf("flush accounting: %v\n", err)
+	}
+}
+
+// 演示模式：在临时目录生成合成语料，未配置后端地址时启动内置的模拟后端，
+// 无需事先准备 summary.txt 和 markdown 文件即可体验完整流水线。返回清理函数
+func setupDemo(cfg *config.Config, n int) func() {
+	dir, err := os.MkdirTemp("", "lento-demo-")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cfg.SummaryFile, cfg.MarkdownDir, err = demo.WriteCorpus(dir, n)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cfg.IndexSnapshot = ""
+	fmt.Printf("demo: synthetic corpus written to %s\n", dir)
+
+	stop := func() { os.RemoveAll(dir) }
+	_, llmSet := os.LookupEnv("LLM_BASE_URL")
+	_, embSet := os.LookupEnv("EMB_BASE_URL")
+	if !llmSet || !embSet {
+		backend := mockbackend.New()
+		if !llmSet {
+			cfg.LlmBaseUrl = backend.BaseUrl()
+		}
+		if !embSet {
+			cfg.EmbBaseUrl = backend.BaseUrl()
+		}
+		fmt.Printf("demo: using stub backend at %s\n", backend.BaseUrl())
+		stop = func() {
+			backend.Close()
+			os.RemoveAll(dir)
+		}
+	}
+	fmt.Printf("demo: try curl -N http://localhost:%d/v1/chat/completions -H 'Content-Type: application/json' -d '{\"model\":\"%s\",\"messages\":[{\"role\":\"user\",\"content\":\"年假有几天？\"}]}'\n",
+		cfg.Port, cfg.ModelWithoutThinking)
+	return stop
+}
+
+// 将旧格式的 summary.txt/files.txt 转换为 JSONL 清单
+func migrate(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	out := fs.String("o", "summary.jsonl", "output file, - for stdout")
+	fs.Parse(args)
+
+	w := os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err := ingest.Migrate(cfg.MarkdownDir, cfg.SummaryFile, w)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// 离线聚类文档并输出主题地图
+func topics(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("topics", flag.ExitOnError)
+	k := fs.Int("k", 0, "number of clusters, 0 to choose by document count")
+	fs.Parse(args)
+
+	pipeline, err := retrieval.NewFromConfig(context.Background(), cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	topics, err := pipeline.BuildTopicMap(context.Background(), *k)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(topics)
+}
+
+// 索引维护命令
+func indexCommand(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: lento index check [-strict]")
+		os.Exit(2)
+	}
+	indexCheck(cfg, args[1:])
+}
+
+// 检查文档清单和索引快照的一致性，以 JSON 输出报告，发现问题时以非零状态退出，用于部署前的检查
+func indexCheck(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("index check", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "also fail on warnings, such as a stale snapshot that will be rebuilt at startup")
+	fs.Parse(args)
+
+	report, err := retrieval.CheckIndex(cfg, *strict)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if !report.OK {
+		os.Exit(1)
+	}
+}
+
+// 基准测试命令
+func benchCommand(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "retrieval" {
+		fmt.Fprintln(os.Stderr, "usage: lento bench retrieval [-queries N] [-c N] [-top LIST] [-nlist N] [-nprobe LIST] [-noise F]")
+		os.Exit(2)
+	}
+	benchRetrieval(cfg, args[1:])
+}
+
+// 以合成查询测试已加载索引的精确检索和 IVF 近似检索在不同召回数量下的吞吐量和延迟，以 JSON 输出报告，用于上线前的容量规划
+func benchRetrieval(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("bench retrieval", flag.ExitOnError)
+	queries := fs.Int("queries", 1000, "number of synthetic queries per run")
+	concurrency := fs.Int("c", 4, "number of concurrent searches")
+	top := fs.String("top", "5,10,20,50", "comma-separated TopEmb values")
+	nlist := fs.Int("nlist", 0, "IVF clusters, 0 for the square root of the document count")
+	nprobe := fs.String("nprobe", "1,4,16", "comma-separated IVF clusters scanned per query")
+	noise := fs.Float64("noise", 0.3, "query noise relative to the document vector length")
+	fs.Parse(args)
+
+	opts := retrieval.BenchOptions{Queries: *queries, Concurrency: *concurrency, Lists: *nlist, Noise: *noise}
+	var err error
+	opts.TopEmb, err = parseInts(*top)
+	if err != nil {
+		log.Fatalf("-top: %v\n", err)
+	}
+	opts.Probes, err = parseInts(*nprobe)
+	if err != nil {
+		log.Fatalf("-nprobe: %v\n", err)
+	}
+
+	pipeline, err := retrieval.NewFromConfig(context.Background(), cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	report, err := pipeline.BenchmarkSearch(opts)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// 解析逗号分隔的正整数列表
+func parseInts(s string) ([]int, error) {
+	values := []int{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid value %q", field)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
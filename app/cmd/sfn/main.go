@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yomorun/yomo"
+	"github.com/yomorun/yomo/serverless"
+
+	"rag_app/internal/config"
+	"rag_app/internal/index"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+type Parameter struct {
+	Question string `json:"question" jsonschema:"description=用户提出的原始问题。如果是多轮回话，请分析上下文后给出最终的完整问题。"`
+}
+
+// JSON 结果格式下的参数，可通过 doc_ids 获取指定文档的完整内容
+type JSONParameter struct {
+	Question string `json:"question" jsonschema:"description=用户提出的原始问题。如果是多轮回话，请分析上下文后给出最终的完整问题。"`
+	DocIds   []int  `json:"doc_ids,omitempty" jsonschema:"description=需要获取完整内容的文档ID列表，来自此前检索结果中的id字段。提供时忽略question，直接返回这些文档的完整内容。"`
+}
+
+// JSON 结果格式下返回的文档
+type DocumentResult struct {
+	Id      int    `json:"id"`
+	Title   string `json:"title,omitempty"`
+	Summary string `json:"summary"`
+	URL     string `json:"url,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+var (
+	cfg       *config.Config
+	pipeline  *retrieval.Pipeline
+	blocklist *retrieval.Blocklist
+)
+
+func Description() string {
+	return fmt.Sprintf("当用户查询%s问题时调用此函数", cfg.Topic)
+}
+
+func InputSchema() any {
+	if cfg.SfnResultFormat == resultFormatJSON {
+		return &JSONParameter{}
+	}
+	return &Parameter{}
+}
+
+// 检索结果的返回格式
+const (
+	resultFormatText = "text"
+	resultFormatJSON = "json"
+)
+
+// 没有相关文档时返回给调用方大模型的结果，代替冗长而无关的检索内容，提示其根据自身知识回答
+func noRelevantResult() string {
+	return fmt.Sprintf("没有与%s相关的文档，请根据自己的知识回答。", cfg.Topic)
+}
+
+// 检索并判断是否有文档达到相关度阈值：没有检索到文档，或配置了 YOMO_SFN_MIN_SCORE
+// 且最高重排序分数低于该值时返回 false。重排序降级、没有分数时不做判断
+func retrieve(question string) (*retrieval.Result, bool, error) {
+	result, err := pipeline.Run(context.Background(), &retrieval.Request{Question: question, Blocklist: blocklist})
+	if errors.Is(err, retrieval.ErrNoRelevantDocs) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(result.Docs) == 0 {
+		return result, false, nil
+	}
+	if top, ok := result.TopRerankScore(); ok && cfg.SfnMinScore > 0 && float64(top) < cfg.SfnMinScore {
+		fmt.Printf("top rerank score %.3f is below YOMO_SFN_MIN_SCORE %g\n", top, cfg.SfnMinScore)
+		return result, false, nil
+	}
+	return result, true, nil
+}
+
+func Init() error {
+	p, err := retrieval.NewFromConfig(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	pipeline = p
+	blocklist, err = retrieval.LoadBlocklist(cfg.BlocklistFile)
+	return err
+}
+
+func Handler(ctx serverless.Context) {
+	if cfg.SfnResultFormat == resultFormatJSON {
+		jsonHandler(ctx)
+		return
+	}
+
+	var msg Parameter
+	err := ctx.ReadLLMArguments(&msg)
+	if err != nil {
+		fmt.Println("ReadLLMArguments error:", err)
+		return
+	}
+
+	result, relevant, err := retrieve(msg.Question)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if !relevant {
+		ctx.WriteLLMResult(noRelevantResult())
+		return
+	}
+
+	ctx.WriteLLMResult(result.Content)
+}
+
+// 以 JSON 数组返回检索到的文档摘要，由调用方的大模型决定是否再获取完整内容
+func jsonHandler(ctx serverless.Context) {
+	var msg JSONParameter
+	err := ctx.ReadLLMArguments(&msg)
+	if err != nil {
+		fmt.Println("ReadLLMArguments error:", err)
+		return
+	}
+
+	results := []DocumentResult{}
+	if len(msg.DocIds) > 0 {
+		results = documentContents(pipeline.Store(), blocklist, msg.DocIds, time.Now())
+	} else {
+		result, relevant, err := retrieve(msg.Question)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		if !relevant {
+			ctx.WriteLLMResult(noRelevantResult())
+			return
+		}
+		for _, doc := range result.Docs {
+			results = append(results, DocumentResult{
+				Id:      doc.DocId,
+				Title:   doc.Title,
+				Summary: doc.Summary,
+				URL:     doc.URL,
+			})
+		}
+	}
+
+	buf, err := json.Marshal(results)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	ctx.WriteLLMResult(string(buf))
+}
+
+// 按 ID 返回文档的完整内容，不存在、已过期或被屏蔽的文档与检索时一样被跳过
+func documentContents(store index.Store, blocklist *retrieval.Blocklist, docIds []int, now time.Time) []DocumentResult {
+	results := []DocumentResult{}
+	for _, docId := range docIds {
+		doc, ok := store.Get(docId)
+		if !ok || doc.Expired(now) || doc.Blocked || blocklist.ContainsDocument(doc) {
+			continue
+		}
+		results = append(results, DocumentResult{
+			Id:      doc.DocId,
+			Title:   doc.Title,
+			Summary: doc.Summary,
+			URL:     doc.URL,
+			Content: doc.Content,
+		})
+	}
+	return results
+}
+
+// 以 yomo stream function 的形式提供检索能力
+func main() {
+	c, err := config.Load()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cfg = c
+	fmt.Println("config:", cfg)
+	provider.SetUpstreamHeaders(cfg.UpstreamHeaders, cfg.UpstreamUserAgent)
+	err = logging.Configure(cfg.LogLevel, cfg.LogDebugSampleRate)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = provider.SetFaults(&provider.Faults{
+		Targets:     cfg.Fault.Targets,
+		ErrorRate:   cfg.Fault.ErrorRate,
+		ErrorStatus: cfg.Fault.ErrorStatus,
+		DelayRate:   cfg.Fault.DelayRate,
+		Delay:       cfg.Fault.Delay,
+	})
+	if err != nil {
+		log.Fatalf("FAULT_*: %v\n", err)
+	}
+
+	switch cfg.SfnResultFormat {
+	case resultFormatText, resultFormatJSON:
+	default:
+		log.Fatalf("invalid YOMO_SFN_RESULT_FORMAT: %q\n", cfg.SfnResultFormat)
+	}
+	if cfg.SfnMinScore < 0 {
+		log.Fatalf("invalid YOMO_SFN_MIN_SCORE: %g\n", cfg.SfnMinScore)
+	}
+
+	sfn := yomo.NewStreamFunction(
+		cfg.SfnName,
+		cfg.SfnZipper,
+		yomo.WithSfnCredential(cfg.SfnCredential),
+		yomo.WithSfnAIFunctionDefinition(Description(), InputSchema()),
+	)
+	defer sfn.Close()
+
+	err = sfn.Init(Init)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sfn.SetObserveDataTags()
+	sfn.SetHandler(Handler)
+	sfn.SetErrorHandler(func(err error) {
+		fmt.Println("sfn error:", err)
+	})
+
+	err = sfn.Connect()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sfn.Wait()
+}
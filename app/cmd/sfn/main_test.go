@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"rag_app/internal/index"
+	"rag_app/internal/retrieval"
+)
+
+// 只按 ID 查找文档的存储
+type mapStore map[int]*index.Document
+
+func (s mapStore) Len() int                     { return len(s) }
+func (s mapStore) Documents() []*index.Document { return nil }
+func (s mapStore) Get(docId int) (*index.Document, bool) {
+	doc, ok := s[docId]
+	return doc, ok
+}
+func (s mapStore) Search(query []float32, topN int, exclude func(doc *index.Document) bool) ([]index.Hit, error) {
+	return nil, nil
+}
+func (s mapStore) BestChunk(doc *index.Document, query []float32) (string, bool) { return "", false }
+func (s mapStore) TopChunks(doc *index.Document, query []float32, n int) []index.Span {
+	return nil
+}
+func (s mapStore) UpdateSummary(docId int, summary string, vector []float32) (*index.Document, error) {
+	return nil, index.ErrDocumentNotFound
+}
+
+func TestDocumentContents(t *testing.T) {
+	now := time.Now()
+	store := mapStore{
+		1: {DocId: 1, Content: "visible"},
+		2: {DocId: 2, Content: "expired", ExpiresAt: now.Add(-time.Hour)},
+		3: {DocId: 3, Content: "blocked in metadata", Blocked: true},
+		4: {DocId: 4, Content: "blocked by admin"},
+		5: {DocId: 5, Content: "split from blocked", SplitFrom: 4},
+	}
+	blocklist, err := retrieval.LoadBlocklist("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = blocklist.Add(4, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		docIds []int
+		want   []int
+	}{
+		{"visible", []int{1}, []int{1}},
+		{"missing", []int{9}, nil},
+		{"expired", []int{2}, nil},
+		{"blocked in metadata", []int{3}, nil},
+		{"blocklist", []int{4}, nil},
+		{"split from blocked", []int{5}, nil},
+		{"mixed", []int{4, 1, 2}, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := documentContents(store, blocklist, tt.docIds, now)
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d documents, want %v", len(results), tt.want)
+			}
+			for i, r := range results {
+				if r.Id != tt.want[i] || r.Content == "" {
+					t.Errorf("result %d: got doc %d with content %q, want doc %d", i, r.Id, r.Content, tt.want[i])
+				}
+			}
+		})
+	}
+}
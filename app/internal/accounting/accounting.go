@@ -0,0 +1,181 @@
+package accounting
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 按天统计的用量
+type Usage struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+func (u *Usage) TotalTokens() int64 {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func (u *Usage) add(v *Usage) {
+	u.Requests += v.Requests
+	u.PromptTokens += v.PromptTokens
+	u.CompletionTokens += v.CompletionTokens
+}
+
+// 日期格式，按服务器本地时区统计
+const dayLayout = time.DateOnly
+
+// 解析 YYYY-MM-DD 格式的日期
+func ParseDay(s string) (time.Time, error) {
+	return time.ParseInLocation(dayLayout, s, time.Local)
+}
+
+// 按租户和日期累计上游 token 用量，可选持久化到 JSON 文件
+type Ledger struct {
+	mu   sync.Mutex
+	path string
+	// 每次记录用量时递增；saved 为最近一次成功写入文件时的值，两者不等时有未保存的用量
+	version uint64
+	saved   uint64
+	// 保证同一时间只有一次写入，定期写入和关闭时的写入不会互相覆盖临时文件
+	flushMu sync.Mutex
+	// 租户 -> 日期 -> 用量
+	usage map[string]map[string]*Usage
+}
+
+// 创建用量账本，path 不为空时从文件加载历史用量
+func NewLedger(path string) (*Ledger, error) {
+	l := &Ledger{
+		path:  path,
+		usage: make(map[string]map[string]*Usage),
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &l.usage)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// 记录一次用量
+func (l *Ledger) Record(tenant string, at time.Time, u *Usage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	days, ok := l.usage[tenant]
+	if !ok {
+		days = make(map[string]*Usage)
+		l.usage[tenant] = days
+	}
+	day := at.Format(dayLayout)
+	if _, ok := days[day]; !ok {
+		days[day] = &Usage{}
+	}
+	days[day].add(u)
+	l.version++
+}
+
+// 统计租户在 [from, to] 日期范围内的用量合计
+func (l *Ledger) Total(tenant string, from, to time.Time) *Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	total := &Usage{}
+	fromDay, toDay := from.Format(dayLayout), to.Format(dayLayout)
+	for day, u := range l.usage[tenant] {
+		if day >= fromDay && day <= toDay {
+			total.add(u)
+		}
+	}
+	return total
+}
+
+// 租户某一天的用量
+type DayUsage struct {
+	Tenant string `json:"tenant"`
+	Day    string `json:"day"`
+	Usage
+}
+
+// 返回 [from, to] 日期范围内每个租户每天的用量，按租户和日期排序；tenant 为空时包含全部租户
+func (l *Ledger) Daily(tenant string, from, to time.Time) []DayUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rows := []DayUsage{}
+	fromDay, toDay := from.Format(dayLayout), to.Format(dayLayout)
+	for t, days := range l.usage {
+		if tenant != "" && t != tenant {
+			continue
+		}
+		for day, u := range days {
+			if day >= fromDay && day <= toDay {
+				rows = append(rows, DayUsage{Tenant: t, Day: day, Usage: *u})
+			}
+		}
+	}
+	slices.SortFunc(rows, func(a, b DayUsage) int {
+		if c := strings.Compare(a.Tenant, b.Tenant); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Day, b.Day)
+	})
+	return rows
+}
+
+// 租户当天的用量
+func (l *Ledger) Today(tenant string, now time.Time) *Usage {
+	return l.Total(tenant, now, now)
+}
+
+// 租户当月的用量
+func (l *Ledger) ThisMonth(tenant string, now time.Time) *Usage {
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return l.Total(tenant, first, now)
+}
+
+// 将用量写入文件，先写临时文件再重命名。写入失败时用量仍标记为未保存，下次写入时重试
+func (l *Ledger) Flush() error {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+
+	l.mu.Lock()
+	if l.path == "" || l.version == l.saved {
+		l.mu.Unlock()
+		return nil
+	}
+	buf, err := json.Marshal(l.usage)
+	version := l.version
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	err = os.WriteFile(tmp, buf, 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, l.path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.saved = version
+	l.mu.Unlock()
+	return nil
+}
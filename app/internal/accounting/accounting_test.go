@@ -0,0 +1,54 @@
+package accounting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 写入失败后用量仍标记为未保存，下一次写入时不需要新的用量也会重试
+func TestFlushRetriesAfterFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "accounting")
+	path := filepath.Join(dir, "usage.json")
+	l, err := NewLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	l.Record("acme", now, &Usage{Requests: 1, PromptTokens: 10, CompletionTokens: 5})
+
+	err = l.Flush()
+	if err == nil {
+		t.Fatal("Flush() into a missing directory succeeded")
+	}
+	err = os.Mkdir(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Today("acme", now).TotalTokens(); got != 15 {
+		t.Errorf("reloaded usage = %d tokens, want 15", got)
+	}
+
+	// 没有新的用量时不再写入
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Flush() without new usage rewrote the file: %v", err)
+	}
+}
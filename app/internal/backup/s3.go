@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3 兼容的对象存储，以 path-style 地址和 AWS Signature V4 签名上传对象
+type s3Store struct {
+	bucket string
+	prefix string
+	opts   S3Options
+	client *http.Client
+}
+
+func newS3Store(bucket, prefix string, opts S3Options) (*s3Store, error) {
+	if opts.AccessKeyId == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("s3 access key id and secret access key are required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	return &s3Store{bucket: bucket, prefix: prefix, opts: opts, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, body []byte) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s: %s: %s", key, resp.Status, msg)
+	}
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("get %s: %w", key, ErrNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("get %s: %s: %s", key, resp.Status, msg)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// 创建已签名的对象请求，key 为前缀下的相对路径
+func (s *s3Store) newRequest(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	u, err := url.Parse(s.opts.Endpoint + "/" + s.bucket + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+	return req, nil
+}
+
+func (s *s3Store) String() string {
+	return fmt.Sprintf("s3://%s/%s (%s)", s.bucket, s.prefix, s.opts.Endpoint)
+}
+
+// 按 AWS Signature V4 为请求签名
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("Content-Type", contentType(req.URL.Path))
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.Header.Get("Content-Type"), req.URL.Host, payloadHash, amzDate)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.opts.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretAccessKey), day)
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func contentType(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".jsonl"):
+		return "application/x-ndjson"
+	case strings.HasSuffix(path, ".json"):
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 备份写入的对象存储
+type Store interface {
+	// 写入一个对象，key 为相对路径
+	Put(ctx context.Context, key string, body []byte) error
+	// 读取一个对象，不存在时返回 ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// 存储位置，用于日志
+	String() string
+}
+
+var ErrNotFound = errors.New("object not found")
+
+// S3 兼容对象存储的连接参数
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+}
+
+// 按地址打开存储：file:///path 写入本地（或挂载的）目录，s3://bucket/prefix 写入 S3 兼容的对象存储
+func Open(rawUrl string, opts S3Options) (Store, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("%s: missing path", rawUrl)
+		}
+		return &fileStore{dir: u.Path}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: missing bucket", rawUrl)
+		}
+		return newS3Store(u.Host, strings.Trim(u.Path, "/"), opts)
+	default:
+		return nil, fmt.Errorf("%s: unsupported scheme %q, expected file or s3", rawUrl, u.Scheme)
+	}
+}
+
+// 本地目录，先写临时文件再重命名
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Put(ctx context.Context, key string, body []byte) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, body, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *fileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	buf, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return buf, err
+}
+
+func (s *fileStore) String() string {
+	return "file://" + s.dir
+}
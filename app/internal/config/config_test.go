@@ -0,0 +1,146 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadParsesEnvironment(t *testing.T) {
+	t.Setenv("TOP_EMB", "7")
+	t.Setenv("RETRIEVAL_CACHE_TTL", "90s")
+	t.Setenv("BUFFERED_PROCESSORS", "grounding,citations")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TopEmb != 7 {
+		t.Errorf("TopEmb = %d, want 7", cfg.TopEmb)
+	}
+	if cfg.RetrievalCacheTtl != 90*time.Second {
+		t.Errorf("RetrievalCacheTtl = %v, want 90s", cfg.RetrievalCacheTtl)
+	}
+	if !slices.Equal(cfg.BufferedProcessors, []string{"grounding", "citations"}) {
+		t.Errorf("BufferedProcessors = %q", cfg.BufferedProcessors)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name, value, field string
+	}{
+		{"TOP_EMB", "many", "TopEmb"},
+		{"RETRIEVAL_CACHE_TTL", "soon", "RetrievalCacheTtl"},
+		{"SSE_GZIP", "maybe", "SseGzip"},
+		{"GROUNDING_MIN_SCORE", "high", "GroundingMinScore"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.name, tt.value)
+			_, err := Load()
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Fatalf("Load() error = %v, want an error naming %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	t.Setenv("LENTO_PROFILE", "local")
+	t.Setenv("SESSION_TTL", "5m")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SessionMaxTurns != 20 {
+		t.Errorf("SessionMaxTurns = %d, want the small preset 20", cfg.SessionMaxTurns)
+	}
+	if cfg.SessionTtl != 5*time.Minute {
+		t.Errorf("SessionTtl = %v, want the explicit 5m over the preset", cfg.SessionTtl)
+	}
+
+	t.Setenv("LENTO_PROFILE", "huge")
+	_, err = Load()
+	if err == nil || !strings.Contains(err.Error(), "LENTO_PROFILE") {
+		t.Fatalf("Load() error = %v, want unknown profile", err)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(path, []byte("s3cret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LLM_TOKEN", "")
+	t.Setenv("LLM_TOKEN_FILE", path)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LlmToken != "s3cret" {
+		t.Errorf("LlmToken = %q, want the trimmed file content", cfg.LlmToken)
+	}
+
+	t.Setenv("LLM_TOKEN", "inline")
+	_, err = Load()
+	if err == nil || !strings.Contains(err.Error(), "LLM_TOKEN_FILE") {
+		t.Fatalf("Load() error = %v, want a conflict between LLM_TOKEN and LLM_TOKEN_FILE", err)
+	}
+
+	t.Setenv("LLM_TOKEN", "")
+	t.Setenv("LLM_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = Load()
+	if err == nil {
+		t.Fatal("Load() succeeded with a missing secret file")
+	}
+}
+
+func TestHashIgnoresSecrets(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := *cfg
+	other.LlmToken = "different"
+	other.AdminToken = "different"
+	if cfg.Hash() != other.Hash() {
+		t.Error("Hash changed with the secrets")
+	}
+	other.TopEmb++
+	if cfg.Hash() == other.Hash() {
+		t.Error("Hash did not change with TOP_EMB")
+	}
+}
+
+func TestStringRedactsSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin_token")
+	err := os.WriteFile(path, []byte("admin-secret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_TOKEN_FILE", path)
+	t.Setenv("LLM_TOKEN", "llm-secret")
+	t.Setenv("BACKUP_S3_SECRET_ACCESS_KEY", "s3-secret")
+	t.Setenv("UPSTREAM_HEADERS", "X-Api-Key:header-secret")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	printed := cfg.String()
+	for _, secret := range []string{"admin-secret", "llm-secret", "s3-secret", "header-secret"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("String() contains %q", secret)
+		}
+	}
+	if !strings.Contains(printed, "X-Api-Key:[REDACTED]") {
+		t.Errorf("String() lost the upstream header names: %s", printed)
+	}
+	if cfg.LlmToken != "llm-secret" {
+		t.Errorf("String() modified the config: LlmToken = %q", cfg.LlmToken)
+	}
+}
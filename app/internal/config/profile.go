@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/caarlos0/env/v11"
+)
+
+// 部署规模预设，以 LENTO_PROFILE 选择。预设只改变缓存、并发和超时等选项的默认值，
+// 单独设置的环境变量优先
+var profiles = map[string]map[string]string{
+	// 单机或本地开发：不开缓存，并发较低
+	"small": {
+		"ADAPTIVE_MAX_INFLIGHT":            "4",
+		"GENERATION_PREFETCH_MAX_INFLIGHT": "2",
+		"BATCH_CONCURRENCY":                "1",
+		"RETRIEVAL_CACHE_SIZE":             "0",
+		"ANSWER_CACHE_SIZE":                "0",
+		"SESSION_MAX_TURNS":                "20",
+		"SESSION_TTL":                      "15m",
+		"CAPTURE_SIZE":                     "20",
+		"REQUEST_MAX_DURATION":             "0s",
+	},
+	// 常规的生产部署：开启检索和回答缓存、请求合并，限制单个请求的耗时
+	"standard": {
+		"ADAPTIVE_MAX_INFLIGHT":            "16",
+		"GENERATION_PREFETCH_MAX_INFLIGHT": "8",
+		"BATCH_CONCURRENCY":                "2",
+		"RETRIEVAL_CACHE_SIZE":             "1000",
+		"RETRIEVAL_CACHE_TTL":              "2m",
+		"ANSWER_CACHE_SIZE":                "1000",
+		"ANSWER_CACHE_TTL":                 "1h",
+		"COALESCE_REQUESTS":                "true",
+		"SESSION_MAX_TURNS":                "50",
+		"SESSION_TTL":                      "30m",
+		"CAPTURE_SIZE":                     "100",
+		"REQUEST_MAX_DURATION":             "120s",
+	},
+	// 多副本的大规模部署：更大的缓存和并发，会话保留更久
+	"enterprise": {
+		"ADAPTIVE_MAX_INFLIGHT":            "64",
+		"GENERATION_PREFETCH_MAX_INFLIGHT": "32",
+		"BATCH_CONCURRENCY":                "8",
+		"RETRIEVAL_CACHE_SIZE":             "10000",
+		"RETRIEVAL_CACHE_TTL":              "5m",
+		"ANSWER_CACHE_SIZE":                "10000",
+		"ANSWER_CACHE_TTL":                 "6h",
+		"COALESCE_REQUESTS":                "true",
+		"SESSION_MAX_TURNS":                "100",
+		"SESSION_TTL":                      "2h",
+		"CAPTURE_SIZE":                     "1000",
+		"REQUEST_MAX_DURATION":             "300s",
+	},
+}
+
+// 预设的别名
+var profileAliases = map[string]string{
+	"local": "small",
+}
+
+// 全部预设名，按名称排序
+func ProfileNames() []string {
+	names := slices.Collect(maps.Keys(profiles))
+	names = append(names, slices.Collect(maps.Keys(profileAliases))...)
+	slices.Sort(names)
+	return names
+}
+
+// 在环境变量之上补充 LENTO_PROFILE 预设的默认值，已设置的环境变量不被覆盖
+func profileEnvironment() (map[string]string, error) {
+	environment := env.ToMap(os.Environ())
+	name := environment["LENTO_PROFILE"]
+	if name == "" {
+		return environment, nil
+	}
+	if alias, ok := profileAliases[name]; ok {
+		name = alias
+	}
+	preset, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("LENTO_PROFILE: unknown profile %q, expected one of %s", environment["LENTO_PROFILE"], strings.Join(ProfileNames(), " "))
+	}
+	for key, value := range preset {
+		if _, set := environment[key]; !set {
+			environment[key] = value
+		}
+	}
+	return environment, nil
+}
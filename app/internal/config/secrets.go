@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// 从 HashiCorp Vault KV v2 读取密钥的配置，Addr 为空时关闭
+type VaultConfig struct {
+	Addr string `env:"ADDR" envDefault:""`
+	// 访问令牌，也可以通过 VAULT_TOKEN_FILE 从文件读取
+	Token     string `env:"TOKEN" envDefault:""`
+	Namespace string `env:"NAMESPACE" envDefault:""`
+	// KV v2 的挂载点和路径，如 secret/lento，密钥名与环境变量名相同，如 LLM_TOKEN
+	Mount string `env:"MOUNT" envDefault:"secret"`
+	Path  string `env:"PATH" envDefault:""`
+}
+
+// Vault 请求的超时时间
+const vaultTimeout = 10 * time.Second
+
+// 可以从文件或 Vault 读取的密钥：环境变量名 -> 配置字段
+func (c *Config) secrets() map[string]*string {
+	return map[string]*string{
+		"LLM_TOKEN":                             &c.LlmToken,
+		"EMB_TOKEN":                             &c.EmbToken,
+		"ADMIN_TOKEN":                           &c.AdminToken,
+		"INDEX_ENCRYPTION_KEY":                  &c.IndexEncryptionKey,
+		"BATCH_WEBHOOK_SECRET":                  &c.BatchWebhookSecret,
+		"BACKUP_S3_SECRET_ACCESS_KEY":           &c.Backup.S3SecretAccessKey,
+		"SNAPSHOT_STORE_S3_SECRET_ACCESS_KEY":   &c.SnapshotStore.S3SecretAccessKey,
+		"TRANSCRIPT_STORE_S3_SECRET_ACCESS_KEY": &c.Transcripts.S3SecretAccessKey,
+		"YOMO_SFN_CREDENTIAL":                   &c.SfnCredential,
+		"VAULT_TOKEN":                           &c.Vault.Token,
+		"DECISIONS_URL":                         &c.Decisions.Url,
+		"LOCK_URL":                              &c.Lock.Url,
+		"QUERY_LOG_HASH_KEY":                    &c.QueryLogHashKey,
+	}
+}
+
+// 读取密钥：设置了 <NAME>_FILE 时从该文件读取（如 Docker/Kubernetes 挂载的 secret），
+// 再从 Vault 读取仍未设置的密钥。同一密钥同时设置了环境变量和文件时报错
+func (c *Config) loadSecrets() error {
+	for name, field := range c.secrets() {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if *field != "" {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		*field = strings.TrimSpace(string(buf))
+	}
+
+	if c.Vault.Addr == "" {
+		return nil
+	}
+	values, err := c.Vault.read()
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	for name, field := range c.secrets() {
+		if v, ok := values[name]; ok && *field == "" {
+			*field = v
+		}
+	}
+	return nil
+}
+
+// 读取 KV v2 密钥的最新版本
+func (v *VaultConfig) read() (map[string]string, error) {
+	if v.Path == "" || v.Token == "" {
+		return nil, fmt.Errorf("VAULT_PATH and VAULT_TOKEN are required")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimSuffix(v.Addr, "/"), strings.Trim(v.Mount, "/"), strings.Trim(v.Path, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("read %s: %s: %s", v.Path, resp.Status, msg)
+	}
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", v.Path, err)
+	}
+	return body.Data.Data, nil
+}
@@ -0,0 +1,63 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// 原样作为 markdown，用于 markdown 和纯文本
+type passthrough struct{}
+
+func (passthrough) Convert(ctx context.Context, data []byte, mimeType string) (string, error) {
+	if !utf8.Valid(data) {
+		return "", errors.New("content is not valid UTF-8")
+	}
+	return string(data), nil
+}
+
+// 将 CSV/TSV 转换为 markdown 表格，第一行为表头
+type table struct {
+	comma rune
+}
+
+func (t table) Convert(ctx context.Context, data []byte, mimeType string) (string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = t.comma
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	var b strings.Builder
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i := range width {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			// 单元格中的竖线和换行会破坏表格结构
+			cell = strings.ReplaceAll(cell, "|", "\\|")
+			cell = strings.Join(strings.Fields(cell), " ")
+			b.WriteString(" " + cell + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(rows[0])
+	b.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return b.String(), nil
+}
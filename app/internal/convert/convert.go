@@ -0,0 +1,192 @@
+package convert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 外部转换器的默认超时
+const defaultTimeout = 2 * time.Minute
+
+// 文档转换器：将原始文件内容转换为 markdown
+type Converter interface {
+	Convert(ctx context.Context, data []byte, mimeType string) (string, error)
+}
+
+// 常见文档格式的扩展名，优先于系统的 mime 表，保证不同环境下识别结果一致
+var extensionTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".txt":      "text/plain",
+	".csv":      "text/csv",
+	".tsv":      "text/tab-separated-values",
+	".html":     "text/html",
+	".htm":      "text/html",
+	".rst":      "text/x-rst",
+	".pdf":      "application/pdf",
+	".doc":      "application/msword",
+	".docx":     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":      "application/vnd.ms-excel",
+	".xlsx":     "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":      "application/vnd.ms-powerpoint",
+	".pptx":     "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":      "application/vnd.oasis.opendocument.text",
+	".epub":     "application/epub+zip",
+}
+
+// 按 mime 类型选择转换器，内置 markdown、纯文本和 CSV/TSV 的转换，其余格式由 CONVERTERS_FILE 配置
+type Registry struct {
+	converters map[string]Converter
+	extensions map[string]string
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{converters: make(map[string]Converter), extensions: make(map[string]string)}
+	r.Register("text/markdown", passthrough{})
+	r.Register("text/x-markdown", passthrough{})
+	r.Register("text/plain", passthrough{})
+	r.Register("text/csv", table{comma: ','})
+	r.Register("text/tab-separated-values", table{comma: '\t'})
+	return r
+}
+
+// 注册转换器，替换该类型已有的转换器。mimeType 可以是 type/* 形式，匹配该大类中没有单独注册的类型
+func (r *Registry) Register(mimeType string, c Converter) {
+	r.converters[mimeType] = c
+}
+
+// 识别文件的 mime 类型：先按扩展名，无法识别时按内容
+func (r *Registry) MimeType(name string, data []byte) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	mimeType := r.extensions[ext]
+	if mimeType == "" {
+		mimeType = extensionTypes[ext]
+	}
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(ext)
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = mediaType
+	}
+	return mimeType
+}
+
+// 返回该类型的转换器，没有时返回 false
+func (r *Registry) Lookup(mimeType string) (Converter, bool) {
+	if c, ok := r.converters[mimeType]; ok {
+		return c, true
+	}
+	major, _, _ := strings.Cut(mimeType, "/")
+	c, ok := r.converters[major+"/*"]
+	return c, ok
+}
+
+// 是否有转换器支持该文件
+func (r *Registry) Supports(name string, data []byte) bool {
+	_, ok := r.Lookup(r.MimeType(name, data))
+	return ok
+}
+
+// 按文件名和内容识别类型并转换为 markdown
+func (r *Registry) Convert(ctx context.Context, name string, data []byte) (string, error) {
+	mimeType := r.MimeType(name, data)
+	c, ok := r.Lookup(mimeType)
+	if !ok {
+		return "", fmt.Errorf("no converter for %s", mimeType)
+	}
+	content, err := c.Convert(ctx, data, mimeType)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", mimeType, err)
+	}
+	return content, nil
+}
+
+// CONVERTERS_FILE 中的一个外部转换器，command 和 url 二选一
+type Spec struct {
+	// 由该转换器处理的 mime 类型，可以是 type/* 形式
+	MimeTypes []string `json:"mime_types"`
+	// 识别为 mime_types 中第一个类型的扩展名，如 .adoc
+	Extensions []string `json:"extensions,omitempty"`
+	// 外部命令及参数，从标准输入读取文件内容，向标准输出写 markdown
+	Command []string `json:"command,omitempty"`
+	// 转换服务的地址，以文件内容为请求体 POST，响应体为 markdown
+	URL string `json:"url,omitempty"`
+	// 不为空时改为以 multipart 表单的该字段上传文件
+	FormField string `json:"form_field,omitempty"`
+	// 不为空时响应为 JSON，markdown 在该字段中
+	ResponseField string `json:"response_field,omitempty"`
+	// 单个文件的转换超时，默认 2m
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// 创建内置转换器，再按 JSON 文件注册外部转换器；path 为空时只有内置转换器
+func Load(path string) (*Registry, error) {
+	r := NewRegistry()
+	if path == "" {
+		return r, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []Spec
+	err = json.Unmarshal(buf, &specs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, spec := range specs {
+		c, err := spec.converter()
+		if err != nil {
+			return nil, fmt.Errorf("%s: converter %d: %w", path, i, err)
+		}
+		for _, mimeType := range spec.MimeTypes {
+			r.Register(mimeType, c)
+		}
+		for _, ext := range spec.Extensions {
+			r.extensions[strings.ToLower(ext)] = spec.MimeTypes[0]
+		}
+	}
+	return r, nil
+}
+
+func (spec *Spec) converter() (Converter, error) {
+	if len(spec.MimeTypes) == 0 {
+		return nil, errors.New("mime_types is empty")
+	}
+	for _, ext := range spec.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			return nil, fmt.Errorf("extension %q must start with a dot", ext)
+		}
+	}
+	timeout := defaultTimeout
+	if spec.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(spec.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", spec.Timeout)
+		}
+	}
+	switch {
+	case len(spec.Command) > 0 && spec.URL == "":
+		return &commandConverter{args: spec.Command, timeout: timeout}, nil
+	case len(spec.Command) == 0 && spec.URL != "":
+		return &httpConverter{
+			url:           spec.URL,
+			formField:     spec.FormField,
+			responseField: spec.ResponseField,
+			client:        &http.Client{Timeout: timeout},
+		}, nil
+	}
+	return nil, errors.New("exactly one of command and url is required")
+}
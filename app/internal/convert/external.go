@@ -0,0 +1,130 @@
+package convert
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 外部命令的错误输出中保留的最大字节数
+const maxStderr = 4096
+
+// 调用外部命令转换，如 pandoc。文件内容从标准输入传入，mime 类型通过环境变量 LENTO_MIME_TYPE 传入
+type commandConverter struct {
+	args    []string
+	timeout time.Duration
+}
+
+func (c *commandConverter) Convert(ctx context.Context, data []byte, mimeType string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Env = append(os.Environ(), "LENTO_MIME_TYPE="+mimeType)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxStderr {
+			msg = msg[:maxStderr]
+		}
+		if msg == "" {
+			return "", fmt.Errorf("%s: %w", c.args[0], err)
+		}
+		return "", fmt.Errorf("%s: %w: %s", c.args[0], err, msg)
+	}
+	return stdout.String(), nil
+}
+
+// 调用转换服务，如本仓库的 /api/to_markdown 或 MinerU 服务。默认以文件内容为请求体，
+// Content-Type 为文件的 mime 类型；配置了 formField 时以 multipart 表单上传。
+// 配置了 responseField 时从 JSON 响应中取该字段，否则响应体即为 markdown
+type httpConverter struct {
+	url           string
+	formField     string
+	responseField string
+	client        *http.Client
+}
+
+func (c *httpConverter) Convert(ctx context.Context, data []byte, mimeType string) (string, error) {
+	body, contentType := io.Reader(bytes.NewReader(data)), mimeType
+	if c.formField != "" {
+		// 转换服务通常按扩展名识别格式，以类型对应的扩展名命名文件
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		part, err := w.CreateFormFile(c.formField, "document"+extensionFor(mimeType))
+		if err == nil {
+			_, err = part.Write(data)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return "", err
+		}
+		body, contentType = &buf, w.FormDataContentType()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "text/markdown")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%s: %s: %s", c.url, resp.Status, bytes.TrimSpace(respBody[:min(len(respBody), maxStderr)]))
+	}
+	if c.responseField == "" {
+		return string(respBody), nil
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(respBody, &fields)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", c.url, err)
+	}
+	var content string
+	err = json.Unmarshal(fields[c.responseField], &content)
+	if err != nil {
+		return "", fmt.Errorf("%s: response field %q: %w", c.url, c.responseField, err)
+	}
+	return content, nil
+}
+
+// mime 类型对应的扩展名，未知时为空
+func extensionFor(mimeType string) string {
+	exts := []string{}
+	for ext, t := range extensionTypes {
+		if t == mimeType {
+			exts = append(exts, ext)
+		}
+	}
+	if len(exts) == 0 {
+		exts, _ = mime.ExtensionsByType(mimeType)
+	}
+	if len(exts) == 0 {
+		return ""
+	}
+	// 同一类型有多个扩展名时取最短的，如 .htm 和 .html 中的 .htm，结果稳定
+	slices.SortFunc(exts, func(a, b string) int { return cmp.Or(len(a)-len(b), strings.Compare(a, b)) })
+	return exts[0]
+}
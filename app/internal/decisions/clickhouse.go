@@ -0,0 +1,73 @@
+package decisions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 通过 HTTP 接口以 JSONEachRow 格式写入 ClickHouse
+type clickHouseSink struct {
+	url      *url.URL
+	user     string
+	password string
+	client   *http.Client
+}
+
+func newClickHouseSink(u *url.URL, table string) *clickHouseSink {
+	s := &clickHouseSink{client: &http.Client{Timeout: time.Minute}}
+	// 账号密码改用请求头传递，避免出现在日志中的地址里
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	endpoint := *u
+	endpoint.User = nil
+	q := endpoint.Query()
+	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table))
+	// 时间以 RFC 3339 格式写入
+	q.Set("date_time_input_format", "best_effort")
+	endpoint.RawQuery = q.Encode()
+	s.url = &endpoint
+	return s
+}
+
+func (s *clickHouseSink) Write(ctx context.Context, records []*Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range records {
+		err := enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url.String(), &body)
+	if err != nil {
+		return err
+	}
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (s *clickHouseSink) String() string {
+	u := *s.url
+	u.RawQuery = ""
+	return "clickhouse " + u.String()
+}
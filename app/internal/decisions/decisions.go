@@ -0,0 +1,142 @@
+package decisions
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"rag_app/internal/logging"
+)
+
+// 一次请求的检索决策：问题、候选文档及其分数、最终选用的文档和各阶段耗时
+type Record struct {
+	Time         time.Time          `json:"time"`
+	RequestId    string             `json:"request_id"`
+	TraceId      string             `json:"trace_id,omitempty"`
+	Tenant       string             `json:"tenant"`
+	Collection   string             `json:"collection"`
+	Model        string             `json:"model"`
+	Question     string             `json:"question"`
+	IndexVersion string             `json:"index_version"`
+	Candidates   []Candidate        `json:"candidates"`
+	DocIds       []int              `json:"doc_ids"`
+	TopScore     *float32           `json:"top_score"`
+	Fallback     bool               `json:"rerank_fallback"`
+	TimingsMs    map[string]float64 `json:"timings_ms"`
+	DurationMs   float64            `json:"duration_ms"`
+}
+
+type Candidate struct {
+	DocId       int      `json:"doc_id"`
+	RecallScore float32  `json:"recall_score"`
+	RerankScore *float32 `json:"rerank_score"`
+	Selected    bool     `json:"selected"`
+}
+
+// 检索决策的写入目标
+type Sink interface {
+	Write(ctx context.Context, records []*Record) error
+	// 写入目标，用于日志，不含密码
+	String() string
+}
+
+// 写入目标的类型
+const (
+	SinkClickHouse = "clickhouse"
+	SinkSQL        = "sql"
+)
+
+// 按类型创建写入目标。clickhouse 通过 HTTP 接口写入，不需要额外的驱动；
+// sql 使用 database/sql，驱动需要在构建时注册
+func Open(kind, rawUrl, table, driver string) (Sink, error) {
+	switch kind {
+	case SinkClickHouse:
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("%s: clickhouse url must be http(s)", u.Redacted())
+		}
+		return newClickHouseSink(u, table), nil
+	case SinkSQL:
+		return openSQLSink(driver, rawUrl, table)
+	}
+	return nil, fmt.Errorf("unknown sink %q, expected %s or %s", kind, SinkClickHouse, SinkSQL)
+}
+
+// 异步批量写入检索决策：请求结束时放入队列，按批量大小或间隔写入，
+// 队列满或写入失败时丢弃记录，不影响请求
+type Writer struct {
+	sink      Sink
+	queue     chan *Record
+	batchSize int
+	interval  time.Duration
+	dropped   atomic.Int64
+	written   atomic.Int64
+}
+
+func NewWriter(sink Sink, bufferSize, batchSize int, interval time.Duration) *Writer {
+	w := &Writer{
+		sink:      sink,
+		queue:     make(chan *Record, max(bufferSize, 1)),
+		batchSize: max(batchSize, 1),
+		interval:  interval,
+	}
+	go w.run()
+	return w
+}
+
+// 放入队列，队列已满时丢弃
+func (w *Writer) Add(r *Record) {
+	select {
+	case w.queue <- r:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// 已写入和丢弃的记录数
+func (w *Writer) Stats() (written, dropped int64) {
+	return w.written.Load(), w.dropped.Load()
+}
+
+// 写入目标，不含密码
+func (w *Writer) String() string {
+	return w.sink.String()
+}
+
+func (w *Writer) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	batch := []*Record{}
+	for {
+		select {
+		case r := <-w.queue:
+			batch = append(batch, r)
+			if len(batch) < w.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		w.flush(batch)
+		batch = []*Record{}
+	}
+}
+
+func (w *Writer) flush(batch []*Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := w.sink.Write(ctx, batch)
+	if err != nil {
+		w.dropped.Add(int64(len(batch)))
+		logging.Errorf("write %d retrieval decisions to %s failed: %v\n", len(batch), w.sink, err)
+		return
+	}
+	w.written.Add(int64(len(batch)))
+}
@@ -0,0 +1,79 @@
+package decisions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 通过 database/sql 写入任意 SQL 数据库，候选文档、文档 ID 和耗时以 JSON 文本保存。
+// 驱动不随 lento 一起构建，需要在 cmd/lento 中以空白导入注册，如 _ "github.com/lib/pq"
+type sqlSink struct {
+	db     *sql.DB
+	driver string
+	insert string
+}
+
+// 列名，与 Record 的字段一一对应。trace_id 不写入，以兼容已经建好的表
+var sqlColumns = []string{
+	"time", "request_id", "tenant", "collection", "model", "question", "index_version",
+	"candidates", "doc_ids", "top_score", "rerank_fallback", "timings_ms", "duration_ms",
+}
+
+// 使用 $1, $2 形式占位符的驱动，其余驱动使用 ?
+var dollarDrivers = []string{"postgres", "pgx"}
+
+func openSQLSink(driver, dsn, table string) (*sqlSink, error) {
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("sql driver %q is not registered, available: %v", driver, sql.Drivers())
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	placeholders := make([]string, len(sqlColumns))
+	for i := range placeholders {
+		placeholders[i] = "?"
+		if slices.Contains(dollarDrivers, driver) {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(sqlColumns, ", "), strings.Join(placeholders, ", "))
+	return &sqlSink{db: db, driver: driver, insert: insert}, nil
+}
+
+// 一批记录在同一事务中写入
+func (s *sqlSink) Write(ctx context.Context, records []*Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, s.insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		candidates, _ := json.Marshal(r.Candidates)
+		docIds, _ := json.Marshal(r.DocIds)
+		timings, _ := json.Marshal(r.TimingsMs)
+		var topScore any
+		if r.TopScore != nil {
+			topScore = *r.TopScore
+		}
+		_, err = stmt.ExecContext(ctx, r.Time, r.RequestId, r.Tenant, r.Collection, r.Model, r.Question, r.IndexVersion,
+			string(candidates), string(docIds), topScore, r.Fallback, string(timings), r.DurationMs)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlSink) String() string {
+	return "sql " + s.driver
+}
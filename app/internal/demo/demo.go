@@ -0,0 +1,116 @@
+package demo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 合成语料的主题：标题、摘要中的关键信息及正文要点
+type topic struct {
+	title   string
+	summary string
+	points  []string
+}
+
+var topics = []topic{
+	{"请假制度", "员工请假审批流程与年假天数规定", []string{
+		"正式员工每年享有十天带薪年假，入职满五年后增加至十五天。",
+		"请假需至少提前三个工作日在系统中提交申请，由直属主管审批。",
+		"病假需提供医院出具的证明，连续病假超过三天需人力资源部备案。",
+	}},
+	{"报销制度", "差旅费用报销标准与报销单据要求", []string{
+		"一线城市差旅住宿标准为每晚五百元，其他城市为每晚三百五十元。",
+		"报销需在费用发生后三十天内提交，并附上合规的增值税发票。",
+		"超过标准的部分需提供说明，由部门负责人额外审批。",
+	}},
+	{"门禁管理", "办公楼门禁卡办理、挂失与访客登记流程", []string{
+		"新员工入职当天由行政部统一办理门禁卡。",
+		"门禁卡遗失需在一个工作日内通过行政服务台挂失，补办收取二十元工本费。",
+		"访客需在前台登记身份证件，由接待员工全程陪同。",
+	}},
+	{"会议室预订", "会议室预订规则、使用时长与取消政策", []string{
+		"会议室通过日历系统预订，单次预订不超过两小时。",
+		"预订后十五分钟未签到的会议室将被自动释放。",
+		"大型会议室需提前一天预订，并注明参会人数。",
+	}},
+	{"VPN 使用指南", "远程办公 VPN 客户端安装、登录与常见问题", []string{
+		"VPN 客户端可从内网软件中心下载，支持 Windows、macOS 和 Linux。",
+		"登录需使用域账号密码并通过手机令牌进行二次验证。",
+		"连接失败时请先检查网络代理设置，仍无法解决可联系 IT 服务台。",
+	}},
+	{"打印服务", "办公打印机的驱动安装、彩色打印申请与耗材更换", []string{
+		"每层楼的打印机均支持刷工卡取件，驱动可在内网软件中心下载。",
+		"彩色打印需在打印系统中申请额度，默认每月一百页。",
+		"打印机缺纸或缺墨时请联系行政部，不要自行拆卸设备。",
+	}},
+	{"入职指南", "新员工入职第一周的手续办理与培训安排", []string{
+		"入职当天需携带身份证、学历证明和离职证明原件到人力资源部报到。",
+		"第一周安排公司文化、信息安全和岗位技能三项培训。",
+		"入职一周内由导师协助开通邮箱、代码仓库等系统账号。",
+	}},
+	{"信息安全规范", "账号密码、数据分级与外发文件的安全要求", []string{
+		"密码长度不少于十二位，且每九十天更换一次。",
+		"机密级数据禁止通过个人邮箱或即时通讯工具外发。",
+		"发现疑似钓鱼邮件时请立即上报信息安全部，不要点击其中的链接。",
+	}},
+	{"加班与调休", "加班申请、加班费计算及调休规则", []string{
+		"工作日加班需提前在系统中提交申请，经主管批准后方可计入。",
+		"周末加班可选择调休或按两倍工资领取加班费。",
+		"调休需在加班后三个月内使用，逾期作废。",
+	}},
+	{"办公用品领用", "办公用品的申领流程、额度与固定资产登记", []string{
+		"常用办公用品可在每周二、周四到行政部领取。",
+		"单价超过两千元的设备属于固定资产，需登记资产编号。",
+		"离职时需归还全部固定资产，由行政部确认后方可办理离职手续。",
+	}},
+}
+
+// 可生成的最大文档数
+func MaxDocs() int {
+	return len(topics)
+}
+
+// 在 dir 下生成 n 篇合成文档（不超过 MaxDocs），格式与正式语料相同：
+// summary.txt 为「文档ID:摘要」，markdown 目录下为 files.txt 清单和「文档ID.md」正文。
+// 返回摘要文件路径和 markdown 目录
+func WriteCorpus(dir string, n int) (string, string, error) {
+	if n <= 0 || n > len(topics) {
+		n = len(topics)
+	}
+	mdDir := filepath.Join(dir, "markdown")
+	err := os.MkdirAll(mdDir, 0o755)
+	if err != nil {
+		return "", "", err
+	}
+
+	var summary, files bytes.Buffer
+	for i, t := range topics[:n] {
+		docId := i + 1
+		fmt.Fprintf(&files, "%d:%s.md\n", docId, t.title)
+		fmt.Fprintf(&summary, "%d:%s\n\n", docId, t.summary)
+
+		var content strings.Builder
+		fmt.Fprintf(&content, "# %s\n\n本文档说明%s。\n", t.title, t.summary)
+		for j, point := range t.points {
+			fmt.Fprintf(&content, "\n## 第%d条\n\n%s\n", j+1, point)
+		}
+		err = os.WriteFile(filepath.Join(mdDir, fmt.Sprintf("%d.md", docId)), []byte(content.String()), 0o644)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(mdDir, "files.txt"), files.Bytes(), 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	summaryFile := filepath.Join(dir, "summary.txt")
+	err = os.WriteFile(summaryFile, summary.Bytes(), 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	return summaryFile, mdDir, nil
+}
@@ -0,0 +1,171 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"rag_app/internal/gateway"
+	"rag_app/internal/retrieval"
+)
+
+// 管理接口及其所需角色，write 表示只读实例应拒绝的修改操作。
+// path 中的参数和查询选用不会产生修改的值，鉴权通过后处理函数只返回校验错误或只读结果
+type adminRoute struct {
+	method, route, path, role string
+	write                     bool
+}
+
+var adminRoutes = []adminRoute{
+	{"GET", "/admin/documents", "/admin/documents", gateway.RoleDocuments, false},
+	{"GET", "/admin/documents/expired", "/admin/documents/expired", gateway.RoleDocuments, false},
+	{"GET", "/admin/documents/changes", "/admin/documents/changes", gateway.RoleDocuments, false},
+	{"GET", "/admin/documents/:id/versions", "/admin/documents/1/versions", gateway.RoleDocuments, false},
+	{"GET", "/admin/blocklist", "/admin/blocklist", gateway.RoleDocuments, false},
+	{"PUT", "/admin/blocklist/:id", "/admin/blocklist/x", gateway.RoleDocuments, true},
+	{"DELETE", "/admin/blocklist/:id", "/admin/blocklist/x", gateway.RoleDocuments, true},
+	{"POST", "/admin/index/reload", "/admin/index/reload?dry_run=true", gateway.RoleIndex, true},
+	{"GET", "/admin/index/progress", "/admin/index/progress", gateway.RoleIndex, false},
+	{"GET", "/admin/jobs/:id/events", "/admin/jobs/missing/events", gateway.RoleIndex, false},
+	{"GET", "/admin/events/stream", "/admin/events/stream?since=x", gateway.RoleAudit, false},
+	{"POST", "/admin/topics", "/admin/topics?k=x", gateway.RoleIndex, true},
+	{"GET", "/admin/topics", "/admin/topics", gateway.RoleAudit, false},
+	{"GET", "/admin/captures", "/admin/captures", gateway.RoleAudit, false},
+	{"GET", "/admin/prompts/templates", "/admin/prompts/templates", gateway.RoleAudit, false},
+	{"POST", "/admin/prompts/preview", "/admin/prompts/preview", gateway.RoleAudit, false},
+	{"GET", "/admin/keys", "/admin/keys", gateway.RoleKeys, false},
+	{"POST", "/admin/keys/:name", "/admin/keys/missing", gateway.RoleKeys, true},
+	{"DELETE", "/admin/keys/:name/:prefix", "/admin/keys/missing/prefix", gateway.RoleKeys, true},
+	{"GET", "/admin/stats/collections", "/admin/stats/collections", gateway.RoleAudit, false},
+	{"GET", "/admin/stats/errors", "/admin/stats/errors", gateway.RoleAudit, false},
+	{"GET", "/admin/backends/check", "/admin/backends/check", gateway.RoleAudit, false},
+	{"GET", "/admin/usage", "/admin/usage", gateway.RoleAudit, false},
+	{"GET", "/admin/users", "/admin/users", gateway.RoleAudit, false},
+	{"GET", "/admin/log", "/admin/log", gateway.RoleLogs, false},
+	{"PUT", "/admin/log", "/admin/log", gateway.RoleLogs, false},
+	{"GET", "/admin/vectors", "/admin/vectors", gateway.RoleIndex, false},
+	{"POST", "/admin/vectors/gc", "/admin/vectors/gc?dry_run=true", gateway.RoleIndex, true},
+	{"PUT", "/v1/documents/:id/summary", "/v1/documents/x/summary", gateway.RoleDocuments, true},
+	{"POST", "/v1/documents:method", "/v1/documents:batchDelete", gateway.RoleDocuments, true},
+	{"GET", "/v1/collections", "/v1/collections", gateway.RoleDocuments, false},
+	{"POST", "/v1/collections", "/v1/collections", gateway.RoleDocuments, true},
+	{"GET", "/v1/collections/:name", "/v1/collections/missing", gateway.RoleDocuments, false},
+	{"PUT", "/v1/collections/:name", "/v1/collections/missing", gateway.RoleDocuments, true},
+	{"DELETE", "/v1/collections/:name", "/v1/collections/missing", gateway.RoleDocuments, true},
+}
+
+var allRoles = []string{gateway.RoleIndex, gateway.RoleDocuments, gateway.RoleAudit, gateway.RoleKeys, gateway.RoleLogs}
+
+// 每个角色一个只拥有该角色的令牌，另有一个缺少该角色、拥有其余全部角色的令牌
+func roleToken(role string) string  { return "only-" + role }
+func otherToken(role string) string { return "except-" + role }
+
+func writeAdminFiles(t *testing.T) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	tokens := []gateway.AdminToken{}
+	for _, role := range allRoles {
+		tokens = append(tokens, gateway.AdminToken{Token: roleToken(role), Name: roleToken(role), Roles: []string{role}})
+		others := slices.DeleteFunc(slices.Clone(allRoles), func(r string) bool { return r == role })
+		tokens = append(tokens, gateway.AdminToken{Token: otherToken(role), Name: otherToken(role), Roles: others})
+	}
+	files := map[string]any{
+		"ADMIN_TOKENS_FILE": tokens,
+		"API_KEYS_FILE":     []gateway.APIKey{{Name: "acme", Key: "sk-test-acme"}},
+		"COLLECTIONS_FILE":  []any{},
+	}
+	vars := map[string]string{}
+	for name, content := range files {
+		buf, err := json.Marshal(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, strings.ToLower(name)+".json")
+		err = os.WriteFile(path, buf, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars[name] = path
+	}
+	return vars
+}
+
+func adminRequest(t *testing.T, router http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// 每个管理接口只接受拥有其角色的令牌，只读实例拒绝修改操作
+func TestAdminRouteRoles(t *testing.T) {
+	_, cfg := setup(t, writeAdminFiles(t))
+	pipeline, err := retrieval.NewFromConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err := gateway.New(cfg, pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gw.Router()
+	readOnlyCfg := *cfg
+	readOnlyCfg.ReadOnly = true
+	readOnly, err := gateway.New(&readOnlyCfg, pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnlyRouter := readOnly.Router()
+
+	// 新增的管理接口须加入上表
+	for _, r := range router.Routes() {
+		if !strings.HasPrefix(r.Path, "/admin/") && !strings.HasPrefix(r.Path, "/v1/documents") && !strings.HasPrefix(r.Path, "/v1/collections") {
+			continue
+		}
+		if !slices.ContainsFunc(adminRoutes, func(a adminRoute) bool {
+			return a.method == r.Method && a.route == r.Path
+		}) {
+			t.Errorf("route %s %s is not covered by the role table", r.Method, r.Path)
+		}
+	}
+
+	for _, tt := range adminRoutes {
+		t.Run(tt.method+" "+tt.route, func(t *testing.T) {
+			if w := adminRequest(t, router, tt.method, tt.path, ""); w.Code != http.StatusUnauthorized {
+				t.Errorf("without a token: status %d, want 401", w.Code)
+			}
+			w := adminRequest(t, router, tt.method, tt.path, otherToken(tt.role))
+			if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "role "+tt.role+" required") {
+				t.Errorf("without role %s: status %d %s, want 403", tt.role, w.Code, w.Body)
+			}
+			for _, role := range allRoles {
+				if role == tt.role {
+					continue
+				}
+				if w := adminRequest(t, router, tt.method, tt.path, roleToken(role)); w.Code != http.StatusForbidden {
+					t.Errorf("%s-only token: status %d, want 403", role, w.Code)
+				}
+			}
+			w = adminRequest(t, router, tt.method, tt.path, roleToken(tt.role))
+			if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+				t.Errorf("with role %s: status %d %s", tt.role, w.Code, w.Body)
+			}
+
+			w = adminRequest(t, readOnlyRouter, tt.method, tt.path, roleToken(tt.role))
+			rejected := w.Code == http.StatusForbidden && strings.Contains(w.Body.String(), "read_only")
+			if rejected != tt.write {
+				t.Errorf("READ_ONLY: status %d %s, want rejected = %t", w.Code, w.Body, tt.write)
+			}
+		})
+	}
+}
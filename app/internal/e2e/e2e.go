@@ -0,0 +1,78 @@
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 内置的测试语料：文档ID -> {标题, 摘要, 内容}
+var corpus = map[int][3]string{
+	1: {"请假制度.docx", "员工请假审批流程与年假天数规定", "# 请假制度\n\n年假天数为每年十天，请假需提前三天提交审批。"},
+	2: {"报销制度.pdf", "差旅费用报销标准与报销单据要求", "# 报销制度\n\n差旅住宿标准为每晚五百元，报销需提供发票。"},
+	3: {"门禁管理.xlsx", "办公楼门禁卡办理与挂失流程", "# 门禁管理\n\n门禁卡遗失需在一个工作日内挂失。"},
+}
+
+// SSE 事件
+type Event struct {
+	Name string
+	Data string
+}
+
+// 解析 SSE 响应体
+func ParseSSE(r io.Reader) ([]Event, error) {
+	events := []Event{}
+	current := Event{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if current.Data != "" || current.Name != "" {
+				events = append(events, current)
+			}
+			current = Event{}
+		case strings.HasPrefix(line, "event: "):
+			current.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.Data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return events, scanner.Err()
+}
+
+// 写入测试语料，返回摘要文件路径和 markdown 目录
+func writeCorpus(dir string) (string, string, error) {
+	mdDir := filepath.Join(dir, "markdown")
+	err := os.MkdirAll(mdDir, 0o755)
+	if err != nil {
+		return "", "", err
+	}
+
+	var summary, files bytes.Buffer
+	for docId := 1; docId <= len(corpus); docId++ {
+		doc := corpus[docId]
+		fmt.Fprintf(&files, "%d:%s\n", docId, doc[0])
+		fmt.Fprintf(&summary, "%d:%s\n\n", docId, doc[1])
+		err = os.WriteFile(filepath.Join(mdDir, fmt.Sprintf("%d.md", docId)), []byte(doc[2]), 0o644)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	summaryFile := filepath.Join(dir, "summary.txt")
+	err = os.WriteFile(summaryFile, summary.Bytes(), 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	err = os.WriteFile(filepath.Join(mdDir, "files.txt"), files.Bytes(), 0o644)
+	if err != nil {
+		return "", "", err
+	}
+
+	return summaryFile, mdDir, nil
+}
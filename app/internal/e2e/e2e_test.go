@@ -0,0 +1,267 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/config"
+	"rag_app/internal/gateway"
+	"rag_app/internal/mockbackend"
+	"rag_app/internal/retrieval"
+)
+
+// 清除环境中全部配置项（含预设和密钥文件），测试结束后恢复，使测试只受自身设置的变量影响
+func isolateEnv(t *testing.T) {
+	t.Helper()
+	params, err := env.GetFieldParams(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"LENTO_PROFILE"}
+	for _, p := range params {
+		keys = append(keys, p.Key, p.Key+"_FILE")
+	}
+	for _, key := range keys {
+		if _, ok := os.LookupEnv(key); ok {
+			t.Setenv(key, "")
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// 在隔离的环境和临时目录中启动模拟后端，写入测试语料，返回后端和设置好的环境下加载的配置
+func setup(t *testing.T, vars map[string]string) (*mockbackend.Server, *config.Config) {
+	t.Helper()
+	gin.SetMode(gin.ReleaseMode)
+	isolateEnv(t)
+
+	backend := mockbackend.New()
+	t.Cleanup(backend.Close)
+
+	summaryFile, mdDir, err := writeCorpus(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LLM_BASE_URL", backend.BaseUrl())
+	t.Setenv("EMB_BASE_URL", backend.BaseUrl())
+	t.Setenv("SUMMARY_FILE", summaryFile)
+	t.Setenv("MARKDOWN_DIR", mdDir)
+	for key, value := range vars {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, cfg
+}
+
+// 发送一次对话请求，读完响应体以便读取 trailer，返回响应和其中的 SSE 事件
+func postChat(t *testing.T, serverUrl string, body []byte) (*http.Response, []Event) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, serverUrl+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	events, err := ParseSSE(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, events
+}
+
+// 拼接 SSE 事件中的回答内容
+func answerOf(t *testing.T, events []Event) string {
+	t.Helper()
+	answer := ""
+	for _, e := range events {
+		if e.Name != "" || e.Data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(e.Data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", e.Data, err)
+		}
+		if len(chunk.Choices) > 0 {
+			answer += chunk.Choices[0].Delta.Content
+		}
+	}
+	return answer
+}
+
+// 启动模拟后端和网关，发送一次对话请求并校验完整的 SSE 响应
+func TestChatStream(t *testing.T) {
+	backend, cfg := setup(t, map[string]string{
+		"TOP_EMB":        "3",
+		"TOP_RERANK":     "1",
+		"SSE_DONE":       "true",
+		"SSE_TERMINATOR": "[DONE]",
+		"SSE_METADATA":   "true",
+		"SSE_PROGRESS":   "true",
+	})
+	ctx := context.Background()
+	pipeline, err := retrieval.NewFromConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err := gateway.New(cfg, pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gw.Router())
+	t.Cleanup(server.Close)
+
+	question := "年假有几天？"
+	buf, err := json.Marshal(struct {
+		openai.ChatCompletionRequest
+		IncludeContext bool `json:"include_context"`
+	}{
+		ChatCompletionRequest: openai.ChatCompletionRequest{
+			Model: "mock-model",
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: "你是一个助手。"},
+				{Role: openai.ChatMessageRoleUser, Content: question},
+			},
+		},
+		IncludeContext: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, events := postChat(t, server.URL, buf)
+	// 开启进度事件时响应头先于检索发送，各阶段耗时在 trailer 中返回
+	if timing := strings.Join(resp.Trailer.Values("Server-Timing"), ", "); !strings.Contains(timing, "rewrite;dur=") || !strings.Contains(timing, "ttft;dur=") {
+		t.Errorf("unexpected Server-Timing trailer: %q", timing)
+	}
+
+	stages := []string{}
+	answered := ""
+	done := 0
+	withContext := false
+	for i, e := range events {
+		switch e.Name {
+		case "lento.status":
+			var v struct{ Stage string }
+			if err := json.Unmarshal([]byte(e.Data), &v); err != nil {
+				t.Fatal(err)
+			}
+			stages = append(stages, v.Stage)
+		case "lento.question", "lento.citations":
+		case "lento.context":
+			withContext = true
+		case "":
+			if e.Data == "[DONE]" {
+				done++
+				if i != len(events)-1 {
+					t.Error("terminator is not the last event")
+				}
+				continue
+			}
+			var chunk openai.ChatCompletionStreamResponse
+			if err := json.Unmarshal([]byte(e.Data), &chunk); err != nil {
+				t.Fatalf("invalid chunk %q: %v", e.Data, err)
+			}
+			if len(chunk.Choices) > 0 {
+				answered += chunk.Choices[0].Delta.Content
+			}
+		}
+	}
+
+	wantStages := []string{
+		retrieval.StageRewriting,
+		retrieval.StageRetrieving,
+		retrieval.StageReranking,
+		retrieval.StageGenerating,
+	}
+	if strings.Join(stages, ",") != strings.Join(wantStages, ",") {
+		t.Errorf("unexpected stages: %v", stages)
+	}
+	if !withContext {
+		t.Error("missing context event")
+	}
+	if done != 1 {
+		t.Errorf("terminator emitted %d times", done)
+	}
+	if answer := strings.Join(backend.Answer, ""); answered != answer {
+		t.Errorf("unexpected answer: %q", answered)
+	}
+
+	// 最终提示词应包含最相关文档的内容
+	requests := backend.ChatRequests()
+	if len(requests) != 2 {
+		t.Fatalf("unexpected upstream chat requests: %d", len(requests))
+	}
+	prompt := requests[1].Messages[len(requests[1].Messages)-1].Content
+	if !strings.Contains(prompt, question) || !strings.Contains(prompt, "年假天数为每年十天") {
+		t.Errorf("unexpected prompt: %q", prompt)
+	}
+}
+
+// 相同的请求第二次命中回答缓存，流式和缓冲模式回放的回答与生成的一致，且不再请求生成后端
+func TestChatAnswerCache(t *testing.T) {
+	backend, cfg := setup(t, map[string]string{
+		"ANSWER_CACHE_SIZE": "16",
+		"SSE_DONE":          "true",
+		"SSE_TERMINATOR":    "[DONE]",
+	})
+	pipeline, err := retrieval.NewFromConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err := gateway.New(cfg, pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gw.Router())
+	t.Cleanup(server.Close)
+
+	want := strings.Join(backend.Answer, "")
+	// 缓冲模式不影响缓存的键，两种模式使用不同的问题
+	questions := map[bool]string{false: "门禁卡丢了怎么办？", true: "报销需要什么？"}
+	for _, buffered := range []bool{false, true} {
+		buf, err := json.Marshal(map[string]any{
+			"model":    "mock-model",
+			"buffered": buffered,
+			"messages": []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: questions[buffered]},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cache := range []string{"miss", "hit"} {
+			before := len(backend.ChatRequests())
+			resp, events := postChat(t, server.URL, buf)
+			if got := resp.Header.Get("X-Lento-Cache"); got != cache {
+				t.Errorf("buffered=%t: X-Lento-Cache = %q, want %q", buffered, got, cache)
+			}
+			if got := answerOf(t, events); got != want {
+				t.Errorf("buffered=%t, %s: answer = %q, want %q", buffered, cache, got, want)
+			}
+			// 命中缓存时只有改写请求
+			if cache == "hit" && len(backend.ChatRequests())-before != 1 {
+				t.Errorf("buffered=%t: cache hit sent %d upstream chat requests", buffered, len(backend.ChatRequests())-before)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/config"
+	"rag_app/internal/gateway"
+	"rag_app/internal/retrieval"
+)
+
+// 提示词组装的黄金文件用例：以不同的配置发送一次对话请求，将模拟后端收到的生成请求
+// 与黄金文件比较，避免重构悄悄改变生产环境中发送给模型的内容
+type goldenCase struct {
+	name     string
+	question string
+	// 请求体中的扩展字段，如 prompt_mode
+	options map[string]any
+	// 在默认的测试配置上修改，dir 为该用例的临时目录
+	configure func(cfg *config.Config, dir string) error
+}
+
+var goldenCases = []goldenCase{
+	{name: "default", question: "年假有几天？"},
+	{name: "placement_system", question: "年假有几天？", configure: func(cfg *config.Config, dir string) error {
+		cfg.ContextPlacement = gateway.PlacementSystem
+		return nil
+	}},
+	{name: "placement_tool", question: "年假有几天？", configure: func(cfg *config.Config, dir string) error {
+		cfg.ContextPlacement = gateway.PlacementTool
+		return nil
+	}},
+	{name: "placement_assistant", question: "年假有几天？", configure: func(cfg *config.Config, dir string) error {
+		cfg.ContextPlacement = gateway.PlacementAssistant
+		return nil
+	}},
+	{name: "template", question: "年假有几天？", configure: func(cfg *config.Config, dir string) error {
+		path := filepath.Join(dir, "templates.json")
+		cfg.PromptTemplatesFile = path
+		return os.WriteFile(path, []byte(`{"concise": "资料：\n{{.Context}}\n\n用一句话回答：{{.Question}}{{.Instruction}}"}`), 0o644)
+	}},
+	{name: "citation_inline", question: "报销需要什么？", configure: func(cfg *config.Config, dir string) error {
+		cfg.CitationFormat = gateway.CitationInline
+		return nil
+	}},
+	{name: "citation_footnote", question: "报销需要什么？", configure: func(cfg *config.Config, dir string) error {
+		cfg.CitationFormat = gateway.CitationFootnote
+		return nil
+	}},
+	{name: "snippets", question: "门禁卡丢了怎么办？", options: map[string]any{"prompt_mode": gateway.PromptModeSnippets}},
+	{name: "truncated", question: "年假有几天？", configure: func(cfg *config.Config, dir string) error {
+		cfg.ContextWindow = 100
+		cfg.GenerationReserve = 0
+		return nil
+	}},
+	{name: "fenced", question: "部署脚本怎么用？", configure: func(cfg *config.Config, dir string) error {
+		cfg.ContextWindow = 145
+		cfg.GenerationReserve = 0
+		cfg.ChunkSize = 60
+		return writeFencedCorpus(cfg, dir)
+	}},
+	{name: "english", question: "How many days of annual leave do I get?"},
+	{name: "traditional", question: "年假有幾天？", configure: func(cfg *config.Config, dir string) error {
+		cfg.QueryNormalize = []string{retrieval.NormalizeT2S}
+		return nil
+	}},
+}
+
+// 只含一篇带代码块和公式块的文档的语料，块内有空行，用于检查切分和截断不会落在块内
+func writeFencedCorpus(cfg *config.Config, dir string) error {
+	mdDir := filepath.Join(dir, "markdown")
+	err := os.MkdirAll(mdDir, 0o755)
+	if err != nil {
+		return err
+	}
+	content := "# 部署脚本\n\n执行以下脚本完成部署：\n\n```bash\nset -e\n\n./build.sh --release\n\n./deploy.sh --env prod\n```\n\n" +
+		"部署耗时按以下公式估算：\n\n$$\nT = N \\times t\n\nt = 30s\n$$\n\n部署失败时查看 logs 目录下的日志，并联系运维值班人员处理。"
+	err = os.WriteFile(filepath.Join(mdDir, "1.md"), []byte(content), 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(mdDir, "files.txt"), []byte("1:部署脚本\n"), 0o644)
+	if err != nil {
+		return err
+	}
+	cfg.MarkdownDir = mdDir
+	cfg.SummaryFile = filepath.Join(dir, "summary.txt")
+	return os.WriteFile(cfg.SummaryFile, []byte("1:部署脚本的用法和耗时估算\n\n"), 0o644)
+}
+
+// 黄金文件中记录的生成请求
+type goldenPrompt struct {
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+	Tools    []openai.Tool                  `json:"tools,omitempty"`
+}
+
+var update = flag.Bool("update", false, "overwrite the golden prompt files in testdata/golden with the current prompts")
+
+// 逐个运行黄金文件用例，黄金文件位于 testdata/golden 中，以用例名命名。
+// 以 -update 运行时以实际的请求覆盖黄金文件，用于有意修改提示词之后
+func TestGoldenPrompts(t *testing.T) {
+	for _, gc := range goldenCases {
+		t.Run(gc.name, func(t *testing.T) {
+			got := runGoldenCase(t, gc)
+			path := filepath.Join("testdata", "golden", gc.name+".json")
+			if *update {
+				err := os.WriteFile(path, got, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("prompt differs from %s (rerun with -update if intended):\n%s", path, got)
+			}
+		})
+	}
+}
+
+// 以用例的配置启动网关并发送一次请求，返回最后一次生成请求格式化后的 JSON
+func runGoldenCase(t *testing.T, gc goldenCase) []byte {
+	t.Helper()
+	backend, cfg := setup(t, map[string]string{
+		"TOP_EMB":    "3",
+		"TOP_RERANK": "2",
+	})
+	if gc.configure != nil {
+		err := gc.configure(cfg, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	pipeline, err := retrieval.NewFromConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err := gateway.New(cfg, pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gw.Router())
+	t.Cleanup(server.Close)
+
+	body := map[string]any{
+		"model": "mock-model",
+		"messages": []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "你是一个助手。"},
+			{Role: openai.ChatMessageRoleUser, Content: gc.question},
+		},
+	}
+	for k, v := range gc.options {
+		body[k] = v
+	}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/v1/chat/completions", bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	_, err = ParseSSE(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := backend.ChatRequests()
+	if len(requests) == 0 {
+		t.Fatal("no upstream chat request")
+	}
+	last := requests[len(requests)-1]
+	out, err := json.MarshalIndent(goldenPrompt{Messages: last.Messages, Tools: last.Tools}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(out, '\n')
+}
@@ -0,0 +1,427 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/index"
+	"rag_app/internal/ingest"
+	"rag_app/internal/lock"
+	"rag_app/internal/logging"
+	"rag_app/internal/retrieval"
+)
+
+// 管理权限角色
+const (
+	// 重建索引
+	RoleIndex = "index"
+	// 管理文档
+	RoleDocuments = "documents"
+	// 查看审计日志
+	RoleAudit = "audit"
+	// 管理 API Key
+	RoleKeys = "keys"
+	// 调整日志级别
+	RoleLogs = "logs"
+)
+
+var allRoles = []string{RoleIndex, RoleDocuments, RoleAudit, RoleKeys, RoleLogs}
+
+// 管理令牌及其拥有的角色
+type AdminToken struct {
+	Token string   `json:"token"`
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// 加载管理令牌，ADMIN_TOKEN 作为拥有全部角色的超级令牌
+func loadAdminTokens(superToken, path string) ([]*AdminToken, error) {
+	tokens := []*AdminToken{}
+	if superToken != "" {
+		tokens = append(tokens, &AdminToken{Token: superToken, Name: "admin", Roles: allRoles})
+	}
+	if path == "" {
+		return tokens, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*AdminToken
+	err = json.Unmarshal(buf, &list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, t := range list {
+		if t.Token == "" {
+			return nil, fmt.Errorf("%s: token %d is empty", path, i)
+		}
+		for _, role := range t.Roles {
+			if !slices.Contains(allRoles, role) {
+				return nil, fmt.Errorf("%s: token %q: unknown role %q", path, t.Name, role)
+			}
+		}
+	}
+
+	return append(tokens, list...), nil
+}
+
+// 管理接口鉴权，校验管理令牌并记录其角色
+func (s *Server) adminAuth(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	for _, t := range s.adminTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			c.Set("adminToken", t)
+			c.Next()
+			return
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+}
+
+// 要求管理令牌拥有指定角色
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, ok := c.Get("adminToken")
+		if !ok || !slices.Contains(v.(*AdminToken).Roles, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "role " + role + " required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// 只读实例拒绝修改文档、索引和密钥的请求
+func (s *Server) writable(c *gin.Context) {
+	if s.cfg.ReadOnly {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": gin.H{"code": "read_only", "message": "this instance is read-only"}})
+		return
+	}
+	c.Next()
+}
+
+// 按文档 ID 分页列出索引中的文档，可按集合过滤，默认每页 100 条
+func (s *Server) documentsHandler(c *gin.Context) {
+	p, ok := parsePage(c, 100)
+	if !ok {
+		return
+	}
+	collection, filter := c.GetQuery("collection")
+	docs := []*index.Document{}
+	for _, doc := range s.currentPipeline().Store().Documents() {
+		if !filter || doc.Collection == collection {
+			docs = append(docs, doc)
+		}
+	}
+	docs, next := paginate(docs, func(doc *index.Document) int { return doc.DocId }, p)
+
+	now := time.Now()
+	list := make([]gin.H, len(docs))
+	for i, doc := range docs {
+		list[i] = gin.H{
+			"doc_id":     doc.DocId,
+			"title":      doc.Title,
+			"collection": doc.Collection,
+			"url":        doc.URL,
+			"tags":       doc.Tags,
+			"source":     doc.Source,
+			"date":       doc.Date,
+			"expires_at": doc.ExpiresAt,
+			"expired":    doc.Expired(now),
+			"blocked":    doc.Blocked || s.blocklist.ContainsDocument(doc),
+			"hash":       doc.Hash,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"documents": list, "next_cursor": next})
+}
+
+// 列出已过期、不再参与检索的文档，传入 limit 时分页
+func (s *Server) expiredDocumentsHandler(c *gin.Context) {
+	p, ok := parsePage(c, 0)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	expired := []*index.Document{}
+	for _, doc := range s.currentPipeline().Store().Documents() {
+		if doc.Expired(now) {
+			expired = append(expired, doc)
+		}
+	}
+	expired, next := paginate(expired, func(doc *index.Document) int { return doc.DocId }, p)
+
+	docs := make([]gin.H, len(expired))
+	for i, doc := range expired {
+		docs[i] = gin.H{
+			"doc_id":     doc.DocId,
+			"title":      doc.Title,
+			"expires_at": doc.ExpiresAt,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"documents": docs, "next_cursor": next})
+}
+
+// 列出被屏蔽的文档，传入 limit 时分页
+func (s *Server) blocklistHandler(c *gin.Context) {
+	p, ok := parsePage(c, 0)
+	if !ok {
+		return
+	}
+	list, next := paginate(s.blocklist.List(), func(e *retrieval.BlockEntry) int { return e.DocId }, p)
+	c.JSON(http.StatusOK, gin.H{"blocklist": list, "next_cursor": next})
+}
+
+// 屏蔽文档，立即生效，无需重建索引
+func (s *Server) blockDocumentHandler(c *gin.Context) {
+	docId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid document id"})
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	// 请求体可以为空
+	if c.Request.ContentLength != 0 && c.ShouldBindJSON(&body) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+
+	entry, err := s.blocklist.Add(docId, body.Reason)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fmt.Printf("document %d blocked: %s\n", docId, body.Reason)
+	c.JSON(http.StatusOK, entry)
+}
+
+// 取消屏蔽文档
+func (s *Server) unblockDocumentHandler(c *gin.Context) {
+	docId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid document id"})
+		return
+	}
+	ok, err := s.blocklist.Remove(docId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not blocked"})
+		return
+	}
+	fmt.Printf("document %d unblocked\n", docId)
+	c.Status(http.StatusNoContent)
+}
+
+// 更新单篇文档的摘要并只重新向量化该摘要，便于逐篇调优检索效果。
+// 修改同时原子写入摘要文件，重新加载索引后仍然保留
+func (s *Server) updateSummaryHandler(c *gin.Context) {
+	docId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid document id"})
+		return
+	}
+	var body struct {
+		Summary string `json:"summary"`
+	}
+	err = c.ShouldBindJSON(&body)
+	if err != nil || strings.TrimSpace(body.Summary) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "summary is required"})
+		return
+	}
+
+	// 与重新加载互斥，避免更新落在即将被替换的索引上
+	if !s.reloading.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "reload in progress"})
+		return
+	}
+	defer s.reloading.Unlock()
+
+	pipeline := s.currentPipeline()
+	prev, ok := pipeline.Store().Get(docId)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": index.ErrDocumentNotFound.Error()})
+		return
+	}
+	// 拆分出的部分的摘要在加载时由原文档的摘要生成，无法单独保存
+	if prev.SplitFrom != 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("document %d was split from document %d; edit its summary in the summary file and reload", docId, prev.SplitFrom)})
+		return
+	}
+	// 先写入摘要清单，重新加载、重启和其他副本重建索引时沿用修改后的摘要
+	err = ingest.UpdateSummary(s.cfg.SummaryFile, docId, body.Summary)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	doc, err := pipeline.UpdateSummary(c.Request.Context(), docId, body.Summary)
+	if err != nil {
+		// 恢复摘要清单，使其与正在使用的索引一致
+		if restoreErr := ingest.UpdateSummary(s.cfg.SummaryFile, docId, prev.Summary); restoreErr != nil {
+			logging.Errorf("doc %d: restore summary: %v\n", docId, restoreErr)
+		}
+		if errors.Is(err, index.ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"doc_id":  doc.DocId,
+		"title":   doc.Title,
+		"summary": doc.Summary,
+	})
+}
+
+// 重新加载文档并重建索引，完成后原子替换正在使用的检索流水线。
+// 带 dry_run=true 参数时仅返回将要新增、更新和删除的文档，不做任何修改；
+// 带 async=true 参数时在后台重建并返回任务 ID，可通过 /admin/jobs/:id/events 查看进度
+func (s *Server) reloadIndexHandler(c *gin.Context) {
+	if !s.reloading.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "reload in progress"})
+		return
+	}
+	var lease lock.Lease
+	if c.Query("dry_run") != "true" {
+		// 多副本共享存储时只由一个副本重建，其余副本继续使用当前索引提供服务
+		var ok bool
+		lease, ok = s.lockReindex(c)
+		if !ok {
+			s.reloading.Unlock()
+			return
+		}
+		if c.Query("async") == "true" {
+			j := s.jobs.start("reindex")
+			go func() {
+				defer s.reloading.Unlock()
+				defer s.unlockReindex(lease)
+				s.reloadIndex(j, lease)
+			}()
+			c.JSON(http.StatusAccepted, gin.H{"job_id": j.Id, "events": "/admin/jobs/" + j.Id + "/events"})
+			return
+		}
+		defer s.unlockReindex(lease)
+	}
+	defer s.reloading.Unlock()
+
+	if c.Query("dry_run") == "true" {
+		docs, err := retrieval.LoadDocuments(s.cfg)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run": true,
+			"diff":    ingest.Diff(s.currentPipeline().Store().Documents(), docs),
+		})
+		return
+	}
+
+	start := time.Now()
+	tracker := retrieval.NewProgressTracker("reindex")
+	s.reindexProgress.Store(tracker)
+	ctx, cancel := reindexContext(context.Background(), lease)
+	defer cancel()
+	ctx = retrieval.WithProgress(ctx, func(event retrieval.ProgressEvent) {
+		tracker.Observe(event)
+	})
+	ctx = retrieval.WithPrevious(ctx, s.currentPipeline())
+	pipeline, err := retrieval.NewFromConfig(ctx, s.cfg)
+	err = lockError(ctx, err)
+	tracker.Finish(err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.docVersions.record(s.currentPipeline().Store().Documents(), pipeline.Store().Documents())
+	s.setPipeline(pipeline)
+	go s.publishSnapshots(pipeline)
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents": pipeline.Store().Len(),
+		"elapsed":   time.Since(start).String(),
+	})
+}
+
+// 在后台重建索引，通过任务事件报告进度，调用方需持有 reloading 锁和 lease（未配置分布式锁时为 nil），
+// 失去 lease 时取消重建
+func (s *Server) reloadIndex(j *job, lease lock.Lease) {
+	start := time.Now()
+	tracker := retrieval.NewProgressTracker("reindex")
+	s.reindexProgress.Store(tracker)
+	ctx, cancel := reindexContext(context.Background(), lease)
+	defer cancel()
+	ctx = retrieval.WithProgress(ctx, func(event retrieval.ProgressEvent) {
+		j.emit("lento.progress", tracker.Observe(event))
+	})
+	ctx = retrieval.WithPrevious(ctx, s.currentPipeline())
+	pipeline, err := retrieval.NewFromConfig(ctx, s.cfg)
+	err = lockError(ctx, err)
+	tracker.Finish(err)
+	if err != nil {
+		fmt.Println("reindex failed:", err)
+		j.finish("lento.error", gin.H{"error": err.Error()})
+		return
+	}
+	s.docVersions.record(s.currentPipeline().Store().Documents(), pipeline.Store().Documents())
+	s.setPipeline(pipeline)
+	go s.publishSnapshots(pipeline)
+	j.finish("lento.done", gin.H{
+		"documents": pipeline.Store().Len(),
+		"elapsed":   time.Since(start).String(),
+	})
+}
+
+// 返回正在进行或最近一次重建索引的进度和当前阶段的剩余时间估算
+func (s *Server) reindexProgressHandler(c *gin.Context) {
+	tracker := s.reindexProgress.Load()
+	if tracker == nil {
+		c.JSON(http.StatusOK, gin.H{"running": false})
+		return
+	}
+	c.JSON(http.StatusOK, tracker.Status())
+}
+
+// 按向量化模型统计向量，并列出磁盘上的快照文件及其孤立条目
+func (s *Server) vectorReportHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.currentPipeline().VectorReport())
+}
+
+// 删除不再使用的快照文件和快照中已删除文档的向量，带 dry_run=true 参数时只返回将要回收的内容
+func (s *Server) vectorGCHandler(c *gin.Context) {
+	// 与重新加载互斥，避免删除正在写入的快照；共享存储时也与其他副本的重建互斥
+	if !s.reloading.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "reload in progress"})
+		return
+	}
+	defer s.reloading.Unlock()
+	lease, ok := s.lockReindex(c)
+	if !ok {
+		return
+	}
+	defer s.unlockReindex(lease)
+
+	report, err := s.currentPipeline().CollectGarbage(c.Query("dry_run") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"rag_app/internal/retrieval"
+)
+
+// 回答缓存的键：模型、系统提示、引用格式、回答提示模板、提示模式、改写后的问题、预填充以及引用文档的哈希，
+// 任一引用文档重新索引后内容变化，键随之改变，旧的缓存自然失效
+func answerCacheKey(model, systemPrompt, citationFormat, promptTemplate, promptMode, question, prefill string, deterministic bool, result *retrieval.Result) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%t\x00", model, systemPrompt, citationFormat, promptTemplate, promptMode, question, prefill, deterministic)
+	for _, doc := range result.Docs {
+		fmt.Fprintf(h, "%d:%s\x00", doc.DocId, doc.Hash)
+	}
+	// 表格集合没有文档，以表结构说明区分不同的集合
+	if promptMode == PromptModeTable {
+		fmt.Fprintf(h, "%s\x00", result.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 合并检索的键：问题、集合、模型、是否确定性模式、会话记忆中的文档以及请求开关的检索功能
+func retrievalKey(req *retrieval.Request) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%t\x00", req.Question, req.Collection, req.Model, req.Deterministic)
+	for _, docId := range req.MemDocIds {
+		fmt.Fprintf(h, "%d\x00", docId)
+	}
+	fmt.Fprintf(h, "sources\x00")
+	for _, source := range req.Sources {
+		fmt.Fprintf(h, "%s\x00", source)
+	}
+	fmt.Fprintf(h, "compare\x00")
+	for _, docId := range req.CompareDocIds {
+		fmt.Fprintf(h, "%d\x00", docId)
+	}
+	fmt.Fprintf(h, "features\x00%t\x00%d\x00%s\x00", req.NoExpansion, req.PromptChunks, req.Hypothetical)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,381 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 新生成的 API Key 的前缀，以及用于查找和展示的前缀长度
+const (
+	apiKeyPrefix    = "sk-lento-"
+	apiKeyPrefixLen = len(apiKeyPrefix) + 8
+)
+
+// API Key 对应的客户端及其绑定的默认配置
+type APIKey struct {
+	// 明文 Key，兼容旧配置；通过管理接口修改后会转存为哈希
+	Key  string `json:"key,omitempty"`
+	Name string `json:"name"`
+	// 哈希存储的 Key，同一客户端可以同时有多个有效的 Key，便于轮换
+	Secrets []*KeySecret `json:"secrets,omitempty"`
+	// 默认检索的集合
+	Collection string `json:"collection,omitempty"`
+	// 系统提示模板，可引用 {{.SystemPrompt}}（客户端传入的系统提示）和 {{.Topic}}
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// 生成回答所用的模型，覆盖客户端传入的模型
+	Model string `json:"model,omitempty"`
+	// 每日和每月的上游 token 预算，0 表示使用全局配置
+	DailyTokenBudget   int64 `json:"daily_token_budget,omitempty"`
+	MonthlyTokenBudget int64 `json:"monthly_token_budget,omitempty"`
+	// 引用来源的呈现方式：inline、footnote、json 或 none，为空时使用全局配置
+	CitationFormat string `json:"citation_format,omitempty"`
+	// 按请求中 user 字段区分的终端用户每分钟请求数，0 表示使用全局配置
+	UserRateLimit int `json:"user_rate_limit,omitempty"`
+
+	promptTmpl *template.Template
+}
+
+// 以 SHA-256 哈希保存的 Key，Prefix 为 Key 的开头部分，用于查找和在管理接口中识别
+type KeySecret struct {
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (k *KeySecret) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Key 的可公开前缀。旧配置中的自定义 Key 只取前四分之一（至多 8 个字符），避免泄露过多内容
+func keyPrefix(key string) string {
+	if strings.HasPrefix(key, apiKeyPrefix) {
+		return key[:min(len(key), apiKeyPrefixLen)]
+	}
+	return key[:min(len(key)/4, 8)]
+}
+
+// API Key 存储，支持运行时新增和吊销 Key，修改后写回 API_KEYS_FILE
+type keyStore struct {
+	mu      sync.RWMutex
+	path    string
+	clients []*APIKey
+}
+
+// 从 JSON 文件加载 API Key 列表
+func loadAPIKeys(path string) (*keyStore, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*APIKey
+	err = json.Unmarshal(buf, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	names := map[string]bool{}
+	for i, k := range keys {
+		if k.Key == "" && len(k.Secrets) == 0 {
+			return nil, fmt.Errorf("%s: key %d is empty", path, i)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("%s: duplicate key name %q", path, k.Name)
+		}
+		names[k.Name] = true
+		err = validateCitationFormat(k.CitationFormat)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, k.Name, err)
+		}
+		if k.SystemPrompt != "" {
+			k.promptTmpl, err = template.New(k.Name).Parse(k.SystemPrompt)
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %q: %w", path, k.Name, err)
+			}
+		}
+	}
+
+	return &keyStore{path: path, clients: keys}, nil
+}
+
+// 按 Key 查找客户端，已过期的 Key 视为无效
+func (ks *keyStore) authenticate(token string, now time.Time) *APIKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	hash := []byte(hashKey(token))
+	prefix := keyPrefix(token)
+	for _, k := range ks.clients {
+		if k.Key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(k.Key)) == 1 {
+			return k
+		}
+		for _, secret := range k.Secrets {
+			if secret.Prefix == prefix && !secret.expired(now) &&
+				subtle.ConstantTimeCompare(hash, []byte(secret.Hash)) == 1 {
+				return k
+			}
+		}
+	}
+	return nil
+}
+
+// 为客户端生成新的 Key，返回明文 Key，明文只在此时可见
+func (ks *keyStore) create(name string, expiresAt *time.Time) (string, *KeySecret, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	token := apiKeyPrefix + hex.EncodeToString(buf)
+	secret := &KeySecret{
+		Prefix:    keyPrefix(token),
+		Hash:      hashKey(token),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	client := ks.find(name)
+	if client == nil {
+		return "", nil, errKeyNotFound
+	}
+	client.Secrets = append(client.Secrets, secret)
+	err = ks.save()
+	if err != nil {
+		// 未能写回文件时撤回新 Key，调用方收到错误后该 Key 不能继续使用
+		client.Secrets = slices.DeleteFunc(client.Secrets, func(s *KeySecret) bool { return s == secret })
+		return "", nil, err
+	}
+	return token, secret, nil
+}
+
+// 吊销前缀对应的 Key；prefix 也可以是旧配置中明文 Key 的前缀
+func (ks *keyStore) revoke(name, prefix string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	client := ks.find(name)
+	if client == nil {
+		return errKeyNotFound
+	}
+	found := false
+	if client.Key != "" && keyPrefix(client.Key) == prefix {
+		client.Key = ""
+		found = true
+	}
+	secrets := client.Secrets[:0]
+	for _, secret := range client.Secrets {
+		if secret.Prefix == prefix {
+			found = true
+			continue
+		}
+		secrets = append(secrets, secret)
+	}
+	client.Secrets = secrets
+	if !found {
+		return errKeyNotFound
+	}
+	return ks.save()
+}
+
+var errKeyNotFound = errors.New("api key not found")
+
+func (ks *keyStore) find(name string) *APIKey {
+	for _, k := range ks.clients {
+		if k.Name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+// 写回 API Key 文件，明文 Key 转为哈希存储。调用方需持有写锁
+func (ks *keyStore) save() error {
+	for _, k := range ks.clients {
+		if k.Key != "" {
+			k.Secrets = append(k.Secrets, &KeySecret{
+				Prefix:    keyPrefix(k.Key),
+				Hash:      hashKey(k.Key),
+				CreatedAt: time.Now().UTC(),
+			})
+			k.Key = ""
+		}
+	}
+
+	buf, err := json.MarshalIndent(ks.clients, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(ks.path), filepath.Base(ks.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), ks.path)
+}
+
+// 客户端的 Key 概要，不含哈希
+type keyInfo struct {
+	Name       string      `json:"name"`
+	Collection string      `json:"collection,omitempty"`
+	Model      string      `json:"model,omitempty"`
+	Keys       []keyStatus `json:"keys"`
+}
+
+type keyStatus struct {
+	Prefix    string     `json:"prefix"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+	// 是否为旧配置中的明文 Key
+	Plaintext bool `json:"plaintext,omitempty"`
+}
+
+func (ks *keyStore) list(now time.Time) []keyInfo {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	infos := []keyInfo{}
+	for _, k := range ks.clients {
+		info := keyInfo{Name: k.Name, Collection: k.Collection, Model: k.Model, Keys: []keyStatus{}}
+		if k.Key != "" {
+			info.Keys = append(info.Keys, keyStatus{Prefix: keyPrefix(k.Key), Plaintext: true})
+		}
+		for _, secret := range k.Secrets {
+			info.Keys = append(info.Keys, keyStatus{
+				Prefix:    secret.Prefix,
+				CreatedAt: &secret.CreatedAt,
+				ExpiresAt: secret.ExpiresAt,
+				Expired:   secret.expired(now),
+			})
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// 按模板生成系统提示，未配置模板时原样返回客户端的系统提示
+func (k *APIKey) renderSystemPrompt(systemPrompt, topic string) (string, error) {
+	if k == nil || k.promptTmpl == nil {
+		return systemPrompt, nil
+	}
+
+	var sb strings.Builder
+	err := k.promptTmpl.Execute(&sb, map[string]string{
+		"SystemPrompt": systemPrompt,
+		"Topic":        topic,
+	})
+	return sb.String(), err
+}
+
+// 校验请求携带的 API Key，未配置 API_KEYS_FILE 时不做校验
+func (s *Server) apiKeyAuth(c *gin.Context) {
+	if s.apiKeys == nil {
+		c.Next()
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if k := s.apiKeys.authenticate(token, time.Now()); k != nil {
+		c.Set("apiKey", k)
+		c.Next()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+}
+
+// 返回当前请求的 API Key，未开启校验时返回 nil
+func apiKeyFrom(c *gin.Context) *APIKey {
+	if v, ok := c.Get("apiKey"); ok {
+		return v.(*APIKey)
+	}
+	return nil
+}
+
+// 列出各客户端的 Key 前缀、创建和过期时间
+func (s *Server) listKeysHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"clients": s.apiKeys.list(time.Now())})
+}
+
+// 为客户端生成新 Key，可通过 expires_in（如 "720h"）设置有效期。旧 Key 在吊销前仍然有效
+func (s *Server) createKeyHandler(c *gin.Context) {
+	var body struct {
+		ExpiresIn string `json:"expires_in"`
+	}
+	if c.Request.ContentLength != 0 {
+		err := c.ShouldBindJSON(&body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var expiresAt *time.Time
+	if body.ExpiresIn != "" {
+		d, err := time.ParseDuration(body.ExpiresIn)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expires_in"})
+			return
+		}
+		t := time.Now().UTC().Add(d)
+		expiresAt = &t
+	}
+
+	token, secret, err := s.apiKeys.create(c.Param("name"), expiresAt)
+	if errors.Is(err, errKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"name":       c.Param("name"),
+		"key":        token,
+		"prefix":     secret.Prefix,
+		"created_at": secret.CreatedAt,
+		"expires_at": secret.ExpiresAt,
+	})
+}
+
+// 吊销客户端的一个 Key，立即生效
+func (s *Server) revokeKeyHandler(c *gin.Context) {
+	err := s.apiKeys.revoke(c.Param("name"), c.Param("prefix"))
+	if errors.Is(err, errKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": c.Param("prefix")})
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKeys(t *testing.T, keys []*APIKey) string {
+	t.Helper()
+	buf, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	err = os.WriteFile(path, buf, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	path := writeKeys(t, []*APIKey{
+		{Name: "legacy", Key: "plaintext-legacy-key"},
+		{Name: "hashed", Secrets: []*KeySecret{
+			{Prefix: keyPrefix("sk-lento-0123456789abcdef"), Hash: hashKey("sk-lento-0123456789abcdef")},
+			{Prefix: keyPrefix("sk-lento-fedcba9876543210"), Hash: hashKey("sk-lento-fedcba9876543210"), ExpiresAt: &past},
+			{Prefix: keyPrefix("sk-lento-aaaabbbbccccdddd"), Hash: hashKey("sk-lento-aaaabbbbccccdddd"), ExpiresAt: &future},
+		}},
+	})
+	ks, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"plaintext", "plaintext-legacy-key", "legacy"},
+		{"hash match", "sk-lento-0123456789abcdef", "hashed"},
+		{"wrong key with same prefix", "sk-lento-0123456789abcdee", ""},
+		{"expired", "sk-lento-fedcba9876543210", ""},
+		{"not yet expired", "sk-lento-aaaabbbbccccdddd", "hashed"},
+		{"hash as token", hashKey("sk-lento-0123456789abcdef"), ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if k := ks.authenticate(tt.token, now); k != nil {
+				got = k.Name
+			}
+			if got != tt.want {
+				t.Errorf("authenticate(%q) = %q, want %q", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+// 通过管理接口修改后，明文 Key 转为哈希存储，文件中不再有明文，原 Key 仍然有效
+func TestCreateMigratesPlaintextKeys(t *testing.T) {
+	path := writeKeys(t, []*APIKey{{Name: "legacy", Key: "plaintext-legacy-key"}})
+	ks, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := ks.create("legacy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := reloaded.clients[0]; k.Key != "" || len(k.Secrets) != 2 {
+		t.Fatalf("saved key = %+v, want two hashed secrets and no plaintext", k)
+	}
+	for _, token := range []string{"plaintext-legacy-key", token} {
+		if k := reloaded.authenticate(token, time.Now()); k == nil || k.Name != "legacy" {
+			t.Errorf("authenticate(%q) after migration = %v", token, k)
+		}
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	path := writeKeys(t, []*APIKey{{Name: "legacy", Key: "plaintext-legacy-key"}})
+	ks, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, secret, err := ks.create("legacy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ks.revoke("legacy", secret.Prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := ks.authenticate(token, time.Now()); k != nil {
+		t.Error("revoked key still authenticates")
+	}
+	if k := ks.authenticate("plaintext-legacy-key", time.Now()); k == nil {
+		t.Error("revoking one key revoked the other")
+	}
+	err = ks.revoke("legacy", keyPrefix("plaintext-legacy-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := ks.authenticate("plaintext-legacy-key", time.Now()); k != nil {
+		t.Error("revoked migrated key still authenticates")
+	}
+	if err := ks.revoke("legacy", secret.Prefix); err != errKeyNotFound {
+		t.Errorf("revoking twice: error = %v, want errKeyNotFound", err)
+	}
+}
+
+// 写回文件失败时新 Key 不生效
+func TestCreateRollsBackWhenSaveFails(t *testing.T) {
+	path := writeKeys(t, []*APIKey{{Name: "acme", Secrets: []*KeySecret{{Prefix: "p", Hash: "h"}}}})
+	ks, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.path = filepath.Join(t.TempDir(), "missing", "keys.json")
+	_, _, err = ks.create("acme", nil)
+	if err == nil {
+		t.Fatal("create() succeeded although the key file could not be written")
+	}
+	if n := len(ks.clients[0].Secrets); n != 1 {
+		t.Errorf("client has %d secrets after a failed create, want 1", n)
+	}
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/retrieval"
+)
+
+// 检查默认生成后端：列出模型确认 MODEL_WITHOUT_THINKING 是否存在，再发送一个只生成 1 个 token 的请求
+func (s *Server) checkLlm(ctx context.Context) retrieval.BackendCheck {
+	check := retrieval.BackendCheck{Backend: "llm", Model: s.cfg.ModelWithoutThinking}
+	models, err := s.llm.ListModels(ctx)
+	if err == nil && len(models.Models) > 0 {
+		listed := slices.ContainsFunc(models.Models, func(m openai.Model) bool { return m.ID == check.Model })
+		check.Listed = &listed
+	}
+	return retrieval.RunBackendCheck(ctx, check, func(ctx context.Context) error {
+		_, err := s.llm.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:     check.Model,
+			Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ping"}},
+			MaxTokens: 1,
+		})
+		return err
+	})
+}
+
+// 检查模型别名的生成后端：发送一个只生成 1 个 token 的流式请求并读完
+func (s *Server) checkAlias(ctx context.Context, alias string) retrieval.BackendCheck {
+	generator, model := s.gens.Resolve(alias)
+	check := retrieval.BackendCheck{Backend: "llm", Model: model, Scope: alias}
+	return retrieval.RunBackendCheck(ctx, check, func(ctx context.Context) error {
+		stream, err := generator.Stream(ctx, openai.ChatCompletionRequest{
+			Model:     model,
+			Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ping"}},
+			MaxTokens: 1,
+			Stream:    true,
+		})
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		for {
+			_, err = stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// 检查全部已配置的后端是否可以连通、模型是否可用，并返回各自的耗时。
+// 每个后端只发送一个极小的请求，仍会产生少量上游用量
+func (s *Server) checkBackendsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	aliases := s.gens.Aliases()
+	llmChecks := make([]retrieval.BackendCheck, 1+len(aliases))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		llmChecks[0] = s.checkLlm(ctx)
+	}()
+	for i, alias := range aliases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			llmChecks[i+1] = s.checkAlias(ctx, alias)
+		}()
+	}
+	checks := s.currentPipeline().CheckBackends(ctx)
+	wg.Wait()
+
+	checks = append(llmChecks, checks...)
+	ok := !slices.ContainsFunc(checks, func(check retrieval.BackendCheck) bool { return !check.Ok })
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"ok": ok, "backends": checks})
+}
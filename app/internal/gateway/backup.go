@@ -0,0 +1,265 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/backup"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 备份文件格式的版本，字段有不兼容的修改时递增
+const backupSchemaVersion = 1
+
+// 单次备份的超时时间
+const backupTimeout = 10 * time.Minute
+
+// 一次查询的摘要，供备份导出
+type queryRecord struct {
+	Time       time.Time `json:"time"`
+	RequestId  string    `json:"request_id"`
+	TraceId    string    `json:"trace_id,omitempty"`
+	Tenant     string    `json:"tenant"`
+	Collection string    `json:"collection"`
+	Model      string    `json:"model"`
+	// QUERY_LOG_MODE=hashed 时为问题的哈希
+	Question   string   `json:"question"`
+	DocIds     []int    `json:"doc_ids"`
+	TopScore   *float32 `json:"top_score,omitempty"`
+	DurationMs float64  `json:"duration_ms"`
+}
+
+// 保留最近若干条查询记录的环形缓冲区
+type queryLog struct {
+	mu    sync.Mutex
+	size  int
+	items []*queryRecord
+	next  int
+}
+
+func (l *queryLog) add(r *queryRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.items) < l.size {
+		l.items = append(l.items, r)
+		return
+	}
+	l.items[l.next] = r
+	l.next = (l.next + 1) % l.size
+}
+
+// 按时间从旧到新返回结束时间在 (from, to] 范围内的记录
+func (l *queryLog) between(from, to time.Time) []*queryRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	records := []*queryRecord{}
+	for i := range l.items {
+		r := l.items[(l.next+i)%len(l.items)]
+		if r.Time.After(from) && !r.Time.After(to) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// 备份中的一个文件
+type backupFile struct {
+	Name   string `json:"name"`
+	Rows   int    `json:"rows"`
+	Sha256 string `json:"sha256"`
+}
+
+// 备份清单，在全部数据文件写入后最后写入，存在清单即表示该次备份完整
+type backupManifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	SnapshotId    string    `json:"snapshot_id"`
+	SnapshotAt    time.Time `json:"snapshot_at"`
+	// 查询和反馈记录的起始时间（不含），即上一次成功备份的 snapshot_at，首次备份为空
+	From         *time.Time   `json:"from,omitempty"`
+	ConfigHash   string       `json:"config_hash"`
+	IndexVersion string       `json:"index_version"`
+	Files        []backupFile `json:"files"`
+}
+
+// 定期将查询记录、反馈、诊断记录、用量和集合统计导出到对象存储
+type backupRunner struct {
+	store    backup.Store
+	queries  *queryLog
+	interval time.Duration
+	// 同一时间只进行一次备份
+	running sync.Mutex
+	mu      sync.Mutex
+	last    time.Time
+	// 最近一次备份的清单和错误
+	manifest *backupManifest
+	err      error
+}
+
+func newBackupRunner(store backup.Store, interval time.Duration, queryLogSize int) *backupRunner {
+	return &backupRunner{
+		store:    store,
+		queries:  &queryLog{size: max(queryLogSize, 1)},
+		interval: interval,
+	}
+}
+
+// 请求结束时记录查询摘要，未配置备份时不记录
+func (s *Server) logQuery(ctx context.Context, start time.Time, tenant, collection, model, question string, result *retrieval.Result) {
+	if s.backups == nil || result == nil {
+		return
+	}
+	r := &queryRecord{
+		Time:       time.Now(),
+		RequestId:  provider.RequestId(ctx),
+		TraceId:    provider.TraceId(ctx),
+		Tenant:     tenant,
+		Collection: collection,
+		Model:      model,
+		Question:   s.questions.question(question),
+		DocIds:     result.DocIds,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if top, ok := result.TopRerankScore(); ok {
+		r.TopScore = &top
+	}
+	s.backups.queries.add(r)
+}
+
+// 按配置的间隔定期备份
+func (s *Server) runBackups() {
+	for range time.Tick(s.backups.interval) {
+		_, err := s.backup(context.Background())
+		if err != nil {
+			logging.Errorf("backup failed: %v\n", err)
+		}
+	}
+}
+
+// 在同一时间点截取各项数据并写入对象存储。查询和反馈记录只导出上一次成功备份之后的部分，
+// 诊断记录、用量和集合统计每次导出全部
+func (s *Server) backup(ctx context.Context) (*backupManifest, error) {
+	b := s.backups
+	b.running.Lock()
+	defer b.running.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, backupTimeout)
+	defer cancel()
+
+	b.mu.Lock()
+	from := b.last
+	b.mu.Unlock()
+	at := time.Now().UTC()
+	manifest := &backupManifest{
+		SchemaVersion: backupSchemaVersion,
+		SnapshotId:    at.Format("20060102T150405.000Z"),
+		SnapshotAt:    at,
+		ConfigHash:    s.configHash,
+		IndexVersion:  s.currentPipeline().IndexVersion(),
+	}
+	if !from.IsZero() {
+		manifest.From = &from
+	}
+
+	// 诊断记录含有提示词和回答原文，只保存哈希时不导出
+	captures := []*capture{}
+	for _, c := range s.captures.list("") {
+		if !c.Time.After(at) && !s.questions.hashed() {
+			captures = append(captures, c)
+		}
+	}
+	files := []struct {
+		name string
+		rows any
+	}{
+		{"queries.jsonl", b.queries.between(from, at)},
+		{"feedback.jsonl", s.prompts.feedbacksBetween(from, at)},
+		{"captures.jsonl", captures},
+		{"usage.jsonl", s.ledger.Daily("", time.Time{}, at)},
+		{"collection_stats.jsonl", s.stats.rows(at, statsRetentionDays, 10)},
+	}
+
+	prefix := at.Format("2006/01/02") + "/" + manifest.SnapshotId + "/"
+	for _, f := range files {
+		buf, n, err := jsonLines(f.rows)
+		if err != nil {
+			return nil, err
+		}
+		err = b.store.Put(ctx, prefix+f.name, buf)
+		if err != nil {
+			return nil, b.fail(err)
+		}
+		sum := sha256.Sum256(buf)
+		manifest.Files = append(manifest.Files, backupFile{Name: f.name, Rows: n, Sha256: hex.EncodeToString(sum[:])})
+	}
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = b.store.Put(ctx, prefix+"manifest.json", buf)
+	if err != nil {
+		return nil, b.fail(err)
+	}
+
+	b.mu.Lock()
+	b.last, b.manifest, b.err = at, manifest, nil
+	b.mu.Unlock()
+	logging.Infof("backup %s written to %s\n", manifest.SnapshotId, b.store)
+	return manifest, nil
+}
+
+func (b *backupRunner) fail(err error) error {
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+	return err
+}
+
+// 每行一个 JSON 对象，返回内容和行数
+func jsonLines(rows any) ([]byte, int, error) {
+	buf, err := json.Marshal(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	var items []json.RawMessage
+	err = json.Unmarshal(buf, &items)
+	if err != nil {
+		return nil, 0, err
+	}
+	var out bytes.Buffer
+	for _, item := range items {
+		out.Write(item)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), len(items), nil
+}
+
+// 立即进行一次备份，返回其清单
+func (s *Server) createBackupHandler(c *gin.Context) {
+	manifest, err := s.backup(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, manifest)
+}
+
+// 最近一次备份的清单和错误
+func (s *Server) backupStatusHandler(c *gin.Context) {
+	b := s.backups
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := gin.H{"store": b.store.String(), "interval": b.interval.String(), "last": b.manifest}
+	if b.err != nil {
+		status["error"] = b.err.Error()
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/cache"
+)
+
+// 默认的回答提示模板名，未配置模板文件时只有这一个模板
+const defaultPromptTemplate = "default"
+
+// 默认的回答提示模板
+var defaultPrompt = template.Must(template.New(defaultPromptTemplate).Parse(
+	"请根据以下检索到的信息，回答用户的原始问题：{{.Question}}\n\n{{.Context}}{{.Instruction}}"))
+
+// 记录每个请求所用模板的数量上限，超出后最早的请求无法再反馈
+const servedPromptSize = 10000
+
+// 回答提示模板的参数
+type promptData struct {
+	Question    string
+	Context     string
+	Instruction string
+}
+
+// 单个模板的服务次数和反馈得分
+type promptArm struct {
+	Name string `json:"name"`
+	// 模板内容的指纹，修改模板后随之改变
+	Version   string  `json:"version"`
+	Served    int64   `json:"served"`
+	Feedbacks int64   `json:"feedbacks"`
+	ScoreSum  float64 `json:"score_sum"`
+	MeanScore float64 `json:"mean_score"`
+}
+
+// 以 epsilon-greedy 多臂老虎机在多个回答提示模板中选择：以 epsilon 的概率随机选择模板，
+// 否则选择平均反馈得分最高的模板，尚未收到反馈的模板优先，保证每个模板都被尝试
+type promptBandit struct {
+	mu        sync.Mutex
+	epsilon   float64
+	templates map[string]*template.Template
+	names     []string
+	arms      map[string]*promptArm
+	rand      *rand.Rand
+	// 请求 ID -> 所用模板，用于将反馈归到对应模板
+	served *cache.LRU[string]
+	// 最近的反馈记录，按时间排序，最多 servedPromptSize 条，供备份导出
+	feedbacks []feedbackRecord
+}
+
+// 一次反馈
+type feedbackRecord struct {
+	Time      time.Time `json:"time"`
+	RequestId string    `json:"request_id"`
+	Template  string    `json:"template"`
+	Version   string    `json:"version"`
+	Score     float64   `json:"score"`
+}
+
+// 加载回答提示模板，JSON 文件的键为模板名，值为 text/template 模板，
+// 可用 .Question、.Context、.Instruction；path 为空时只有默认模板
+func loadPromptTemplates(path string) (map[string]*template.Template, error) {
+	if path == "" {
+		return map[string]*template.Template{defaultPromptTemplate: defaultPrompt}, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	texts := map[string]string{}
+	err = json.Unmarshal(buf, &texts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("%s: no templates", path)
+	}
+
+	templates := make(map[string]*template.Template, len(texts))
+	for name, text := range texts {
+		tmpl, err := template.New(name).Parse(text)
+		if err == nil {
+			// 引用了不存在的字段时执行才会出错，加载时先试渲染一次
+			_, err = renderPrompt(tmpl, "", "", "")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: template %q: %w", path, name, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+func newPromptBandit(templates map[string]*template.Template, epsilon float64) *promptBandit {
+	b := &promptBandit{
+		epsilon:   epsilon,
+		templates: templates,
+		arms:      make(map[string]*promptArm, len(templates)),
+		rand:      rand.New(rand.NewSource(rand.Int63())),
+		served:    cache.NewLRU[string](servedPromptSize, 0),
+	}
+	for name := range templates {
+		b.names = append(b.names, name)
+		b.arms[name] = &promptArm{Name: name, Version: templateVersion(templates[name])}
+	}
+	slices.Sort(b.names)
+	return b
+}
+
+// 模板版本：解析后模板内容的哈希
+func templateVersion(tmpl *template.Template) string {
+	sum := sha256.Sum256([]byte(tmpl.Root.String()))
+	return hex.EncodeToString(sum[:4])
+}
+
+// 模板名及其版本，如 default@1a2b3c4d
+func (b *promptBandit) version(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return name + "@" + b.arms[name].Version
+}
+
+// 为请求选择模板并记录，确定性模式下不做随机探索
+func (b *promptBandit) choose(requestId string, deterministic bool) (string, *template.Template) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := ""
+	if !deterministic && len(b.names) > 1 && b.rand.Float64() < b.epsilon {
+		name = b.names[b.rand.Intn(len(b.names))]
+	} else {
+		best := -1.0
+		for _, n := range b.names {
+			arm := b.arms[n]
+			if arm.Feedbacks == 0 && !deterministic {
+				name = n
+				break
+			}
+			if mean := arm.mean(); name == "" || mean > best {
+				name, best = n, mean
+			}
+		}
+	}
+	b.arms[name].Served++
+	if requestId != "" {
+		b.served.Set(requestId, name)
+	}
+	return name, b.templates[name]
+}
+
+// 记录请求的反馈得分，返回其所用的模板；请求未知或已反馈过时返回 false
+func (b *promptBandit) feedback(requestId string, score float64) (string, bool) {
+	name, ok := b.served.Get(requestId)
+	if !ok {
+		return "", false
+	}
+	b.served.Delete(requestId)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	arm := b.arms[name]
+	arm.Feedbacks++
+	arm.ScoreSum += score
+	if len(b.feedbacks) >= servedPromptSize {
+		b.feedbacks = slices.Delete(b.feedbacks, 0, len(b.feedbacks)-servedPromptSize+1)
+	}
+	b.feedbacks = append(b.feedbacks, feedbackRecord{
+		Time:      time.Now(),
+		RequestId: requestId,
+		Template:  name,
+		Version:   arm.Version,
+		Score:     score,
+	})
+	return name, true
+}
+
+// (from, to] 时间范围内的反馈记录
+func (b *promptBandit) feedbacksBetween(from, to time.Time) []feedbackRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	records := []feedbackRecord{}
+	for _, r := range b.feedbacks {
+		if r.Time.After(from) && !r.Time.After(to) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// 各模板的统计
+func (b *promptBandit) report() []promptArm {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	arms := make([]promptArm, 0, len(b.names))
+	for _, n := range b.names {
+		arm := *b.arms[n]
+		arm.MeanScore = arm.mean()
+		arms = append(arms, arm)
+	}
+	return arms
+}
+
+func (a *promptArm) mean() float64 {
+	if a.Feedbacks == 0 {
+		return 0
+	}
+	return a.ScoreSum / float64(a.Feedbacks)
+}
+
+// 渲染回答提示，tmpl 为 nil 时使用默认模板
+func renderPrompt(tmpl *template.Template, question, content, instruction string) (string, error) {
+	if tmpl == nil {
+		tmpl = defaultPrompt
+	}
+	var sb strings.Builder
+	err := tmpl.Execute(&sb, &promptData{Question: question, Context: content, Instruction: instruction})
+	return sb.String(), err
+}
+
+// 对一次回答的反馈，request_id 为响应头中的 X-Request-ID，score 取值 0~1
+type feedbackRequest struct {
+	RequestId string   `json:"request_id"`
+	Score     *float64 `json:"score"`
+}
+
+// 接收用户对回答的反馈，作为所用回答提示模板的得分
+func (s *Server) feedbackHandler(c *gin.Context) {
+	var body feedbackRequest
+	err := c.ShouldBindJSON(&body)
+	if err != nil || body.RequestId == "" || body.Score == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request_id and score are required"})
+		return
+	}
+	if *body.Score < 0 || *body.Score > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "score must be between 0 and 1"})
+		return
+	}
+	name, ok := s.prompts.feedback(body.RequestId, *body.Score)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown request_id or feedback already recorded"})
+		return
+	}
+	fmt.Printf("feedback: request %s template %s score %g\n", body.RequestId, name, *body.Score)
+	c.JSON(http.StatusOK, gin.H{"request_id": body.RequestId, "template": name})
+}
+
+// 列出各回答提示模板的服务次数和平均反馈得分
+func (s *Server) promptTemplatesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"epsilon": s.prompts.epsilon, "templates": s.prompts.report()})
+}
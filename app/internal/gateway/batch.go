@@ -0,0 +1,469 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/hooks"
+	"rag_app/internal/logging"
+	"rag_app/internal/retrieval"
+	"rag_app/internal/tokens"
+)
+
+// 保留的最近批量任务数
+const maxBatches = 100
+
+// 回调失败时的重试次数及单次超时
+const (
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+)
+
+// 批量任务及其中问题的状态
+const (
+	batchQueued    = "queued"
+	batchRunning   = "running"
+	batchCompleted = "completed"
+	batchFailed    = "failed"
+)
+
+// 回调事件
+const (
+	eventAnswerCompleted = "answer.completed"
+	eventBatchCompleted  = "batch.completed"
+)
+
+type batchRequest struct {
+	Model        string   `json:"model"`
+	SystemPrompt string   `json:"system_prompt"`
+	Questions    []string `json:"questions"`
+	// 生成回答使用的模型别名，通常指向更便宜、更慢的后端，避免与在线请求争抢。
+	// 为空时使用 BATCH_GENERATION_MODEL，仍为空时使用 model
+	GenerationModel string `json:"generation_model"`
+	// 每个问题完成及整个任务完成时推送结果的地址，为空时只能轮询
+	CallbackUrl string `json:"callback_url"`
+}
+
+type batchUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// 批量任务中的一个问题
+type batchItem struct {
+	Index     int                  `json:"index"`
+	Question  string               `json:"question"`
+	Status    string               `json:"status"`
+	Answer    string               `json:"answer,omitempty"`
+	Citations []retrieval.Citation `json:"citations,omitempty"`
+	Usage     *batchUsage          `json:"usage,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// 异步执行的批量问答任务，问题按顺序排队回答
+type batch struct {
+	Id    string `json:"id"`
+	Model string `json:"model"`
+	// 生成回答使用的模型，为空表示与 model 相同
+	GenerationModel string       `json:"generation_model,omitempty"`
+	CallbackUrl     string       `json:"callback_url,omitempty"`
+	CreatedAt       time.Time    `json:"created_at"`
+	Status          string       `json:"status"`
+	Items           []*batchItem `json:"items"`
+
+	mu           sync.Mutex
+	tenant       string
+	apiKey       *APIKey
+	systemPrompt string
+}
+
+// 返回任务当前状态的快照
+func (b *batch) snapshot() *batch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snap := &batch{
+		Id:              b.Id,
+		Model:           b.Model,
+		GenerationModel: b.GenerationModel,
+		CallbackUrl:     b.CallbackUrl,
+		CreatedAt:       b.CreatedAt,
+		Status:          b.Status,
+		Items:           make([]*batchItem, len(b.Items)),
+	}
+	for i, item := range b.Items {
+		copied := *item
+		snap.Items[i] = &copied
+	}
+	return snap
+}
+
+type batchStore struct {
+	mu      sync.Mutex
+	batches map[string]*batch
+	order   []string
+}
+
+func newBatchStore() *batchStore {
+	return &batchStore{batches: make(map[string]*batch)}
+}
+
+func (s *batchStore) add(b *batch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[b.Id] = b
+	s.order = append(s.order, b.Id)
+	if len(s.order) > maxBatches {
+		delete(s.batches, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *batchStore) get(id string) (*batch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.batches[id]
+	return b, ok
+}
+
+// 校验回调地址，只允许 http 和 https。配置了 BATCH_CALLBACK_HOSTS 时主机必须在其中，
+// 否则不允许回环、链路本地和内网地址
+func (s *Server) validateCallbackUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("callback_url must be an absolute http(s) URL")
+	}
+	if len(s.cfg.BatchCallbackHosts) > 0 {
+		if !s.callbackHostAllowed(u.Hostname()) {
+			return fmt.Errorf("callback_url host %q is not in BATCH_CALLBACK_HOSTS", u.Hostname())
+		}
+		return nil
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return fmt.Errorf("callback_url host %s is not a public address", ip)
+	}
+	return nil
+}
+
+// 主机是否在 BATCH_CALLBACK_HOSTS 中，这些主机可以解析到内网地址
+func (s *Server) callbackHostAllowed(host string) bool {
+	return slices.ContainsFunc(s.cfg.BatchCallbackHosts, func(allowed string) bool {
+		return strings.EqualFold(allowed, host)
+	})
+}
+
+// 是否为公网地址：排除回环、内网、链路本地、组播和未指定地址
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// 推送回调的客户端。不在 BATCH_CALLBACK_HOSTS 中的主机只能连接公网地址，
+// 在建立连接时检查解析后的地址，域名解析到内网地址（包括 DNS 重绑定）时拒绝连接
+func (s *Server) webhookClient(host string) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !s.callbackHostAllowed(host) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if parsed := net.ParseIP(ip); parsed == nil || !publicIP(parsed) {
+				return fmt.Errorf("callback address %s is not a public address", ip)
+			}
+			return nil
+		}
+	}
+	// 直接连接回调地址，不经过代理，也不跟随重定向，以免绕过地址检查
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// 提交批量问答任务，立即返回任务 ID。问题在后台依次回答，
+// 配置了 callback_url 时每个问题完成后推送其回答、引用和用量，全部完成后再推送一次汇总
+func (s *Server) createBatchHandler(c *gin.Context) {
+	var body batchRequest
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(body.Questions) == 0 || len(body.Questions) > s.cfg.BatchMaxQuestions {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("questions must contain 1 to %d items", s.cfg.BatchMaxQuestions)})
+		return
+	}
+	if body.CallbackUrl != "" {
+		if err := s.validateCallbackUrl(body.CallbackUrl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	apiKey := apiKeyFrom(c)
+	tenant := tenantOf(apiKey)
+	if !s.checkBudget(c, tenant, apiKey) {
+		return
+	}
+	model := body.Model
+	if apiKey != nil && apiKey.Model != "" {
+		model = apiKey.Model
+	}
+	if model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+	// 绑定了模型的 API Key 不能自选生成模型，只使用 BATCH_GENERATION_MODEL
+	generationModel := s.cfg.BatchGenerationModel
+	if body.GenerationModel != "" && (apiKey == nil || apiKey.Model == "") {
+		if !s.gens.HasAlias(body.GenerationModel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("generation_model %q is not a configured model alias", body.GenerationModel)})
+			return
+		}
+		generationModel = body.GenerationModel
+	}
+	collection := ""
+	if apiKey != nil {
+		collection = apiKey.Collection
+	}
+	systemPrompt, err := s.renderSystemPrompt(apiKey, s.collections.get(collection), body.SystemPrompt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	b := &batch{
+		Id:              hex.EncodeToString(buf),
+		Model:           model,
+		GenerationModel: generationModel,
+		CallbackUrl:     body.CallbackUrl,
+		CreatedAt:       time.Now(),
+		Status:          batchQueued,
+		tenant:          tenant,
+		apiKey:          apiKey,
+		systemPrompt:    systemPrompt,
+	}
+	for i, q := range body.Questions {
+		b.Items = append(b.Items, &batchItem{Index: i, Question: q, Status: batchQueued})
+	}
+	s.batches.add(b)
+	go s.runBatch(b)
+
+	c.JSON(http.StatusAccepted, gin.H{"batch_id": b.Id, "status": "/v1/batches/" + b.Id})
+}
+
+// 查询批量任务的状态和已完成的回答，只能查询本租户的任务
+func (s *Server) batchHandler(c *gin.Context) {
+	b, ok := s.batches.get(c.Param("id"))
+	if !ok || b.tenant != tenantOf(apiKeyFrom(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "batch not found"})
+		return
+	}
+	c.JSON(http.StatusOK, b.snapshot())
+}
+
+// 依次回答任务中的问题，同时回答的问题数受 BATCH_CONCURRENCY 限制，避免与在线请求争抢后端
+func (s *Server) runBatch(b *batch) {
+	b.mu.Lock()
+	b.Status = batchRunning
+	b.mu.Unlock()
+
+	failed := 0
+	var budgetErr error
+	for _, item := range b.Items {
+		s.batchSlots <- struct{}{}
+		// 每个问题开始前按已记录的用量重新检查租户预算，超出后其余问题不再回答，均以预算错误结束
+		if budgetErr == nil {
+			budgetErr = s.budgetError(b.tenant, b.apiKey)
+		}
+		if budgetErr != nil {
+			<-s.batchSlots
+			b.mu.Lock()
+			item.Status, item.Error = batchFailed, budgetErr.Error()
+			failed++
+			copied := *item
+			b.mu.Unlock()
+			s.deliverWebhook(b, eventAnswerCompleted, gin.H{"batch_id": b.Id, "item": &copied})
+			continue
+		}
+		answer, citations, usage, err := s.answerBatchQuestion(b, item.Question)
+		<-s.batchSlots
+
+		b.mu.Lock()
+		if err != nil {
+			logging.Warnf("batch %s question %d failed\n", b.Id, item.Index)
+			item.Status, item.Error = batchFailed, s.redact(context.Background(), err).Error()
+			failed++
+		} else {
+			item.Status, item.Answer, item.Citations = batchCompleted, answer, citations
+		}
+		item.Usage = &batchUsage{
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens(),
+		}
+		copied := *item
+		b.mu.Unlock()
+
+		s.deliverWebhook(b, eventAnswerCompleted, gin.H{"batch_id": b.Id, "item": &copied})
+	}
+
+	b.mu.Lock()
+	b.Status = batchCompleted
+	b.mu.Unlock()
+	logging.Infof("batch %s completed: %d questions, %d failed\n", b.Id, len(b.Items), failed)
+	s.deliverWebhook(b, eventBatchCompleted, gin.H{
+		"batch_id":  b.Id,
+		"questions": len(b.Items),
+		"failed":    failed,
+	})
+}
+
+// 检索并生成一个问题的完整回答，用量在回答结束时计入任务所属租户，供下一个问题检查预算
+func (s *Server) answerBatchQuestion(b *batch, question string) (string, []retrieval.Citation, *accounting.Usage, error) {
+	usage := &accounting.Usage{Requests: 1}
+	defer func() { s.ledger.Record(b.tenant, time.Now(), usage) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	collection := ""
+	if b.apiKey != nil {
+		collection = b.apiKey.Collection
+	}
+	pipeline := s.currentPipeline()
+	result, err := pipeline.Run(ctx, &retrieval.Request{
+		Question:   question,
+		Collection: collection,
+		Sources:    s.collections.get(collection).sources(),
+		Model:      b.Model,
+		Blocklist:  s.blocklist,
+	})
+	if err != nil {
+		return "", nil, usage, err
+	}
+	// 批量任务的问题不经过改写，不执行 pre_rewrite 钩子
+	hookState := &hooks.State{RequestId: b.Id, Tenant: b.tenant, Collection: collection, Model: b.Model, Question: question}
+	result, err = s.retrievalHooks(ctx, hookState, result, false)
+	if err != nil {
+		return "", nil, usage, err
+	}
+
+	// 检索按请求的模型，生成及其上下文窗口按生成模型
+	model := b.Model
+	if b.GenerationModel != "" {
+		model = b.GenerationModel
+	}
+	placement := s.contextPlacement(model)
+	instruction := citationInstruction(s.citationFormat(b.apiKey))
+	result, messages, err := fitPrompt(pipeline.ContextWindow(model), s.cfg.GenerationReserve, result,
+		func(result *retrieval.Result) []openai.ChatCompletionMessage {
+			return contextMessages(placement, nil, b.systemPrompt, question, result.Content, instruction)
+		})
+	if err != nil {
+		return "", nil, usage, err
+	}
+	messages, err = s.messageHooks(ctx, hooks.PreGeneration, hookState, messages)
+	if err != nil {
+		return "", nil, usage, err
+	}
+	usage.PromptTokens += estimateMessages(messages)
+
+	generator, upstreamModel := s.gens.Resolve(model)
+	stream, err := generator.Stream(ctx, openai.ChatCompletionRequest{Model: upstreamModel, Messages: messages, Stream: true})
+	if err != nil {
+		return "", nil, usage, err
+	}
+	defer stream.Close()
+	var answer strings.Builder
+	for {
+		buf, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", nil, usage, err
+		}
+		answer.WriteString(chunkContent(buf))
+	}
+	usage.CompletionTokens += int64(tokens.Estimate(answer.String()))
+	return s.answerHooks(ctx, hookState, answer.String()), result.Citations(), usage, nil
+}
+
+// 向任务的回调地址推送事件，失败时按指数退避重试。
+// 配置了 BATCH_WEBHOOK_SECRET 时以 HMAC-SHA256 签名请求体，放在 X-Lento-Signature 头中
+func (s *Server) deliverWebhook(b *batch, event string, payload gin.H) {
+	if b.CallbackUrl == "" {
+		return
+	}
+	payload["event"] = event
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logging.Errorf("batch %s webhook: %v\n", b.Id, err)
+		return
+	}
+
+	u, err := url.Parse(b.CallbackUrl)
+	if err != nil {
+		logging.Errorf("batch %s webhook: %v\n", b.Id, err)
+		return
+	}
+	client := s.webhookClient(u.Hostname())
+	backoff := time.Second
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, b.CallbackUrl, bytes.NewReader(body))
+		if err != nil {
+			logging.Errorf("batch %s webhook: %v\n", b.Id, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Lento-Event", event)
+		if s.cfg.BatchWebhookSecret != "" {
+			mac := hmac.New(sha256.New, []byte(s.cfg.BatchWebhookSecret))
+			mac.Write(body)
+			req.Header.Set("X-Lento-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = errors.New(resp.Status)
+		}
+		logging.Warnf("batch %s webhook %s attempt %d failed: %v\n", b.Id, event, attempt, err)
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
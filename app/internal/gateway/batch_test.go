@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/config"
+)
+
+func TestValidateCallbackUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string
+		url     string
+		wantErr bool
+	}{
+		{"public host", nil, "https://hooks.example.com/batch", false},
+		{"public ip", nil, "http://203.0.113.7:8080/batch", false},
+		{"relative", nil, "/batch", true},
+		{"other scheme", nil, "file:///etc/passwd", true},
+		{"loopback", nil, "http://127.0.0.1:8080/batch", true},
+		{"loopback v6", nil, "http://[::1]/batch", true},
+		{"private", nil, "http://10.0.0.5/batch", true},
+		{"link-local metadata", nil, "http://169.254.169.254/latest/meta-data", true},
+		{"unspecified", nil, "http://0.0.0.0/batch", true},
+		{"allowlisted", []string{"hooks.internal"}, "http://hooks.internal/batch", false},
+		{"not allowlisted", []string{"hooks.internal"}, "https://hooks.example.com/batch", true},
+		{"allowlisted private ip", []string{"10.0.0.5"}, "http://10.0.0.5/batch", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{cfg: &config.Config{BatchCallbackHosts: tt.hosts}}
+			err := s.validateCallbackUrl(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCallbackUrl(%q) error = %v, wantErr %t", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 域名解析到内网地址时，建立连接前被拒绝；在 BATCH_CALLBACK_HOSTS 中的主机可以连接
+func TestWebhookClientRejectsPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "http://localhost:" + u.Port()
+
+	s := &Server{cfg: &config.Config{}}
+	_, err = s.webhookClient("localhost").Post(target, "application/json", nil)
+	if err == nil {
+		t.Fatal("webhook client connected to a loopback address")
+	}
+
+	s = &Server{cfg: &config.Config{BatchCallbackHosts: []string{"localhost"}}}
+	resp, err := s.webhookClient("localhost").Post(target, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+// 租户预算已用完时，任务中的问题不再回答，均以预算错误结束
+func TestRunBatchStopsWhenBudgetExceeded(t *testing.T) {
+	ledger, err := accounting.NewLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		cfg:        &config.Config{TenantDailyTokens: 1000},
+		ledger:     ledger,
+		batchSlots: make(chan struct{}, 1),
+	}
+	if err := s.budgetError("acme", nil); err != nil {
+		t.Fatalf("budgetError() = %v before any usage", err)
+	}
+	ledger.Record("acme", time.Now(), &accounting.Usage{Requests: 1, PromptTokens: 900, CompletionTokens: 200})
+
+	b := &batch{Id: "b1", tenant: "acme"}
+	for i, q := range []string{"a", "b", "c"} {
+		b.Items = append(b.Items, &batchItem{Index: i, Question: q, Status: batchQueued})
+	}
+	s.runBatch(b)
+	for _, item := range b.Items {
+		if item.Status != batchFailed || !strings.Contains(item.Error, "daily token budget exceeded: 1100/1000") {
+			t.Errorf("item %d: status %s, error %q", item.Index, item.Status, item.Error)
+		}
+	}
+	if b.Status != batchCompleted {
+		t.Errorf("batch status = %s", b.Status)
+	}
+}
@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/index"
+	"rag_app/internal/ingest"
+	"rag_app/internal/lock"
+	"rag_app/internal/retrieval"
+)
+
+// 批量删除的过滤条件，各条件之间为 AND，至少指定一个
+type documentFilter struct {
+	Collection *string `json:"collection"`
+	Tag        string  `json:"tag"`
+	Source     string  `json:"source"`
+	// 文档日期的范围，均包含当天，RFC3339 或 YYYY-MM-DD；没有日期的文档不匹配
+	DateFrom string `json:"date_from"`
+	DateTo   string `json:"date_to"`
+
+	from, to time.Time
+}
+
+func (f *documentFilter) parse() error {
+	if f.Collection == nil && f.Tag == "" && f.Source == "" && f.DateFrom == "" && f.DateTo == "" {
+		return errors.New("filter is empty")
+	}
+	var err error
+	if f.DateFrom != "" {
+		f.from, err = ingest.ParseDate(f.DateFrom)
+		if err != nil {
+			return fmt.Errorf("invalid date_from: %w", err)
+		}
+	}
+	if f.DateTo != "" {
+		f.to, err = ingest.ParseDate(f.DateTo)
+		if err != nil {
+			return fmt.Errorf("invalid date_to: %w", err)
+		}
+		// 只有日期时包含当天
+		if _, err := time.Parse(time.RFC3339, f.DateTo); err != nil {
+			f.to = f.to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	return nil
+}
+
+func (f *documentFilter) match(doc *index.Document) bool {
+	if f.Collection != nil && doc.Collection != *f.Collection {
+		return false
+	}
+	if f.Tag != "" && !slices.Contains(doc.Tags, f.Tag) {
+		return false
+	}
+	if f.Source != "" && doc.Source != f.Source {
+		return false
+	}
+	if !f.from.IsZero() || !f.to.IsZero() {
+		if doc.Date.IsZero() || (!f.from.IsZero() && doc.Date.Before(f.from)) || (!f.to.IsZero() && doc.Date.After(f.to)) {
+			return false
+		}
+	}
+	return true
+}
+
+// /v1/documents:{method} 形式的自定义方法，目前只有 batchDelete。
+// 路由不支持在路径段中间使用冒号字面量，由参数匹配后分发
+func (s *Server) documentsMethodHandler(c *gin.Context) {
+	switch c.Param("method") {
+	case ":batchDelete":
+		s.batchDeleteHandler(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown method"})
+	}
+}
+
+// 按元数据条件批量删除文档：从源目录中删除匹配的文档后重建索引，完成后一次性替换正在使用的检索流水线。
+// 带 dry_run 时只返回将要删除的文档
+func (s *Server) batchDeleteHandler(c *gin.Context) {
+	var body struct {
+		Filter documentFilter `json:"filter"`
+		DryRun bool           `json:"dry_run"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	err = body.Filter.parse()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 与重新加载互斥，共享存储时也与其他副本的重建互斥
+	if !s.reloading.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "reload in progress"})
+		return
+	}
+	defer s.reloading.Unlock()
+	var lease lock.Lease
+	if !body.DryRun {
+		var ok bool
+		lease, ok = s.lockReindex(c)
+		if !ok {
+			return
+		}
+		defer s.unlockReindex(lease)
+	}
+
+	// 拆分出的文档按原文档删除
+	docIds := []int{}
+	matched := []gin.H{}
+	for _, doc := range s.currentPipeline().Store().Documents() {
+		if !body.Filter.match(doc) {
+			continue
+		}
+		docId := doc.DocId
+		if doc.SplitFrom != 0 {
+			docId = doc.SplitFrom
+		}
+		if slices.Contains(docIds, docId) {
+			continue
+		}
+		docIds = append(docIds, docId)
+		matched = append(matched, gin.H{"doc_id": docId, "title": doc.Title, "collection": doc.Collection})
+	}
+	if body.DryRun || len(docIds) == 0 {
+		c.JSON(http.StatusOK, gin.H{"dry_run": body.DryRun, "deleted": matched})
+		return
+	}
+
+	start := time.Now()
+	err = ingest.Delete(s.cfg.MarkdownDir, s.cfg.SummaryFile, docIds)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fmt.Printf("documents deleted by filter: %v\n", docIds)
+
+	// 源目录已修改，重建失败时继续使用当前索引，下次重新加载时生效
+	ctx, cancel := reindexContext(context.Background(), lease)
+	defer cancel()
+	ctx = retrieval.WithPrevious(ctx, s.currentPipeline())
+	pipeline, err := retrieval.NewFromConfig(ctx, s.cfg)
+	err = lockError(ctx, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("documents deleted, reindex failed: %v", err)})
+		return
+	}
+	s.docVersions.record(s.currentPipeline().Store().Documents(), pipeline.Store().Documents())
+	s.setPipeline(pipeline)
+	go s.publishSnapshots(pipeline)
+
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run":   false,
+		"deleted":   matched,
+		"documents": pipeline.Store().Len(),
+		"elapsed":   time.Since(start).String(),
+	})
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/tokens"
+)
+
+// 未配置 API Key 时的租户名
+const defaultTenant = "default"
+
+// 返回请求所属的租户
+func tenantOf(apiKey *APIKey) string {
+	if apiKey == nil || apiKey.Name == "" {
+		return defaultTenant
+	}
+	return apiKey.Name
+}
+
+// 租户一项预算的上限和已用 token 数
+type budgetUsage struct {
+	name  string
+	limit int64
+	used  int64
+}
+
+func (b budgetUsage) exceeded() bool {
+	return b.used >= b.limit
+}
+
+func (b budgetUsage) String() string {
+	return fmt.Sprintf("%s token budget exceeded: %d/%d", b.name, b.used, b.limit)
+}
+
+// 返回租户设置了上限的每日和每月预算，API Key 上的预算优先
+func (s *Server) budgets(tenant string, apiKey *APIKey) []budgetUsage {
+	daily, monthly := s.cfg.TenantDailyTokens, s.cfg.TenantMonthlyTokens
+	if apiKey != nil {
+		if apiKey.DailyTokenBudget > 0 {
+			daily = apiKey.DailyTokenBudget
+		}
+		if apiKey.MonthlyTokenBudget > 0 {
+			monthly = apiKey.MonthlyTokenBudget
+		}
+	}
+
+	now := time.Now()
+	budgets := []budgetUsage{}
+	for _, b := range []struct {
+		name  string
+		limit int64
+		used  func() *accounting.Usage
+	}{
+		{"daily", daily, func() *accounting.Usage { return s.ledger.Today(tenant, now) }},
+		{"monthly", monthly, func() *accounting.Usage { return s.ledger.ThisMonth(tenant, now) }},
+	} {
+		if b.limit <= 0 {
+			continue
+		}
+		budgets = append(budgets, budgetUsage{name: b.name, limit: b.limit, used: b.used().TotalTokens()})
+	}
+	return budgets
+}
+
+// 租户已超出任一预算时返回错误
+func (s *Server) budgetError(tenant string, apiKey *APIKey) error {
+	for _, b := range s.budgets(tenant, apiKey) {
+		if b.exceeded() {
+			return errors.New(b.String())
+		}
+	}
+	return nil
+}
+
+// 检查租户的 token 预算，超出时返回 429；接近上限时通过响应头给出警告
+func (s *Server) checkBudget(c *gin.Context, tenant string, apiKey *APIKey) bool {
+	for _, b := range s.budgets(tenant, apiKey) {
+		if b.exceeded() {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+				"code":    "budget_exceeded",
+				"message": b.String(),
+			}})
+			return false
+		}
+		if float64(b.used) >= float64(b.limit)*s.cfg.BudgetWarnRatio {
+			c.Writer.Header().Add("X-Lento-Budget-Warning",
+				fmt.Sprintf("%s %d/%d tokens used", b.name, b.used, b.limit))
+		}
+	}
+	return true
+}
+
+// 估算消息列表的 token 数
+func estimateMessages(messages []openai.ChatCompletionMessage) int64 {
+	n := 0
+	for _, msg := range messages {
+		n += tokens.Estimate(msg.Content) + 4
+	}
+	return int64(n)
+}
+
+// 从流式 chunk 中取出增量内容
+func chunkContent(buf []byte) string {
+	var chunk openai.ChatCompletionStreamResponse
+	if json.Unmarshal(buf, &chunk) != nil || len(chunk.Choices) == 0 {
+		return ""
+	}
+	return chunk.Choices[0].Delta.Content
+}
@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 缓冲模式下等待完整回答后再输出，由后处理器对整段回答做检查或改写
+type bufferedAnswer struct {
+	Text     string
+	Question string
+	Model    string
+	Result   *retrieval.Result
+	// 在回答之后发送的 SSE 事件
+	Events []sseEvent
+
+	pipeline     *retrieval.Pipeline
+	attributions []retrieval.Attribution
+}
+
+type sseEvent struct {
+	name string
+	data any
+}
+
+// 逐句归属，多个后处理器共用一次计算
+func (a *bufferedAnswer) attribute(ctx context.Context) ([]retrieval.Attribution, error) {
+	if a.attributions != nil {
+		return a.attributions, nil
+	}
+	attributions, err := a.pipeline.Attribute(ctx, a.Text, a.Result.Docs)
+	if err != nil {
+		return nil, err
+	}
+	a.attributions = attributions
+	return attributions, nil
+}
+
+// 整段回答的后处理器，可以修改 Text 或添加事件
+type answerProcessor func(s *Server, ctx context.Context, a *bufferedAnswer) error
+
+// BUFFERED_PROCESSORS 可用的后处理器
+var answerProcessors = map[string]answerProcessor{
+	"grounding": (*Server).checkGrounding,
+	"citations": (*Server).injectCitations,
+}
+
+// 依据检查：以 lento.grounding 事件返回与文档相似度低于 GROUNDING_MIN_SCORE 的句子
+func (s *Server) checkGrounding(ctx context.Context, a *bufferedAnswer) error {
+	attributions, err := a.attribute(ctx)
+	if err != nil {
+		return err
+	}
+	unsupported := []retrieval.Attribution{}
+	for _, attr := range attributions {
+		if float64(attr.Score) < s.cfg.GroundingMinScore {
+			unsupported = append(unsupported, attr)
+		}
+	}
+	ratio := 1.0
+	if len(attributions) > 0 {
+		ratio = 1 - float64(len(unsupported))/float64(len(attributions))
+	}
+	a.Events = append(a.Events, sseEvent{"lento.grounding", gin.H{"grounded_ratio": ratio, "unsupported": unsupported}})
+	return nil
+}
+
+var citationMarker = regexp.MustCompile(`\[\d+\]`)
+
+// 引用注入：在相似度不低于 GROUNDING_MIN_SCORE、且尚无引用标记的句子后插入 [n]
+func (s *Server) injectCitations(ctx context.Context, a *bufferedAnswer) error {
+	attributions, err := a.attribute(ctx)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	rest := a.Text
+	for _, attr := range attributions {
+		i := strings.Index(rest, attr.Sentence)
+		if i < 0 {
+			continue
+		}
+		end := i + len(attr.Sentence)
+		b.WriteString(rest[:end])
+		rest = rest[end:]
+		if float64(attr.Score) >= s.cfg.GroundingMinScore && !citationMarker.MatchString(attr.Sentence) &&
+			!strings.HasPrefix(rest, "[") {
+			fmt.Fprintf(&b, "[%d]", attr.Citation)
+		}
+	}
+	b.WriteString(rest)
+	a.Text = b.String()
+	return nil
+}
+
+// 依次执行后处理器。后处理器失败时记录日志并跳过，不影响回答
+func (s *Server) processAnswer(ctx context.Context, a *bufferedAnswer, processors []string) {
+	for _, name := range processors {
+		err := answerProcessors[name](s, ctx, a)
+		if err != nil {
+			logging.Warnf("answer processor %s failed in request %s: %v\n", name, provider.RequestId(ctx), err)
+		}
+	}
+}
+
+// 用处理后的回答替换数据块中的内容：第一个内容块携带完整回答，其余内容被去掉，
+// 角色、结束原因和用量等数据块保持不变。回答未被修改时原样返回
+func replaceContent(chunks [][]byte, original, text string) [][]byte {
+	if text == original {
+		return chunks
+	}
+	out := [][]byte{}
+	replaced := false
+	for _, buf := range chunks {
+		if chunkContent(buf) == "" {
+			out = append(out, buf)
+			continue
+		}
+		if !replaced {
+			var chunk openai.ChatCompletionStreamResponse
+			json.Unmarshal(buf, &chunk)
+			if body, err := provider.NewChunk(chunk.ID, chunk.Model, text, ""); err == nil {
+				out = append(out, body)
+				replaced = true
+			}
+		}
+		if _, finish, ok := splitFinish(buf); ok {
+			out = append(out, finish)
+		}
+	}
+	return out
+}
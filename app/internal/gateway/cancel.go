@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/provider"
+)
+
+// 进行中的回答请求，可通过请求 ID 取消
+type inflightRequest struct {
+	tenant string
+	cancel context.CancelFunc
+}
+
+// 请求 ID -> 进行中的请求。调用方可以自带 X-Request-ID，因此同一 ID 可能对应多个请求
+type inflightRequests struct {
+	mu       sync.Mutex
+	requests map[string][]*inflightRequest
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{requests: map[string][]*inflightRequest{}}
+}
+
+// 登记请求，返回结束时注销的函数
+func (r *inflightRequests) add(id, tenant string, cancel context.CancelFunc) func() {
+	req := &inflightRequest{tenant: tenant, cancel: cancel}
+	r.mu.Lock()
+	r.requests[id] = append(r.requests[id], req)
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		reqs := slices.DeleteFunc(r.requests[id], func(v *inflightRequest) bool { return v == req })
+		if len(reqs) == 0 {
+			delete(r.requests, id)
+		} else {
+			r.requests[id] = reqs
+		}
+	}
+}
+
+// 取消租户的指定请求，返回取消的请求数
+func (r *inflightRequests) cancel(id, tenant string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, req := range r.requests[id] {
+		if req.tenant == tenant {
+			req.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// 为回答请求建立可通过取消接口结束的上下文，替换到 c.Request 中。
+// 返回仅在调用取消接口时结束的上下文，用于不随连接断开而取消的上游调用，以及结束时的清理函数
+func (s *Server) cancellable(c *gin.Context, tenant string) (context.Context, func()) {
+	ctx, cancelRequest := context.WithCancel(c.Request.Context())
+	stopped, stop := context.WithCancel(context.Background())
+	context.AfterFunc(stopped, cancelRequest)
+	c.Request = c.Request.WithContext(ctx)
+	remove := s.inflight.add(provider.RequestId(ctx), tenant, stop)
+	return stopped, func() {
+		remove()
+		stop()
+		cancelRequest()
+	}
+}
+
+// 取消进行中的回答请求：结束上游调用并关闭响应流。只能取消本租户的请求
+func (s *Server) cancelRequestHandler(c *gin.Context) {
+	id := c.Param("id")
+	if s.inflight.cancel(id, tenantOf(apiKeyFrom(c))) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "request not found or already finished"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"request_id": id, "cancelled": true})
+}
@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/retrieval"
+)
+
+// 按 MODEL_POLICIES_FILE 中登记的模型能力校验请求，返回可以直接交给客户端的错误说明，
+// 代替上游难以理解的错误。lento 自身依赖工具调用的摘要模式在模型不支持时退回完整文档，
+// tool 上下文位置由 toolPlacement 退回 user
+func (s *Server) checkCapabilities(pipeline *retrieval.Pipeline, model string, request *openai.ChatCompletionRequest, promptMode *string) error {
+	policy := pipeline.ModelPolicy(model)
+	if !retrieval.Supports(policy.Tools) {
+		if len(request.Tools) > 0 || len(request.Functions) > 0 {
+			return fmt.Errorf("model %s does not support tool calling, remove tools and functions from the request", model)
+		}
+		switch *promptMode {
+		case PromptModeTable:
+			return fmt.Errorf("table collections need a model with tool calling, model %s does not support it", model)
+		case PromptModeSnippets:
+			fmt.Printf("model %s does not support tool calling, prompt_mode snippets falls back to full\n", model)
+			*promptMode = PromptModeFull
+		}
+	}
+	if !retrieval.Supports(policy.Vision) && hasImages(request.Messages) {
+		return fmt.Errorf("model %s does not support image input, send text only", model)
+	}
+	if !retrieval.Supports(policy.JSONSchema) && request.ResponseFormat != nil &&
+		request.ResponseFormat.Type == openai.ChatCompletionResponseFormatTypeJSONSchema {
+		return fmt.Errorf("model %s does not support response_format json_schema, use json_object and describe the schema in the prompt", model)
+	}
+	maxTokens := max(request.MaxCompletionTokens, request.MaxTokens)
+	if window := pipeline.ContextWindow(model); window > 0 && maxTokens >= window {
+		return fmt.Errorf("max_tokens %d leaves no room for the prompt in the %d-token context window of model %s", maxTokens, window, model)
+	}
+	return nil
+}
+
+// 模型不支持工具调用时，tool 上下文位置改用 user
+func toolPlacement(pipeline *retrieval.Pipeline, model, placement string) string {
+	if placement == PlacementTool && !retrieval.Supports(pipeline.ModelPolicy(model).Tools) {
+		return PlacementUser
+	}
+	return placement
+}
+
+// 消息中是否含有图片
+func hasImages(messages []openai.ChatCompletionMessage) bool {
+	for _, msg := range messages {
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/config"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 记录原因
+const (
+	CaptureSlow          = "slow"
+	CaptureLowConfidence = "low_confidence"
+	CaptureSampled       = "sampled"
+)
+
+// 一次请求的完整诊断信息
+type capture struct {
+	Time       time.Time                      `json:"time"`
+	RequestId  string                         `json:"request_id"`
+	TraceId    string                         `json:"trace_id,omitempty"`
+	Reasons    []string                       `json:"reasons"`
+	Tenant     string                         `json:"tenant"`
+	Model      string                         `json:"model"`
+	Question   string                         `json:"question"`
+	Duration   float64                        `json:"duration_ms"`
+	Timings    map[string]float64             `json:"timings_ms"`
+	TopScore   *float32                       `json:"top_score,omitempty"`
+	Candidates []retrieval.Candidate          `json:"candidates"`
+	Warnings   []string                       `json:"warnings,omitempty"`
+	Messages   []openai.ChatCompletionMessage `json:"messages"`
+	Answer     string                         `json:"answer"`
+}
+
+// 保留最近若干条诊断记录的环形缓冲区
+type captureLog struct {
+	cfg   config.CaptureConfig
+	mu    sync.Mutex
+	items []*capture
+	next  int
+}
+
+func newCaptureLog(cfg config.CaptureConfig) *captureLog {
+	return &captureLog{cfg: cfg}
+}
+
+// 判断请求是否需要记录，返回记录原因
+func (l *captureLog) reasons(duration time.Duration, result *retrieval.Result) []string {
+	if l.cfg.Size <= 0 {
+		return nil
+	}
+	reasons := []string{}
+	if l.cfg.Slow > 0 && duration >= l.cfg.Slow {
+		reasons = append(reasons, CaptureSlow)
+	}
+	if l.cfg.MinScore > 0 {
+		if top, ok := result.TopRerankScore(); !ok || float64(top) < l.cfg.MinScore {
+			reasons = append(reasons, CaptureLowConfidence)
+		}
+	}
+	if len(reasons) == 0 && l.cfg.SampleRate > 0 && rand.Float64() < l.cfg.SampleRate {
+		reasons = append(reasons, CaptureSampled)
+	}
+	return reasons
+}
+
+func (l *captureLog) add(c *capture) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.items) < l.cfg.Size {
+		l.items = append(l.items, c)
+		return
+	}
+	l.items[l.next] = c
+	l.next = (l.next + 1) % l.cfg.Size
+}
+
+// 按时间从新到旧返回记录，reason 不为空时只返回包含该原因的记录
+func (l *captureLog) list(reason string) []*capture {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	items := []*capture{}
+	for i := range l.items {
+		c := l.items[(l.next+len(l.items)-1-i)%len(l.items)]
+		if reason == "" || slices.Contains(c.Reasons, reason) {
+			items = append(items, c)
+		}
+	}
+	return items
+}
+
+// 请求结束时按需记录诊断信息
+func (s *Server) captureRequest(ctx context.Context, start time.Time, tenant, model, question string, timing *serverTiming,
+	result *retrieval.Result, messages []openai.ChatCompletionMessage, answer string) {
+	duration := time.Since(start)
+	reasons := s.captures.reasons(duration, result)
+	if len(reasons) == 0 {
+		return
+	}
+
+	c := &capture{
+		Time:       start,
+		RequestId:  provider.RequestId(ctx),
+		TraceId:    provider.TraceId(ctx),
+		Reasons:    reasons,
+		Tenant:     tenant,
+		Model:      model,
+		Question:   question,
+		Duration:   float64(duration.Microseconds()) / 1000,
+		Timings:    map[string]float64{},
+		Candidates: result.Candidates,
+		Warnings:   result.Warnings,
+		Messages:   messages,
+		Answer:     answer,
+	}
+	for _, t := range timing.timings {
+		c.Timings[t.Name] = float64(t.Duration.Microseconds()) / 1000
+	}
+	if top, ok := result.TopRerankScore(); ok {
+		c.TopScore = &top
+	}
+	s.captures.add(c)
+}
+
+// 列出最近记录的慢查询、低置信度和抽样请求，可按 reason 过滤
+func (s *Server) capturesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"captures": s.captures.list(c.Query("reason"))})
+}
@@ -0,0 +1,694 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/hooks"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+	"rag_app/internal/tokens"
+)
+
+func (s *Server) chatApiHandler(c *gin.Context) {
+	s.serveChat(c, nil)
+}
+
+// 回答聊天请求，transform 不为 nil 时在输出前转换每个数据块的格式
+func (s *Server) serveChat(c *gin.Context, transform func([]byte) []byte) {
+	requestStart := time.Now()
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request, opts, err := parseChatRequest(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 末尾的预填充助手消息不参与改写和检索，生成时接在提示词之后
+	var prefill *openai.ChatCompletionMessage
+	if s.cfg.AssistantPrefill == PrefillPassthrough {
+		request.Messages, prefill = splitPrefill(request.Messages)
+	}
+	if len(request.Messages) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "messages is empty"})
+		return
+	}
+	userQuestion := ""
+	if questions := userMessages(request.Messages); len(questions) > 0 {
+		userQuestion = questions[len(questions)-1]
+	}
+	rewriter, err := s.rewriter(opts.Rewriter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	promptMode, err := s.promptMode(opts.PromptMode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	features, err := s.parseFeatures(opts.Features)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 对比模式按文档给出相关片段，不使用摘要模式
+	if opts.Compare != nil {
+		promptMode = PromptModeFull
+	}
+	if opts.Deterministic {
+		pinSampling(request)
+	}
+
+	// 缓存用户原始的模型和系统提示
+	systemPrompt := ""
+	if request.Messages[0].Role == openai.ChatMessageRoleSystem {
+		systemPrompt = request.Messages[0].Content
+	}
+	model := request.Model
+
+	// 按 API Key 绑定的配置覆盖模型和系统提示
+	apiKey := apiKeyFrom(c)
+	citationFormat := s.citationFormat(apiKey)
+	collection := ""
+	if apiKey != nil {
+		if apiKey.Model != "" {
+			model = apiKey.Model
+		}
+		collection = apiKey.Collection
+	}
+	// 集合的配置在 API Key 未配置的项上生效
+	col := s.collections.get(collection)
+	if col != nil && col.Model != "" && (apiKey == nil || apiKey.Model == "") {
+		model = col.Model
+	}
+	systemPrompt, err = s.renderSystemPrompt(apiKey, col, systemPrompt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 表格集合由模型生成结构化查询，不做向量检索
+	tableCollection := s.tables.Collection(collection)
+	if tableCollection != nil {
+		if opts.Compare != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "compare is not supported for table collections"})
+			return
+		}
+		promptMode = PromptModeTable
+	}
+	err = s.checkCapabilities(s.currentPipeline(), model, request, &promptMode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查租户预算，并在请求结束时记录实际消耗的上游 token
+	tenant := tenantOf(apiKey)
+	if !s.checkBudget(c, tenant, apiKey) || !s.checkUserRate(c, tenant, apiKey, request.User) {
+		return
+	}
+	usage := &accounting.Usage{Requests: 1}
+	var answer strings.Builder
+	defer func() {
+		usage.CompletionTokens += int64(tokens.Estimate(answer.String()))
+		s.ledger.Record(tenant, time.Now(), usage)
+	}()
+
+	// 可通过 POST /v1/requests/{id}/cancel 取消，stopped 仅在取消时结束
+	stopped, unregister := s.cancellable(c, tenant)
+	defer unregister()
+	// 超出 REQUEST_MAX_DURATION 时，开始输出前返回 504，输出中途以 timeout 结束原因结束
+	pastDeadline, releaseDeadline := s.withDeadline(c, requestStart)
+	defer releaseDeadline()
+
+	// 开启进度事件时，提前建立 SSE 连接，之后的错误均以 SSE 事件返回
+	sse := newSSEWriter(c, s.cfg.SseDone, s.cfg.SseTerminator)
+	sse.compress, sse.compressLevel = s.cfg.SseGzip, s.cfg.SseGzipLevel
+	sse.transform = transform
+	if s.cfg.SseMetadata {
+		sse.requestId = provider.RequestId(c.Request.Context())
+	}
+	defer sse.finish()
+	fail := func(err error) {
+		if pastDeadline() {
+			err = deadlineError(err, s.cfg.RequestMaxDuration)
+		}
+		clientErr := s.redact(c.Request.Context(), err)
+		if sse.started {
+			sse.error(clientErr)
+			return
+		}
+		c.JSON(clientErr.status, clientErr.body())
+	}
+	// 开启生成预热时，在重排序开始时预热生成后端的连接，检索失败或请求结束时取消
+	prefetchCtx, cancelPrefetch := context.WithCancel(c.Request.Context())
+	defer cancelPrefetch()
+	onStage := func(stage string) {
+		if s.cfg.SseProgress {
+			sse.event("lento.status", gin.H{"stage": stage})
+		}
+		if stage == retrieval.StageReranking && s.prefetches != nil {
+			s.prefetchGeneration(prefetchCtx, model)
+		}
+	}
+	// 在响应中标明产生回答的索引和配置版本，便于将问题报告与配置对应起来
+	pls := s.pipelines.Load()
+	indexVersion := pls.main.IndexVersion()
+	versions := gin.H{"index_version": indexVersion, "config_hash": s.configHash}
+	c.Writer.Header().Set("X-Lento-Index-Version", indexVersion)
+	c.Writer.Header().Set("X-Lento-Config-Hash", s.configHash)
+	if s.cfg.SseProgress {
+		sse.start()
+	}
+
+	onStage(retrieval.StageRewriting)
+	// 从聊天历史中提取用户原始问题
+	timing := &serverTiming{}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 60*time.Second)
+	defer cancel()
+	ctx, cancelDeadline := s.deadlineContext(ctx, requestStart)
+	defer cancelDeadline()
+	defer context.AfterFunc(stopped, cancel)()
+	// 长会话以滚动摘要代替早期消息，摘要失败时按完整的消息继续
+	sessionId := c.GetHeader("X-Session-Id")
+	history := *request
+	if s.cfg.SessionSummaryTurns > 0 {
+		summary := ""
+		history.Messages, summary, err = s.summarizeHistory(ctx, sessionId, tenant, request.Messages, usage)
+		if err != nil {
+			logging.Warnf("summarize session %s failed, continuing with the full history: %v\n", sessionId, err)
+		}
+		timing.add("summarize", time.Since(start))
+		start = time.Now()
+		if summary != "" && s.cfg.SessionSummaryInPrompt {
+			systemPrompt += "\n\n此前对话的摘要：" + summary
+		}
+	}
+	// 挂载的钩子在各阶段看到和修改的请求状态
+	hookState := &hooks.State{RequestId: provider.RequestId(c.Request.Context()), Tenant: tenant, Collection: collection, Model: model}
+	history.Messages, err = s.messageHooks(ctx, hooks.PreRewrite, hookState, history.Messages)
+	if err != nil {
+		fail(err)
+		return
+	}
+	question, err := rewriter.Rewrite(ctx, history, usage)
+	if err != nil {
+		fail(fmt.Errorf("%w: %w", retrieval.ErrRewriteFailed, err))
+		return
+	}
+	timing.add("rewrite", time.Since(start))
+	if opts.Clarify && opts.Compare == nil {
+		start = time.Now()
+		clarify := s.needsClarification(ctx, history.Messages, question, usage)
+		timing.add("clarify", time.Since(start))
+		if clarify != nil {
+			cancelPrefetch()
+			s.streamClarification(c, sse, model, question, clarify)
+			answer.WriteString(clarify.Clarification)
+			s.sessions.recordTurn(sessionId, tenant, &sessionTurn{
+				Question:   userQuestion,
+				Rewritten:  question,
+				Answer:     clarify.Clarification,
+				Model:      model,
+				Citations:  []retrieval.Citation{},
+				StartedAt:  requestStart,
+				FinishedAt: time.Now(),
+			})
+			return
+		}
+	}
+
+	// 调用RAG模型，获取检索结果
+	retrievalReq := &retrieval.Request{
+		Question:      question,
+		MemDocIds:     s.sessions.get(sessionId),
+		Collection:    collection,
+		Sources:       col.sources(),
+		Model:         model,
+		OnStage:       onStage,
+		Blocklist:     s.blocklist,
+		Deterministic: opts.Deterministic,
+	}
+	s.applyFeatures(features, retrievalReq)
+	if opts.Compare != nil {
+		retrievalReq.CompareDocIds, err = pls.main.ResolveDocuments(opts.Compare.DocIds, opts.Compare.Titles, collection, s.blocklist)
+		if err != nil {
+			cancelPrefetch()
+			fail(err)
+			return
+		}
+		retrievalReq.MemDocIds = nil
+	} else if features.enabled(FeatureHyDE, false) && tableCollection == nil {
+		// 生成失败时以问题召回
+		start = time.Now()
+		retrievalReq.Hypothetical, err = s.hypotheticalAnswer(ctx, question, usage)
+		if err != nil {
+			logging.Warnf("hyde failed in request %s, retrieving with the question: %v\n", provider.RequestId(c.Request.Context()), err)
+		}
+		timing.add("hyde", time.Since(start))
+	}
+	runRetrieval := func(ctx context.Context) (*retrieval.Result, error) {
+		if tableCollection != nil {
+			return s.tableResult(tableCollection), nil
+		}
+		result, err := pls.main.Run(ctx, retrievalReq)
+		if err == nil && pls.shadow != nil && opts.Compare == nil {
+			pls.shadow.RunShadow(retrievalReq, result)
+		}
+		return result, err
+	}
+	var result *retrieval.Result
+	// 确定性模式下复用同一流水线对相同问题的检索结果，开启检索缓存时短时间内复用最近的检索结果
+	retrievals, retrievalCacheKey := s.retrievalCache, ""
+	if opts.Deterministic {
+		retrievals = s.pinnedRetrievals
+	}
+	if retrievals != nil {
+		retrievalCacheKey = fmt.Sprintf("%p\x00%s", pls.main, retrievalKey(retrievalReq))
+		result, _ = retrievals.Get(retrievalCacheKey)
+		// 缓存的检索结果中有文档被屏蔽时重新检索
+		if result != nil && slices.ContainsFunc(result.Docs, s.blocklist.ContainsDocument) {
+			result = nil
+		}
+	}
+	if result != nil {
+		logging.Debugf("retrieval cached: %s\n", question)
+	} else if s.cfg.CoalesceRequests {
+		// 相同的并发检索只执行一次，执行者断开连接不影响其他等待者
+		key := retrievalKey(retrievalReq)
+		var shared bool
+		result, err, shared = s.retrievals.do(key, func() (*retrieval.Result, error) {
+			return runRetrieval(context.WithoutCancel(c.Request.Context()))
+		})
+		if shared {
+			logging.Debugf("retrieval coalesced: %s\n", question)
+		}
+	} else {
+		result, err = runRetrieval(c.Request.Context())
+	}
+	if err != nil {
+		cancelPrefetch()
+		fail(err)
+		return
+	}
+	if retrievals != nil {
+		retrievals.Set(retrievalCacheKey, result)
+	}
+	// 钩子按请求调整文档，缓存和合并的是调整前的结果
+	hookState.Question = question
+	result, err = s.retrievalHooks(ctx, hookState, result, tableCollection != nil)
+	if err != nil {
+		cancelPrefetch()
+		fail(err)
+		return
+	}
+	timing.timings = append(timing.timings, result.Timings...)
+	s.stats.record(collection, result, time.Now())
+	s.sessions.remember(sessionId, result.DocIds)
+
+	// 结合用户问题和检索结果，调用大模型，获取最终的输出结果
+	request.Model = model
+	request.Stream = true // 仅支持流式响应
+	placement := toolPlacement(pls.main, model, s.contextPlacement(model))
+	// user 位置的回答提示模板由多臂老虎机选择，并记录到请求 ID 上以便之后反馈。
+	// 对比模式的说明写在提示模板中，因此总是使用 user 位置和对比模板
+	promptTemplate, tmpl := "", (*template.Template)(nil)
+	if opts.Compare != nil {
+		placement, promptTemplate, tmpl = PlacementUser, comparePromptTemplate, s.comparePrompt
+		versions["prompt_version"] = comparePromptTemplate + "@" + templateVersion(tmpl)
+		if !sse.started {
+			c.Writer.Header().Set("X-Lento-Prompt-Template", promptTemplate)
+		}
+	} else if placement == PlacementUser {
+		requestId := provider.RequestId(c.Request.Context())
+		// 集合固定了模板时不参与选择，也不计入反馈
+		if col != nil && col.PromptTemplate != "" {
+			promptTemplate, tmpl = col.PromptTemplate, s.prompts.templates[col.PromptTemplate]
+		} else {
+			promptTemplate, tmpl = s.prompts.choose(requestId, opts.Deterministic)
+		}
+		promptVersion := s.prompts.version(promptTemplate)
+		versions["prompt_version"] = promptVersion
+		logging.Debugf("prompt template: %s (request %s)\n", promptVersion, requestId)
+		if !sse.started {
+			c.Writer.Header().Set("X-Lento-Prompt-Template", promptTemplate)
+			c.Writer.Header().Set("X-Lento-Prompt-Version", promptVersion)
+		}
+	}
+	buildMessages := func(result *retrieval.Result) []openai.ChatCompletionMessage {
+		content := result.Content
+		snippets := promptMode == PromptModeSnippets && len(result.Docs) > 0
+		if snippets {
+			content = formatSnippets(result.Docs)
+		}
+		messages := contextMessages(placement, tmpl, systemPrompt, question, content, citationInstruction(citationFormat))
+		// 工具调用循环会在末尾追加工具调用和结果，此时不使用预填充
+		if prefill != nil && !snippets && tableCollection == nil {
+			messages = append(messages, *prefill)
+		}
+		return messages
+	}
+	result, request.Messages, err = fitPrompt(pls.main.ContextWindow(model), s.generationReserve(request), result, buildMessages)
+	if err != nil {
+		fail(err)
+		return
+	}
+	request.Messages, err = s.messageHooks(ctx, hooks.PreGeneration, hookState, request.Messages)
+	if err != nil {
+		fail(err)
+		return
+	}
+	onStage(retrieval.StageGenerating)
+
+	promptMessages := request.Messages
+	defer func() {
+		s.captureRequest(c.Request.Context(), requestStart, tenant, model, question, timing, result, promptMessages, answer.String())
+		s.logQuery(c.Request.Context(), requestStart, tenant, collection, model, question, result)
+		s.recordDecision(c.Request.Context(), requestStart, tenant, collection, model, question, indexVersion, timing, result)
+	}()
+
+	// 按需在结束标记之前返回发送给模型的提示上下文
+	if opts.IncludeContext {
+		defer func() {
+			if sse.started {
+				sse.event("lento.context", gin.H{
+					"doc_ids":   result.DocIds,
+					"citations": result.Citations(),
+					"messages":  promptMessages,
+					"warnings":  result.Warnings,
+				})
+			}
+		}()
+	}
+
+	// SSE 流式返回；开启进度事件时响应头已发送，问题仅通过元数据事件返回，各阶段耗时改由 Server-Timing trailer 返回
+	beginStream := func(cacheStatus string) {
+		if !sse.started {
+			c.Writer.Header().Set("X-Lento-Question", url.PathEscape(question))
+			c.Writer.Header().Set("X-Lento-Cache", cacheStatus)
+			c.Writer.Header().Set("Server-Timing", timing.String())
+			sse.start()
+		} else {
+			c.Writer.Header().Add(http.TrailerPrefix+"Server-Timing", timing.String())
+		}
+		if s.cfg.SseMetadata {
+			sse.event("lento.question", gin.H{"question": question})
+			sse.event("lento.versions", versions)
+		}
+		if citationEvent(citationFormat, s.cfg.SseMetadata) {
+			sse.event("lento.citations", gin.H{"citations": result.Citations()})
+		}
+		if opts.Explain {
+			sse.event("lento.explain", gin.H{
+				"candidates":      result.Candidates,
+				"limits":          result.Limits,
+				"rerank_fallback": result.RerankFallback,
+				"warnings":        result.Warnings,
+				"excerpted_docs":  result.Excerpted,
+				"normalized":      result.Normalized,
+			})
+		}
+	}
+
+	var text strings.Builder
+	meter := &streamMeter{start: requestStart}
+	// 在结束 chunk 之前插入脚注格式的参考资料列表和免责声明，上游未返回结束 chunk 时在流结束后补发
+	var trailers [][]byte
+	if citationFormat == CitationFootnote {
+		if buf := footnoteChunk(model, result.Citations()); buf != nil {
+			trailers = append(trailers, buf)
+		}
+	}
+	if buf := s.disclaimerChunk(model, collection, pls.main); buf != nil {
+		trailers = append(trailers, buf)
+	}
+	flushTrailers := func() {
+		for _, buf := range trailers {
+			sse.data(buf)
+		}
+		trailers = nil
+	}
+	emit := func(buf []byte) {
+		content := chunkContent(buf)
+		meter.observe(content)
+		text.WriteString(content)
+		if trailers != nil {
+			if body, finish, ok := splitFinish(buf); ok {
+				if body != nil {
+					sse.data(body)
+				}
+				flushTrailers()
+				sse.data(finish)
+				return
+			}
+		}
+		sse.data(buf)
+	}
+
+	// 开启回答归属时，在回答结束后逐句返回其与文档片段的相似度
+	attribute := func() {
+		if !s.cfg.AnswerAttribution || !citationEvent(citationFormat, s.cfg.SseMetadata) {
+			return
+		}
+		start := time.Now()
+		attributions, err := pls.main.Attribute(c.Request.Context(), text.String(), result.Docs)
+		if err != nil {
+			logging.Errorf("attribution failed in request %s: %v\n", provider.RequestId(c.Request.Context()), err)
+			return
+		}
+		logging.Infof("attribution: %d sentences in %v\n", len(attributions), time.Since(start))
+		sse.event("lento.attribution", gin.H{"sentences": attributions})
+	}
+
+	// 请求开启依据检查时，流式回答结束后同样返回 lento.grounding 事件
+	ground := func() {
+		if !features.enabled(FeatureGrounding, false) {
+			return
+		}
+		a := &bufferedAnswer{Text: text.String(), Question: question, Model: model, Result: result, pipeline: pls.main}
+		err := s.checkGrounding(c.Request.Context(), a)
+		if err != nil {
+			logging.Errorf("grounding check failed in request %s: %v\n", provider.RequestId(c.Request.Context()), err)
+			return
+		}
+		for _, e := range a.Events {
+			sse.event(e.name, e.data)
+		}
+	}
+
+	// 回答完成后记入会话记录，供导出
+	recordTurn := func() {
+		s.sessions.recordTurn(sessionId, tenant, &sessionTurn{
+			Question:   userQuestion,
+			Rewritten:  question,
+			Answer:     text.String(),
+			Model:      model,
+			Citations:  result.Citations(),
+			StartedAt:  requestStart,
+			FinishedAt: time.Now(),
+		})
+	}
+
+	// 缓冲模式下整段回答经后处理后一次输出
+	deliverBuffered := func(chunks [][]byte, cacheStatus string) {
+		original := ""
+		for _, buf := range chunks {
+			original += chunkContent(buf)
+		}
+		a := &bufferedAnswer{Text: original, Question: question, Model: model, Result: result, pipeline: pls.main}
+		s.processAnswer(c.Request.Context(), a, s.bufferedProcessors(features))
+		a.Text = s.answerHooks(c.Request.Context(), hookState, a.Text)
+		beginStream(cacheStatus)
+		for _, buf := range replaceContent(chunks, original, a.Text) {
+			emit(buf)
+		}
+		flushTrailers()
+		for _, e := range a.Events {
+			sse.event(e.name, e.data)
+		}
+		s.finishStream(c, meter, model, cacheStatus, text.String())
+		attribute()
+		recordTurn()
+	}
+
+	// 命中回答缓存时直接回放
+	prefillText := ""
+	if prefill != nil {
+		prefillText = messageText(*prefill)
+	}
+	cacheKey := answerCacheKey(model, systemPrompt, citationFormat, promptTemplate, promptMode, question, prefillText, opts.Deterministic, result)
+	// 钩子或上下文压缩改变了提示中的内容时，还要区分实际的提示消息
+	if s.hooks.Has(hooks.PreGeneration) || retrievalReq.PromptChunks != 0 {
+		cacheKey = hookedCacheKey(cacheKey, request.Messages)
+	}
+	if chunks, ok := s.answers.Get(cacheKey); ok {
+		timing.add("ttft", time.Since(requestStart))
+		s.archiveChunks(s.newTranscript(c.Request.Context(), requestStart, tenant, model, question, "hit", promptMessages), chunks)
+		if opts.Buffered {
+			deliverBuffered(chunks, "hit")
+			return
+		}
+		beginStream("hit")
+		for _, buf := range chunks {
+			emit(buf)
+		}
+		flushTrailers()
+		s.finishStream(c, meter, model, "hit", text.String())
+		s.answerHooks(c.Request.Context(), hookState, text.String())
+		ground()
+		attribute()
+		recordTurn()
+		return
+	}
+
+	start = time.Now()
+	generator, upstreamModel := s.gens.Resolve(model)
+	generator = provider.SanitizeGenerator(generator, s.currentPipeline().ModelPolicy(model).UnsupportedParams)
+	request.Model = upstreamModel
+	genRequest := *request
+	// 摘要模式和表格集合的工具调用轮次额外发送的提示 token
+	var toolPromptTokens atomic.Int64
+	produce := func(ctx context.Context, push func(buf []byte)) error {
+		if tableCollection != nil {
+			return s.generateWithTables(ctx, generator, genRequest, tableCollection, &toolPromptTokens, push)
+		}
+		if promptMode == PromptModeSnippets && len(result.Docs) > 0 {
+			return s.generateWithFetch(ctx, generator, genRequest, result.Docs, &toolPromptTokens, push)
+		}
+		stream, err := generator.Stream(ctx, genRequest)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		if s.cfg.NormalizeStream {
+			stream = provider.NormalizeStream(stream)
+		}
+		for {
+			buf, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			push(bytes.Clone(buf))
+		}
+	}
+
+	// 开启请求合并时，相同的并发请求共用一次生成，各自按进度读取
+	flightKey := ""
+	if s.cfg.CoalesceRequests {
+		flightKey = cacheKey
+	}
+	flight, leader := s.flights.join(c.Request.Context(), flightKey, produce)
+	cacheStatus := "miss"
+	if !leader {
+		cacheStatus = "coalesced"
+	}
+	// 开启存档时先于客户端订阅，客户端断开后继续生成并保存完整的回答
+	s.archiveFlight(c.Request.Context(), s.newTranscript(c.Request.Context(), requestStart, tenant, model, question, cacheStatus, promptMessages), flight, requestStart)
+	recv, release := flight.subscribe(c.Request.Context())
+	defer release()
+	if leader {
+		// 上游 token 只由发起生成的请求计入用量
+		usage.PromptTokens += estimateMessages(request.Messages)
+	}
+
+	// 先读取第一个数据块，以便在响应头中返回生成阶段的首字节耗时
+	first, firstErr := recv()
+	timing.add("ttfb-generation", time.Since(start))
+	timing.add("ttft", time.Since(requestStart))
+	if firstErr != nil && firstErr != io.EOF {
+		fail(firstErr)
+		return
+	}
+
+	if opts.Buffered {
+		chunks := [][]byte{}
+		buf, err := first, firstErr
+		for err == nil {
+			chunks = append(chunks, buf)
+			buf, err = recv()
+		}
+		if err != io.EOF {
+			fail(err)
+			return
+		}
+		if leader {
+			s.answers.Set(cacheKey, chunks)
+			usage.PromptTokens += toolPromptTokens.Load()
+			for _, buf := range chunks {
+				answer.WriteString(chunkContent(buf))
+			}
+		}
+		deliverBuffered(chunks, cacheStatus)
+		return
+	}
+
+	beginStream(cacheStatus)
+	chunks := [][]byte{}
+	c.Stream(
+		func(w io.Writer) bool {
+			var buf []byte
+			var err error
+			if first != nil || firstErr != nil {
+				buf, err = first, firstErr
+				first, firstErr = nil, nil
+			} else {
+				buf, err = recv()
+			}
+			if err != nil {
+				if err == io.EOF {
+					flushTrailers()
+					s.finishStream(c, meter, model, cacheStatus, text.String())
+					s.answerHooks(c.Request.Context(), hookState, text.String())
+					ground()
+					attribute()
+					recordTurn()
+					if leader {
+						s.answers.Set(cacheKey, chunks)
+						usage.PromptTokens += toolPromptTokens.Load()
+					}
+				} else if stopped.Err() != nil {
+					logging.Infof("request %s cancelled by client\n", provider.RequestId(c.Request.Context()))
+					sse.event("lento.cancelled", gin.H{"request_id": provider.RequestId(c.Request.Context())})
+				} else if pastDeadline() {
+					// 已输出的内容保留，以 timeout 结束原因结束，不写入回答缓存
+					logging.Errorf("request %s failed (%s): %v\n", provider.RequestId(c.Request.Context()), codeRequestTimeout, deadlineError(err, s.cfg.RequestMaxDuration))
+					s.errorCounts.record(codeRequestTimeout)
+					flushTrailers()
+					if buf := timeoutChunk(model); buf != nil {
+						sse.data(buf)
+					}
+					s.finishStream(c, meter, model, cacheStatus, text.String())
+					recordTurn()
+				} else {
+					sse.error(s.redact(c.Request.Context(), err))
+				}
+				return false
+			}
+			emit(buf)
+			if leader {
+				chunks = append(chunks, buf)
+				answer.WriteString(chunkContent(buf))
+			}
+			return true
+		},
+	)
+}
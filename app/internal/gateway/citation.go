@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 回答中引用来源的呈现方式
+const (
+	// 仅在开启 SSE_METADATA 时通过 lento.citations 事件返回
+	CitationDefault = ""
+	// 要求模型在正文中以 [n] 标注来源
+	CitationInline = "inline"
+	// 在正文中以 [n] 标注来源，并在回答末尾附上参考资料列表
+	CitationFootnote = "footnote"
+	// 始终通过 lento.citations 事件返回引用列表
+	CitationJSON = "json"
+	// 不返回任何引用信息
+	CitationNone = "none"
+)
+
+func validateCitationFormat(format string) error {
+	switch format {
+	case CitationDefault, CitationInline, CitationFootnote, CitationJSON, CitationNone:
+		return nil
+	}
+	return fmt.Errorf("invalid citation format %q", format)
+}
+
+// 按 API Key 覆盖全局的引用格式
+func (s *Server) citationFormat(apiKey *APIKey) string {
+	if apiKey != nil && apiKey.CitationFormat != "" {
+		return apiKey.CitationFormat
+	}
+	return s.cfg.CitationFormat
+}
+
+// 追加在提示词末尾的引用要求
+func citationInstruction(format string) string {
+	switch format {
+	case CitationInline, CitationFootnote:
+		return "\n\n回答时请在引用了文档内容的句子末尾用 [n] 标注来源，n 为上面文档的序号。"
+	}
+	return ""
+}
+
+// 是否通过 lento.citations 事件返回引用列表
+func citationEvent(format string, sseMetadata bool) bool {
+	return format == CitationJSON || (sseMetadata && format != CitationNone)
+}
+
+// 构造附在回答末尾的参考资料列表，作为一个独立的流式 chunk 返回
+func footnoteChunk(model string, citations []retrieval.Citation) []byte {
+	if len(citations) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\n参考资料：\n")
+	for i, c := range citations {
+		title := c.Title
+		if title == "" {
+			title = fmt.Sprintf("文档 %d", c.DocId)
+		}
+		fmt.Fprintf(&sb, "[%d] %s", i+1, title)
+		if href := c.Href(); href != "" {
+			fmt.Fprintf(&sb, " %s", href)
+		}
+		sb.WriteString("\n")
+	}
+	buf, err := provider.NewChunk(provider.NewChunkId(), model, sb.String(), "")
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+// 将携带结束原因的 chunk 拆分为去掉结束原因的内容 chunk 和仅含结束原因的 chunk，
+// 以便在两者之间插入内容；不是结束 chunk 时返回 false。内容为空时 body 为 nil
+func splitFinish(buf []byte) (body, finish []byte, ok bool) {
+	var chunk map[string]any
+	if json.Unmarshal(buf, &chunk) != nil {
+		return nil, nil, false
+	}
+	choices, _ := chunk["choices"].([]any)
+	if len(choices) == 0 {
+		return nil, nil, false
+	}
+	choice, _ := choices[0].(map[string]any)
+	reason, _ := choice["finish_reason"].(string)
+	if reason == "" {
+		return nil, nil, false
+	}
+
+	id, _ := chunk["id"].(string)
+	model, _ := chunk["model"].(string)
+	finish, err := provider.NewChunk(id, model, "", reason)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	delta, _ := choice["delta"].(map[string]any)
+	if content, _ := delta["content"].(string); content != "" {
+		choice["finish_reason"] = nil
+		body, err = json.Marshal(chunk)
+		if err != nil {
+			return nil, nil, false
+		}
+	}
+	return body, finish, true
+}
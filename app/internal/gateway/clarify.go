@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/tokens"
+)
+
+// 判断问题是否含糊的提示
+const clarifyPrompt = `你负责判断用户的问题是否足够明确，可以直接用于检索文档。
+如果问题缺少必要的信息（如产品名称、版本、操作系统、具体场景等），导致无法确定用户要找的内容，则视为含糊。
+只输出一个 JSON 对象，不要输出其他内容，格式为：
+{"ambiguity": 0~1 之间的数字，越大表示越含糊, "clarification": "问题含糊时向用户提出的一个简短的澄清问题，否则为空字符串"}`
+
+// 问题的含糊程度判断结果
+type clarification struct {
+	Ambiguity     float64 `json:"ambiguity"`
+	Clarification string  `json:"clarification"`
+}
+
+// 请非推理模型判断问题是否含糊，上游调用的 token 计入 usage
+func (s *Server) judgeAmbiguity(ctx context.Context, messages []openai.ChatCompletionMessage, question string, usage *accounting.Usage) (*clarification, error) {
+	request := openai.ChatCompletionRequest{
+		Model: s.cfg.ModelWithoutThinking,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: clarifyPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("聊天记录：\n%s\n\n待判断的问题：%s", buildChatHistory(messages), question)},
+		},
+	}
+	usage.PromptTokens += estimateMessages(request.Messages)
+	response, err := s.llm.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("clarify: empty response")
+	}
+	content := response.Choices[0].Message.Content
+	usage.CompletionTokens += int64(tokens.Estimate(content))
+
+	// 模型可能在 JSON 外包裹代码块或说明文字
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("clarify: no JSON object in %q", content)
+	}
+	var result clarification
+	err = json.Unmarshal([]byte(content[start:end+1]), &result)
+	if err != nil {
+		return nil, fmt.Errorf("clarify: %w", err)
+	}
+	result.Clarification = strings.TrimSpace(result.Clarification)
+	return &result, nil
+}
+
+// 开启澄清时判断问题是否含糊，含糊程度不低于 CLARIFY_THRESHOLD 且模型给出了澄清问题时返回该结果。
+// 判断失败不影响回答，按原问题继续检索
+func (s *Server) needsClarification(ctx context.Context, messages []openai.ChatCompletionMessage, question string, usage *accounting.Usage) *clarification {
+	result, err := s.judgeAmbiguity(ctx, messages, question, usage)
+	if err != nil {
+		logging.Warnf("clarify failed, continuing with retrieval: %v\n", err)
+		return nil
+	}
+	logging.Infof("clarify: ambiguity %.2f for %q\n", result.Ambiguity, question)
+	if result.Ambiguity < s.cfg.ClarifyThreshold || result.Clarification == "" {
+		return nil
+	}
+	return result
+}
+
+// 以一次完整的回答流返回澄清问题，不做检索和生成
+func (s *Server) streamClarification(c *gin.Context, sse *sseWriter, model, question string, result *clarification) {
+	if !sse.started {
+		c.Writer.Header().Set("X-Lento-Clarification", "true")
+		sse.start()
+	}
+	if s.cfg.SseMetadata {
+		sse.event("lento.question", gin.H{"question": question})
+	}
+	sse.event("lento.clarification", gin.H{"question": question, "ambiguity": result.Ambiguity})
+	id := provider.NewChunkId()
+	if buf, err := provider.NewChunk(id, model, result.Clarification, ""); err == nil {
+		sse.data(buf)
+	}
+	if buf, err := provider.NewChunk(id, model, "", string(openai.FinishReasonStop)); err == nil {
+		sse.data(buf)
+	}
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 单次生成的最长时间
+const generationTimeout = 300 * time.Second
+
+// 一次流式生成，产生的 chunk 全部缓存在内存中，每个订阅者按各自的进度读取，
+// 慢速客户端不会阻塞生成和其他客户端。所有订阅者都离开时取消上游请求
+type streamFlight struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	chunks [][]byte
+	err    error
+	done   bool
+	refs   int
+	cancel context.CancelFunc
+}
+
+// 执行一次流式生成，将 chunk 依次交给 push，返回流结束的原因，正常结束时为 io.EOF
+type producer func(ctx context.Context, push func(buf []byte)) error
+
+// 生成不随发起请求的连接断开而取消，但保留其上下文中的值，如请求 ID
+func startFlight(parent context.Context, produce producer, onDone func()) *streamFlight {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), generationTimeout)
+	f := &streamFlight{cancel: cancel}
+	f.cond = sync.NewCond(&f.mu)
+
+	go func() {
+		defer cancel()
+		err := produce(ctx, func(buf []byte) {
+			f.mu.Lock()
+			f.chunks = append(f.chunks, buf)
+			f.mu.Unlock()
+			f.cond.Broadcast()
+		})
+		if onDone != nil {
+			onDone()
+		}
+		f.mu.Lock()
+		f.err, f.done = err, true
+		f.mu.Unlock()
+		f.cond.Broadcast()
+	}()
+	return f
+}
+
+// 订阅生成结果，返回从头读取 chunk 的函数和取消订阅的函数。ctx 结束后 recv 立即返回其错误，
+// 不影响其他订阅者
+func (f *streamFlight) subscribe(ctx context.Context) (recv func() ([]byte, error), release func()) {
+	f.mu.Lock()
+	f.refs++
+	f.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.cond.Broadcast()
+	})
+
+	next := 0
+	recv = func() ([]byte, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if next < len(f.chunks) {
+				next++
+				return f.chunks[next-1], nil
+			}
+			if f.done {
+				return nil, f.err
+			}
+			f.cond.Wait()
+		}
+	}
+	release = func() {
+		stop()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.refs--
+		if f.refs == 0 && !f.done {
+			f.cancel()
+		}
+	}
+	return recv, release
+}
+
+// 合并相同请求的生成：同一 key 的生成进行中时，新请求直接订阅已有的生成
+type coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*streamFlight
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{flights: map[string]*streamFlight{}}
+}
+
+// 加入或发起 key 对应的生成，发起者返回 true。key 为空时不合并
+func (c *coalescer) join(ctx context.Context, key string, produce producer) (*streamFlight, bool) {
+	if key == "" {
+		return startFlight(ctx, produce, nil), true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f, false
+	}
+
+	var f *streamFlight
+	f = startFlight(ctx, produce, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.flights[key] == f {
+			delete(c.flights, key)
+		}
+	})
+	c.flights[key] = f
+	return f, true
+}
+
+// 合并相同的非流式调用，如检索
+type callGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+type call[T any] struct {
+	wg  sync.WaitGroup
+	val T
+	err error
+}
+
+// 执行 fn 或等待进行中的相同调用，第三个返回值表示结果是否来自其他请求
+func (g *callGroup[T]) do(key string, fn func() (T, error)) (T, error, bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call[T]{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return c.val, c.err, false
+}
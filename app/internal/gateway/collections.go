@@ -0,0 +1,327 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 集合的管理对象，集中配置原先分散在环境变量和 API Key 中的主题、提示词、生成模型和文档来源。
+// 集合包含哪些文档仍由 metadata.json 中的 collection 决定，向量化和重排序模型仍由 COLLECTION_MODELS_FILE 配置
+type Collection struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// 系统提示模板中的 {{.Topic}}，为空时使用 TOPIC
+	Topic string `json:"topic,omitempty"`
+	// 系统提示模板，可引用 {{.SystemPrompt}} 和 {{.Topic}}；API Key 配置了系统提示时以 API Key 的为准
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// 固定使用的回答提示模板名，来自 PROMPT_TEMPLATES_FILE，为空时由多臂老虎机选择
+	PromptTemplate string `json:"prompt_template,omitempty"`
+	// 生成回答所用的模型，API Key 绑定了模型时以 API Key 的为准
+	Model string `json:"model,omitempty"`
+	// 免责声明模板，覆盖 DISCLAIMERS_FILE 中的配置，空字符串表示不附加
+	Disclaimer *string `json:"disclaimer,omitempty"`
+	// 只检索来源在列表中的文档，为空时不限制
+	Sources   []string  `json:"sources,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	promptTmpl     *template.Template
+	disclaimerTmpl *template.Template
+}
+
+var errCollectionNotFound = errors.New("collection not found")
+
+// 集合存储，修改后写回 COLLECTIONS_FILE。保存的集合不再修改，更新时整体替换，读取方无需加锁
+type collectionStore struct {
+	mu    sync.RWMutex
+	path  string
+	items map[string]*Collection
+}
+
+// 从 JSON 文件加载集合列表，文件不存在时为空，首次修改时创建
+func loadCollections(path string, prompts map[string]*template.Template) (*collectionStore, error) {
+	cs := &collectionStore{path: path, items: make(map[string]*Collection)}
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Collection
+	err = json.Unmarshal(buf, &list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, col := range list {
+		err = col.compile(prompts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, ok := cs.items[col.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate collection %q", path, col.Name)
+		}
+		cs.items[col.Name] = col
+	}
+	return cs, nil
+}
+
+// 校验集合并解析其中的模板
+func (col *Collection) compile(prompts map[string]*template.Template) error {
+	if col.Name == "" || strings.ContainsAny(col.Name, "/\x00") {
+		return fmt.Errorf("invalid collection name %q", col.Name)
+	}
+	var err error
+	col.promptTmpl, col.disclaimerTmpl = nil, nil
+	if col.SystemPrompt != "" {
+		col.promptTmpl, err = template.New(col.Name).Parse(col.SystemPrompt)
+		if err != nil {
+			return fmt.Errorf("collection %q: system_prompt: %w", col.Name, err)
+		}
+	}
+	if col.PromptTemplate != "" {
+		if _, ok := prompts[col.PromptTemplate]; !ok {
+			return fmt.Errorf("collection %q: prompt_template %q is not in PROMPT_TEMPLATES_FILE", col.Name, col.PromptTemplate)
+		}
+	}
+	if col.Disclaimer != nil && *col.Disclaimer != "" {
+		tmpl, err := template.New("disclaimer").Parse(*col.Disclaimer)
+		if err == nil {
+			err = tmpl.Execute(&strings.Builder{}, &disclaimerData{})
+		}
+		if err != nil {
+			return fmt.Errorf("collection %q: disclaimer: %w", col.Name, err)
+		}
+		col.disclaimerTmpl = tmpl
+	}
+	return nil
+}
+
+// 集合限定的文档来源，集合为 nil 时不限制
+func (col *Collection) sources() []string {
+	if col == nil {
+		return nil
+	}
+	return col.Sources
+}
+
+// 按名称返回集合，未配置集合存储或集合不存在时返回 nil
+func (cs *collectionStore) get(name string) *Collection {
+	if cs == nil || name == "" {
+		return nil
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.items[name]
+}
+
+// 全部集合，按名称排序
+func (cs *collectionStore) list() []*Collection {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	list := make([]*Collection, 0, len(cs.items))
+	for _, col := range cs.items {
+		list = append(list, col)
+	}
+	slices.SortFunc(list, func(a, b *Collection) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+// 新建或替换集合并保存，替换时保留创建时间。返回是否为新建
+func (cs *collectionStore) put(col *Collection) (bool, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	now := time.Now().UTC()
+	old, exists := cs.items[col.Name]
+	col.CreatedAt, col.UpdatedAt = now, now
+	if exists {
+		col.CreatedAt = old.CreatedAt
+	}
+	cs.items[col.Name] = col
+	err := cs.save()
+	if err != nil {
+		if exists {
+			cs.items[col.Name] = old
+		} else {
+			delete(cs.items, col.Name)
+		}
+		return false, err
+	}
+	return !exists, nil
+}
+
+// 删除集合并保存
+func (cs *collectionStore) remove(name string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	old, ok := cs.items[name]
+	if !ok {
+		return errCollectionNotFound
+	}
+	delete(cs.items, name)
+	err := cs.save()
+	if err != nil {
+		cs.items[name] = old
+	}
+	return err
+}
+
+// 写回 JSON 文件，先写临时文件再重命名。调用方需持有写锁
+func (cs *collectionStore) save() error {
+	list := make([]*Collection, 0, len(cs.items))
+	for _, col := range cs.items {
+		list = append(list, col)
+	}
+	slices.SortFunc(list, func(a, b *Collection) int { return strings.Compare(a.Name, b.Name) })
+	buf, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(cs.path), filepath.Base(cs.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cs.path)
+}
+
+// 按 API Key 和集合的配置渲染系统提示：API Key 配置了系统提示模板时使用 API Key 的，
+// 否则使用集合的；主题优先使用集合的 topic
+func (s *Server) renderSystemPrompt(apiKey *APIKey, col *Collection, systemPrompt string) (string, error) {
+	topic := s.cfg.Topic
+	if col != nil && col.Topic != "" {
+		topic = col.Topic
+	}
+	if (apiKey == nil || apiKey.promptTmpl == nil) && col != nil && col.promptTmpl != nil {
+		var sb strings.Builder
+		err := col.promptTmpl.Execute(&sb, map[string]string{
+			"SystemPrompt": systemPrompt,
+			"Topic":        topic,
+		})
+		return sb.String(), err
+	}
+	return apiKey.renderSystemPrompt(systemPrompt, topic)
+}
+
+// 集合及其文档数和最近 7 天的检索统计
+func (s *Server) collectionView(col *Collection) gin.H {
+	documents := 0
+	for _, doc := range s.currentPipeline().Store().Documents() {
+		if doc.Collection == col.Name && doc.SplitFrom == 0 {
+			documents++
+		}
+	}
+	stats := []collectionDayRow{}
+	for _, row := range s.stats.rows(time.Now(), 7, 5) {
+		if row.Collection == col.Name {
+			stats = append(stats, row)
+		}
+	}
+	return gin.H{"collection": col, "documents": documents, "stats": stats}
+}
+
+func (s *Server) listCollectionsHandler(c *gin.Context) {
+	views := []gin.H{}
+	for _, col := range s.collections.list() {
+		views = append(views, s.collectionView(col))
+	}
+	c.JSON(http.StatusOK, gin.H{"collections": views})
+}
+
+func (s *Server) getCollectionHandler(c *gin.Context) {
+	col := s.collections.get(c.Param("name"))
+	if col == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errCollectionNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.collectionView(col))
+}
+
+// 新建集合，名称已存在时返回 409
+func (s *Server) createCollectionHandler(c *gin.Context) {
+	var col Collection
+	err := c.ShouldBindJSON(&col)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if s.collections.get(col.Name) != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("collection %q already exists", col.Name)})
+		return
+	}
+	s.putCollection(c, &col)
+}
+
+// 整体替换集合的配置，不存在时新建；请求体中的名称可以省略
+func (s *Server) updateCollectionHandler(c *gin.Context) {
+	var col Collection
+	err := c.ShouldBindJSON(&col)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if col.Name == "" {
+		col.Name = c.Param("name")
+	}
+	if col.Name != c.Param("name") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name does not match the path"})
+		return
+	}
+	s.putCollection(c, &col)
+}
+
+func (s *Server) putCollection(c *gin.Context, col *Collection) {
+	err := col.compile(s.prompts.templates)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created, err := s.collections.put(col)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fmt.Printf("collection %s saved\n", col.Name)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, s.collectionView(col))
+}
+
+func (s *Server) deleteCollectionHandler(c *gin.Context) {
+	err := s.collections.remove(c.Param("name"))
+	if errors.Is(err, errCollectionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fmt.Printf("collection %s deleted\n", c.Param("name"))
+	c.JSON(http.StatusOK, gin.H{"deleted": c.Param("name")})
+}
@@ -0,0 +1,35 @@
+package gateway
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// 对比模式的回答提示模板名，记录在答案缓存键和响应头中
+const comparePromptTemplate = "compare"
+
+// 对比模式默认的回答提示模板，要求以表格并列对比各文档
+const defaultComparePrompt = "请对比以下各篇文档，回答用户的问题：{{.Question}}\n\n{{.Context}}" +
+	"请以 Markdown 表格并列对比：每篇文档一列，以文档标题为列名，每个对比维度一行，文档中未提及的项填写“未提及”。" +
+	"表格之后用一两句话总结主要差异。{{.Instruction}}"
+
+// 对比模式的扩展字段，doc_ids 和 titles 可以同时使用，合计 2 至 5 篇文档
+type compareOptions struct {
+	DocIds []int    `json:"doc_ids"`
+	Titles []string `json:"titles"`
+}
+
+// 加载对比模式的回答提示模板，tmpl 为空时使用默认模板
+func loadComparePrompt(tmpl string) (*template.Template, error) {
+	if tmpl == "" {
+		tmpl = defaultComparePrompt
+	}
+	t, err := template.New(comparePromptTemplate).Parse(tmpl)
+	if err == nil {
+		_, err = renderPrompt(t, "", "", "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("COMPARE_TEMPLATE: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// 文本补全格式的流式数据块
+type completionChunk struct {
+	Id      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+	Usage   *openai.Usage      `json:"usage,omitempty"`
+}
+
+type completionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	Logprobs     any     `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// 兼容旧版文本补全接口：将 prompt 作为用户问题交给聊天流程回答，以文本补全格式流式返回。
+// 请求体中同样可以使用 lento 的扩展字段
+func (s *Server) completionsHandler(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	chatBody, err := completionToChat(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(chatBody))
+	s.serveChat(c, chatChunkToCompletion)
+}
+
+// 将文本补全请求转换为等价的聊天请求，保留扩展字段
+func completionToChat(body []byte) ([]byte, error) {
+	var request openai.CompletionRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := completionPrompt(request.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	if request.N > 1 || request.BestOf > 1 {
+		return nil, errors.New("n and best_of greater than 1 are not supported")
+	}
+	if request.Echo || request.Suffix != "" {
+		return nil, errors.New("echo and suffix are not supported")
+	}
+
+	// 扩展字段原样保留，标准字段替换为聊天请求的字段
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(body, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"prompt", "best_of", "echo", "suffix", "logprobs", "logit_bias", "n"} {
+		delete(fields, k)
+	}
+	chat, err := json.Marshal(&openai.ChatCompletionRequest{
+		Model:            request.Model,
+		Messages:         []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}},
+		MaxTokens:        request.MaxTokens,
+		Temperature:      request.Temperature,
+		TopP:             request.TopP,
+		Stop:             request.Stop,
+		Seed:             request.Seed,
+		PresencePenalty:  request.PresencePenalty,
+		FrequencyPenalty: request.FrequencyPenalty,
+		User:             request.User,
+		Stream:           true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(chat, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// prompt 可以是字符串或只含一个字符串的数组
+func completionPrompt(v any) (string, error) {
+	switch p := v.(type) {
+	case string:
+		if p != "" {
+			return p, nil
+		}
+	case []any:
+		if len(p) == 1 {
+			if s, ok := p[0].(string); ok && s != "" {
+				return s, nil
+			}
+		} else if len(p) > 1 {
+			return "", errors.New("only one prompt per request is supported")
+		}
+	}
+	return "", errors.New("prompt must be a non-empty string")
+}
+
+// 将聊天格式的数据块转换为文本补全格式，错误等其他数据原样返回
+func chatChunkToCompletion(buf []byte) []byte {
+	var chunk openai.ChatCompletionStreamResponse
+	if json.Unmarshal(buf, &chunk) != nil || (chunk.Object != "chat.completion.chunk" && len(chunk.Choices) == 0) {
+		return buf
+	}
+	out := completionChunk{
+		Id:      chunk.ID,
+		Object:  "text_completion",
+		Created: chunk.Created,
+		Model:   chunk.Model,
+		Choices: make([]completionChoice, len(chunk.Choices)),
+		Usage:   chunk.Usage,
+	}
+	for i, choice := range chunk.Choices {
+		out.Choices[i] = completionChoice{Text: choice.Delta.Content, Index: choice.Index}
+		if choice.FinishReason != "" {
+			reason := string(choice.FinishReason)
+			out.Choices[i].FinishReason = &reason
+		}
+	}
+	converted, err := json.Marshal(&out)
+	if err != nil {
+		return buf
+	}
+	return converted
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/provider"
+)
+
+// 请求超出 REQUEST_MAX_DURATION 时上下文结束的原因
+var errRequestTimeout = errors.New("request exceeded REQUEST_MAX_DURATION")
+
+// 流式回答超时结束时的结束原因
+const finishReasonTimeout = "timeout"
+
+// 为回答请求设置整体的截止时间，替换到 c.Request 中。未配置 REQUEST_MAX_DURATION 时不做限制，
+// 返回的 exceeded 总是 false
+func (s *Server) withDeadline(c *gin.Context, start time.Time) (exceeded func() bool, cleanup func()) {
+	if s.cfg.RequestMaxDuration <= 0 {
+		return func() bool { return false }, func() {}
+	}
+	ctx, cancel := s.deadlineContext(c.Request.Context(), start)
+	c.Request = c.Request.WithContext(ctx)
+	return func() bool { return errors.Is(context.Cause(ctx), errRequestTimeout) }, cancel
+}
+
+// 在 parent 上加上从 start 起算的截止时间，用于不随连接断开而取消的上游调用
+func (s *Server) deadlineContext(parent context.Context, start time.Time) (context.Context, context.CancelFunc) {
+	if s.cfg.RequestMaxDuration <= 0 {
+		return parent, func() {}
+	}
+	return context.WithDeadlineCause(parent, start.Add(s.cfg.RequestMaxDuration), errRequestTimeout)
+}
+
+// 超出截止时间的错误，保留原始错误用于日志
+func deadlineError(err error, max time.Duration) error {
+	return fmt.Errorf("%w (%v): %w", errRequestTimeout, max, err)
+}
+
+// 流式回答中途超时时代替剩余内容的结束 chunk
+func timeoutChunk(model string) []byte {
+	buf, err := provider.NewChunk(provider.NewChunkId(), model, "", finishReasonTimeout)
+	if err != nil {
+		return nil
+	}
+	return buf
+}
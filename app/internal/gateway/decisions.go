@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/decisions"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 将请求的检索决策放入写入队列，未开启决策日志或未检索时忽略
+func (s *Server) recordDecision(ctx context.Context, start time.Time, tenant, collection, model, question, indexVersion string,
+	timing *serverTiming, result *retrieval.Result) {
+	if s.decisions == nil || result == nil {
+		return
+	}
+	r := &decisions.Record{
+		Time:         time.Now(),
+		RequestId:    provider.RequestId(ctx),
+		TraceId:      provider.TraceId(ctx),
+		Tenant:       tenant,
+		Collection:   collection,
+		Model:        model,
+		Question:     s.questions.question(question),
+		IndexVersion: indexVersion,
+		Candidates:   make([]decisions.Candidate, len(result.Candidates)),
+		DocIds:       result.DocIds,
+		Fallback:     result.RerankFallback,
+		TimingsMs:    map[string]float64{},
+		DurationMs:   float64(time.Since(start).Microseconds()) / 1000,
+	}
+	for i, c := range result.Candidates {
+		r.Candidates[i] = decisions.Candidate{DocId: c.DocId, RecallScore: c.RecallScore, RerankScore: c.RerankScore, Selected: c.Selected}
+	}
+	for _, t := range timing.timings {
+		r.TimingsMs[t.Name] = float64(t.Duration.Microseconds()) / 1000
+	}
+	if top, ok := result.TopRerankScore(); ok {
+		r.TopScore = &top
+	}
+	s.decisions.Add(r)
+}
+
+// 检索决策日志已写入和丢弃的记录数
+func (s *Server) decisionStatsHandler(c *gin.Context) {
+	written, dropped := s.decisions.Stats()
+	c.JSON(http.StatusOK, gin.H{"sink": s.decisions.String(), "written": written, "dropped": dropped})
+}
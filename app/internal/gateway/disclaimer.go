@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 免责声明模板的参数
+type disclaimerData struct {
+	// 当前索引的建立日期
+	IndexDate  string
+	Collection string
+	Model      string
+}
+
+// 加载免责声明模板：DISCLAIMER 为默认模板，DISCLAIMERS_FILE 为集合名到模板的 JSON 映射，
+// 覆盖对应集合的默认模板，模板为空字符串表示该集合不附加免责声明。返回的映射中默认模板的键为空字符串
+func loadDisclaimers(text, path string) (map[string]*template.Template, error) {
+	texts := map[string]string{}
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(buf, &texts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if _, ok := texts[""]; !ok {
+		texts[""] = text
+	}
+
+	disclaimers := make(map[string]*template.Template, len(texts))
+	for collection, text := range texts {
+		if text == "" {
+			disclaimers[collection] = nil
+			continue
+		}
+		tmpl, err := template.New("disclaimer").Parse(text)
+		if err == nil {
+			err = tmpl.Execute(&strings.Builder{}, &disclaimerData{})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("disclaimer for collection %q: %w", collection, err)
+		}
+		disclaimers[collection] = tmpl
+	}
+	return disclaimers, nil
+}
+
+// 构造附在回答末尾的免责声明，作为一个独立的流式 chunk 返回；集合没有免责声明时返回 nil
+func (s *Server) disclaimerChunk(model, collection string, pipeline *retrieval.Pipeline) []byte {
+	tmpl, ok := s.disclaimers[collection]
+	if !ok {
+		tmpl = s.disclaimers[""]
+	}
+	if col := s.collections.get(collection); col != nil && col.Disclaimer != nil {
+		tmpl = col.disclaimerTmpl
+	}
+	if tmpl == nil {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\n")
+	err := tmpl.Execute(&sb, &disclaimerData{
+		IndexDate:  pipeline.BuiltAt().Format(time.DateOnly),
+		Collection: collection,
+		Model:      model,
+	})
+	if err != nil {
+		logging.Errorf("disclaimer: %v\n", err)
+		return nil
+	}
+	buf, err := provider.NewChunk(provider.NewChunkId(), model, sb.String(), "")
+	if err != nil {
+		return nil
+	}
+	return buf
+}
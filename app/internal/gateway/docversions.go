@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/index"
+	"rag_app/internal/ingest"
+	"rag_app/internal/logging"
+)
+
+// 每次重新加载后文档的版本变化，每篇文档保留最近 size 个版本，设置了 path 时持久化到文件
+type docVersions struct {
+	mu       sync.Mutex
+	size     int
+	path     string
+	versions map[int][]*ingest.DocVersion
+}
+
+func newDocVersions(size int, path string) (*docVersions, error) {
+	v := &docVersions{size: size, path: path, versions: map[int][]*ingest.DocVersion{}}
+	if path == "" {
+		return v, nil
+	}
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return v, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &v.versions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, nil
+}
+
+// 比较重新加载前后的文档，记录变化的文档的新版本
+func (v *docVersions) record(current, next []*index.Document) {
+	if v.size <= 0 {
+		return
+	}
+	changes := ingest.Versions(current, next, time.Now())
+	if len(changes) == 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, change := range changes {
+		list := append(v.versions[change.DocId], change)
+		if len(list) > v.size {
+			list = slices.Clone(list[len(list)-v.size:])
+		}
+		v.versions[change.DocId] = list
+	}
+	logging.Infof("document versions: %d documents changed\n", len(changes))
+	if v.path == "" {
+		return
+	}
+	buf, err := json.Marshal(v.versions)
+	if err == nil {
+		err = os.WriteFile(v.path, buf, 0o644)
+	}
+	if err != nil {
+		logging.Errorf("save document versions to %s: %v\n", v.path, err)
+	}
+}
+
+// 文档的版本，最新的在前
+func (v *docVersions) list(docId int) []*ingest.DocVersion {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	list := slices.Clone(v.versions[docId])
+	slices.Reverse(list)
+	return list
+}
+
+// 全部文档的变化，最新的在前，不含内容差异
+func (v *docVersions) recent() []ingest.DocVersion {
+	v.mu.Lock()
+	all := []ingest.DocVersion{}
+	for _, list := range v.versions {
+		for _, version := range list {
+			summary := *version
+			summary.ContentDiff = ""
+			all = append(all, summary)
+		}
+	}
+	v.mu.Unlock()
+	slices.SortFunc(all, func(a, b ingest.DocVersion) int {
+		if c := b.ChangedAt.Compare(a.ChangedAt); c != 0 {
+			return c
+		}
+		return a.DocId - b.DocId
+	})
+	return all
+}
+
+// 列出文档的历史版本及每个版本相对上一版本的内容差异
+func (s *Server) documentVersionsHandler(c *gin.Context) {
+	docId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid document id"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"doc_id": docId, "versions": s.docVersions.list(docId)})
+}
+
+// 列出最近重新加载时变化的文档，默认 100 条，带 stale=true 参数时只返回摘要可能需要重新生成的文档
+func (s *Server) documentChangesHandler(c *gin.Context) {
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)})
+			return
+		}
+		limit = n
+	}
+	changes := s.docVersions.recent()
+	if c.Query("stale") == "true" {
+		changes = slices.DeleteFunc(changes, func(v ingest.DocVersion) bool { return !v.SummaryStale })
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": changes[:min(limit, len(changes))]})
+}
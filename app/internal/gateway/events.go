@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/provider"
+)
+
+// 运维事件的类型
+const (
+	eventRequestStarted   = "request.started"
+	eventRequestCompleted = "request.completed"
+	eventError            = "error"
+	eventIndexChanged     = "index.changed"
+)
+
+// 未指定 since 时默认回放的最近事件数
+const defaultEventTail = 50
+
+// 一条运维事件，seq 从 1 开始递增，可用于断线后续传
+type opsEvent struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	Data gin.H     `json:"data"`
+}
+
+// 最近的运维事件，只保留最新的 size 条，供 /admin/events/stream 实时查看
+type eventLog struct {
+	mu     sync.Mutex
+	size   int
+	events []opsEvent
+	seq    int64
+	// 有新事件时关闭并替换，用于唤醒等待中的订阅者
+	changed chan struct{}
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{size: size, changed: make(chan struct{})}
+}
+
+func (l *eventLog) emit(kind string, data gin.H) {
+	if l == nil || l.size <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.events = append(l.events, opsEvent{Seq: l.seq, Time: time.Now().UTC(), Type: kind, Data: data})
+	if len(l.events) > l.size {
+		l.events = l.events[len(l.events)-l.size:]
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// 返回序号大于 seq 的事件，以及下一次事件的通知
+func (l *eventLog) since(seq int64) ([]opsEvent, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := len(l.events)
+	for i > 0 && l.events[i-1].Seq > seq {
+		i--
+	}
+	return l.events[i:], l.changed
+}
+
+// 最近 n 条事件之前的序号
+func (l *eventLog) tail(n int) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n >= len(l.events) {
+		return l.seq - int64(len(l.events))
+	}
+	return l.seq - int64(n)
+}
+
+// 记录 /v1 接口请求的开始和结束
+func (s *Server) requestEvents(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+		c.Next()
+		return
+	}
+	start := time.Now()
+	requestId := provider.RequestId(c.Request.Context())
+	s.events.emit(eventRequestStarted, gin.H{
+		"request_id": requestId,
+		"method":     c.Request.Method,
+		"path":       c.Request.URL.Path,
+	})
+	c.Next()
+	s.events.emit(eventRequestCompleted, gin.H{
+		"request_id":  requestId,
+		"method":      c.Request.Method,
+		"path":        c.Request.URL.Path,
+		"tenant":      tenantOf(apiKeyFrom(c)),
+		"status":      c.Writer.Status(),
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
+
+// 以 SSE 实时推送运维事件：先回放最近的事件，再持续推送直至客户端断开。
+// since 指定从哪个序号之后开始，否则回放最近 tail 条；type 为逗号分隔的类型前缀，只推送匹配的事件
+func (s *Server) eventsStreamHandler(c *gin.Context) {
+	var next int64
+	if v := c.Query("since"); v != "" {
+		seq, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+		next = seq
+	} else {
+		n := defaultEventTail
+		if v := c.Query("tail"); v != "" {
+			var err error
+			n, err = strconv.Atoi(v)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tail"})
+				return
+			}
+		}
+		next = s.events.tail(n)
+	}
+	var types []string
+	if v := c.Query("type"); v != "" {
+		types = strings.Split(v, ",")
+	}
+
+	sse := newSSEWriter(c, s.cfg.SseDone, s.cfg.SseTerminator)
+	sse.compress, sse.compressLevel = s.cfg.SseGzip, s.cfg.SseGzipLevel
+	defer sse.finish()
+	sse.start()
+	for {
+		events, changed := s.events.since(next)
+		for _, e := range events {
+			if types == nil || matchEventType(types, e.Type) {
+				sse.event(e.Type, e)
+			}
+			next = e.Seq
+		}
+		select {
+		case <-changed:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func matchEventType(types []string, kind string) bool {
+	for _, t := range types {
+		if strings.HasPrefix(kind, strings.TrimSpace(t)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导出会话的完整问答记录及每轮的引用，format=markdown 时返回 Markdown，默认返回 JSON。
+// 只能导出本租户的会话
+func (s *Server) exportSessionHandler(c *gin.Context) {
+	id := c.Param("id")
+	turns, ok := s.sessions.transcript(id, tenantOf(apiKeyFrom(c)))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{"session_id": id, "turns": turns})
+	case "markdown":
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "session-"+id+".md"))
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(sessionMarkdown(id, turns)))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or markdown"})
+	}
+}
+
+func sessionMarkdown(id string, turns []*sessionTurn) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# 会话 %s\n", id)
+	for i, turn := range turns {
+		fmt.Fprintf(&sb, "\n## 第 %d 轮（%s）\n\n", i+1, turn.StartedAt.Format(time.DateTime))
+		fmt.Fprintf(&sb, "**问题**：%s\n\n", turn.Question)
+		fmt.Fprintf(&sb, "**回答**：\n\n%s\n", strings.TrimSpace(turn.Answer))
+		if len(turn.Citations) == 0 {
+			continue
+		}
+		sb.WriteString("\n参考资料：\n\n")
+		for j, c := range turn.Citations {
+			title := c.Title
+			if title == "" {
+				title = fmt.Sprintf("文档 %d", c.DocId)
+			}
+			if href := c.Href(); href != "" {
+				fmt.Fprintf(&sb, "%d. [%s](%s)\n", j+1, title, href)
+			} else {
+				fmt.Fprintf(&sb, "%d. %s\n", j+1, title)
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/config"
+	"rag_app/internal/retrieval"
+	"rag_app/internal/tokens"
+)
+
+// 请求可以通过 features 按次开关的实验性功能
+const (
+	// 按术语表扩展检索问题，默认在配置了 GLOSSARY_FILE 时开启
+	FeatureQueryExpansion = "query_expansion"
+	// HyDE：先由非推理模型写一段假设性回答，以其召回文档，默认关闭
+	FeatureHyDE = "hyde"
+	// 上下文压缩：每篇文档只取最相关的片段放入提示词，默认按 PROMPT_CHUNKS
+	FeatureCompression = "compression"
+	// 依据检查：以 lento.grounding 事件返回依据不足的句子，默认按 BUFFERED_PROCESSORS
+	FeatureGrounding = "grounding"
+)
+
+var requestFeatures = []string{FeatureQueryExpansion, FeatureHyDE, FeatureCompression, FeatureGrounding}
+
+// 请求开启上下文压缩而未配置 PROMPT_CHUNKS 时，每篇文档放入提示词的片段数
+const compressionChunks = 3
+
+// 生成假设性回答的提示
+const hydePrompt = "请直接写一段简短的文字回答用户的问题，写法像是摘自相关的文档。不确定时也给出最可能的内容，不要说明或提问。"
+
+// 请求中的功能开关，未出现的功能按部署配置
+type featureFlags map[string]bool
+
+// 校验 REQUEST_FEATURES：只能是已知功能，上下文压缩依赖片段索引
+func checkRequestFeatures(cfg *config.Config) error {
+	for _, name := range cfg.RequestFeatures {
+		if !slices.Contains(requestFeatures, name) {
+			return fmt.Errorf("invalid REQUEST_FEATURES: %q", name)
+		}
+	}
+	if slices.Contains(cfg.RequestFeatures, FeatureCompression) && cfg.RerankOn != retrieval.RerankOnChunk {
+		return errors.New("REQUEST_FEATURES: compression requires RERANK_ON=chunk")
+	}
+	return nil
+}
+
+// 校验请求的 features：功能须为已知的，且在 REQUEST_FEATURES 允许请求开关的范围内
+func (s *Server) parseFeatures(flags map[string]bool) (featureFlags, error) {
+	for name := range flags {
+		if !slices.Contains(requestFeatures, name) {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		if !slices.Contains(s.cfg.RequestFeatures, name) {
+			return nil, fmt.Errorf("feature %q is not allowed", name)
+		}
+	}
+	return featureFlags(flags), nil
+}
+
+// 功能是否开启，请求未指定时为 def
+func (f featureFlags) enabled(name string, def bool) bool {
+	if v, ok := f[name]; ok {
+		return v
+	}
+	return def
+}
+
+// 按请求的开关设置检索请求中的术语表扩展和上下文压缩
+func (s *Server) applyFeatures(f featureFlags, req *retrieval.Request) {
+	req.NoExpansion = !f.enabled(FeatureQueryExpansion, true)
+	if v, ok := f[FeatureCompression]; ok {
+		req.PromptChunks = -1
+		if v {
+			req.PromptChunks = compressionChunks
+			if s.cfg.PromptChunks > 0 {
+				req.PromptChunks = s.cfg.PromptChunks
+			}
+		}
+	}
+}
+
+// 缓冲模式下执行的后处理器：请求的 grounding 开关决定是否包含依据检查，其余按 BUFFERED_PROCESSORS
+func (s *Server) bufferedProcessors(f featureFlags) []string {
+	v, ok := f[FeatureGrounding]
+	if !ok {
+		return s.cfg.BufferedProcessors
+	}
+	processors := slices.DeleteFunc(slices.Clone(s.cfg.BufferedProcessors), func(name string) bool { return name == "grounding" })
+	if v {
+		processors = append([]string{"grounding"}, processors...)
+	}
+	return processors
+}
+
+// 请非推理模型针对问题写一段假设性回答，用于召回。上游调用的 token 计入 usage
+func (s *Server) hypotheticalAnswer(ctx context.Context, question string, usage *accounting.Usage) (string, error) {
+	request := openai.ChatCompletionRequest{
+		Model: s.cfg.ModelWithoutThinking,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: hydePrompt},
+			{Role: openai.ChatMessageRoleUser, Content: question},
+		},
+	}
+	usage.PromptTokens += estimateMessages(request.Messages)
+	response, err := s.llm.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("hyde: empty response")
+	}
+	content := strings.TrimSpace(response.Choices[0].Message.Content)
+	usage.CompletionTokens += int64(tokens.Estimate(content))
+	if content == "" {
+		return "", errors.New("hyde: empty answer")
+	}
+	return content, nil
+}
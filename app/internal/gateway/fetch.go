@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/index"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/tokens"
+)
+
+// 提示模式
+const (
+	// 检索到的文档全文放入提示词
+	PromptModeFull = "full"
+	// 只放入文档摘要，模型通过 fetch_document 工具按需获取全文
+	PromptModeSnippets = "snippets"
+)
+
+var promptModes = []string{PromptModeFull, PromptModeSnippets}
+
+// 获取文档全文的工具
+const fetchDocumentToolName = "fetch_document"
+
+var fetchDocumentTool = openai.Tool{
+	Type: openai.ToolTypeFunction,
+	Function: &openai.FunctionDefinition{
+		Name:        fetchDocumentToolName,
+		Description: "获取检索到的文档的全文。文档摘要不足以回答问题时调用",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"id":{"type":"integer","description":"文档ID"}},"required":["id"]}`),
+	},
+}
+
+// 返回请求生效的提示模式，为空时使用 PROMPT_MODE
+func (s *Server) promptMode(name string) (string, error) {
+	if name == "" {
+		name = s.cfg.PromptMode
+	}
+	if !slices.Contains(promptModes, name) {
+		return "", fmt.Errorf("invalid prompt mode %q", name)
+	}
+	return name, nil
+}
+
+// 拼接文档的摘要，代替全文放入提示词
+func formatSnippets(docs []*index.Document) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "检索到以下%d篇文档，这里只给出摘要，需要全文时调用 %s 工具获取：\n\n", len(docs), fetchDocumentToolName)
+	for _, doc := range docs {
+		fmt.Fprintf(&b, "文档ID %d", doc.DocId)
+		if len(doc.Title) > 0 {
+			fmt.Fprintf(&b, "，标题为「%s」", doc.Title)
+		}
+		if len(doc.URL) > 0 {
+			fmt.Fprintf(&b, "，链接为 %s", doc.URL)
+		}
+		fmt.Fprintf(&b, "：\n%s\n\n", doc.Summary)
+	}
+	return b.String()
+}
+
+// 执行一次 fetch_document 调用，只能获取本次检索到的文档，全文按 FETCH_DOCUMENT_MAX_TOKENS 截断
+func (s *Server) fetchDocument(docs []*index.Document, arguments string) string {
+	var args struct {
+		Id int `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("参数错误：%v", err)
+	}
+	i := slices.IndexFunc(docs, func(doc *index.Document) bool { return doc.DocId == args.Id })
+	if i < 0 {
+		return fmt.Sprintf("文档 %d 不在检索结果中", args.Id)
+	}
+	content := docs[i].Content
+	if s.cfg.FetchDocumentMaxTokens > 0 {
+		content = tokens.Truncate(content, s.cfg.FetchDocumentMaxTokens)
+	}
+	return content
+}
+
+// 流式返回中正在拼接的工具调用
+type pendingToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// 提供 fetch_document 工具的生成循环，由网关取回文档全文后继续生成。
+// 最多进行 FETCH_DOCUMENT_MAX_CALLS 轮工具调用，之后要求模型直接回答
+func (s *Server) generateWithFetch(ctx context.Context, generator provider.Generator, request openai.ChatCompletionRequest,
+	docs []*index.Document, promptTokens *atomic.Int64, push func(buf []byte)) error {
+	call := func(name, arguments string) string {
+		return s.fetchDocument(docs, arguments)
+	}
+	return s.generateWithTools(ctx, generator, request, []openai.Tool{fetchDocumentTool}, s.cfg.FetchDocumentMaxCalls, call, promptTokens, push)
+}
+
+// 由网关执行工具的生成循环：模型调用工具时不向客户端输出，由 call 执行后将结果交给模型继续生成。
+// 最多进行 maxCalls 轮工具调用，之后要求模型直接回答，模型仍调用工具时返回错误。
+// 工具调用轮次额外发送的提示 token 计入 promptTokens
+func (s *Server) generateWithTools(ctx context.Context, generator provider.Generator, request openai.ChatCompletionRequest,
+	tools []openai.Tool, maxCalls int, call func(name, arguments string) string, promptTokens *atomic.Int64, push func(buf []byte)) error {
+	request.Tools = tools
+	for round := 0; ; round++ {
+		if round >= maxCalls {
+			request.ToolChoice = "none"
+		}
+		if round > 0 {
+			promptTokens.Add(estimateMessages(request.Messages))
+		}
+		stream, err := generator.Stream(ctx, request)
+		if err != nil {
+			return err
+		}
+		if s.cfg.NormalizeStream {
+			stream = provider.NormalizeStream(stream)
+		}
+
+		calls := []*pendingToolCall{}
+		for {
+			buf, err := stream.Recv()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				stream.Close()
+				return err
+			}
+			var chunk openai.ChatCompletionStreamResponse
+			if json.Unmarshal(buf, &chunk) != nil || len(chunk.Choices) == 0 {
+				push(bytes.Clone(buf))
+				continue
+			}
+			choice := chunk.Choices[0]
+			for _, tc := range choice.Delta.ToolCalls {
+				// 没有 index 的增量属于上一个工具调用，第一个增量也没有 index 时为第一个
+				i := max(len(calls)-1, 0)
+				if tc.Index != nil {
+					i = *tc.Index
+				}
+				for len(calls) <= i {
+					calls = append(calls, &pendingToolCall{})
+				}
+				if tc.ID != "" {
+					calls[i].id = tc.ID
+				}
+				if tc.Function.Name != "" {
+					calls[i].name = tc.Function.Name
+				}
+				calls[i].arguments.WriteString(tc.Function.Arguments)
+			}
+			if len(choice.Delta.ToolCalls) > 0 || choice.FinishReason == openai.FinishReasonToolCalls {
+				continue
+			}
+			push(bytes.Clone(buf))
+		}
+		stream.Close()
+		if len(calls) == 0 {
+			return io.EOF
+		}
+		// 后端忽略 tool_choice=none 仍调用工具时停止，避免无限循环
+		if round >= maxCalls {
+			return fmt.Errorf("model called tools after %d tool rounds despite tool_choice=none", maxCalls)
+		}
+
+		assistant := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+		results := []openai.ChatCompletionMessage{}
+		for i, pending := range calls {
+			if pending.id == "" {
+				pending.id = fmt.Sprintf("lento_tool_%d_%d", round, i)
+			}
+			assistant.ToolCalls = append(assistant.ToolCalls, openai.ToolCall{
+				ID:       pending.id,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: pending.name, Arguments: pending.arguments.String()},
+			})
+			content := fmt.Sprintf("未知的工具 %s", pending.name)
+			if slices.ContainsFunc(tools, func(t openai.Tool) bool { return t.Function.Name == pending.name }) {
+				content = call(pending.name, pending.arguments.String())
+			}
+			// 参数可能含有用户输入，只在调试级别记录
+			logging.Infof("tool call %s in request %s\n", pending.name, provider.RequestId(ctx))
+			logging.Debugf("tool call %s arguments in request %s: %s\n", pending.name, provider.RequestId(ctx), pending.arguments.String())
+			results = append(results, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				ToolCallID: pending.id,
+				Name:       pending.name,
+				Content:    content,
+			})
+		}
+		request.Messages = append(slices.Clip(request.Messages), assistant)
+		request.Messages = append(request.Messages, results...)
+	}
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/config"
+	"rag_app/internal/provider"
+)
+
+// 忽略 tool_choice、每轮都调用工具的后端
+type toolLoopGenerator struct {
+	requests []openai.ChatCompletionRequest
+}
+
+func (g *toolLoopGenerator) Stream(ctx context.Context, request openai.ChatCompletionRequest) (provider.ChatStream, error) {
+	g.requests = append(g.requests, request)
+	chunk, err := json.Marshal(openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{{
+			Delta: openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{{
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: fetchDocumentTool.Function.Name, Arguments: `{"id": 1}`},
+			}}},
+			FinishReason: openai.FinishReasonToolCalls,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chunkStream{chunks: [][]byte{chunk}}, nil
+}
+
+type chunkStream struct {
+	chunks [][]byte
+}
+
+func (s *chunkStream) Recv() ([]byte, error) {
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *chunkStream) Close() error { return nil }
+
+func TestGenerateWithToolsStopsAfterForcedRound(t *testing.T) {
+	s := &Server{cfg: &config.Config{}}
+	generator := &toolLoopGenerator{}
+	calls := 0
+	call := func(name, arguments string) string {
+		calls++
+		return "content"
+	}
+	var promptTokens atomic.Int64
+	err := s.generateWithTools(context.Background(), generator, openai.ChatCompletionRequest{}, []openai.Tool{fetchDocumentTool},
+		2, call, &promptTokens, func(buf []byte) {})
+	if err == nil {
+		t.Fatal("generateWithTools() succeeded although the model kept calling tools")
+	}
+	if len(generator.requests) != 3 {
+		t.Errorf("sent %d generation requests, want 2 tool rounds and 1 forced round", len(generator.requests))
+	}
+	if calls != 2 {
+		t.Errorf("executed %d tool calls, want 2", calls)
+	}
+	if last := generator.requests[len(generator.requests)-1]; last.ToolChoice != "none" {
+		t.Errorf("last request tool_choice = %v, want none", last.ToolChoice)
+	}
+}
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/tokens"
+)
+
+// 工具调用参数和工具结果在聊天记录中保留的最大 token 数
+const toolContentTokens = 50
+
+// 构造用于提取原始问题的聊天记录。系统消息被跳过，早期对话的摘要除外；工具调用只保留函数名和截断的参数，
+// 工具返回结果只保留开头部分，避免大段结构化数据干扰问题提取
+func buildChatHistory(messages []openai.ChatCompletionMessage) string {
+	var sb strings.Builder
+	for i, msg := range messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleSystem:
+			if msg.Name == summaryMessageName {
+				fmt.Fprintf(&sb, "%d. [此前对话的摘要] %s\n\n", i, msg.Content)
+			}
+			continue
+		case openai.ChatMessageRoleTool, openai.ChatMessageRoleFunction:
+			name := msg.Name
+			if name == "" {
+				name = msg.ToolCallID
+			}
+			fmt.Fprintf(&sb, "%d. [role=%s] 工具 %s 返回：%s\n\n", i, msg.Role, name, compact(msg.Content))
+			continue
+		}
+
+		content := messageText(msg)
+		calls := []string{}
+		for _, call := range msg.ToolCalls {
+			calls = append(calls, fmt.Sprintf("%s(%s)", call.Function.Name, compact(call.Function.Arguments)))
+		}
+		if msg.FunctionCall != nil {
+			calls = append(calls, fmt.Sprintf("%s(%s)", msg.FunctionCall.Name, compact(msg.FunctionCall.Arguments)))
+		}
+		if len(calls) > 0 {
+			if content != "" {
+				content += " "
+			}
+			content += "调用工具 " + strings.Join(calls, "，")
+		}
+		if content == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "%d. [role=%s] %s\n\n", i, msg.Role, content)
+	}
+	return sb.String()
+}
+
+// 消息的文本内容，多模态消息只取其中的文本部分
+func messageText(msg openai.ChatCompletionMessage) string {
+	if msg.Content != "" || len(msg.MultiContent) == 0 {
+		return msg.Content
+	}
+	parts := []string{}
+	for _, part := range msg.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeText {
+			parts = append(parts, part.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// 压缩为单行并截断
+func compact(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	truncated := tokens.Truncate(s, toolContentTokens)
+	if len(truncated) < len(s) {
+		truncated += "…"
+	}
+	return truncated
+}
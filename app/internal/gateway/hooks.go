@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/hooks"
+	"rag_app/internal/index"
+	"rag_app/internal/logging"
+	"rag_app/internal/retrieval"
+)
+
+// 执行 pre_rewrite 或 pre_generation 钩子，返回钩子修改后的消息。钩子拿到的是副本，不影响原消息
+func (s *Server) messageHooks(ctx context.Context, stage string, st *hooks.State, messages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, error) {
+	if !s.hooks.Has(stage) {
+		return messages, nil
+	}
+	st.Messages = slices.Clone(messages)
+	err := s.hooks.Run(ctx, stage, st)
+	if err != nil {
+		return nil, err
+	}
+	return st.Messages, nil
+}
+
+// 执行 post_retrieval 钩子。钩子修改了文档时按新的文档重新拼接，返回新的检索结果；
+// 检索结果可能被缓存和合并的请求共享，不修改原结果。表格集合没有文档，不允许修改
+func (s *Server) retrievalHooks(ctx context.Context, st *hooks.State, result *retrieval.Result, table bool) (*retrieval.Result, error) {
+	if !s.hooks.Has(hooks.PostRetrieval) {
+		return result, nil
+	}
+	st.Docs = slices.Clone(result.Docs)
+	st.Candidates = slices.Clone(result.Candidates)
+	err := s.hooks.Run(ctx, hooks.PostRetrieval, st)
+	if err != nil {
+		return nil, err
+	}
+	if slices.EqualFunc(st.Docs, result.Docs, func(a, b *index.Document) bool { return a == b }) {
+		return result, nil
+	}
+	if table {
+		return nil, fmt.Errorf("hooks: %s hooks cannot change documents of a table collection", hooks.PostRetrieval)
+	}
+	return result.WithDocs(st.Docs), nil
+}
+
+// 执行 post_generation 钩子，返回钩子修改后的回答。回答可能已经发送，钩子失败时只记录日志，返回原回答
+func (s *Server) answerHooks(ctx context.Context, st *hooks.State, answer string) string {
+	if !s.hooks.Has(hooks.PostGeneration) {
+		return answer
+	}
+	st.Answer = answer
+	err := s.hooks.Run(ctx, hooks.PostGeneration, st)
+	if err != nil {
+		logging.Errorf("%s hooks failed in request %s, keeping the original answer: %v\n", hooks.PostGeneration, st.RequestId, err)
+		return answer
+	}
+	return st.Answer
+}
+
+// pre_generation 钩子可能按请求修改提示消息，此时回答缓存的键还要区分修改后的消息
+func hookedCacheKey(key string, messages []openai.ChatCompletionMessage) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", key)
+	for _, m := range messages {
+		fmt.Fprintf(h, "%s\x00%s\x00", m.Role, messageText(m))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 保留的最近任务数
+const maxJobs = 20
+
+// 后台任务的事件，按顺序保存以便后来的订阅者回放
+type jobEvent struct {
+	name string
+	data any
+}
+
+// 异步执行的后台任务，如重建索引
+type job struct {
+	Id        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	StartedAt time.Time `json:"started_at"`
+
+	mu       sync.Mutex
+	events   []jobEvent
+	finished bool
+	// 有新事件时关闭并替换，用于唤醒等待中的订阅者
+	changed chan struct{}
+}
+
+func (j *job) emit(name string, data any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.finished {
+		return
+	}
+	j.events = append(j.events, jobEvent{name, data})
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// 记录任务的最后一个事件并结束任务
+func (j *job) finish(name string, data any) {
+	j.emit(name, data)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finished = true
+}
+
+// 返回从 from 开始的事件、任务是否结束，以及下一次事件的通知
+func (j *job) since(from int) ([]jobEvent, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.events[from:], j.finished, j.changed
+}
+
+type jobStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*job)}
+}
+
+func (s *jobStore) start(kind string) *job {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	j := &job{
+		Id:        hex.EncodeToString(buf),
+		Kind:      kind,
+		StartedAt: time.Now(),
+		changed:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.Id] = j
+	s.order = append(s.order, j.Id)
+	if len(s.order) > maxJobs {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	return j
+}
+
+func (s *jobStore) get(id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// 以 SSE 返回后台任务的进度事件：先回放已有事件，再持续推送直至任务结束或客户端断开
+func (s *Server) jobEventsHandler(c *gin.Context) {
+	j, ok := s.jobs.get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+
+	sse := newSSEWriter(c, s.cfg.SseDone, s.cfg.SseTerminator)
+	sse.compress, sse.compressLevel = s.cfg.SseGzip, s.cfg.SseGzipLevel
+	defer sse.finish()
+	sse.start()
+	sse.event("lento.job", j)
+
+	next := 0
+	for {
+		events, finished, changed := j.since(next)
+		for _, e := range events {
+			sse.event(e.name, e.data)
+		}
+		next += len(events)
+		if finished {
+			return
+		}
+		select {
+		case <-changed:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/lock"
+	"rag_app/internal/logging"
+)
+
+// 获取和释放分布式锁的超时时间
+const lockTimeout = 10 * time.Second
+
+// 重建期间失去跨副本的重建锁
+var errLockLost = errors.New("reindex lock lost, another replica may be rebuilding")
+
+// 获取跨副本的重建锁，调用方需已持有本副本的 reloading 锁。未配置分布式锁时返回 nil；
+// 锁被其他副本持有或锁服务不可用时写入错误响应并返回 false
+func (s *Server) lockReindex(c *gin.Context) (lock.Lease, bool) {
+	if s.locker == nil {
+		return nil, true
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), lockTimeout)
+	defer cancel()
+	lease, err := s.locker.TryLock(ctx, s.cfg.Lock.Name)
+	if errors.Is(err, lock.ErrHeld) {
+		c.JSON(http.StatusConflict, gin.H{"error": "reload in progress on another replica"})
+		return nil, false
+	}
+	if err != nil {
+		logging.Errorf("lock %s: %v\n", s.cfg.Lock.Name, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("acquire lock: %v", err)})
+		return nil, false
+	}
+	return lease, true
+}
+
+// 释放跨副本的重建锁，失败时只记录日志，redis 锁会在过期后自动释放
+func (s *Server) unlockReindex(lease lock.Lease) {
+	if lease == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	err := lease.Release(ctx)
+	if err != nil {
+		logging.Errorf("lock %s: release failed: %v\n", s.cfg.Lock.Name, err)
+	}
+}
+
+// 派生受重建锁保护的操作使用的 context，持有期间失去锁时以 errLockLost 取消。
+// 未配置分布式锁时 lease 为 nil，只在 cancel 时取消
+func reindexContext(parent context.Context, lease lock.Lease) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	if lease != nil {
+		go func() {
+			select {
+			case <-lease.Lost():
+				cancel(errLockLost)
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// 重建结束后检查锁是否仍然有效：失去锁后即使重建成功也不替换索引，
+// 因为其他副本可能已基于更新的语料重建并写入了共享的快照
+func lockError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, errLockLost) {
+		return cause
+	}
+	return err
+}
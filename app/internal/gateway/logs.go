@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/logging"
+)
+
+// 修改日志设置的请求，未设置的字段保持不变
+type logSettingsRequest struct {
+	Level           string   `json:"level"`
+	DebugSampleRate *float64 `json:"debug_sample_rate"`
+}
+
+// 查看当前的日志级别和调试日志抽样比例
+func (s *Server) logSettingsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, logging.Current())
+}
+
+// 运行时修改日志级别和调试日志抽样比例，无需重启，重启后恢复为 LOG_LEVEL 和 LOG_DEBUG_SAMPLE_RATE
+func (s *Server) updateLogSettingsHandler(c *gin.Context) {
+	var body logSettingsRequest
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	settings := logging.Current()
+	if body.Level != "" {
+		settings.Level = body.Level
+	}
+	if body.DebugSampleRate != nil {
+		settings.DebugSampleRate = *body.DebugSampleRate
+	}
+	err = logging.Configure(settings.Level, settings.DebugSampleRate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fmt.Printf("log settings changed by %s: level=%s debug_sample_rate=%g\n",
+		c.MustGet("adminToken").(*AdminToken).Name, settings.Level, settings.DebugSampleRate)
+	c.JSON(http.StatusOK, settings)
+}
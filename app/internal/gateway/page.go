@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 分页查询的最大条数
+const maxPageLimit = 1000
+
+// 按文档 ID 分页：limit 为每页条数，cursor 为上一页返回的 next_cursor。
+// 结果总是按文档 ID 升序排列，翻页期间有文档增删也不会重复或遗漏其余文档
+type page struct {
+	limit int
+	after int
+}
+
+// 解析 limit 和 cursor 参数，未传 limit 时使用 defaultLimit，0 表示不分页。解析失败时已返回 400
+func parsePage(c *gin.Context, defaultLimit int) (page, bool) {
+	p := page{limit: defaultLimit, after: -1}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)})
+			return p, false
+		}
+		p.limit = limit
+	}
+	if v := c.Query("cursor"); v != "" {
+		after, err := decodeCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return p, false
+		}
+		p.after = after
+	}
+	return p, true
+}
+
+// 游标为最后一条的文档 ID，编码后对调用方不透明
+func encodeCursor(docId int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("doc:" + strconv.Itoa(docId)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	var docId int
+	_, err = fmt.Sscanf(string(buf), "doc:%d", &docId)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	return docId, nil
+}
+
+// 按文档 ID 排序后取出一页，还有下一页时返回其游标
+func paginate[T any](items []T, docId func(T) int, p page) ([]T, string) {
+	items = slices.Clone(items)
+	slices.SortStableFunc(items, func(a, b T) int { return docId(a) - docId(b) })
+	start, _ := slices.BinarySearchFunc(items, p.after+1, func(item T, target int) int { return docId(item) - target })
+	items = items[start:]
+	if p.limit <= 0 || len(items) <= p.limit {
+		return items, ""
+	}
+	items = items[:p.limit]
+	return items, encodeCursor(docId(items[len(items)-1]))
+}
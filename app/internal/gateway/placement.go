@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 检索到的上下文在提示词中的位置，不同模型对各位置上的依据指令遵循程度不同
+const (
+	// 作为用户消息的前缀，与问题放在一起
+	PlacementUser = "user"
+	// 追加到系统提示之后，用户消息只包含问题
+	PlacementSystem = "system"
+	// 模拟一次检索工具调用，上下文作为工具结果返回
+	PlacementTool = "tool"
+	// 作为问题之前的一条助手消息
+	PlacementAssistant = "assistant"
+)
+
+var placements = []string{PlacementUser, PlacementSystem, PlacementTool, PlacementAssistant}
+
+// 模拟的检索工具名及其调用 ID
+const (
+	retrieveToolName   = "retrieve"
+	retrieveToolCallId = "lento_retrieve"
+)
+
+// 检查上下文位置的配置
+func validatePlacements(defaultPlacement string, byModel map[string]string) error {
+	if !slices.Contains(placements, defaultPlacement) {
+		return fmt.Errorf("invalid CONTEXT_PLACEMENT: %q", defaultPlacement)
+	}
+	for model, placement := range byModel {
+		if !slices.Contains(placements, placement) {
+			return fmt.Errorf("invalid CONTEXT_PLACEMENT_MODELS: %q for model %q", placement, model)
+		}
+	}
+	return nil
+}
+
+// 返回模型对应的上下文位置，未单独配置时使用 CONTEXT_PLACEMENT
+func (s *Server) contextPlacement(model string) string {
+	if placement, ok := s.cfg.ContextPlacementModels[model]; ok {
+		return placement
+	}
+	return s.cfg.ContextPlacement
+}
+
+// 按上下文位置组装发送给模型的消息，instruction 为引用格式等附加要求。
+// user 位置的消息由回答提示模板 tmpl 渲染，tmpl 为 nil 时使用默认模板
+func contextMessages(placement string, tmpl *template.Template, systemPrompt, question, content, instruction string) []openai.ChatCompletionMessage {
+	system := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: systemPrompt}
+	switch placement {
+	case PlacementSystem:
+		system.Content += fmt.Sprintf("\n\n请根据以下检索到的信息回答用户的问题。\n\n%s", content) + instruction
+		return []openai.ChatCompletionMessage{
+			system,
+			{Role: openai.ChatMessageRoleUser, Content: question},
+		}
+	case PlacementTool:
+		args, _ := json.Marshal(map[string]string{"query": question})
+		return []openai.ChatCompletionMessage{
+			system,
+			{Role: openai.ChatMessageRoleUser, Content: question},
+			{
+				Role: openai.ChatMessageRoleAssistant,
+				ToolCalls: []openai.ToolCall{{
+					ID:   retrieveToolCallId,
+					Type: openai.ToolTypeFunction,
+					Function: openai.FunctionCall{
+						Name:      retrieveToolName,
+						Arguments: string(args),
+					},
+				}},
+			},
+			{
+				Role:       openai.ChatMessageRoleTool,
+				ToolCallID: retrieveToolCallId,
+				Name:       retrieveToolName,
+				Content:    strings.TrimSpace(content) + "\n\n请根据以上检索到的信息回答用户的问题。" + instruction,
+			},
+		}
+	case PlacementAssistant:
+		return []openai.ChatCompletionMessage{
+			system,
+			{Role: openai.ChatMessageRoleAssistant, Content: content},
+			{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("请根据以上检索到的信息，回答问题：%s", question) + instruction},
+		}
+	}
+	prompt, err := renderPrompt(tmpl, question, content, instruction)
+	if err != nil {
+		// 模板在加载时已校验，渲染失败时退回默认模板
+		fmt.Printf("render prompt template %s: %v\n", tmpl.Name(), err)
+		prompt, _ = renderPrompt(nil, question, content, instruction)
+	}
+	return []openai.ChatCompletionMessage{
+		system,
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/retrieval"
+	"rag_app/internal/tokens"
+)
+
+// 缩减文档后重新拼接提示词的最大次数
+const maxRefits = 5
+
+// 系统提示和问题本身已超出模型上下文窗口，缩减文档也无法容纳
+type contextLengthError struct {
+	tokens int
+	window int
+}
+
+func (e *contextLengthError) Error() string {
+	return fmt.Sprintf("prompt needs about %d tokens, over the model context window of %d", e.tokens, e.window)
+}
+
+func (e *contextLengthError) Unwrap() error {
+	return retrieval.ErrContextTooLarge
+}
+
+// 为回答预留的 token 数：请求中的 max_tokens，未设置时为 GENERATION_RESERVE_TOKENS
+func (s *Server) generationReserve(request *openai.ChatCompletionRequest) int {
+	if request.MaxCompletionTokens > 0 {
+		return request.MaxCompletionTokens
+	}
+	if request.MaxTokens > 0 {
+		return request.MaxTokens
+	}
+	return s.cfg.GenerationReserve
+}
+
+// 生成前估算提示词的 token 数，超出模型上下文窗口时按超出的量缩减检索到的文档并重新拼接，
+// 代替上游返回难以理解的上下文长度错误。window 为 0 时不检查
+func fitPrompt(window, reserve int, result *retrieval.Result, build func(*retrieval.Result) []openai.ChatCompletionMessage) (*retrieval.Result, []openai.ChatCompletionMessage, error) {
+	messages := build(result)
+	if window <= 0 {
+		return result, messages, nil
+	}
+	// 每次按超出的量（至少 10%）继续减小文档内容的上限，截断位置落在换行处导致仍然超出时也能收敛
+	budget := tokens.Estimate(result.Content)
+	for i := 0; ; i++ {
+		n := int(estimateMessages(messages)) + reserve
+		if n <= window {
+			return result, messages, nil
+		}
+		budget -= max(n-window, budget/10)
+		fitted, ok := result.Refit(budget)
+		if !ok || i == maxRefits {
+			return nil, nil, &contextLengthError{tokens: n, window: window}
+		}
+		fmt.Printf("prompt %d tokens over context window %d, context reduced to %d tokens\n", n, window, budget)
+		result = fitted
+		messages = build(result)
+	}
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+)
+
+// 预热连接的超时时间
+const prefetchTimeout = 5 * time.Second
+
+// 在重排序进行时预先解析模型路由并预热生成后端的连接，缩短首字时间。
+// 同时进行的预热数达到 GENERATION_PREFETCH_MAX_INFLIGHT 时直接跳过，不给上游增加额外压力；
+// ctx 取消（如检索失败）时预热随之中止
+func (s *Server) prefetchGeneration(ctx context.Context, model string) {
+	generator, _ := s.gens.Resolve(model)
+	warmer, ok := generator.(provider.Warmer)
+	if !ok {
+		return
+	}
+	select {
+	case s.prefetches <- struct{}{}:
+	default:
+		logging.Debugf("generation prefetch skipped: %d in flight\n", cap(s.prefetches))
+		return
+	}
+	go func() {
+		defer func() { <-s.prefetches }()
+		ctx, cancel := context.WithTimeout(ctx, prefetchTimeout)
+		defer cancel()
+		start := time.Now()
+		err := warmer.Warm(ctx)
+		if err != nil && ctx.Err() == nil {
+			logging.Warnf("generation prefetch failed: %v\n", err)
+			return
+		}
+		logging.Debugf("generation prefetch: %s\n", time.Since(start))
+	}()
+}
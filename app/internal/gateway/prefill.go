@@ -0,0 +1,30 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 请求以助手消息结尾（预填充回答的开头）时的处理方式
+const (
+	// 与其他消息一样计入聊天记录，用于改写问题，生成时不保留
+	PrefillHistory = "history"
+	// 不参与改写和检索，原样放在发送给模型的消息末尾，由模型接着生成
+	PrefillPassthrough = "passthrough"
+)
+
+var prefillModes = []string{PrefillHistory, PrefillPassthrough}
+
+// 拆出末尾的预填充助手消息。只有不含工具调用、内容非空的助手消息视为预填充
+func splitPrefill(messages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, *openai.ChatCompletionMessage) {
+	if len(messages) == 0 {
+		return messages, nil
+	}
+	last := messages[len(messages)-1]
+	if last.Role != openai.ChatMessageRoleAssistant || len(last.ToolCalls) > 0 || last.FunctionCall != nil ||
+		strings.TrimSpace(messageText(last)) == "" {
+		return messages, nil
+	}
+	return messages[:len(messages)-1], &last
+}
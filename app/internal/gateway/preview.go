@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/hooks"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 预览模板草稿的长度上限
+const maxPreviewTemplate = 64 * 1024
+
+// 提示预览的请求。template 为模板草稿，与 template_name 二选一，均为空时使用默认模板
+type previewRequest struct {
+	Template     string `json:"template"`
+	TemplateName string `json:"template_name"`
+	Question     string `json:"question"`
+	Collection   string `json:"collection"`
+	Model        string `json:"model"`
+	SystemPrompt string `json:"system_prompt"`
+	// 引用标注格式，为空时使用 CITATION_FORMAT
+	CitationFormat string `json:"citation_format"`
+}
+
+// 按模板草稿和示例问题组装完整的提示词：使用真实的检索结果，但不调用生成模型，
+// 也不计入模板统计、会话记忆和检索统计，供编辑模板时在启用前反复试验
+func (s *Server) previewPromptHandler(c *gin.Context) {
+	var body previewRequest
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(body.Question) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "question is required"})
+		return
+	}
+	name, tmpl, err := s.previewTemplate(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	citationFormat := body.CitationFormat
+	if citationFormat == "" {
+		citationFormat = s.cfg.CitationFormat
+	}
+	err = validateCitationFormat(citationFormat)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	col := s.collections.get(body.Collection)
+	systemPrompt, err := s.renderSystemPrompt(nil, col, body.SystemPrompt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+	start := time.Now()
+	pipeline := s.currentPipeline()
+	result, err := pipeline.Run(ctx, &retrieval.Request{
+		Question:      body.Question,
+		Collection:    body.Collection,
+		Sources:       col.sources(),
+		Model:         body.Model,
+		Blocklist:     s.blocklist,
+		Deterministic: true,
+	})
+	if err != nil {
+		clientErr := s.redact(c.Request.Context(), err)
+		c.JSON(clientErr.status, clientErr.body())
+		return
+	}
+	// 与实际请求一样经过钩子，预览的提示词与线上一致
+	hookState := &hooks.State{RequestId: provider.RequestId(c.Request.Context()), Collection: body.Collection, Model: body.Model, Question: body.Question}
+	var messages []openai.ChatCompletionMessage
+	result, err = s.retrievalHooks(ctx, hookState, result, false)
+	if err == nil {
+		// 模板只在 user 位置生效，预览总是使用 user 位置
+		result, messages, err = fitPrompt(pipeline.ContextWindow(body.Model), s.cfg.GenerationReserve, result, func(result *retrieval.Result) []openai.ChatCompletionMessage {
+			return contextMessages(PlacementUser, tmpl, systemPrompt, body.Question, result.Content, citationInstruction(citationFormat))
+		})
+	}
+	if err == nil {
+		messages, err = s.messageHooks(ctx, hooks.PreGeneration, hookState, messages)
+	}
+	if err != nil {
+		clientErr := s.redact(c.Request.Context(), err)
+		c.JSON(clientErr.status, clientErr.body())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"template":         name,
+		"template_version": templateVersion(tmpl),
+		"messages":         messages,
+		"prompt_tokens":    estimateMessages(messages),
+		"doc_ids":          result.DocIds,
+		"citations":        result.Citations(),
+		"warnings":         result.Warnings,
+		"elapsed":          time.Since(start).String(),
+	})
+}
+
+// 解析预览所用的模板：草稿、已加载的模板或默认模板。草稿引用了不存在的字段时返回错误
+func (s *Server) previewTemplate(body *previewRequest) (string, *template.Template, error) {
+	switch {
+	case body.Template != "" && body.TemplateName != "":
+		return "", nil, errors.New("template and template_name are mutually exclusive")
+	case body.TemplateName != "":
+		tmpl, ok := s.prompts.templates[body.TemplateName]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown template_name: %q", body.TemplateName)
+		}
+		return body.TemplateName, tmpl, nil
+	case body.Template == "":
+		return defaultPromptTemplate, defaultPrompt, nil
+	}
+	if len(body.Template) > maxPreviewTemplate {
+		return "", nil, fmt.Errorf("template exceeds %d bytes", maxPreviewTemplate)
+	}
+	tmpl, err := template.New("draft").Parse(body.Template)
+	if err == nil {
+		_, err = renderPrompt(tmpl, "", "", "")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return "draft", tmpl, nil
+}
@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 查询记录中问题的保存方式
+const (
+	// 保存问题原文
+	QueryLogRaw = "raw"
+	// 只保存问题的带密钥哈希，相同的问题哈希相同，可用于统计但无法还原原文
+	QueryLogHashed = "hashed"
+)
+
+var queryLogModes = []string{QueryLogRaw, QueryLogHashed}
+
+// 哈希问题的前缀，表明字段中保存的不是原文
+const questionHashPrefix = "hmac-sha256:"
+
+// 按 QUERY_LOG_MODE 处理写入查询记录和检索决策日志的问题
+type questionLogger struct {
+	// 为 nil 时保存原文
+	key []byte
+}
+
+func newQuestionLogger(mode, key string) (*questionLogger, error) {
+	if !slices.Contains(queryLogModes, mode) {
+		return nil, fmt.Errorf("invalid QUERY_LOG_MODE: %q", mode)
+	}
+	if mode == QueryLogRaw {
+		return &questionLogger{}, nil
+	}
+	// 没有密钥时常见问题可以通过穷举还原
+	if key == "" {
+		return nil, errors.New("QUERY_LOG_MODE=hashed requires QUERY_LOG_HASH_KEY")
+	}
+	return &questionLogger{key: []byte(key)}, nil
+}
+
+// 是否只保存哈希
+func (l *questionLogger) hashed() bool {
+	return l.key != nil
+}
+
+// 记录中保存的问题：原文，或规范化后的 HMAC-SHA256 的前 16 字节。
+// 规范化去掉首尾空白、合并连续空白并转为小写，使仅有大小写和空白差异的问题计为同一个
+func (l *questionLogger) question(question string) string {
+	if !l.hashed() {
+		return question
+	}
+	normalized := strings.ToLower(strings.Join(strings.Fields(question), " "))
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(normalized))
+	return questionHashPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 记录的终端用户数上限，超出时淘汰最久未访问的用户
+const maxTrackedUsers = 100000
+
+// 空闲超过此时长的用户在淘汰时优先删除
+const userIdleTtl = time.Hour
+
+// 一个统计窗口内被限流的次数达到此值时标记为可疑用户
+const abuseThreshold = 20
+
+// user 字段参与限流的最大字节数，更长的部分被截断
+const maxUserLength = 256
+
+// 请求中 user 字段标识的终端用户的限流状态和统计
+type userState struct {
+	Tenant    string    `json:"tenant"`
+	User      string    `json:"user"`
+	Requests  int64     `json:"requests"`
+	Limited   int64     `json:"limited"`
+	Flagged   bool      `json:"flagged"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// 令牌桶中剩余的请求数
+	tokens float64
+	// 当前统计窗口的开始时间和窗口内被限流的次数
+	windowStart time.Time
+	windowLimit int
+	// 在 users 中的键
+	key string
+}
+
+// 按终端用户的令牌桶限流。多个产品共用一个 API Key 时，
+// 单个终端用户的突发请求不会耗尽整个 Key 的配额
+type userLimiter struct {
+	mu    sync.Mutex
+	users map[string]*list.Element
+	// 按最近访问时间排序的用户，最近访问的在前
+	order *list.List
+}
+
+func newUserLimiter() *userLimiter {
+	return &userLimiter{users: make(map[string]*list.Element), order: list.New()}
+}
+
+// 消耗用户的一个请求，perMinute 为每分钟的请求数，burst 为桶容量。
+// 被限流时返回 false 和需要等待的时长
+func (l *userLimiter) allow(tenant, user string, perMinute, burst int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := tenant + "\x00" + user
+	var st *userState
+	if el, ok := l.users[key]; ok {
+		st = el.Value.(*userState)
+		l.order.MoveToFront(el)
+	} else {
+		l.evict(now)
+		st = &userState{Tenant: tenant, User: user, FirstSeen: now, LastSeen: now, tokens: float64(burst), windowStart: now, key: key}
+		l.users[key] = l.order.PushFront(st)
+	}
+	rate := float64(perMinute) / 60
+	st.tokens = math.Min(float64(burst), st.tokens+now.Sub(st.LastSeen).Seconds()*rate)
+	st.LastSeen = now
+	st.Requests++
+	if now.Sub(st.windowStart) >= time.Minute {
+		st.windowStart, st.windowLimit = now, 0
+	}
+
+	if st.tokens >= 1 {
+		st.tokens--
+		return true, 0
+	}
+	st.Limited++
+	st.windowLimit++
+	if st.windowLimit == abuseThreshold {
+		st.Flagged = true
+		fmt.Printf("user %q of tenant %s flagged: rate limited %d times within a minute\n", user, tenant, abuseThreshold)
+	}
+	return false, time.Duration((1 - st.tokens) / rate * float64(time.Second))
+}
+
+// 用户数达到上限时先删除空闲的用户，仍然超出时删除最久未访问的用户。
+// 用户按访问时间排序，只需从最久未访问的一端删除
+func (l *userLimiter) evict(now time.Time) {
+	if len(l.users) < maxTrackedUsers {
+		return
+	}
+	for el := l.order.Back(); el != nil; el = l.order.Back() {
+		st := el.Value.(*userState)
+		if len(l.users) < maxTrackedUsers && now.Sub(st.LastSeen) <= userIdleTtl {
+			return
+		}
+		l.order.Remove(el)
+		delete(l.users, st.key)
+	}
+}
+
+// 返回租户下的用户统计，被限流次数多的排在前面，tenant 为空时返回全部租户
+func (l *userLimiter) report(tenant string, limit int) []userState {
+	l.mu.Lock()
+	rows := []userState{}
+	for el := l.order.Front(); el != nil; el = el.Next() {
+		st := el.Value.(*userState)
+		if tenant == "" || st.Tenant == tenant {
+			rows = append(rows, *st)
+		}
+	}
+	l.mu.Unlock()
+
+	slices.SortFunc(rows, func(a, b userState) int {
+		if a.Limited != b.Limited {
+			return int(b.Limited - a.Limited)
+		}
+		return int(b.Requests - a.Requests)
+	})
+	return rows[:min(limit, len(rows))]
+}
+
+// 按请求中的 user 字段限流，未传 user 或未配置 USER_RATE_LIMIT 时不限制。
+// 超出时返回 429 和 Retry-After
+func (s *Server) checkUserRate(c *gin.Context, tenant string, apiKey *APIKey, user string) bool {
+	perMinute := s.cfg.UserRateLimit
+	if apiKey != nil && apiKey.UserRateLimit > 0 {
+		perMinute = apiKey.UserRateLimit
+	}
+	if user == "" || perMinute <= 0 {
+		return true
+	}
+	burst := s.cfg.UserRateBurst
+	if burst <= 0 {
+		burst = perMinute
+	}
+	// 限制 user 的长度，避免超长的值占用内存
+	if len(user) > maxUserLength {
+		cut := maxUserLength
+		for cut > 0 && !utf8.RuneStart(user[cut]) {
+			cut--
+		}
+		user = user[:cut]
+	}
+
+	ok, wait := s.users.allow(tenant, user, perMinute, burst, time.Now())
+	if ok {
+		return true
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+		"code":    "user_rate_limited",
+		"message": fmt.Sprintf("rate limit of %d requests per minute exceeded for user %q", perMinute, user),
+	}})
+	return false
+}
+
+// 列出终端用户的请求数、被限流次数和是否被标记为可疑，用于发现滥用。
+// 可按 tenant 过滤，limit 默认 100
+func (s *Server) usersHandler(c *gin.Context) {
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)})
+			return
+		}
+		limit = n
+	}
+	c.JSON(http.StatusOK, gin.H{"users": s.users.report(c.Query("tenant"), limit)})
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/config"
+)
+
+func TestUserLimiterEvictsLeastRecentlySeen(t *testing.T) {
+	l := newUserLimiter()
+	now := time.Now()
+	for i := range maxTrackedUsers {
+		l.allow("acme", strconv.Itoa(i), 60, 10, now)
+	}
+	// 再次访问 0 号用户后，最久未访问的是 1 号用户
+	l.allow("acme", "0", 60, 10, now.Add(time.Second))
+	l.allow("acme", "new", 60, 10, now.Add(2*time.Second))
+	if len(l.users) != maxTrackedUsers || l.order.Len() != maxTrackedUsers {
+		t.Fatalf("tracking %d users (%d ordered), want %d", len(l.users), l.order.Len(), maxTrackedUsers)
+	}
+	for user, want := range map[string]bool{"0": true, "1": false, "2": true, "new": true} {
+		if _, ok := l.users["acme\x00"+user]; ok != want {
+			t.Errorf("user %s tracked = %t, want %t", user, ok, want)
+		}
+	}
+
+	// 空闲超过 userIdleTtl 的用户一并删除
+	l.allow("acme", "later", 60, 10, now.Add(userIdleTtl+time.Minute))
+	if len(l.users) != 1 || l.order.Len() != 1 {
+		t.Errorf("tracking %d users after idle eviction, want 1", len(l.users))
+	}
+}
+
+func TestCheckUserRateCapsUserLength(t *testing.T) {
+	l := newUserLimiter()
+	long := strings.Repeat("用", maxUserLength)
+	s := &Server{cfg: &config.Config{UserRateLimit: 60}, users: l}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if !s.checkUserRate(c, "acme", nil, long) {
+		t.Fatal("first request was rate limited")
+	}
+	for el := l.order.Front(); el != nil; el = el.Next() {
+		if user := el.Value.(*userState).User; len(user) > maxUserLength || !strings.HasPrefix(long, user) {
+			t.Errorf("tracked user of %d bytes, want at most %d", len(user), maxUserLength)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/hooks"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+)
+
+// 返回给客户端的错误码
+const (
+	codeContextLength       = "context_length_exceeded"
+	codeInvalidRequest      = "invalid_request"
+	codeCancelled           = "request_cancelled"
+	codeRequestTimeout      = "request_timeout"
+	codeUpstreamTimeout     = "upstream_timeout"
+	codeUpstreamRateLimited = "upstream_rate_limited"
+	codeUpstreamAuth        = "upstream_auth_failed"
+	codeUpstreamRejected    = "upstream_rejected"
+	codeUpstreamUnavailable = "upstream_unavailable"
+	codeRewriteFailed       = "rewrite_failed"
+	codeEmbeddingBackend    = "embedding_backend_error"
+	codeRerankBackend       = "rerank_backend_error"
+	codeNoRelevantDocs      = "no_relevant_documents"
+	codeRejected            = "request_rejected"
+	codeInternal            = "internal_error"
+)
+
+// 流水线各阶段的错误及其状态码和错误码，上游限流和超时仍按原因返回，以便客户端重试
+var stageErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{retrieval.ErrRewriteFailed, http.StatusBadGateway, codeRewriteFailed},
+	{retrieval.ErrEmbeddingBackend, http.StatusServiceUnavailable, codeEmbeddingBackend},
+	{retrieval.ErrRerankBackend, http.StatusBadGateway, codeRerankBackend},
+}
+
+// 各错误码对应的通用说明，脱敏时代替原始错误信息
+var errorMessages = map[string]string{
+	codeCancelled:           "the request was cancelled",
+	codeRequestTimeout:      "the request exceeded the maximum duration",
+	codeUpstreamTimeout:     "the upstream model service timed out",
+	codeUpstreamRateLimited: "the upstream model service is rate limited, retry later",
+	codeUpstreamAuth:        "the gateway failed to authenticate with the upstream model service",
+	codeUpstreamRejected:    "the upstream model service rejected the request",
+	codeUpstreamUnavailable: "the upstream model service is unavailable",
+	codeRewriteFailed:       "failed to extract the question from the conversation",
+	codeEmbeddingBackend:    "the embedding service failed",
+	codeRerankBackend:       "the rerank service failed",
+	codeInternal:            "internal error",
+}
+
+// 可返回给客户端的错误
+type clientError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *clientError) Error() string {
+	return e.message
+}
+
+// OpenAI 兼容的错误响应体
+func (e *clientError) body() gin.H {
+	return gin.H{"error": gin.H{"code": e.code, "message": e.message}}
+}
+
+// 按错误类型确定状态码和错误码
+func classifyError(err error) (int, string) {
+	if rejectErr := hooks.Rejected(err); rejectErr != nil {
+		if rejectErr.Status < 400 || rejectErr.Status > 599 {
+			return http.StatusForbidden, codeRejected
+		}
+		return rejectErr.Status, codeRejected
+	}
+	switch {
+	case errors.Is(err, errRequestTimeout):
+		return http.StatusGatewayTimeout, codeRequestTimeout
+	case errors.Is(err, retrieval.ErrContextTooLarge):
+		return http.StatusBadRequest, codeContextLength
+	case errors.Is(err, retrieval.ErrCompareDocuments):
+		return http.StatusBadRequest, codeInvalidRequest
+	case errors.Is(err, retrieval.ErrNoRelevantDocs):
+		return http.StatusNotFound, codeNoRelevantDocs
+	case errors.Is(err, context.Canceled):
+		return 499, codeCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, codeUpstreamTimeout
+	}
+
+	status, code := classifyUpstreamError(err)
+	if code == codeUpstreamRateLimited || code == codeUpstreamTimeout {
+		return status, code
+	}
+	for _, stage := range stageErrors {
+		if errors.Is(err, stage.err) {
+			return stage.status, stage.code
+		}
+	}
+	return status, code
+}
+
+// 按上游返回的状态码或网络错误确定状态码和错误码
+func classifyUpstreamError(err error) (int, string) {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	var netErr net.Error
+	upstreamStatus := 0
+	switch {
+	case errors.As(err, &apiErr):
+		upstreamStatus = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		upstreamStatus = reqErr.HTTPStatusCode
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return http.StatusGatewayTimeout, codeUpstreamTimeout
+		}
+		return http.StatusBadGateway, codeUpstreamUnavailable
+	default:
+		return http.StatusInternalServerError, codeInternal
+	}
+
+	switch {
+	case upstreamStatus == http.StatusTooManyRequests:
+		return http.StatusTooManyRequests, codeUpstreamRateLimited
+	case upstreamStatus == http.StatusUnauthorized || upstreamStatus == http.StatusForbidden:
+		return http.StatusBadGateway, codeUpstreamAuth
+	case upstreamStatus >= 400 && upstreamStatus < 500:
+		return http.StatusBadGateway, codeUpstreamRejected
+	default:
+		return http.StatusBadGateway, codeUpstreamUnavailable
+	}
+}
+
+// 将内部错误转换为返回给客户端的错误。上游错误信息中可能包含后端地址和令牌，
+// 开启 ERROR_REDACTION 时只返回错误码、通用说明和请求 ID，完整错误打印在服务端日志中
+func (s *Server) redact(ctx context.Context, err error) *clientError {
+	status, code := classifyError(err)
+	requestId := provider.RequestId(ctx)
+	logging.Errorf("request %s failed (%s): %v\n", requestId, code, err)
+	s.errorCounts.record(code)
+	s.events.emit(eventError, gin.H{"request_id": requestId, "status": status, "code": code, "error": err.Error()})
+
+	// 钩子拒绝的说明由钩子给出，原样返回，不带钩子名称和阶段
+	if rejectErr := hooks.Rejected(err); rejectErr != nil {
+		return &clientError{status: status, code: code, message: rejectErr.Message}
+	}
+	// 上下文长度、请求参数和无相关文档的错误由网关生成，不含敏感信息
+	if !s.cfg.ErrorRedaction || code == codeContextLength || code == codeInvalidRequest || code == codeNoRelevantDocs {
+		return &clientError{status: status, code: code, message: err.Error()}
+	}
+	message := errorMessages[code]
+	if requestId != "" {
+		message += fmt.Sprintf(" (request id %s)", requestId)
+	}
+	return &clientError{status: status, code: code, message: message}
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"encoding/json"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// lento 在 OpenAI 请求之外支持的扩展字段
+type requestOptions struct {
+	// 在结束前以 lento.context 事件返回发送给模型的完整提示上下文
+	IncludeContext bool `json:"include_context"`
+	// 在回答之前以 lento.explain 事件返回全部候选文档的召回和重排序分数及生效的数量限制
+	Explain bool `json:"explain"`
+	// 提取检索问题的策略，为空时使用 REWRITER 配置
+	Rewriter string `json:"rewriter"`
+	// 确定性模式：固定采样参数，缓存检索结果，使相同请求可复现相同回答
+	Deterministic bool `json:"deterministic"`
+	// 对比模式：对比指定的文档，以表格并列给出各文档的异同
+	Compare *compareOptions `json:"compare"`
+	// 问题含糊时返回澄清问题，不做检索
+	Clarify bool `json:"clarify"`
+	// 提示模式，为空时使用 PROMPT_MODE
+	PromptMode string `json:"prompt_mode"`
+	// 缓冲模式：等待完整回答，经 BUFFERED_PROCESSORS 后处理后再输出
+	Buffered bool `json:"buffered"`
+	// 按次开关的实验性功能，只能是 REQUEST_FEATURES 允许的，未指定的按部署配置
+	Features map[string]bool `json:"features"`
+}
+
+// 解析请求体，同时得到标准的 OpenAI 请求和扩展字段
+func parseChatRequest(body []byte) (*openai.ChatCompletionRequest, *requestOptions, error) {
+	body, schema, err := splitJSONSchema(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var request openai.ChatCompletionRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if schema != nil && request.ResponseFormat != nil && request.ResponseFormat.JSONSchema != nil {
+		request.ResponseFormat.JSONSchema.Schema = schema
+	}
+
+	var opts requestOptions
+	err = json.Unmarshal(body, &opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &request, &opts, nil
+}
+
+// 客户端库无法反序列化 response_format.json_schema.schema（类型为 json.Marshaler），
+// 解析前从请求体中取出，以原始 JSON 放回请求
+func splitJSONSchema(body []byte) ([]byte, json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields["response_format"] == nil {
+		return body, nil, nil
+	}
+	var format map[string]json.RawMessage
+	if json.Unmarshal(fields["response_format"], &format) != nil || format["json_schema"] == nil {
+		return body, nil, nil
+	}
+	var jsonSchema map[string]json.RawMessage
+	if json.Unmarshal(format["json_schema"], &jsonSchema) != nil || jsonSchema["schema"] == nil {
+		return body, nil, nil
+	}
+	schema := jsonSchema["schema"]
+	delete(jsonSchema, "schema")
+
+	var err error
+	format["json_schema"], err = json.Marshal(jsonSchema)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields["response_format"], err = json.Marshal(format)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err = json.Marshal(fields)
+	return body, schema, err
+}
+
+// 确定性模式的默认随机种子
+const deterministicSeed = 0
+
+// 固定采样参数。temperature 为 0 时会被客户端省略，因此使用一个足够小的正数，
+// 上游按贪心解码处理
+func pinSampling(request *openai.ChatCompletionRequest) {
+	request.Temperature = 1e-6
+	request.TopP = 1
+	if request.Seed == nil {
+		seed := deterministicSeed
+		request.Seed = &seed
+	}
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+	"rag_app/internal/tokens"
+)
+
+// 重排序代理的超时时间
+const rerankProxyTimeout = 60 * time.Second
+
+// 将重排序请求透传到 EMB_BASE_URL 的 /rerank 接口，使用网关的 API Key 鉴权和预算，
+// 调用方无需持有后端令牌。未指定 model 时使用 MODEL_RERANK
+func (s *Server) rerankHandler(c *gin.Context) {
+	var body map[string]any
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, _ := body["query"].(string)
+	documents, _ := body["documents"].([]any)
+	if query == "" || len(documents) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query and documents are required"})
+		return
+	}
+	if model, _ := body["model"].(string); model == "" {
+		body["model"] = s.cfg.ModelRerank
+	}
+
+	apiKey := apiKeyFrom(c)
+	tenant := tenantOf(apiKey)
+	if !s.checkBudget(c, tenant, apiKey) {
+		return
+	}
+	usage := &accounting.Usage{Requests: 1, PromptTokens: int64(tokens.Estimate(query)) * int64(len(documents))}
+	for _, doc := range documents {
+		if text, ok := doc.(string); ok {
+			usage.PromptTokens += int64(tokens.Estimate(text))
+		}
+	}
+	defer s.ledger.Record(tenant, time.Now(), usage)
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), rerankProxyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.EmbBaseUrl+"/rerank", bytes.NewReader(buf))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.cfg.EmbToken)
+
+	resp, err := provider.HTTPClient.Do(req)
+	if err != nil {
+		clientErr := s.redact(c.Request.Context(), fmt.Errorf("%w: %w", retrieval.ErrRerankBackend, err))
+		c.JSON(clientErr.status, clientErr.body())
+		return
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	c.Status(resp.StatusCode)
+	c.Header("Content-Type", contentType)
+	_, err = io.Copy(c.Writer, resp.Body)
+	if err != nil {
+		c.Error(err)
+	}
+}
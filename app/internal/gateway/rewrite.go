@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/tokens"
+)
+
+// 从聊天记录中提取检索问题的策略
+const (
+	// 调用非推理模型总结用户的原始问题
+	RewriterLLM = "llm"
+	// 取最后一条用户消息
+	RewriterLastUser = "last_user"
+	// 按模板拼接此前的用户消息和最后一条用户消息
+	RewriterTemplate = "template"
+	// 不做任何处理，直接使用最后一条消息
+	RewriterNone = "none"
+)
+
+// 模板改写时保留的此前用户消息条数
+const templateHistoryTurns = 2
+
+// 从聊天记录中提取用于检索的问题，上游调用的 token 计入 usage
+type Rewriter interface {
+	Rewrite(ctx context.Context, request openai.ChatCompletionRequest, usage *accounting.Usage) (string, error)
+}
+
+// 创建各策略的改写器，template 为模板改写所用的 text/template
+func newRewriters(llm *openai.Client, model, tmpl string) (map[string]Rewriter, error) {
+	t, err := template.New("rewrite").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("REWRITE_TEMPLATE: %w", err)
+	}
+	return map[string]Rewriter{
+		RewriterLLM:      &llmRewriter{client: llm, model: model},
+		RewriterLastUser: lastUserRewriter{},
+		RewriterTemplate: &templateRewriter{tmpl: t},
+		RewriterNone:     noneRewriter{},
+	}, nil
+}
+
+// 按请求指定的策略选择改写器，未指定时使用部署配置的策略
+func (s *Server) rewriter(name string) (Rewriter, error) {
+	if name == "" {
+		name = s.cfg.Rewriter
+	}
+	r, ok := s.rewriters[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid rewriter %q", name)
+	}
+	return r, nil
+}
+
+type llmRewriter struct {
+	client *openai.Client
+	model  string
+}
+
+func (r *llmRewriter) Rewrite(ctx context.Context, request openai.ChatCompletionRequest, usage *accounting.Usage) (string, error) {
+	request.Model = r.model
+	request.Stream = false
+	// 客户端的工具和响应格式针对回答，不用于提取问题
+	request.Tools, request.ToolChoice, request.Functions, request.ResponseFormat = nil, nil, nil, nil
+	chatHistory := buildChatHistory(request.Messages)
+	request.Messages = []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "请根据以下提供的聊天记录历史，总结出一条用户的原始问题。",
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: chatHistory,
+		},
+	}
+	usage.PromptTokens += estimateMessages(request.Messages)
+	response, err := r.client.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("rewrite: empty response")
+	}
+	question := response.Choices[0].Message.Content
+	usage.CompletionTokens += int64(tokens.Estimate(question))
+	return question, nil
+}
+
+type lastUserRewriter struct{}
+
+func (lastUserRewriter) Rewrite(ctx context.Context, request openai.ChatCompletionRequest, usage *accounting.Usage) (string, error) {
+	questions := userMessages(request.Messages)
+	if len(questions) == 0 {
+		return "", errors.New("no user message")
+	}
+	return questions[len(questions)-1], nil
+}
+
+type templateRewriter struct {
+	tmpl *template.Template
+}
+
+func (r *templateRewriter) Rewrite(ctx context.Context, request openai.ChatCompletionRequest, usage *accounting.Usage) (string, error) {
+	questions := userMessages(request.Messages)
+	if len(questions) == 0 {
+		return "", errors.New("no user message")
+	}
+	last := len(questions) - 1
+	var sb strings.Builder
+	err := r.tmpl.Execute(&sb, struct {
+		History  []string
+		Question string
+	}{
+		History:  questions[max(0, last-templateHistoryTurns):last],
+		Question: questions[last],
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+type noneRewriter struct{}
+
+func (noneRewriter) Rewrite(ctx context.Context, request openai.ChatCompletionRequest, usage *accounting.Usage) (string, error) {
+	last := request.Messages[len(request.Messages)-1]
+	return messageText(last), nil
+}
+
+// 全部非空的用户消息文本
+func userMessages(messages []openai.ChatCompletionMessage) []string {
+	texts := []string{}
+	for _, msg := range messages {
+		if msg.Role != openai.ChatMessageRoleUser {
+			continue
+		}
+		if text := strings.TrimSpace(messageText(msg)); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
@@ -0,0 +1,464 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/backup"
+	"rag_app/internal/cache"
+	"rag_app/internal/config"
+	"rag_app/internal/decisions"
+	"rag_app/internal/hooks"
+	"rag_app/internal/lock"
+	"rag_app/internal/metrics"
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+	"rag_app/internal/tables"
+)
+
+// 用量写入文件的间隔
+const ledgerFlushInterval = 30 * time.Second
+
+// 确定性请求缓存的检索结果数
+const pinnedRetrievalSize = 1024
+
+// OpenAI 兼容的 RAG 网关
+type Server struct {
+	cfg        *config.Config
+	llm        *openai.Client
+	gens       *provider.GeneratorRouter
+	pipelines  atomic.Pointer[pipelines]
+	reloading  sync.Mutex
+	sessions   *sessionMemory
+	answers    *cache.LRU[[][]byte]
+	flights    *coalescer
+	retrievals callGroup[*retrieval.Result]
+	// 确定性请求的检索结果，键包含流水线，重建索引后自然失效
+	pinnedRetrievals *cache.LRU[*retrieval.Result]
+	// 最近问题的检索结果，键同上，未开启检索缓存时为 nil
+	retrievalCache *cache.LRU[*retrieval.Result]
+	apiKeys        *keyStore
+	// 通过 /v1/collections 管理的集合，未配置 COLLECTIONS_FILE 时为 nil
+	collections *collectionStore
+	adminTokens []*AdminToken
+	ledger      *accounting.Ledger
+	captures    *captureLog
+	stats       *collectionStats
+	errorCounts *errorStats
+	inflight    *inflightRequests
+	jobs        *jobStore
+	events      *eventLog
+	rewriters   map[string]Rewriter
+	topics      atomic.Pointer[retrieval.TopicMap]
+	// 最近一次重建索引的进度，从未重建时为 nil
+	reindexProgress atomic.Pointer[retrieval.ProgressTracker]
+	prompts         *promptBandit
+	// 对比模式的回答提示模板
+	comparePrompt *template.Template
+	// 集合 -> 免责声明模板，默认模板的键为空字符串，nil 表示不附加
+	disclaimers map[string]*template.Template
+	// 正在进行的生成连接预热，容量为同时预热的上限
+	prefetches chan struct{}
+	batches    *batchStore
+	// 配置指纹，随响应返回
+	configHash string
+	users      *userLimiter
+	// 正在回答的批量问题，容量为同时回答的上限
+	batchSlots chan struct{}
+	blocklist  *retrieval.Blocklist
+	// 定期备份，未配置 BACKUP_URL 时为 nil
+	backups *backupRunner
+	// 检索决策日志，未配置 DECISIONS_SINK 时为 nil
+	decisions *decisions.Writer
+	// 查询记录和检索决策日志中问题的保存方式
+	questions *questionLogger
+	// PIPELINE_HOOKS 启用的流水线钩子，未配置时为 nil
+	hooks *hooks.Chain
+	// 跨副本的重建锁，未配置时为 nil
+	locker lock.Locker
+	// 发布索引快照的对象存储，未配置时为 nil
+	snapshots backup.Store
+	// 存档流式回答的对象存储，未配置时为 nil
+	transcripts backup.Store
+	// 以表格为数据来源的集合，由模型生成结构化查询
+	tables tables.Catalog
+	// 以 /metrics 导出的 Prometheus 指标
+	metrics       *metrics.Registry
+	streamMetrics *streamMetrics
+	docVersions   *docVersions
+}
+
+// 当前生效的主流水线和影子流水线，重建索引时整体替换
+type pipelines struct {
+	main   *retrieval.Pipeline
+	shadow *retrieval.Pipeline
+}
+
+func New(cfg *config.Config, pipeline *retrieval.Pipeline) (*Server, error) {
+	s := &Server{
+		cfg:        cfg,
+		configHash: cfg.Hash(),
+		llm:        provider.NewOpenAIClient(cfg.LlmBaseUrl, cfg.LlmToken),
+		sessions: newSessionMemory(cfg.SessionMemoryDocs, cfg.SessionMaxTurns, cfg.SessionTtl, sessionLimits{
+			maxSessions: cfg.SessionMaxSessions,
+			maxBytes:    cfg.SessionMaxBytes,
+			quotaBytes:  cfg.SessionQuotaBytes,
+		}),
+		answers:          cache.NewLRU[[][]byte](cfg.AnswerCacheSize, cfg.AnswerCacheTtl),
+		flights:          newCoalescer(),
+		pinnedRetrievals: cache.NewLRU[*retrieval.Result](pinnedRetrievalSize, 0),
+		captures:         newCaptureLog(cfg.Capture),
+		stats:            newCollectionStats(),
+		errorCounts:      newErrorStats(),
+		inflight:         newInflightRequests(),
+		jobs:             newJobStore(),
+		events:           newEventLog(cfg.EventsBufferSize),
+		batches:          newBatchStore(),
+		users:            newUserLimiter(),
+		batchSlots:       make(chan struct{}, max(cfg.BatchConcurrency, 1)),
+		metrics:          metrics.NewRegistry(),
+	}
+	s.streamMetrics = newStreamMetrics(s.metrics)
+
+	s.setPipeline(pipeline)
+	if cfg.RetrievalCacheSize > 0 {
+		s.retrievalCache = cache.NewLRU[*retrieval.Result](cfg.RetrievalCacheSize, cfg.RetrievalCacheTtl)
+	}
+
+	blocklist, err := retrieval.LoadBlocklist(cfg.BlocklistFile)
+	if err != nil {
+		return nil, err
+	}
+	s.blocklist = blocklist
+
+	s.tables, err = tables.Load(cfg.TablesFile)
+	if err != nil {
+		return nil, fmt.Errorf("TABLES_FILE: %w", err)
+	}
+
+	if cfg.SseGzip && (cfg.SseGzipLevel < gzip.HuffmanOnly || cfg.SseGzipLevel > gzip.BestCompression) {
+		return nil, fmt.Errorf("SSE_GZIP_LEVEL: %d is not between %d and %d", cfg.SseGzipLevel, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+
+	err = validateCitationFormat(cfg.CitationFormat)
+	if err != nil {
+		return nil, fmt.Errorf("CITATION_FORMAT: %w", err)
+	}
+
+	gens, err := provider.NewGeneratorRouter(
+		provider.NewOpenAIGenerator(cfg.LlmBaseUrl, cfg.LlmToken),
+		cfg.ModelAliasesFile,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.gens = gens
+	if cfg.BatchGenerationModel != "" && !gens.HasAlias(cfg.BatchGenerationModel) {
+		return nil, fmt.Errorf("BATCH_GENERATION_MODEL: %q is not in MODEL_ALIASES_FILE", cfg.BatchGenerationModel)
+	}
+
+	s.rewriters, err = newRewriters(s.llm, cfg.ModelWithoutThinking, cfg.RewriteTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.rewriters[cfg.Rewriter]; !ok {
+		return nil, fmt.Errorf("invalid REWRITER: %q", cfg.Rewriter)
+	}
+	err = hooks.LoadPlugins(cfg.PipelineHookPlugins)
+	if err != nil {
+		return nil, err
+	}
+	s.hooks, err = hooks.New(cfg.PipelineHooks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PIPELINE_HOOKS: %w", err)
+	}
+	for _, name := range cfg.BufferedProcessors {
+		if _, ok := answerProcessors[name]; !ok {
+			return nil, fmt.Errorf("invalid BUFFERED_PROCESSORS: %q", name)
+		}
+	}
+	err = checkRequestFeatures(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SessionMaxSessions < 0 || cfg.SessionMaxBytes < 0 || cfg.SessionQuotaBytes < 0 {
+		return nil, errors.New("SESSION_MAX_SESSIONS, SESSION_MAX_BYTES and SESSION_QUOTA_BYTES must not be negative")
+	}
+	if cfg.SessionMaxBytes > 0 && cfg.SessionQuotaBytes > cfg.SessionMaxBytes {
+		return nil, fmt.Errorf("SESSION_QUOTA_BYTES: %d exceeds SESSION_MAX_BYTES %d", cfg.SessionQuotaBytes, cfg.SessionMaxBytes)
+	}
+	if !slices.Contains(prefillModes, cfg.AssistantPrefill) {
+		return nil, fmt.Errorf("invalid ASSISTANT_PREFILL: %q", cfg.AssistantPrefill)
+	}
+	if !slices.Contains(promptModes, cfg.PromptMode) {
+		return nil, fmt.Errorf("invalid PROMPT_MODE: %q", cfg.PromptMode)
+	}
+	if cfg.GenerationPrefetch {
+		s.prefetches = make(chan struct{}, max(cfg.GenerationPrefetchMaxInflight, 1))
+	}
+	templates, err := loadPromptTemplates(cfg.PromptTemplatesFile)
+	if err != nil {
+		return nil, err
+	}
+	s.prompts = newPromptBandit(templates, cfg.PromptBanditEpsilon)
+	s.comparePrompt, err = loadComparePrompt(cfg.CompareTemplate)
+	if err != nil {
+		return nil, err
+	}
+	s.disclaimers, err = loadDisclaimers(cfg.Disclaimer, cfg.DisclaimersFile)
+	if err != nil {
+		return nil, err
+	}
+	err = validatePlacements(cfg.ContextPlacement, cfg.ContextPlacementModels)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ApiKeysFile != "" {
+		keys, err := loadAPIKeys(cfg.ApiKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		s.apiKeys = keys
+	}
+	if cfg.CollectionsFile != "" {
+		s.collections, err = loadCollections(cfg.CollectionsFile, templates)
+		if err != nil {
+			return nil, fmt.Errorf("COLLECTIONS_FILE: %w", err)
+		}
+	}
+
+	s.docVersions, err = newDocVersions(cfg.DocVersions, cfg.DocVersionsFile)
+	if err != nil {
+		return nil, fmt.Errorf("DOC_VERSIONS_FILE: %w", err)
+	}
+
+	s.ledger, err = accounting.NewLedger(cfg.AccountingFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AccountingFile != "" {
+		go s.flushLedger()
+	}
+
+	if cfg.Backup.Url != "" {
+		store, err := backup.Open(cfg.Backup.Url, backup.S3Options{
+			Endpoint:        cfg.Backup.S3Endpoint,
+			Region:          cfg.Backup.S3Region,
+			AccessKeyId:     cfg.Backup.S3AccessKeyId,
+			SecretAccessKey: cfg.Backup.S3SecretAccessKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("BACKUP_URL: %w", err)
+		}
+		s.backups = newBackupRunner(store, cfg.Backup.Interval, cfg.Backup.QueryLogSize)
+		if cfg.Backup.Interval > 0 {
+			go s.runBackups()
+		}
+	}
+
+	if cfg.Transcripts.Url != "" {
+		s.transcripts, err = backup.Open(cfg.Transcripts.Url, backup.S3Options{
+			Endpoint:        cfg.Transcripts.S3Endpoint,
+			Region:          cfg.Transcripts.S3Region,
+			AccessKeyId:     cfg.Transcripts.S3AccessKeyId,
+			SecretAccessKey: cfg.Transcripts.S3SecretAccessKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("TRANSCRIPT_STORE_URL: %w", err)
+		}
+	}
+
+	s.questions, err = newQuestionLogger(cfg.QueryLogMode, cfg.QueryLogHashKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Decisions.Sink != "" {
+		sink, err := decisions.Open(cfg.Decisions.Sink, cfg.Decisions.Url, cfg.Decisions.Table, cfg.Decisions.SqlDriver)
+		if err != nil {
+			return nil, fmt.Errorf("DECISIONS_SINK: %w", err)
+		}
+		s.decisions = decisions.NewWriter(sink, cfg.Decisions.BufferSize, cfg.Decisions.BatchSize, cfg.Decisions.FlushInterval)
+	}
+
+	s.snapshots, err = retrieval.OpenSnapshotStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if pipeline.Missing() > 0 {
+		go s.catchUp()
+	} else {
+		go s.publishSnapshots(pipeline)
+	}
+
+	if cfg.Lock.Backend != "" {
+		s.locker, err = lock.Open(cfg.Lock.Backend, cfg.Lock.Url, cfg.Lock.SqlDriver, cfg.Lock.Ttl)
+		if err != nil {
+			return nil, fmt.Errorf("LOCK_BACKEND: %w", err)
+		}
+		fmt.Printf("reindex lock: %s %s\n", s.locker, cfg.Lock.Name)
+	}
+
+	adminTokens, err := loadAdminTokens(cfg.AdminToken, cfg.AdminTokensFile)
+	if err != nil {
+		return nil, err
+	}
+	s.adminTokens = adminTokens
+
+	return s, nil
+}
+
+// 停止服务时调用，写入尚未保存的用量
+func (s *Server) Close() error {
+	return s.ledger.Flush()
+}
+
+// 定期将用量写入文件
+func (s *Server) flushLedger() {
+	for range time.Tick(ledgerFlushInterval) {
+		if err := s.ledger.Flush(); err != nil {
+			fmt.Println("flush accounting:", err)
+		}
+	}
+}
+
+func (s *Server) setPipeline(pipeline *retrieval.Pipeline) {
+	s.pipelines.Store(&pipelines{main: pipeline, shadow: pipeline.Shadow()})
+	s.events.emit(eventIndexChanged, gin.H{"index_version": pipeline.IndexVersion(), "documents": pipeline.Store().Len()})
+}
+
+func (s *Server) currentPipeline() *retrieval.Pipeline {
+	return s.pipelines.Load().main
+}
+
+func (s *Server) Router() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(accessLog), gin.Recovery(), requestId, s.requestEvents)
+	router.GET("/metrics", s.metricsHandler)
+	router.POST("/v1/chat/completions", s.apiKeyAuth, s.chatApiHandler)
+	router.POST("/v1/completions", s.apiKeyAuth, s.completionsHandler)
+	router.POST("/v1/requests/:id/cancel", s.apiKeyAuth, s.cancelRequestHandler)
+	router.POST("/v1/rerank", s.apiKeyAuth, s.rerankHandler)
+	router.GET("/v1/sessions/:id/export", s.apiKeyAuth, s.exportSessionHandler)
+	router.POST("/v1/feedback", s.apiKeyAuth, s.feedbackHandler)
+	router.POST("/v1/batches", s.apiKeyAuth, s.createBatchHandler)
+	router.GET("/v1/batches/:id", s.apiKeyAuth, s.batchHandler)
+
+	// 仅在配置了管理令牌时开放管理接口
+	if len(s.adminTokens) > 0 {
+		admin := router.Group("/admin", s.adminAuth)
+		admin.GET("/documents", requireRole(RoleDocuments), s.documentsHandler)
+		admin.GET("/documents/expired", requireRole(RoleDocuments), s.expiredDocumentsHandler)
+		admin.GET("/documents/changes", requireRole(RoleDocuments), s.documentChangesHandler)
+		admin.GET("/documents/:id/versions", requireRole(RoleDocuments), s.documentVersionsHandler)
+		admin.GET("/blocklist", requireRole(RoleDocuments), s.blocklistHandler)
+		admin.PUT("/blocklist/:id", s.writable, requireRole(RoleDocuments), s.blockDocumentHandler)
+		admin.DELETE("/blocklist/:id", s.writable, requireRole(RoleDocuments), s.unblockDocumentHandler)
+		admin.POST("/index/reload", s.writable, requireRole(RoleIndex), s.reloadIndexHandler)
+		admin.GET("/index/progress", requireRole(RoleIndex), s.reindexProgressHandler)
+		admin.GET("/jobs/:id/events", requireRole(RoleIndex), s.jobEventsHandler)
+		admin.GET("/events/stream", requireRole(RoleAudit), s.eventsStreamHandler)
+		admin.POST("/topics", s.writable, requireRole(RoleIndex), s.buildTopicsHandler)
+		admin.GET("/topics", requireRole(RoleAudit), s.topicsHandler)
+		admin.GET("/captures", requireRole(RoleAudit), s.capturesHandler)
+		admin.GET("/prompts/templates", requireRole(RoleAudit), s.promptTemplatesHandler)
+		admin.POST("/prompts/preview", requireRole(RoleAudit), s.previewPromptHandler)
+		if s.apiKeys != nil {
+			admin.GET("/keys", requireRole(RoleKeys), s.listKeysHandler)
+			admin.POST("/keys/:name", s.writable, requireRole(RoleKeys), s.createKeyHandler)
+			admin.DELETE("/keys/:name/:prefix", s.writable, requireRole(RoleKeys), s.revokeKeyHandler)
+		}
+		admin.GET("/stats/collections", requireRole(RoleAudit), s.collectionStatsHandler)
+		admin.GET("/stats/errors", requireRole(RoleAudit), s.errorStatsHandler)
+		admin.GET("/backends/check", requireRole(RoleAudit), s.checkBackendsHandler)
+		if s.decisions != nil {
+			admin.GET("/stats/decisions", requireRole(RoleAudit), s.decisionStatsHandler)
+		}
+		admin.GET("/usage", requireRole(RoleAudit), s.usageHandler)
+		admin.GET("/users", requireRole(RoleAudit), s.usersHandler)
+		if s.backups != nil {
+			admin.GET("/backups", requireRole(RoleAudit), s.backupStatusHandler)
+			admin.POST("/backups", requireRole(RoleAudit), s.createBackupHandler)
+		}
+		admin.GET("/log", requireRole(RoleLogs), s.logSettingsHandler)
+		admin.PUT("/log", requireRole(RoleLogs), s.updateLogSettingsHandler)
+		admin.GET("/vectors", requireRole(RoleIndex), s.vectorReportHandler)
+		admin.POST("/vectors/gc", s.writable, requireRole(RoleIndex), s.vectorGCHandler)
+		router.PUT("/v1/documents/:id/summary", s.adminAuth, s.writable, requireRole(RoleDocuments), s.updateSummaryHandler)
+		router.POST("/v1/documents:method", s.adminAuth, s.writable, requireRole(RoleDocuments), s.documentsMethodHandler)
+		if s.collections != nil {
+			router.GET("/v1/collections", s.adminAuth, requireRole(RoleDocuments), s.listCollectionsHandler)
+			router.POST("/v1/collections", s.adminAuth, s.writable, requireRole(RoleDocuments), s.createCollectionHandler)
+			router.GET("/v1/collections/:name", s.adminAuth, requireRole(RoleDocuments), s.getCollectionHandler)
+			router.PUT("/v1/collections/:name", s.adminAuth, s.writable, requireRole(RoleDocuments), s.updateCollectionHandler)
+			router.DELETE("/v1/collections/:name", s.adminAuth, s.writable, requireRole(RoleDocuments), s.deleteCollectionHandler)
+		}
+	}
+
+	return router
+}
+
+// 调用方传入的请求 ID 的最大长度
+const maxRequestIdLength = 128
+
+// 沿用调用方的 X-Request-ID，没有或不合法时生成一个；调用方传入 traceparent 时沿用其调用链。
+// 两者写入上下文以传递给上游请求、日志和审计记录，并在响应头中返回
+func requestId(c *gin.Context) {
+	id := c.GetHeader("X-Request-ID")
+	if !validRequestId(id) {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+	c.Header("X-Request-ID", id)
+	c.Set("request_id", id)
+	ctx := provider.WithRequestId(c.Request.Context(), id)
+	if trace, ok := provider.ParseTraceparent(c.GetHeader("traceparent"), c.GetHeader("tracestate")); ok {
+		c.Header("traceresponse", trace.Traceparent())
+		c.Set("trace_id", trace.TraceId)
+		ctx = provider.WithTrace(ctx, trace)
+	}
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// 请求 ID 会写入日志和上游请求头，只接受不含空白和控制字符的可打印 ASCII
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// 访问日志，在 gin 默认格式之后附加请求 ID 和 trace ID
+func accessLog(p gin.LogFormatterParams) string {
+	line := fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v",
+		p.TimeStamp.Format("2006/01/02 - 15:04:05"), p.StatusCode, p.Latency, p.ClientIP, p.Method, p.Path)
+	if id, ok := p.Keys["request_id"].(string); ok {
+		line += " | request " + id
+	}
+	if traceId, ok := p.Keys["trace_id"].(string); ok {
+		line += " | trace " + traceId
+	}
+	if p.ErrorMessage != "" {
+		line += "\n" + p.ErrorMessage
+	}
+	return line + "\n"
+}
@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+
+	"rag_app/internal/logging"
+	"rag_app/internal/retrieval"
+)
+
+// 会话记忆，记录同一会话中此前回答所引用的文档，便于追问时复用；
+// 同时保存每轮的问答记录，供导出会话。
+// 会话数和占用的内存都有上限，超出时淘汰最久未更新的会话，单个会话超出配额时丢弃最早的问答
+type sessionMemory struct {
+	mu       sync.Mutex
+	maxDocs  int
+	maxTurns int
+	ttl      time.Duration
+	limits   sessionLimits
+	items    map[string]*sessionEntry
+	// 按更新时间排列的会话 ID，最近更新的在前
+	ll *list.List
+	// 全部会话的估算字节数
+	bytes int
+}
+
+// 会话记忆的容量限制，为 0 时不限制
+type sessionLimits struct {
+	maxSessions int
+	maxBytes    int
+	// 单个会话的字节数配额
+	quotaBytes int
+}
+
+type sessionEntry struct {
+	docIds []int
+	// 会话所属的租户，只有同一租户可以导出
+	owner     string
+	turns     []*sessionTurn
+	updatedAt time.Time
+	// 早期对话的滚动摘要，未开启摘要或轮数不足时为 nil
+	summary *sessionSummary
+	el      *list.Element
+	// 计入全局用量的估算字节数
+	bytes int
+}
+
+// 会话中的一轮问答
+type sessionTurn struct {
+	Question string `json:"question"`
+	// 改写后用于检索的问题
+	Rewritten  string               `json:"rewritten"`
+	Answer     string               `json:"answer"`
+	Model      string               `json:"model"`
+	Citations  []retrieval.Citation `json:"citations"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt time.Time            `json:"finished_at"`
+}
+
+func newSessionMemory(maxDocs, maxTurns int, ttl time.Duration, limits sessionLimits) *sessionMemory {
+	return &sessionMemory{
+		maxDocs:  maxDocs,
+		maxTurns: maxTurns,
+		ttl:      ttl,
+		limits:   limits,
+		items:    make(map[string]*sessionEntry),
+		ll:       list.New(),
+	}
+}
+
+// 清除过期的会话，调用方需持有锁
+func (m *sessionMemory) expire(now time.Time) {
+	for el := m.ll.Back(); el != nil; el = m.ll.Back() {
+		id := el.Value.(string)
+		if now.Sub(m.items[id].updatedAt) <= m.ttl {
+			return
+		}
+		m.remove(id)
+	}
+}
+
+// 删除会话并扣除其用量，调用方需持有锁
+func (m *sessionMemory) remove(id string) {
+	entry, ok := m.items[id]
+	if !ok {
+		return
+	}
+	m.ll.Remove(entry.el)
+	m.bytes -= entry.bytes
+	delete(m.items, id)
+}
+
+// 返回会话，不存在时创建，调用方需持有锁。修改会话后需调用 account
+func (m *sessionMemory) entry(id string, now time.Time) *sessionEntry {
+	m.expire(now)
+	entry, ok := m.items[id]
+	if !ok {
+		entry = &sessionEntry{el: m.ll.PushFront(id)}
+		m.items[id] = entry
+	} else {
+		m.ll.MoveToFront(entry.el)
+	}
+	entry.updatedAt = now
+	return entry
+}
+
+// 重新计算会话的用量：超出单个会话的配额时丢弃最早的问答，
+// 超出全局上限时淘汰最久未更新的其他会话。调用方需持有锁
+func (m *sessionMemory) account(id string, entry *sessionEntry) {
+	size := entry.size()
+	if quota := m.limits.quotaBytes; quota > 0 && size > quota {
+		dropped := 0
+		for size > quota && len(entry.turns) > 0 {
+			size -= entry.turns[0].size()
+			entry.turns = entry.turns[1:]
+			dropped++
+		}
+		if dropped > 0 {
+			logging.Warnf("session %s exceeds quota of %d bytes, dropped %d turns\n", id, quota, dropped)
+		}
+	}
+	m.bytes += size - entry.bytes
+	entry.bytes = size
+
+	evicted := 0
+	for m.ll.Len() > 1 && m.over() {
+		m.remove(m.ll.Back().Value.(string))
+		evicted++
+	}
+	if evicted > 0 {
+		logging.Warnf("session memory full, evicted %d least recently used sessions\n", evicted)
+	}
+}
+
+func (m *sessionMemory) over() bool {
+	return (m.limits.maxSessions > 0 && m.ll.Len() > m.limits.maxSessions) ||
+		(m.limits.maxBytes > 0 && m.bytes > m.limits.maxBytes)
+}
+
+// 会话占用内存的估算值，只计算文本和 ID，不计结构体本身的开销
+func (e *sessionEntry) size() int {
+	n := len(e.owner) + 8*len(e.docIds)
+	for _, turn := range e.turns {
+		n += turn.size()
+	}
+	if e.summary != nil {
+		n += len(e.summary.text) + len(e.summary.hash)
+	}
+	return n
+}
+
+func (t *sessionTurn) size() int {
+	n := len(t.Question) + len(t.Rewritten) + len(t.Answer) + len(t.Model)
+	for _, c := range t.Citations {
+		n += 8 + len(c.Title) + len(c.URL) + len(c.Link)
+	}
+	return n
+}
+
+// 获取会话中记住的文档ID，过期的会话会被清除
+func (m *sessionMemory) get(id string) []int {
+	if id == "" || m.maxDocs <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.items[id]
+	if !ok {
+		return nil
+	}
+	if time.Since(entry.updatedAt) > m.ttl {
+		m.remove(id)
+		return nil
+	}
+	return entry.docIds
+}
+
+// 记录本轮引用的文档，最多保留 SessionMemoryDocs 篇，最近引用的排在前面
+func (m *sessionMemory) remember(id string, docIds []int) {
+	if id == "" || m.maxDocs <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entry(id, time.Now())
+	merged := append([]int{}, docIds...)
+	for _, docId := range entry.docIds {
+		if !slices.Contains(merged, docId) {
+			merged = append(merged, docId)
+		}
+	}
+	if len(merged) > m.maxDocs {
+		merged = merged[:m.maxDocs]
+	}
+	entry.docIds = merged
+	m.account(id, entry)
+}
+
+// 记录一轮问答，最多保留 SessionMaxTurns 轮。会话已属于其他租户时不记录
+func (m *sessionMemory) recordTurn(id, owner string, turn *sessionTurn) {
+	if id == "" || m.maxTurns <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entry(id, time.Now())
+	if len(entry.turns) == 0 {
+		entry.owner = owner
+	} else if entry.owner != owner {
+		return
+	}
+	entry.turns = append(entry.turns, turn)
+	if len(entry.turns) > m.maxTurns {
+		entry.turns = entry.turns[len(entry.turns)-m.maxTurns:]
+	}
+	m.account(id, entry)
+}
+
+// 返回属于 owner 的会话的对话摘要
+func (m *sessionMemory) summary(id, owner string) (*sessionSummary, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.items[id]
+	if !ok || time.Since(entry.updatedAt) > m.ttl || entry.summary == nil || entry.owner != owner {
+		return nil, false
+	}
+	return entry.summary, true
+}
+
+// 保存会话的对话摘要。会话已属于其他租户时不保存
+func (m *sessionMemory) setSummary(id, owner string, summary *sessionSummary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entry(id, time.Now())
+	if len(entry.turns) == 0 && entry.summary == nil {
+		entry.owner = owner
+	} else if entry.owner != owner {
+		return
+	}
+	entry.summary = summary
+	m.account(id, entry)
+}
+
+// 返回属于 owner 的会话的全部问答，会话不存在、已过期或属于其他租户时返回 false
+func (m *sessionMemory) transcript(id, owner string) ([]*sessionTurn, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.items[id]
+	if !ok || time.Since(entry.updatedAt) > m.ttl || len(entry.turns) == 0 || entry.owner != owner {
+		return nil, false
+	}
+	return slices.Clone(entry.turns), true
+}
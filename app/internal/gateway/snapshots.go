@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"rag_app/internal/logging"
+	"rag_app/internal/retrieval"
+)
+
+// 快速启动后补齐索引失败时的重试间隔
+const catchUpRetryInterval = 30 * time.Second
+
+// 发布快照的超时时间
+const publishTimeout = 5 * time.Minute
+
+// 启动时只用快照中的向量建立了部分索引，在后台完整重建，只向量化缺少的文档，完成后替换流水线。
+// 向量化服务不可用时按间隔重试，期间继续以部分索引提供服务
+func (s *Server) catchUp() {
+	for {
+		s.reloading.Lock()
+		current := s.currentPipeline()
+		if current.Missing() == 0 {
+			s.reloading.Unlock()
+			return
+		}
+		start := time.Now()
+		logging.Infof("warm start: catching up %d documents\n", current.Missing())
+		ctx := retrieval.WithPrevious(context.Background(), current)
+		pipeline, err := retrieval.NewFromConfig(ctx, s.cfg)
+		if err == nil {
+			s.setPipeline(pipeline)
+		}
+		s.reloading.Unlock()
+		if err != nil {
+			logging.Warnf("warm start: catch up failed, retrying in %v: %v\n", catchUpRetryInterval, err)
+			time.Sleep(catchUpRetryInterval)
+			continue
+		}
+		logging.Infof("warm start: caught up in %v, %d documents\n", time.Since(start), pipeline.Store().Len())
+		s.publishSnapshots(pipeline)
+		return
+	}
+}
+
+// 将流水线的快照发布到 SNAPSHOT_STORE_URL，供新副本启动时下载。只读副本不发布
+func (s *Server) publishSnapshots(pipeline *retrieval.Pipeline) {
+	if s.snapshots == nil || s.cfg.ReadOnly {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	published, err := pipeline.PublishSnapshots(ctx, s.snapshots)
+	if err != nil {
+		logging.Errorf("publish snapshots to %s: %v\n", s.snapshots, err)
+		return
+	}
+	if published {
+		logging.Infof("snapshots of index %s published to %s\n", pipeline.IndexVersion(), s.snapshots)
+	}
+}
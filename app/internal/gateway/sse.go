@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SSE 响应写入器，保证结束标记在所有退出路径上仅输出一次
+type sseWriter struct {
+	c          *gin.Context
+	done       bool
+	terminator string
+	started    bool
+	finished   bool
+	// 转换数据块的格式，如将聊天格式转换为文本补全格式，nil 表示原样输出
+	transform func([]byte) []byte
+	// 不为空时在流开始时以 lento.request 事件返回请求 ID，供取消请求使用
+	requestId string
+	// 客户端声明支持 gzip 时压缩响应，以及压缩级别
+	compress      bool
+	compressLevel int
+	// 压缩响应时的 gzip 写入器，结束时关闭
+	gz *gzip.Writer
+}
+
+func newSSEWriter(c *gin.Context, done bool, terminator string) *sseWriter {
+	return &sseWriter{c: c, done: done, terminator: terminator}
+}
+
+func (s *sseWriter) start() {
+	s.started = true
+	s.c.Writer.Header().Set("Content-Type", "text/event-stream")
+	s.c.Writer.Header().Set("Cache-Control", "no-cache")
+	s.c.Writer.Header().Set("Connection", "keep-alive")
+	if s.compress && acceptsGzip(s.c.GetHeader("Accept-Encoding")) {
+		gz, err := gzip.NewWriterLevel(s.c.Writer, s.compressLevel)
+		if err == nil {
+			s.c.Writer.Header().Set("Content-Encoding", "gzip")
+			s.c.Writer.Header().Add("Vary", "Accept-Encoding")
+			s.c.Writer.Header().Del("Content-Length")
+			s.gz = gz
+			s.c.Writer = &gzipResponseWriter{ResponseWriter: s.c.Writer, gz: gz}
+		}
+	}
+	if s.requestId != "" {
+		s.event("lento.request", gin.H{"request_id": s.requestId})
+	}
+}
+
+func (s *sseWriter) data(buf []byte) {
+	if s.finished {
+		return
+	}
+	if s.transform != nil {
+		buf = s.transform(buf)
+	}
+	s.c.Writer.Write([]byte("data: "))
+	s.c.Writer.Write(buf)
+	s.c.Writer.Write([]byte("\n\n"))
+	s.c.Writer.Flush()
+}
+
+// 输出命名事件，需要客户端能够区分 event 字段
+func (s *sseWriter) event(name string, v any) {
+	if s.finished {
+		return
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.c.Writer, "event: %s\ndata: %s\n\n", name, buf)
+	s.c.Writer.Flush()
+}
+
+func (s *sseWriter) error(err *clientError) {
+	buf, _ := json.Marshal(err.body())
+	s.data(buf)
+}
+
+// 输出结束标记，可通过配置关闭或自定义
+func (s *sseWriter) finish() {
+	if !s.started || s.finished {
+		return
+	}
+	s.finished = true
+	if s.done {
+		s.c.Writer.Write([]byte("data: " + s.terminator + "\n\n"))
+		s.c.Writer.Flush()
+	}
+	if s.gz != nil {
+		s.gz.Close()
+		s.c.Writer.Flush()
+	}
+}
+
+// 以 gzip 压缩写入的响应。每次 Flush 都输出已压缩的完整数据块，客户端可以边接收边解压，
+// 同一连接上重复的 JSON 结构只在压缩字典中出现一次
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.gz.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	w.gz.Flush()
+	w.ResponseWriter.Flush()
+}
+
+// Accept-Encoding 中是否包含 q 值不为 0 的 gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		v, err := strconv.ParseFloat(q, 64)
+		return err == nil && v > 0
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package gateway
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/retrieval"
+)
+
+// 统计保留的天数
+const statsRetentionDays = 31
+
+// 未限定集合的检索在统计中的名称
+const allCollections = "_all"
+
+// 按集合和日期统计的检索指标，仅保存在内存中
+type collectionStats struct {
+	mu   sync.Mutex
+	days map[string]map[string]*dayStats
+}
+
+type dayStats struct {
+	queries  int
+	zeroHits int
+	scoreSum float64
+	scored   int
+	// 重排序失败而按召回分数排序的次数
+	rerankFallbacks int
+	cited           map[int]int
+	titles          map[int]string
+}
+
+func newCollectionStats() *collectionStats {
+	return &collectionStats{days: map[string]map[string]*dayStats{}}
+}
+
+// 记录一次检索
+func (cs *collectionStats) record(collection string, result *retrieval.Result, at time.Time) {
+	if collection == "" {
+		collection = allCollections
+	}
+	date := at.Format(time.DateOnly)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	days, ok := cs.days[collection]
+	if !ok {
+		days = map[string]*dayStats{}
+		cs.days[collection] = days
+	}
+	ds, ok := days[date]
+	if !ok {
+		ds = &dayStats{cited: map[int]int{}, titles: map[int]string{}}
+		days[date] = ds
+		cutoff := at.AddDate(0, 0, -statsRetentionDays).Format(time.DateOnly)
+		for d := range days {
+			if d < cutoff {
+				delete(days, d)
+			}
+		}
+	}
+
+	ds.queries++
+	if len(result.DocIds) == 0 {
+		ds.zeroHits++
+	}
+	if result.RerankFallback {
+		ds.rerankFallbacks++
+	}
+	if top, ok := result.TopRerankScore(); ok {
+		ds.scoreSum += float64(top)
+		ds.scored++
+	}
+	for _, doc := range result.Docs {
+		ds.cited[doc.DocId]++
+		ds.titles[doc.DocId] = doc.Title
+	}
+}
+
+type citedDocument struct {
+	DocId     int    `json:"doc_id"`
+	Title     string `json:"title,omitempty"`
+	Citations int    `json:"citations"`
+}
+
+// 一个集合在一天内的统计，每行对应 Grafana 表格的一行
+type collectionDayRow struct {
+	Collection      string          `json:"collection"`
+	Date            string          `json:"date"`
+	Queries         int             `json:"queries"`
+	ZeroHits        int             `json:"zero_hits"`
+	ZeroHitRate     float64         `json:"zero_hit_rate"`
+	AvgRerankScore  float64         `json:"avg_rerank_score"`
+	RerankFallbacks int             `json:"rerank_fallbacks"`
+	TopDocuments    []citedDocument `json:"top_documents"`
+}
+
+// 返回最近 days 天的统计，按集合和日期排序
+func (cs *collectionStats) rows(now time.Time, days, topN int) []collectionDayRow {
+	cutoff := now.AddDate(0, 0, -days+1).Format(time.DateOnly)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	rows := []collectionDayRow{}
+	for collection, byDate := range cs.days {
+		for date, ds := range byDate {
+			if date < cutoff {
+				continue
+			}
+			row := collectionDayRow{
+				Collection:      collection,
+				Date:            date,
+				Queries:         ds.queries,
+				ZeroHits:        ds.zeroHits,
+				RerankFallbacks: ds.rerankFallbacks,
+				TopDocuments:    []citedDocument{},
+			}
+			if ds.queries > 0 {
+				row.ZeroHitRate = float64(ds.zeroHits) / float64(ds.queries)
+			}
+			if ds.scored > 0 {
+				row.AvgRerankScore = ds.scoreSum / float64(ds.scored)
+			}
+			for docId, n := range ds.cited {
+				row.TopDocuments = append(row.TopDocuments, citedDocument{DocId: docId, Title: ds.titles[docId], Citations: n})
+			}
+			slices.SortFunc(row.TopDocuments, func(a, b citedDocument) int {
+				if a.Citations != b.Citations {
+					return b.Citations - a.Citations
+				}
+				return a.DocId - b.DocId
+			})
+			if len(row.TopDocuments) > topN {
+				row.TopDocuments = row.TopDocuments[:topN]
+			}
+			rows = append(rows, row)
+		}
+	}
+	slices.SortFunc(rows, func(a, b collectionDayRow) int {
+		if a.Collection != b.Collection {
+			if a.Collection < b.Collection {
+				return -1
+			}
+			return 1
+		}
+		if a.Date < b.Date {
+			return -1
+		} else if a.Date > b.Date {
+			return 1
+		}
+		return 0
+	})
+	return rows
+}
+
+// 按集合返回每日查询数、零命中率、平均重排序分数和引用最多的文档，
+// 返回扁平的 JSON 数组，可直接作为 Grafana JSON / Infinity 数据源使用
+func (s *Server) collectionStatsHandler(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 || days > statsRetentionDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and " + strconv.Itoa(statsRetentionDays)})
+		return
+	}
+	top, err := strconv.Atoi(c.DefaultQuery("top", "5"))
+	if err != nil || top < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid top"})
+		return
+	}
+	c.JSON(http.StatusOK, s.stats.rows(time.Now(), days, top))
+}
+
+// 按错误码统计的失败请求数，仅保存在内存中
+type errorStats struct {
+	mu     sync.Mutex
+	since  time.Time
+	counts map[string]int64
+}
+
+func newErrorStats() *errorStats {
+	return &errorStats{since: time.Now(), counts: map[string]int64{}}
+}
+
+func (es *errorStats) record(code string) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.counts[code]++
+}
+
+// 自启动以来各错误码的失败请求数，错误码即 OpenAI 兼容错误响应中的 code
+func (s *Server) errorStatsHandler(c *gin.Context) {
+	s.errorCounts.mu.Lock()
+	defer s.errorCounts.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"since": s.errorCounts.since, "errors": s.errorCounts.counts})
+}
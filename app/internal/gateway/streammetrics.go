@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/metrics"
+	"rag_app/internal/tokens"
+)
+
+// 首 token 耗时和流式吞吐量的直方图，按模型别名和缓存状态区分
+type streamMetrics struct {
+	ttft       *metrics.Histogram
+	throughput *metrics.Histogram
+}
+
+func newStreamMetrics(registry *metrics.Registry) *streamMetrics {
+	m := &streamMetrics{
+		ttft: metrics.NewHistogram("lento_time_to_first_token_seconds",
+			"Time from request start to the first answer token sent to the client.",
+			[]float64{0.1, 0.25, 0.5, 1, 1.5, 2, 3, 5, 8, 13, 20, 30}, "model", "cache"),
+		throughput: metrics.NewHistogram("lento_stream_tokens_per_second",
+			"Estimated answer tokens per second after the first token.",
+			[]float64{1, 5, 10, 20, 30, 50, 75, 100, 150, 250, 500}, "model", "cache"),
+	}
+	registry.Register(m.ttft)
+	registry.Register(m.throughput)
+	return m
+}
+
+// 一次回答的流式输出计时：记录第一个带内容的 chunk 的时间，结束时计算吞吐量
+type streamMeter struct {
+	start time.Time
+	first time.Time
+}
+
+func (m *streamMeter) observe(content string) {
+	if content != "" && m.first.IsZero() {
+		m.first = time.Now()
+	}
+}
+
+// 指标中的模型标签：配置了别名的模型使用别名，其余模型名由客户端决定，统一为 default 以免标签无限增长
+func (s *Server) metricsModel(model string) string {
+	if s.gens.HasAlias(model) {
+		return model
+	}
+	return "default"
+}
+
+// 回答结束时记录首 token 耗时和吞吐量，并以 Server-Timing trailer 返回
+func (s *Server) finishStream(c *gin.Context, meter *streamMeter, model, cacheStatus, answer string) {
+	if meter.first.IsZero() {
+		return
+	}
+	label := s.metricsModel(model)
+	ttft := meter.first.Sub(meter.start)
+	s.streamMetrics.ttft.Observe(ttft.Seconds(), label, cacheStatus)
+	n := tokens.Estimate(answer)
+	elapsed := time.Since(meter.first)
+	timing := fmt.Sprintf("ttft;dur=%.1f", float64(ttft.Microseconds())/1000)
+	// 命中回答缓存时直接回放，吞吐量没有意义
+	if n > 0 && elapsed > 0 && cacheStatus != "hit" {
+		tps := float64(n) / elapsed.Seconds()
+		s.streamMetrics.throughput.Observe(tps, label, cacheStatus)
+		timing += fmt.Sprintf(`, stream;dur=%.1f;desc="%d tokens, %.1f tok/s"`, float64(elapsed.Microseconds())/1000, n, tps)
+	}
+	c.Writer.Header().Add(http.TrailerPrefix+"Server-Timing", timing)
+}
+
+// Prometheus 指标
+func (s *Server) metricsHandler(c *gin.Context) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	s.metrics.Write(c.Writer)
+}
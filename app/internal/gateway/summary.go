@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/accounting"
+	"rag_app/internal/tokens"
+)
+
+// 代表此前对话摘要的系统消息的名称，聊天记录中以摘要的形式出现
+const summaryMessageName = "lento_summary"
+
+// 压缩此前对话的提示
+const summaryPrompt = `请将对话压缩为一段简洁的摘要，保留用户关心的问题、提到的产品和场景、已经确认的事实和结论，不超过 300 字。
+如果给出了已有摘要，请将新的对话合并进去，输出完整的新摘要。只输出摘要本身。`
+
+// 会话的滚动摘要，覆盖请求消息中的前 covered 条
+type sessionSummary struct {
+	text    string
+	covered int
+	// 被覆盖消息的哈希，客户端修改了历史消息时不再沿用
+	hash string
+}
+
+// 消息的哈希，用于判断摘要覆盖的消息是否未变
+func messagesHash(messages []openai.ChatCompletionMessage) string {
+	h := sha256.New()
+	for _, msg := range messages {
+		buf, _ := json.Marshal(msg)
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 会话超过 SESSION_SUMMARY_TURNS 轮用户消息时，将更早的消息压缩为摘要，保存在会话中并逐轮滚动更新。
+// 返回以摘要代替早期消息后的请求消息和摘要；未超过轮数时原样返回，摘要为空
+func (s *Server) summarizeHistory(ctx context.Context, sessionId, tenant string, messages []openai.ChatCompletionMessage, usage *accounting.Usage) ([]openai.ChatCompletionMessage, string, error) {
+	keep := s.cfg.SessionSummaryTurns
+	if keep <= 0 || sessionId == "" {
+		return messages, "", nil
+	}
+
+	// 最近 keep 轮从倒数第 keep 条用户消息开始，最前面的系统提示单独保留
+	first := 0
+	if messages[0].Role == openai.ChatMessageRoleSystem {
+		first = 1
+	}
+	recent, users := len(messages), 0
+	for recent > first && users < keep {
+		recent--
+		if messages[recent].Role == openai.ChatMessageRoleUser {
+			users++
+		}
+	}
+	older := messages[first:recent]
+	if users < keep || len(older) == 0 {
+		return messages, "", nil
+	}
+
+	// 沿用已有摘要覆盖的部分，只压缩其后新增的消息
+	previous, ok := s.sessions.summary(sessionId, tenant)
+	if ok && (previous.covered > len(older) || previous.hash != messagesHash(older[:previous.covered])) {
+		previous, ok = nil, false
+	}
+	text := ""
+	if ok {
+		text = previous.text
+	}
+	if !ok || previous.covered < len(older) {
+		from := 0
+		if ok {
+			from = previous.covered
+		}
+		var err error
+		text, err = s.summarize(ctx, text, older[from:], usage)
+		if err != nil {
+			return messages, "", err
+		}
+		s.sessions.setSummary(sessionId, tenant, &sessionSummary{text: text, covered: len(older), hash: messagesHash(older)})
+	}
+
+	condensed := append([]openai.ChatCompletionMessage{}, messages[:first]...)
+	condensed = append(condensed, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Name: summaryMessageName, Content: text})
+	condensed = append(condensed, messages[recent:]...)
+	return condensed, text, nil
+}
+
+// 调用非推理模型将新增的对话合并到已有摘要中，上游调用的 token 计入 usage
+func (s *Server) summarize(ctx context.Context, previous string, messages []openai.ChatCompletionMessage, usage *accounting.Usage) (string, error) {
+	var content strings.Builder
+	if previous != "" {
+		fmt.Fprintf(&content, "已有摘要：\n%s\n\n", previous)
+	}
+	fmt.Fprintf(&content, "对话：\n%s", buildChatHistory(messages))
+	request := openai.ChatCompletionRequest{
+		Model: s.cfg.ModelWithoutThinking,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: summaryPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: content.String()},
+		},
+	}
+	usage.PromptTokens += estimateMessages(request.Messages)
+	response, err := s.llm.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("summarize: empty response")
+	}
+	text := strings.TrimSpace(response.Choices[0].Message.Content)
+	usage.CompletionTokens += int64(tokens.Estimate(text))
+	return text, nil
+}
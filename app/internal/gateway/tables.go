@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/provider"
+	"rag_app/internal/retrieval"
+	"rag_app/internal/tables"
+)
+
+// 表格集合的提示模式：提示词中只有表结构，模型通过 query_table 工具查询数据
+const PromptModeTable = "table"
+
+// 表格集合不做向量检索，以表结构说明代替检索到的文档放入提示词
+func (s *Server) tableResult(collection *tables.Collection) *retrieval.Result {
+	return &retrieval.Result{Content: collection.Describe(s.cfg.TableSampleRows)}
+}
+
+// 提供 query_table 工具的生成循环，由网关校验并执行模型生成的查询，查询结果作为上下文继续生成。
+// 查询不合法时把错误交给模型修正，最多进行 TABLE_QUERY_MAX_CALLS 轮工具调用
+func (s *Server) generateWithTables(ctx context.Context, generator provider.Generator, request openai.ChatCompletionRequest,
+	collection *tables.Collection, promptTokens *atomic.Int64, push func(buf []byte)) error {
+	tool := openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        tables.ToolName,
+			Description: "查询数据表，返回符合条件的行或聚合结果",
+			Parameters:  collection.ToolParameters(),
+		},
+	}
+	call := func(name, arguments string) string {
+		rows, err := collection.Execute(arguments, s.cfg.TableQueryMaxRows)
+		if err != nil {
+			return fmt.Sprintf("查询错误：%v", err)
+		}
+		return rows
+	}
+	return s.generateWithTools(ctx, generator, request, []openai.Tool{tool}, s.cfg.TableQueryMaxCalls, call, promptTokens, push)
+}
@@ -0,0 +1,26 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"rag_app/internal/retrieval"
+)
+
+// 收集各阶段耗时，以 Server-Timing 响应头返回
+type serverTiming struct {
+	timings []retrieval.Timing
+}
+
+func (t *serverTiming) add(name string, d time.Duration) {
+	t.timings = append(t.timings, retrieval.Timing{Name: name, Duration: d})
+}
+
+func (t *serverTiming) String() string {
+	strs := make([]string, len(t.timings))
+	for i, v := range t.timings {
+		strs[i] = fmt.Sprintf("%s;dur=%.1f", v.Name, float64(v.Duration.Microseconds())/1000)
+	}
+	return strings.Join(strs, ", ")
+}
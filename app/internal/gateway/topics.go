@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/retrieval"
+)
+
+// 在后台聚类文档并生成主题地图，返回任务 ID，可通过 /admin/jobs/:id/events 查看进度。
+// k 参数指定簇数，默认按文档数自动选择
+func (s *Server) buildTopicsHandler(c *gin.Context) {
+	k, err := strconv.Atoi(c.DefaultQuery("k", "0"))
+	if err != nil || k < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid k"})
+		return
+	}
+
+	j := s.jobs.start("topics")
+	pipeline := s.currentPipeline()
+	go func() {
+		ctx := retrieval.WithProgress(context.Background(), func(event retrieval.ProgressEvent) {
+			j.emit("lento.progress", event)
+		})
+		topics, err := pipeline.BuildTopicMap(ctx, k)
+		if err != nil {
+			fmt.Println("topic map failed:", err)
+			j.finish("lento.error", gin.H{"error": err.Error()})
+			return
+		}
+		s.topics.Store(topics)
+		j.finish("lento.done", gin.H{"documents": topics.Documents, "topics": len(topics.Topics)})
+	}()
+	c.JSON(http.StatusAccepted, gin.H{"job_id": j.Id, "events": "/admin/jobs/" + j.Id + "/events"})
+}
+
+// 返回最近一次生成的主题地图
+func (s *Server) topicsHandler(c *gin.Context) {
+	topics := s.topics.Load()
+	if topics == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "topic map not generated"})
+		return
+	}
+	c.JSON(http.StatusOK, topics)
+}
@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+)
+
+// 写入一次存档的超时时间
+const transcriptWriteTimeout = 30 * time.Second
+
+// 一次回答的存档：发送给模型的提示词和模型生成的回答
+type transcript struct {
+	RequestId  string                         `json:"request_id"`
+	TraceId    string                         `json:"trace_id,omitempty"`
+	Tenant     string                         `json:"tenant"`
+	Model      string                         `json:"model"`
+	Question   string                         `json:"question"`
+	Cache      string                         `json:"cache"`
+	StartedAt  time.Time                      `json:"started_at"`
+	FinishedAt *time.Time                     `json:"finished_at,omitempty"`
+	Messages   []openai.ChatCompletionMessage `json:"messages"`
+	Answer     string                         `json:"answer"`
+	// 生成中为 streaming，之后为 done、cancelled、timeout 或 error
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	mu     sync.Mutex
+	answer strings.Builder
+	key    string
+}
+
+// 存档的状态
+const (
+	transcriptStreaming = "streaming"
+	transcriptDone      = "done"
+	transcriptCancelled = "cancelled"
+	transcriptTimeout   = "timeout"
+	transcriptError     = "error"
+)
+
+// 对象键中不允许出现的字符，请求 ID 可由调用方指定
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// 建立一次回答的存档，未配置 TRANSCRIPT_STORE_URL 时返回 nil
+func (s *Server) newTranscript(ctx context.Context, start time.Time, tenant, model, question, cacheStatus string, messages []openai.ChatCompletionMessage) *transcript {
+	if s.transcripts == nil {
+		return nil
+	}
+	requestId := provider.RequestId(ctx)
+	return &transcript{
+		RequestId: requestId,
+		TraceId:   provider.TraceId(ctx),
+		Tenant:    tenant,
+		Model:     model,
+		Question:  question,
+		Cache:     cacheStatus,
+		StartedAt: start,
+		Messages:  messages,
+		Status:    transcriptStreaming,
+		// 按日期分目录，同一请求 ID 可能被调用方重复使用，以开始时间区分
+		key: fmt.Sprintf("transcripts/%s/%d-%s.json", start.UTC().Format("2006/01/02"), start.UnixNano(),
+			unsafeKeyChars.ReplaceAllString(requestId, "_")),
+	}
+}
+
+// 写入存档的当前内容
+func (s *Server) writeTranscript(t *transcript) {
+	t.mu.Lock()
+	t.Answer = t.answer.String()
+	buf, err := json.MarshalIndent(t, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		logging.Errorf("transcript: %v\n", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), transcriptWriteTimeout)
+	defer cancel()
+	err = s.transcripts.Put(ctx, t.key, buf)
+	if err != nil {
+		logging.Errorf("transcript %s: %v\n", t.key, err)
+	}
+}
+
+// 存档回放的缓存回答
+func (s *Server) archiveChunks(t *transcript, chunks [][]byte) {
+	if t == nil {
+		return
+	}
+	for _, buf := range chunks {
+		t.answer.WriteString(chunkContent(buf))
+	}
+	t.finish(transcriptDone, nil)
+	go s.writeTranscript(t)
+}
+
+// 作为生成的另一个订阅者读取全部输出并存档。先于客户端订阅，客户端断开后生成不会因无人订阅而取消，
+// 仍然保存完整的回答；通过取消接口取消或超出 REQUEST_MAX_DURATION 时结束。生成过程中按
+// TRANSCRIPT_STORE_FLUSH_INTERVAL 写入已生成的部分
+func (s *Server) archiveFlight(parent context.Context, t *transcript, flight *streamFlight, start time.Time) {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	ctx, cancelDeadline := s.deadlineContext(ctx, start)
+	removeInflight := s.inflight.add(t.RequestId, t.Tenant, cancel)
+	recv, release := flight.subscribe(ctx)
+	go func() {
+		defer cancel()
+		defer cancelDeadline()
+		defer removeInflight()
+		defer release()
+
+		stopFlush := func() {}
+		if interval := s.cfg.Transcripts.FlushInterval; interval > 0 {
+			ticker := time.NewTicker(interval)
+			done, exited := make(chan struct{}), make(chan struct{})
+			// 等待进行中的写入完成，避免部分内容覆盖最终的存档
+			stopFlush = func() {
+				ticker.Stop()
+				close(done)
+				<-exited
+			}
+			go func() {
+				defer close(exited)
+				for {
+					select {
+					case <-ticker.C:
+						s.writeTranscript(t)
+					case <-done:
+						return
+					}
+				}
+			}()
+		}
+
+		var err error
+		for {
+			var buf []byte
+			buf, err = recv()
+			if err != nil {
+				break
+			}
+			t.mu.Lock()
+			t.answer.WriteString(chunkContent(buf))
+			t.mu.Unlock()
+		}
+		stopFlush()
+		switch {
+		case err == io.EOF:
+			t.finish(transcriptDone, nil)
+		case ctx.Err() == context.Canceled:
+			t.finish(transcriptCancelled, nil)
+		case ctx.Err() != nil:
+			t.finish(transcriptTimeout, nil)
+		default:
+			t.finish(transcriptError, err)
+		}
+		s.writeTranscript(t)
+	}()
+}
+
+func (t *transcript) finish(status string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.Status, t.FinishedAt = status, &now
+	if err != nil {
+		t.Error = err.Error()
+	}
+}
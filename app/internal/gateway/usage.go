@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rag_app/internal/accounting"
+)
+
+// 用量报表的一行：租户某一天的请求数、token 用量和估算费用
+type usageRow struct {
+	accounting.DayUsage
+	TotalTokens int64   `json:"total_tokens"`
+	Cost        float64 `json:"cost"`
+}
+
+// 报表范围内的合计
+type usageTotal struct {
+	accounting.Usage
+	TotalTokens int64   `json:"total_tokens"`
+	Cost        float64 `json:"cost"`
+}
+
+type usageReport struct {
+	From  string     `json:"from"`
+	To    string     `json:"to"`
+	Rows  []usageRow `json:"rows"`
+	Total usageTotal `json:"total"`
+}
+
+// 按租户和日期汇总用量，用于按月分摊费用。from/to 为 YYYY-MM-DD，默认为当月 1 日至今天，
+// tenant 为空时包含全部租户。费用按 PROMPT_TOKEN_PRICE / COMPLETION_TOKEN_PRICE（每百万 token）估算
+func (s *Server) usageHandler(c *gin.Context) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := accounting.ParseDay(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": p.name + " must be YYYY-MM-DD"})
+			return
+		}
+		*p.t = t
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from is after to"})
+		return
+	}
+
+	report := usageReport{
+		From: from.Format(time.DateOnly),
+		To:   to.Format(time.DateOnly),
+		Rows: []usageRow{},
+	}
+	for _, u := range s.ledger.Daily(c.Query("tenant"), from, to) {
+		row := usageRow{DayUsage: u, TotalTokens: u.TotalTokens(), Cost: s.usageCost(&u.Usage)}
+		report.Rows = append(report.Rows, row)
+		report.Total.Requests += u.Requests
+		report.Total.PromptTokens += u.PromptTokens
+		report.Total.CompletionTokens += u.CompletionTokens
+		report.Total.TotalTokens += row.TotalTokens
+		report.Total.Cost += row.Cost
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// 按每百万 token 的单价估算费用
+func (s *Server) usageCost(u *accounting.Usage) float64 {
+	return (float64(u.PromptTokens)*s.cfg.PromptTokenPrice + float64(u.CompletionTokens)*s.cfg.CompletionTokenPrice) / 1e6
+}
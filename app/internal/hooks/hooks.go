@@ -0,0 +1,164 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/index"
+	"rag_app/internal/retrieval"
+)
+
+// 可以挂载钩子的流水线阶段
+const (
+	// 改写问题之前，可修改参与改写的对话消息
+	PreRewrite = "pre_rewrite"
+	// 检索之后，可删除、重排或添加选入提示词的文档
+	PostRetrieval = "post_retrieval"
+	// 调用模型之前，可修改发送给模型的提示消息
+	PreGeneration = "pre_generation"
+	// 回答完成之后，可检查或修改完整的回答
+	PostGeneration = "post_generation"
+)
+
+// 钩子看到的请求状态，各阶段可以修改的字段不同，其余字段只读
+type State struct {
+	RequestId  string
+	Tenant     string
+	Collection string
+	Model      string
+	// pre_rewrite 时为参与改写的对话消息，pre_generation 时为发送给模型的提示消息，均可修改
+	Messages []openai.ChatCompletionMessage
+	// 改写后的问题，post_retrieval 起有值
+	Question string
+	// 选入提示词的文档，按相关性排序，post_retrieval 时可修改
+	Docs []*index.Document
+	// 参与重排序的候选文档及其分数，post_retrieval 起有值
+	Candidates []retrieval.Candidate
+	// 完整的回答，post_generation 时有值。缓冲模式和批量任务中修改后代替原回答，流式输出时已发送，修改无效
+	Answer string
+}
+
+// 一个阶段的钩子函数。返回错误时请求失败，用 Reject 可指定返回给客户端的状态码和说明
+type Func func(ctx context.Context, st *State) error
+
+// 注册的钩子，只需设置关心的阶段
+type Hook struct {
+	PreRewrite     Func
+	PostRetrieval  Func
+	PreGeneration  Func
+	PostGeneration Func
+}
+
+func (h *Hook) stage(stage string) Func {
+	switch stage {
+	case PreRewrite:
+		return h.PreRewrite
+	case PostRetrieval:
+		return h.PostRetrieval
+	case PreGeneration:
+		return h.PreGeneration
+	case PostGeneration:
+		return h.PostGeneration
+	}
+	return nil
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]*Hook{}
+)
+
+// 注册钩子，供 PIPELINE_HOOKS 按名称启用。通常在包的 init 中调用，
+// 由 cmd/lento 空导入该包或以 Go 插件加载，与 database/sql 注册驱动的方式相同。名称重复时 panic
+func Register(name string, hook *Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+	if hook == nil {
+		panic("hooks: Register hook is nil")
+	}
+	if _, ok := registry[name]; ok {
+		panic("hooks: Register called twice for hook " + name)
+	}
+	registry[name] = hook
+}
+
+// 按配置顺序启用的钩子，同一阶段的钩子依次执行，后一个看到前一个修改后的状态。
+// 没有启用钩子时为 nil，各方法均可在 nil 上调用
+type Chain struct {
+	names []string
+	hooks []*Hook
+}
+
+// 按名称启用已注册的钩子，名称未注册时返回错误
+func New(names []string) (*Chain, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	c := &Chain{}
+	for _, name := range names {
+		hook, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("hook %q is not registered", name)
+		}
+		c.names = append(c.names, name)
+		c.hooks = append(c.hooks, hook)
+	}
+	return c, nil
+}
+
+// 是否有钩子挂载在该阶段
+func (c *Chain) Has(stage string) bool {
+	if c == nil {
+		return false
+	}
+	return slices.ContainsFunc(c.hooks, func(h *Hook) bool { return h.stage(stage) != nil })
+}
+
+// 依次执行挂载在该阶段的钩子，遇到错误时停止
+func (c *Chain) Run(ctx context.Context, stage string, st *State) error {
+	if c == nil {
+		return nil
+	}
+	for i, hook := range c.hooks {
+		f := hook.stage(stage)
+		if f == nil {
+			continue
+		}
+		err := f(ctx, st)
+		if err != nil {
+			return fmt.Errorf("hook %s (%s): %w", c.names[i], stage, err)
+		}
+	}
+	return nil
+}
+
+// 钩子拒绝请求的错误，状态码和说明原样返回给客户端
+type RejectError struct {
+	Status  int
+	Message string
+}
+
+func (e *RejectError) Error() string {
+	return e.Message
+}
+
+// 拒绝请求，status 为返回给客户端的 HTTP 状态码
+func Reject(status int, message string) error {
+	return &RejectError{Status: status, Message: message}
+}
+
+// 返回钩子拒绝请求的错误，不是时返回 nil
+func Rejected(err error) *RejectError {
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		return rejectErr
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package hooks
+
+import (
+	"fmt"
+	"plugin"
+
+	"rag_app/internal/logging"
+)
+
+// 加载 Go 插件，插件在 init 中调用 Register 注册钩子。
+// 插件须与网关使用同一版本的 Go 和依赖构建，且需要开启 cgo
+func LoadPlugins(paths []string) error {
+	for _, path := range paths {
+		_, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("load hook plugin %s: %w", path, err)
+		}
+		logging.Infof("hook plugin loaded: %s\n", path)
+	}
+	return nil
+}
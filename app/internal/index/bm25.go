@@ -0,0 +1,198 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"rag_app/internal/lang"
+)
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// 基于 BM25 的纯词法索引，不依赖向量化后端。
+// 每篇文档的标题和摘要作为一个片段，内容按 chunkSize 切分为其余片段，文档得分取片段得分的最大值。
+// 中文按 seg 分词，seg 为 nil 时按单字和相邻二字切分
+type BM25 struct {
+	mu     sync.RWMutex
+	seg    *lang.Segmenter
+	ids    map[int]int
+	docs   []*Document
+	chunks [][]bm25Chunk
+	df     map[string]int
+	total  int
+	sumLen int
+}
+
+type bm25Chunk struct {
+	text   string
+	tf     map[string]int
+	length int
+}
+
+func NewBM25(docs []*Document, chunkSize int, seg *lang.Segmenter) (*BM25, error) {
+	x := &BM25{
+		seg:    seg,
+		ids:    make(map[int]int, len(docs)),
+		docs:   docs,
+		chunks: make([][]bm25Chunk, len(docs)),
+		df:     map[string]int{},
+	}
+	for i, doc := range docs {
+		if _, ok := x.ids[doc.DocId]; ok {
+			return nil, fmt.Errorf("duplicate doc id %d", doc.DocId)
+		}
+		x.ids[doc.DocId] = i
+
+		x.chunks[i] = append(x.chunks[i], x.newChunk(doc.Title+"\n"+doc.Summary))
+		for _, text := range SplitChunks(doc.Content, chunkSize) {
+			x.chunks[i] = append(x.chunks[i], x.newChunk(text))
+		}
+		for _, c := range x.chunks[i] {
+			x.add(c, 1)
+		}
+	}
+	return x, nil
+}
+
+func (x *BM25) newChunk(text string) bm25Chunk {
+	terms := x.seg.Terms(text)
+	tf := make(map[string]int, len(terms))
+	for _, t := range terms {
+		tf[t]++
+	}
+	return bm25Chunk{text: text, tf: tf, length: len(terms)}
+}
+
+// 将片段计入（sign 为 1）或移出（sign 为 -1）全局统计
+func (x *BM25) add(c bm25Chunk, sign int) {
+	x.total += sign
+	x.sumLen += sign * c.length
+	for t := range c.tf {
+		x.df[t] += sign
+	}
+}
+
+func (x *BM25) score(c *bm25Chunk, terms []string) float64 {
+	avgLen := float64(x.sumLen) / float64(max(x.total, 1))
+	score := 0.0
+	for _, t := range terms {
+		tf := float64(c.tf[t])
+		if tf == 0 {
+			continue
+		}
+		df := float64(x.df[t])
+		idf := math.Log(1 + (float64(x.total)-df+0.5)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(c.length)/max(avgLen, 1)))
+	}
+	return score
+}
+
+func (x *BM25) uniqueTerms(query string) []string {
+	terms := x.seg.Terms(query)
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+// 按 BM25 得分检索文档，只返回得分大于 0 的文档
+func (x *BM25) SearchText(query string, topN int, exclude func(doc *Document) bool) []Hit {
+	terms := x.uniqueTerms(query)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	hits := []Hit{}
+	for i, doc := range x.docs {
+		if exclude != nil && exclude(doc) {
+			continue
+		}
+		best := 0.0
+		for j := range x.chunks[i] {
+			best = max(best, x.score(&x.chunks[i][j], terms))
+		}
+		if best > 0 {
+			hits = append(hits, Hit{Doc: doc, Score: float32(best)})
+		}
+	}
+	return MergeHits(hits, topN)
+}
+
+// 返回文档内容中 BM25 得分最高的片段
+func (x *BM25) BestChunkText(doc *Document, query string) (string, bool) {
+	terms := x.uniqueTerms(query)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	idx, ok := x.ids[doc.DocId]
+	if !ok || len(x.chunks[idx]) <= 1 {
+		return "", false
+	}
+	best, bestScore := "", -1.0
+	for j := 1; j < len(x.chunks[idx]); j++ {
+		if score := x.score(&x.chunks[idx][j], terms); score > bestScore {
+			best, bestScore = x.chunks[idx][j].text, score
+		}
+	}
+	return best, true
+}
+
+func (x *BM25) Len() int {
+	return len(x.docs)
+}
+
+func (x *BM25) Documents() []*Document {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return slices.Clone(x.docs)
+}
+
+func (x *BM25) Get(docId int) (*Document, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	idx, ok := x.ids[docId]
+	if !ok {
+		return nil, false
+	}
+	return x.docs[idx], true
+}
+
+// 词法索引不支持向量检索
+func (x *BM25) Search(query []float32, topN int, exclude func(doc *Document) bool) ([]Hit, error) {
+	return nil, errors.New("vector search is not supported by the bm25 index")
+}
+
+func (x *BM25) BestChunk(doc *Document, query []float32) (string, bool) {
+	return "", false
+}
+
+func (x *BM25) TopChunks(doc *Document, query []float32, n int) []Span {
+	return nil
+}
+
+// 替换文档摘要并重新统计摘要片段的词项，vector 被忽略
+func (x *BM25) UpdateSummary(docId int, summary string, vector []float32) (*Document, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	idx, ok := x.ids[docId]
+	if !ok {
+		return nil, ErrDocumentNotFound
+	}
+
+	doc := *x.docs[idx]
+	doc.Summary = summary
+	doc.Hash = HashDocument(summary, doc.Content)
+	x.docs[idx] = &doc
+
+	x.add(x.chunks[idx][0], -1)
+	x.chunks[idx][0] = x.newChunk(doc.Title + "\n" + doc.Summary)
+	x.add(x.chunks[idx][0], 1)
+	return &doc, nil
+}
@@ -0,0 +1,121 @@
+package index
+
+import (
+	"fmt"
+	"os"
+)
+
+// 一致性问题的严重程度。error 表示索引不可用或已损坏，
+// warning 表示快照与文档不一致，启动时会重新向量化
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// 一致性检查发现的问题
+type Problem struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Path     string `json:"path,omitempty"`
+	DocId    int    `json:"doc_id,omitempty"`
+	Message  string `json:"message"`
+}
+
+// 检查快照文件与文档是否一致：文档 ID 唯一、向量维度一致且非零、片段都有向量、
+// 片段数和位置与按当前配置切分的结果一致。快照不存在时返回 warning
+func CheckSnapshot(docs []*Document, opts BuildOptions) []Problem {
+	problems := []Problem{}
+	add := func(severity, check string, docId int, format string, args ...any) {
+		problems = append(problems, Problem{
+			Severity: severity,
+			Check:    check,
+			Path:     opts.Snapshot,
+			DocId:    docId,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	snap, err := readSnapshot(opts.Snapshot, opts.Key)
+	if os.IsNotExist(err) {
+		add(SeverityWarning, "snapshot_missing", 0, "snapshot does not exist, the index will be built at startup")
+		return problems
+	} else if err != nil {
+		add(SeverityError, "snapshot_unreadable", 0, "%v", err)
+		return problems
+	}
+	if snap.Version != SnapshotVersion {
+		add(SeverityWarning, "snapshot_config", 0, "snapshot version %d, expected %d", snap.Version, SnapshotVersion)
+	}
+	if snap.Model != opts.Model {
+		add(SeverityWarning, "snapshot_config", 0, "snapshot model %q, expected %q", snap.Model, opts.Model)
+	}
+	if snap.Chunks != opts.Chunks || (opts.Chunks && (snap.ChunkSize != opts.ChunkSize || snap.ChunkOverlap != opts.ChunkOverlap)) {
+		add(SeverityWarning, "snapshot_config", 0, "snapshot chunking (chunks=%v size=%d overlap=%d) differs from configuration",
+			snap.Chunks, snap.ChunkSize, snap.ChunkOverlap)
+	}
+
+	byId := make(map[int]*Document, len(docs))
+	for _, doc := range docs {
+		byId[doc.DocId] = doc
+	}
+	seen := make(map[int]bool, len(snap.Entries))
+	dimension := 0
+	checkVector := func(docId int, what string, v []float32) {
+		if len(v) == 0 {
+			add(SeverityError, "missing_vector", docId, "%s has no vector", what)
+			return
+		}
+		if dimension == 0 {
+			dimension = len(v)
+		} else if len(v) != dimension {
+			add(SeverityError, "dimension_mismatch", docId, "%s has dimension %d, expected %d", what, len(v), dimension)
+			return
+		}
+		if norm(v) <= 0 {
+			add(SeverityError, "zero_vector", docId, "%s vector is zero", what)
+		}
+	}
+
+	for _, entry := range snap.Entries {
+		if seen[entry.DocId] {
+			add(SeverityError, "duplicate_doc_id", entry.DocId, "doc id appears more than once in snapshot")
+			continue
+		}
+		seen[entry.DocId] = true
+
+		checkVector(entry.DocId, "summary", entry.Vector)
+		for i, c := range entry.Chunks {
+			checkVector(entry.DocId, fmt.Sprintf("chunk %d", i), c.Vector)
+		}
+
+		doc, ok := byId[entry.DocId]
+		if !ok {
+			add(SeverityWarning, "orphaned_entry", entry.DocId, "snapshot entry has no document")
+			continue
+		}
+		if doc.Hash != entry.Hash {
+			add(SeverityWarning, "stale_entry", entry.DocId, "document changed since the snapshot was written")
+			continue
+		}
+		if !snap.Chunks {
+			continue
+		}
+		spans := SplitSpans(doc.Content, snap.ChunkSize, snap.ChunkOverlap)
+		if len(spans) != len(entry.Chunks) {
+			add(SeverityError, "chunk_count", entry.DocId, "snapshot has %d chunks, document splits into %d", len(entry.Chunks), len(spans))
+			continue
+		}
+		for i, c := range entry.Chunks {
+			if c.Start != spans[i].Start || c.End != spans[i].End {
+				add(SeverityError, "chunk_span", entry.DocId, "chunk %d spans [%d,%d), expected [%d,%d)", i, c.Start, c.End, spans[i].Start, spans[i].End)
+				break
+			}
+		}
+	}
+	for _, doc := range docs {
+		if !seen[doc.DocId] {
+			add(SeverityWarning, "missing_entry", doc.DocId, "document is not in snapshot")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,137 @@
+package index
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"rag_app/internal/markdown"
+)
+
+// 片段在文档内容中的位置，以字符（rune）为单位，左闭右开
+type Span struct {
+	Start int
+	End   int
+}
+
+// 按段落将文档内容切分为不超过 maxChars 个字符的片段，超长段落会被硬切分。
+// 代码块和公式块不会被切开，本身超过 maxChars 的围栏块单独成为一个片段
+func SplitChunks(content string, maxChars int) []string {
+	runes := []rune(content)
+	spans := SplitSpans(content, maxChars, 0)
+	chunks := make([]string, len(spans))
+	for i, s := range spans {
+		chunks[i] = string(runes[s.Start:s.End])
+	}
+	return chunks
+}
+
+// 与 SplitChunks 的切分方式相同，返回各片段的位置。overlap 大于 0 时，
+// 除第一个片段外每个片段向前多包含前一片段末尾的 overlap 个字符
+func SplitSpans(content string, maxChars, overlap int) []Span {
+	runes := []rune(content)
+	if maxChars <= 0 {
+		return []Span{{0, len(runes)}}
+	}
+
+	fences := markdown.Fences(content)
+	spans := []Span{}
+	// 当前累积的片段，length 为 0 表示为空
+	start, end, length := 0, 0, 0
+	flush := func() {
+		if length > 0 {
+			spans = trimSpan(runes, Span{start, end}, spans)
+		}
+		length = 0
+	}
+
+	pos := 0
+	for _, para := range paragraphs(content, fences) {
+		paraStart := pos
+		paraLen := len([]rune(para))
+		pos += paraLen + 2
+
+		if length+paraLen+2 > maxChars {
+			flush()
+		}
+		for paraLen > maxChars {
+			// 切分点落在围栏块内时改在围栏块之前，围栏块在开头时整块保留
+			cut := paraStart + maxChars
+			if f, ok := markdown.At(fences, cut); ok {
+				if f.Start > paraStart {
+					cut = f.Start
+				} else {
+					cut = min(f.End, paraStart+paraLen)
+				}
+			}
+			start, end, length = paraStart, cut, cut-paraStart
+			flush()
+			paraLen -= cut - paraStart
+			paraStart = cut
+		}
+		if length > 0 {
+			length += 2 + paraLen
+			end = paraStart + paraLen
+		} else if paraLen > 0 {
+			start, end, length = paraStart, paraStart+paraLen, paraLen
+		}
+	}
+	flush()
+
+	if overlap > 0 {
+		for i := 1; i < len(spans); i++ {
+			start := max(spans[i].Start-overlap, spans[i-1].Start)
+			// 重叠部分不从围栏块中间开始
+			if f, ok := markdown.At(fences, start); ok {
+				start = min(f.End, spans[i].Start)
+			}
+			spans[i].Start = start
+		}
+	}
+	return spans
+}
+
+// 按空行切分段落，围栏块内的空行不切分
+func paragraphs(content string, fences []markdown.Fence) []string {
+	paras := []string{}
+	pos := 0
+	for _, para := range strings.Split(content, "\n\n") {
+		n := len(paras)
+		if _, ok := markdown.At(fences, pos); ok && n > 0 {
+			paras[n-1] += "\n\n" + para
+		} else {
+			paras = append(paras, para)
+		}
+		pos += len([]rune(para)) + 2
+	}
+	return paras
+}
+
+// 去掉片段首尾的空白，全为空白的片段被丢弃
+func trimSpan(runes []rune, s Span, spans []Span) []Span {
+	for s.Start < s.End && unicode.IsSpace(runes[s.Start]) {
+		s.Start++
+	}
+	for s.End > s.Start && unicode.IsSpace(runes[s.End-1]) {
+		s.End--
+	}
+	if s.Start == s.End {
+		return spans
+	}
+	return append(spans, s)
+}
+
+// 按位置合并重叠或相邻的片段，返回按位置排序的结果
+func MergeSpans(spans []Span) []Span {
+	sorted := slices.Clone(spans)
+	slices.SortFunc(sorted, func(a, b Span) int { return a.Start - b.Start })
+	merged := []Span{}
+	for _, s := range sorted {
+		if n := len(merged); n > 0 && s.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, s.End)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
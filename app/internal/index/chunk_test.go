@@ -0,0 +1,68 @@
+package index
+
+import (
+	"slices"
+	"testing"
+
+	"rag_app/internal/markdown"
+)
+
+func TestSplitChunksFences(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		maxChars int
+		want     []string
+	}{
+		{
+			"fence split across the boundary",
+			"intro para\n\n```go\na := 1\n\nb := 2\n```\n\ntail para", 20,
+			[]string{"intro para", "```go\na := 1\n\nb := 2\n```", "tail para"},
+		},
+		{
+			"math block with blank lines",
+			"aaaa\n\n$$\nx = 1\n\ny = 2\n$$\n\nbbbb", 8,
+			[]string{"aaaa", "$$\nx = 1\n\ny = 2\n$$", "bbbb"},
+		},
+		{
+			"fence longer than the chunk",
+			"```\n0123456789\n0123456789\n```", 10,
+			[]string{"```\n0123456789\n0123456789\n```"},
+		},
+		{
+			"unterminated fence runs to the end",
+			"intro\n\n```go\nline one\n\nline two\n\nline three", 12,
+			[]string{"intro", "```go\nline one\n\nline two\n\nline three"},
+		},
+		{
+			"no fences",
+			"aaaa\n\nbbbb\n\ncccc", 10,
+			[]string{"aaaa\n\nbbbb", "cccc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitChunks(tt.content, tt.maxChars)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SplitChunks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSpansOverlapOutsideFences(t *testing.T) {
+	content := "first para here\n\n```\ncode\n```\n\nlast para"
+	spans := SplitSpans(content, 15, 10)
+	want := []Span{{0, 15}, {7, 29}, {29, 40}}
+	if !slices.Equal(spans, want) {
+		t.Fatalf("SplitSpans() = %v, want %v", spans, want)
+	}
+	fences := markdown.Fences(content)
+	for _, s := range spans {
+		for _, pos := range []int{s.Start, s.End} {
+			if f, ok := markdown.At(fences, pos); ok {
+				t.Errorf("span %v has a boundary inside the fence %v", s, f)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package index
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// k-means 的最大迭代次数
+const kmeansIterations = 50
+
+// 返回索引中的文档及其摘要向量，用于离线分析
+func (x *Index) Vectors() ([]*Document, [][]float32) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return slices.Clone(x.docs), slices.Clone(x.vectors)
+}
+
+// 以余弦距离对向量做 k-means 聚类，返回每个向量所属的簇。
+// 使用固定种子的 k-means++ 初始化，相同输入得到相同结果
+func KMeans(vectors [][]float32, k int) []int {
+	n := len(vectors)
+	assign := make([]int, n)
+	if n == 0 || k <= 1 {
+		return assign
+	}
+	k = min(k, n)
+
+	points := make([][]float32, n)
+	for i, v := range vectors {
+		points[i] = normalize(v)
+	}
+
+	// k-means++：依次按与已选中心的距离加权抽取新中心
+	rng := rand.New(rand.NewPCG(1, uint64(n)))
+	centers := [][]float32{points[rng.IntN(n)]}
+	dist := make([]float64, n)
+	for len(centers) < k {
+		sum := 0.0
+		for i, p := range points {
+			dist[i] = 1 - float64(dot(p, centers[0]))
+			for _, c := range centers[1:] {
+				dist[i] = min(dist[i], 1-float64(dot(p, c)))
+			}
+			dist[i] = max(dist[i], 0)
+			sum += dist[i]
+		}
+		if sum == 0 {
+			break
+		}
+		r := rng.Float64() * sum
+		next := n - 1
+		for i, d := range dist {
+			r -= d
+			if r <= 0 {
+				next = i
+				break
+			}
+		}
+		centers = append(centers, points[next])
+	}
+
+	for range kmeansIterations {
+		changed := false
+		for i, p := range points {
+			best, bestSim := 0, float32(-2)
+			for j, c := range centers {
+				if sim := dot(p, c); sim > bestSim {
+					best, bestSim = j, sim
+				}
+			}
+			if assign[i] != best {
+				assign[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		dim := len(points[0])
+		sums := make([][]float32, len(centers))
+		for j := range sums {
+			sums[j] = make([]float32, dim)
+		}
+		for i, p := range points {
+			for d := range min(dim, len(p)) {
+				sums[assign[i]][d] += p[d]
+			}
+		}
+		for j, s := range sums {
+			// 空簇保留原中心
+			if norm(s) > 0 {
+				centers[j] = normalize(s)
+			}
+		}
+	}
+	return assign
+}
+
+func normalize(v []float32) []float32 {
+	n := norm(v)
+	out := make([]float32, len(v))
+	if n == 0 {
+		return out
+	}
+	for i, x := range v {
+		out[i] = x / n
+	}
+	return out
+}
+
+// 单位向量的点积即余弦相似度，维度不一致时视为 0
+func dot(a, b []float32) float32 {
+	d, _ := dotProduct(a, b)
+	return d
+}
@@ -0,0 +1,106 @@
+package index
+
+import (
+	"fmt"
+	"slices"
+)
+
+// 由多个子索引组成的索引，各子索引可以使用不同的向量化模型。
+// Search 对每个子索引使用同一个查询向量，仅适用于向量维度相同的子索引；
+// 不同模型的子索引应分别检索后合并结果
+type Composite struct {
+	parts []Store
+	owner map[int]Store
+}
+
+func NewComposite(parts ...Store) (*Composite, error) {
+	x := &Composite{
+		parts: parts,
+		owner: map[int]Store{},
+	}
+	for _, part := range parts {
+		for _, doc := range part.Documents() {
+			if _, ok := x.owner[doc.DocId]; ok {
+				return nil, fmt.Errorf("duplicate doc id %d", doc.DocId)
+			}
+			x.owner[doc.DocId] = part
+		}
+	}
+	return x, nil
+}
+
+func (x *Composite) Parts() []Store {
+	return x.parts
+}
+
+func (x *Composite) Len() int {
+	return len(x.owner)
+}
+
+func (x *Composite) Documents() []*Document {
+	docs := make([]*Document, 0, len(x.owner))
+	for _, part := range x.parts {
+		docs = append(docs, part.Documents()...)
+	}
+	return docs
+}
+
+func (x *Composite) Get(docId int) (*Document, bool) {
+	part, ok := x.owner[docId]
+	if !ok {
+		return nil, false
+	}
+	return part.Get(docId)
+}
+
+func (x *Composite) Search(query []float32, topN int, exclude func(doc *Document) bool) ([]Hit, error) {
+	hits := []Hit{}
+	for _, part := range x.parts {
+		h, err := part.Search(query, topN, exclude)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, h...)
+	}
+	return MergeHits(hits, topN), nil
+}
+
+func (x *Composite) BestChunk(doc *Document, query []float32) (string, bool) {
+	part, ok := x.owner[doc.DocId]
+	if !ok {
+		return "", false
+	}
+	return part.BestChunk(doc, query)
+}
+
+func (x *Composite) TopChunks(doc *Document, query []float32, n int) []Span {
+	part, ok := x.owner[doc.DocId]
+	if !ok {
+		return nil
+	}
+	return part.TopChunks(doc, query, n)
+}
+
+func (x *Composite) UpdateSummary(docId int, summary string, vector []float32) (*Document, error) {
+	part, ok := x.owner[docId]
+	if !ok {
+		return nil, ErrDocumentNotFound
+	}
+	return part.UpdateSummary(docId, summary, vector)
+}
+
+// 按分数从高到低合并多路检索结果，保留前 topN 条，分数相同时按文档 ID 排序
+func MergeHits(hits []Hit, topN int) []Hit {
+	slices.SortStableFunc(hits, func(a Hit, b Hit) int {
+		if a.Score > b.Score {
+			return -1
+		} else if a.Score < b.Score {
+			return 1
+		}
+		return a.Doc.DocId - b.Doc.DocId
+	})
+	if topN < len(hits) {
+		hits = hits[:topN]
+	}
+	return hits
+}
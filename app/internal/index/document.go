@@ -0,0 +1,43 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type Document struct {
+	DocId     int
+	Title     string
+	Content   string
+	Summary   string
+	ExpiresAt time.Time
+	// 所属集合，为空表示默认集合
+	Collection string
+	// 源系统中的原始链接，用于引用
+	URL string
+	// 在元数据中标记为屏蔽，不参与检索
+	Blocked bool
+	// 元数据中的标签、来源和文档日期
+	Tags   []string
+	Source string
+	Date   time.Time
+	// 摘要和内容的哈希，文档被重新索引且内容变化时随之改变
+	Hash string
+	// 超出 DOC_MAX_BYTES 被拆分时，原文档的 ID；未拆分时为 0
+	SplitFrom int
+}
+
+// 文档是否已过期，未设置过期时间的文档永不过期
+func (d *Document) Expired(now time.Time) bool {
+	return !d.ExpiresAt.IsZero() && !now.Before(d.ExpiresAt)
+}
+
+// 摘要和内容的哈希，摘要或内容变化时随之改变
+func HashDocument(summary, content string) string {
+	h := sha256.New()
+	h.Write([]byte(summary))
+	h.Write([]byte{0})
+	h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
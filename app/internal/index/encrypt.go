@@ -0,0 +1,80 @@
+package index
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 加密快照的文件头，未加密的快照直接以 gob 数据开头
+var encryptedMagic = []byte("LENTOENC1\n")
+
+var errSnapshotEncrypted = errors.New("snapshot is encrypted but no key is configured")
+
+// 解析 AES-256 密钥，支持 base64 或十六进制编码
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		key, err = hex.DecodeString(s)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes, base64 or hex encoded")
+	}
+	return key, nil
+}
+
+// 以 AES-GCM 加密，输出为文件头 + 随机 nonce + 密文
+func encrypt(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	out := append(bytes.Clone(encryptedMagic), nonce...)
+	return gcm.Seal(out, nonce, plain, encryptedMagic), nil
+}
+
+// 解密 encrypt 的输出，数据未加密时原样返回
+func decrypt(key, data []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, false, nil
+	}
+	if key == nil {
+		return nil, true, errSnapshotEncrypted
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, true, err
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, true, errors.New("encrypted snapshot is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], encryptedMagic)
+	if err != nil {
+		return nil, true, fmt.Errorf("decrypt snapshot: %w", err)
+	}
+	return plain, true, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
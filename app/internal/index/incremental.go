@@ -0,0 +1,153 @@
+package index
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"rag_app/internal/provider"
+)
+
+// 可复用的向量，按摘要和片段文本的哈希查找。文档修改后只有变化的摘要和片段需要重新向量化
+type vectorCache struct {
+	summaries map[string][]float32
+	// 文档哈希 -> 摘要向量，用于未记录摘要哈希的旧快照
+	docs   map[string][]float32
+	chunks map[string][]float32
+}
+
+func newVectorCache() *vectorCache {
+	return &vectorCache{
+		summaries: make(map[string][]float32),
+		docs:      make(map[string][]float32),
+		chunks:    make(map[string][]float32),
+	}
+}
+
+func textHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// 收集快照中的向量，快照格式或向量化模型不同时向量不可复用
+func (vc *vectorCache) addSnapshot(snap *snapshot, model string) {
+	if snap.Version != SnapshotVersion || snap.Model != model {
+		return
+	}
+	for _, entry := range snap.Entries {
+		if entry.SummaryHash != "" {
+			vc.summaries[entry.SummaryHash] = entry.Vector
+		}
+		vc.docs[entry.Hash] = entry.Vector
+		for _, c := range entry.Chunks {
+			vc.chunks[textHash(c.Text)] = c.Vector
+		}
+	}
+}
+
+// 收集当前索引中的向量，调用方需保证索引使用相同的向量化模型
+func (vc *vectorCache) addIndex(x *Index) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for i, doc := range x.docs {
+		vc.summaries[textHash(doc.Summary)] = x.vectors[i]
+	}
+	for _, chunks := range x.chunks {
+		for _, c := range chunks {
+			vc.chunks[textHash(c.text)] = c.vector
+		}
+	}
+}
+
+func (vc *vectorCache) summary(doc *Document) ([]float32, bool) {
+	if v, ok := vc.summaries[textHash(doc.Summary)]; ok {
+		return v, true
+	}
+	v, ok := vc.docs[doc.Hash]
+	return v, ok
+}
+
+func (vc *vectorCache) chunk(text string) ([]float32, bool) {
+	v, ok := vc.chunks[textHash(text)]
+	return v, ok
+}
+
+func (vc *vectorCache) empty() bool {
+	return len(vc.summaries) == 0 && len(vc.docs) == 0 && len(vc.chunks) == 0
+}
+
+// 计算文本的向量，lookup 命中的直接复用，只对其余文本调用向量化服务。
+// 每批完成后以已完成的条数（含复用的）调用 onBatch，返回向量和复用的条数
+func embedReusing(ctx context.Context, embedder provider.Embedder, texts []string, lookup func(i int) ([]float32, bool), onBatch func(done int)) ([][]float32, int, error) {
+	vectors := make([][]float32, len(texts))
+	missing := []int{}
+	for i := range texts {
+		if v, ok := lookup(i); ok {
+			vectors[i] = v
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	reused := len(texts) - len(missing)
+	if len(missing) == 0 {
+		onBatch(len(texts))
+		return vectors, reused, nil
+	}
+
+	missingTexts := make([]string, len(missing))
+	for j, i := range missing {
+		missingTexts[j] = texts[i]
+	}
+	embedded, err := embedBatches(ctx, embedder, missingTexts, func(done int) {
+		onBatch(reused + done)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for j, i := range missing {
+		vectors[i] = embedded[j]
+	}
+	return vectors, reused, nil
+}
+
+// 只用已有的向量建立索引，不调用向量化服务。缺少摘要向量或任一片段向量的文档暂不加入索引，
+// 计入 missing，由之后完整的重建补齐
+func warmIndex(docs []*Document, reuse *vectorCache, opts BuildOptions) (*Index, error) {
+	x := &Index{metric: opts.Metric, ids: make(map[int]int, len(docs))}
+	vectors := [][]float32{}
+	for _, doc := range docs {
+		vector, ok := reuse.summary(doc)
+		if !ok {
+			x.missing++
+			continue
+		}
+		var chunks []chunk
+		if opts.Chunks {
+			runes := []rune(doc.Content)
+			for _, span := range SplitSpans(doc.Content, opts.ChunkSize, opts.ChunkOverlap) {
+				text := string(runes[span.Start:span.End])
+				v, ok := reuse.chunk(text)
+				if !ok {
+					chunks = nil
+					break
+				}
+				chunks = append(chunks, chunk{text: text, span: span, vector: v, norm: norm(v)})
+			}
+			if chunks == nil && doc.Content != "" {
+				x.missing++
+				continue
+			}
+			x.chunks = append(x.chunks, chunks)
+		}
+		x.ids[doc.DocId] = len(x.docs)
+		x.docs = append(x.docs, doc)
+		vectors = append(vectors, vector)
+	}
+	err := x.setVectors(vectors)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("warm index: %d/%d documents from existing vectors\n", len(x.docs), len(docs))
+	return x, nil
+}
@@ -0,0 +1,426 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync"
+
+	"rag_app/internal/provider"
+)
+
+// 文档索引接口，便于替换为其他存储实现
+type Store interface {
+	// 文档总数
+	Len() int
+	// 按加载顺序返回全部文档
+	Documents() []*Document
+	// 按文档ID查找文档
+	Get(docId int) (*Document, bool)
+	// 按向量相似度检索文档，exclude 返回 true 的文档不参与检索
+	Search(query []float32, topN int, exclude func(doc *Document) bool) ([]Hit, error)
+	// 返回文档中与查询最相似的内容片段，未建立片段索引时返回 false
+	BestChunk(doc *Document, query []float32) (string, bool)
+	// 返回文档中与查询最相似的 n 个片段的位置，未建立片段索引时返回 nil
+	TopChunks(doc *Document, query []float32, n int) []Span
+	// 替换文档摘要及其向量，其余文档和片段索引保持不变
+	UpdateSummary(docId int, summary string, vector []float32) (*Document, error)
+}
+
+var ErrDocumentNotFound = errors.New("document not found")
+
+type Hit struct {
+	Doc   *Document
+	Score float32
+}
+
+// 单次向量化请求的最大条数
+const embedBatchSize = 64
+
+type BuildOptions struct {
+	// 是否为文档内容建立片段索引
+	Chunks bool
+	// 片段的最大字符数
+	ChunkSize int
+	// 相邻片段重叠的字符数
+	ChunkOverlap int
+	// 向量化模型，记录在快照中，模型变化时快照失效
+	Model string
+	// 索引快照文件，为空时不使用快照
+	Snapshot string
+	// 相似度度量，默认为余弦相似度
+	Metric string
+	// 快照的 AES-256 加密密钥，为空时不加密
+	Key []byte
+	// 构建进度回调，可为空
+	Progress func(stage string, done, total int)
+	// 使用相同向量化模型的现有索引，其中摘要和片段文本未变化的向量直接复用，可为空
+	Previous *Index
+	// 只读，不写入快照，用于共享同一快照的副本实例
+	ReadOnly bool
+	// 只使用快照和 Previous 中已有的向量，不调用向量化服务，缺少向量的文档暂不加入索引
+	SnapshotOnly bool
+}
+
+// 构建进度的阶段
+const (
+	// 从快照加载
+	ProgressSnapshot = "snapshot"
+	// 向量化文档摘要
+	ProgressSummaries = "summaries"
+	// 向量化文档片段
+	ProgressChunks = "chunks"
+)
+
+func (opts BuildOptions) progress(stage string, done, total int) {
+	if opts.Progress != nil {
+		opts.Progress(stage, done, total)
+	}
+}
+
+// 基于内存的文档索引，对文档摘要做向量检索
+type Index struct {
+	// 保护单篇文档摘要的原地更新
+	mu      sync.RWMutex
+	metric  string
+	ids     map[int]int
+	docs    []*Document
+	vectors [][]float32
+	norms   []float32
+	chunks  [][]chunk
+	// 以 SnapshotOnly 建立时因缺少向量而未加入索引的文档数
+	missing int
+}
+
+type chunk struct {
+	text   string
+	span   Span
+	vector []float32
+	norm   float32
+}
+
+// 计算全部文档摘要的embedding并建立索引；配置了快照且快照与文档一致时直接从快照加载
+func Build(ctx context.Context, docs []*Document, embedder provider.Embedder, opts BuildOptions) (*Index, error) {
+	if opts.Metric == "" {
+		opts.Metric = MetricCosine
+	}
+	err := validateMetric(opts.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int]int, len(docs))
+	summaries := make([]string, len(docs))
+	for i, doc := range docs {
+		if _, ok := ids[doc.DocId]; ok {
+			return nil, fmt.Errorf("duplicate doc id %d", doc.DocId)
+		}
+		ids[doc.DocId] = i
+		summaries[i] = doc.Summary
+	}
+	x := &Index{
+		metric: opts.Metric,
+		ids:    ids,
+		docs:   docs,
+	}
+
+	// 快照与文档不完全一致时，复用其中未变化的摘要和片段的向量
+	reuse := newVectorCache()
+	if opts.Snapshot != "" {
+		snap, err := readSnapshot(opts.Snapshot, opts.Key)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Printf("load snapshot %s: %v\n", opts.Snapshot, err)
+		} else if snap != nil {
+			ok, err := x.restore(snap, opts)
+			if err != nil {
+				fmt.Printf("load snapshot %s: %v\n", opts.Snapshot, err)
+			} else if ok {
+				fmt.Printf("index loaded from snapshot %s\n", opts.Snapshot)
+				opts.progress(ProgressSnapshot, len(docs), len(docs))
+				return x, nil
+			}
+			reuse.addSnapshot(snap, opts.Model)
+		}
+	}
+	if opts.Previous != nil {
+		reuse.addIndex(opts.Previous)
+	}
+	if opts.SnapshotOnly {
+		return warmIndex(docs, reuse, opts)
+	}
+
+	vectors, reused, err := embedReusing(ctx, embedder, summaries, func(i int) ([]float32, bool) {
+		return reuse.summary(docs[i])
+	}, func(done int) {
+		opts.progress(ProgressSummaries, done, len(summaries))
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = x.setVectors(vectors)
+	if err != nil {
+		return nil, err
+	}
+	if !reuse.empty() {
+		fmt.Printf("reused %d/%d summary vectors\n", reused, len(summaries))
+	}
+
+	if opts.Chunks {
+		err = x.buildChunks(ctx, embedder, opts, reuse)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Snapshot != "" && opts.ReadOnly {
+		fmt.Printf("snapshot %s is missing or stale, index built in memory only (read-only)\n", opts.Snapshot)
+	} else if opts.Snapshot != "" {
+		err = x.save(opts)
+		if err != nil {
+			fmt.Printf("save snapshot %s: %v\n", opts.Snapshot, err)
+		}
+	}
+
+	return x, nil
+}
+
+func (x *Index) setVectors(vectors [][]float32) error {
+	if len(vectors) != len(x.docs) {
+		return errors.New("embedding length mismatch")
+	}
+	norms := make([]float32, len(vectors))
+	for i, v := range vectors {
+		norms[i] = norm(v)
+		if norms[i] <= 0 {
+			return fmt.Errorf("embedding %d is zero", i)
+		}
+	}
+	x.vectors = vectors
+	x.norms = norms
+	return nil
+}
+
+// 切分全部文档内容并计算片段的embedding，reuse 中已有的片段向量直接复用
+func (x *Index) buildChunks(ctx context.Context, embedder provider.Embedder, opts BuildOptions, reuse *vectorCache) error {
+	x.chunks = make([][]chunk, len(x.docs))
+	texts := []string{}
+	for i, doc := range x.docs {
+		runes := []rune(doc.Content)
+		for _, span := range SplitSpans(doc.Content, opts.ChunkSize, opts.ChunkOverlap) {
+			text := string(runes[span.Start:span.End])
+			x.chunks[i] = append(x.chunks[i], chunk{text: text, span: span})
+			texts = append(texts, text)
+		}
+	}
+
+	vectors, reused, err := embedReusing(ctx, embedder, texts, func(i int) ([]float32, bool) {
+		return reuse.chunk(texts[i])
+	}, func(done int) {
+		opts.progress(ProgressChunks, done, len(texts))
+	})
+	if err != nil {
+		return err
+	}
+	if !reuse.empty() {
+		fmt.Printf("reused %d/%d chunk vectors\n", reused, len(texts))
+	}
+
+	n := 0
+	for i := range x.chunks {
+		for j := range x.chunks[i] {
+			x.chunks[i][j].vector = vectors[n]
+			x.chunks[i][j].norm = norm(vectors[n])
+			n++
+		}
+	}
+	fmt.Printf("total %d chunks\n", n)
+
+	return nil
+}
+
+// 分批计算向量，每批完成后以已完成的条数调用 onBatch。ctx 取消时在下一批之前停止
+func embedBatches(ctx context.Context, embedder provider.Embedder, texts []string, onBatch func(done int)) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for i := 0; i < len(texts); i += embedBatchSize {
+		if err := context.Cause(ctx); err != nil {
+			return nil, err
+		}
+		end := min(i+embedBatchSize, len(texts))
+		embs, err := embedder.Embed(ctx, texts[i:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, embs...)
+		onBatch(end)
+	}
+	return vectors, nil
+}
+
+func (x *Index) Len() int {
+	return len(x.docs)
+}
+
+// 以 SnapshotOnly 建立时因缺少向量而未加入索引的文档数，完整的索引为 0
+func (x *Index) Missing() int {
+	return x.missing
+}
+
+func (x *Index) Documents() []*Document {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return slices.Clone(x.docs)
+}
+
+func (x *Index) Get(docId int) (*Document, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	idx, ok := x.ids[docId]
+	if !ok {
+		return nil, false
+	}
+	return x.docs[idx], true
+}
+
+// 索引使用的相似度度量
+func (x *Index) Metric() string {
+	return x.metric
+}
+
+// 按配置的相似度度量查询相似语料
+func (x *Index) Search(query []float32, topN int, exclude func(doc *Document) bool) ([]Hit, error) {
+	normA := norm(query)
+	if normA <= 0 && x.metric == MetricCosine {
+		return nil, errors.New("embedding is zero")
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	hits := make([]Hit, 0, len(x.docs))
+	for i, v := range x.vectors {
+		doc := x.docs[i]
+		if exclude != nil && exclude(doc) {
+			continue
+		}
+
+		score, err := similarity(x.metric, query, normA, v, x.norms[i])
+		if err != nil {
+			return nil, err
+		}
+
+		hits = append(hits, Hit{
+			Doc:   doc,
+			Score: score,
+		})
+	}
+
+	return MergeHits(hits, topN), nil
+}
+
+func (x *Index) BestChunk(doc *Document, query []float32) (string, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	idx, ok := x.ids[doc.DocId]
+	if !ok || x.chunks == nil || len(x.chunks[idx]) == 0 {
+		return "", false
+	}
+
+	normA := norm(query)
+
+	best := ""
+	bestScore := float32(math.Inf(-1))
+	for _, c := range x.chunks[idx] {
+		score, err := similarity(x.metric, query, normA, c.vector, c.norm)
+		if err != nil {
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = c.text, score
+		}
+	}
+	return best, best != ""
+}
+
+// 按相似度从高到低排列
+func (x *Index) TopChunks(doc *Document, query []float32, n int) []Span {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	idx, ok := x.ids[doc.DocId]
+	if !ok || x.chunks == nil || len(x.chunks[idx]) == 0 {
+		return nil
+	}
+
+	normA := norm(query)
+	type scored struct {
+		span  Span
+		score float32
+	}
+	list := []scored{}
+	for _, c := range x.chunks[idx] {
+		score, err := similarity(x.metric, query, normA, c.vector, c.norm)
+		if err != nil {
+			continue
+		}
+		list = append(list, scored{c.span, score})
+	}
+	slices.SortStableFunc(list, func(a, b scored) int {
+		if a.score > b.score {
+			return -1
+		} else if a.score < b.score {
+			return 1
+		}
+		return 0
+	})
+
+	spans := make([]Span, 0, min(n, len(list)))
+	for _, s := range list[:min(n, len(list))] {
+		spans = append(spans, s.span)
+	}
+	return spans
+}
+
+// 替换单篇文档的摘要和向量。文档以副本替换，正在进行的请求持有的旧文档不受影响
+func (x *Index) UpdateSummary(docId int, summary string, vector []float32) (*Document, error) {
+	n := norm(vector)
+	if n <= 0 {
+		return nil, errors.New("embedding is zero")
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	idx, ok := x.ids[docId]
+	if !ok {
+		return nil, ErrDocumentNotFound
+	}
+	if len(vector) != len(x.vectors[idx]) {
+		return nil, errors.New("vector length mismatch")
+	}
+
+	doc := *x.docs[idx]
+	doc.Summary = summary
+	doc.Hash = HashDocument(summary, doc.Content)
+	x.docs[idx] = &doc
+	x.vectors[idx] = vector
+	x.norms[idx] = n
+	return &doc, nil
+}
+
+func dotProduct(a, b []float32) (float32, error) {
+	if len(a) != len(b) {
+		return 0, errors.New("vector length mismatch")
+	}
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum, nil
+}
+
+func norm(v []float32) float32 {
+	dot, _ := dotProduct(v, v)
+	return float32(math.Sqrt(float64(dot)))
+}
@@ -0,0 +1,235 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// 按文本中的关键词生成向量的向量化服务，记录调用次数
+type keywordEmbedder struct {
+	calls int
+	err   error
+}
+
+var keywords = []string{"apple", "banana", "cherry"}
+
+func (e *keywordEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	vectors := make([][]float32, len(input))
+	for i, text := range input {
+		v := make([]float32, len(keywords)+1)
+		v[len(keywords)] = 0.1
+		for j, k := range keywords {
+			v[j] = float32(strings.Count(text, k))
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func testDocuments() []*Document {
+	return []*Document{
+		{DocId: 1, Title: "A", Summary: "apple", Content: "apple pie. apple juice."},
+		{DocId: 2, Title: "B", Summary: "banana", Content: "banana bread."},
+		{DocId: 3, Title: "C", Summary: "cherry cherry apple", Content: "cherry tart."},
+	}
+}
+
+func docIds(hits []Hit) []int {
+	ids := []int{}
+	for _, hit := range hits {
+		ids = append(ids, hit.Doc.DocId)
+	}
+	return ids
+}
+
+func TestBuildAndSearch(t *testing.T) {
+	embedder := &keywordEmbedder{}
+	x, err := Build(context.Background(), testDocuments(), embedder, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", x.Len())
+	}
+	if doc, ok := x.Get(2); !ok || doc.Title != "B" {
+		t.Fatalf("Get(2) = %v, %t", doc, ok)
+	}
+	if _, ok := x.Get(9); ok {
+		t.Fatal("Get(9) found a missing document")
+	}
+
+	query, _ := embedder.Embed(context.Background(), []string{"cherry"})
+	tests := []struct {
+		name    string
+		topN    int
+		exclude func(doc *Document) bool
+		want    []int
+	}{
+		{"ranked", 3, nil, []int{3, 1, 2}},
+		{"top n", 1, nil, []int{3}},
+		{"exclude", 3, func(doc *Document) bool { return doc.DocId == 3 }, []int{1, 2}},
+		{"exclude all", 3, func(doc *Document) bool { return true }, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := x.Search(query[0], tt.topN, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := docIds(hits); !slices.Equal(got, tt.want) {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err = x.Search(make([]float32, 4), 3, nil)
+	if err == nil {
+		t.Error("Search() with a zero query succeeded under cosine")
+	}
+}
+
+func TestBuildRejectsDuplicateIds(t *testing.T) {
+	docs := append(testDocuments(), &Document{DocId: 1, Summary: "apple"})
+	_, err := Build(context.Background(), docs, &keywordEmbedder{}, BuildOptions{})
+	if err == nil || !strings.Contains(err.Error(), "duplicate doc id 1") {
+		t.Fatalf("Build() error = %v, want duplicate doc id", err)
+	}
+}
+
+func TestBuildChunks(t *testing.T) {
+	x, err := Build(context.Background(), testDocuments(), &keywordEmbedder{}, BuildOptions{Chunks: true, ChunkSize: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := x.Get(1)
+	query := []float32{0, 0, 0, 1}
+	if _, ok := x.BestChunk(doc, query); !ok {
+		t.Error("BestChunk() found no chunk")
+	}
+	if spans := x.TopChunks(doc, query, 1); len(spans) != 1 {
+		t.Errorf("TopChunks() = %v, want one span", spans)
+	}
+}
+
+func TestSnapshotSaveAndLoad(t *testing.T) {
+	key, err := ParseKey(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{"plain", nil},
+		{"encrypted", key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "index.snap")
+			opts := BuildOptions{Snapshot: path, Model: "m", Key: tt.key, Chunks: true, ChunkSize: 12}
+			built, err := Build(context.Background(), testDocuments(), &keywordEmbedder{}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Fatalf("snapshot not saved: %v", err)
+			}
+
+			// 从快照加载时不调用向量化服务
+			failing := &keywordEmbedder{err: errors.New("backend down")}
+			loaded, err := Build(context.Background(), testDocuments(), failing, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failing.calls != 0 {
+				t.Errorf("embedder called %d times, want the snapshot to be used", failing.calls)
+			}
+			query := []float32{0, 1, 0, 0}
+			want, _ := built.Search(query, 3, nil)
+			got, err := loaded.Search(query, 3, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(docIds(got), docIds(want)) {
+				t.Errorf("loaded Search() = %v, want %v", docIds(got), docIds(want))
+			}
+
+			// 模型变化时快照失效，需要重新向量化
+			opts.Model = "other"
+			_, err = Build(context.Background(), testDocuments(), failing, opts)
+			if err == nil {
+				t.Error("Build() reused a snapshot of another model")
+			}
+		})
+	}
+}
+
+func TestMergeHits(t *testing.T) {
+	docs := testDocuments()
+	hits := []Hit{
+		{Doc: docs[1], Score: 0.5},
+		{Doc: docs[2], Score: 0.9},
+		{Doc: docs[0], Score: 0.5},
+	}
+	tests := []struct {
+		name string
+		topN int
+		want []int
+	}{
+		{"score then doc id", 3, []int{3, 1, 2}},
+		{"truncated", 2, []int{3, 1}},
+		{"top n beyond hits", 10, []int{3, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := docIds(MergeHits(slices.Clone(hits), tt.topN))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("MergeHits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateSummary(t *testing.T) {
+	embedder := &keywordEmbedder{}
+	docs := testDocuments()
+	for _, doc := range docs {
+		doc.Hash = HashDocument(doc.Summary, doc.Content)
+	}
+	x, err := Build(context.Background(), docs, embedder, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := x.Get(2)
+	vector, _ := embedder.Embed(context.Background(), []string{"cherry cherry cherry"})
+	doc, err := x.UpdateSummary(2, "cherry cherry cherry", vector[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Summary != "cherry cherry cherry" || doc.Hash != HashDocument(doc.Summary, doc.Content) || doc.Hash == old.Hash {
+		t.Errorf("UpdateSummary() = %+v, want the new summary and its hash", doc)
+	}
+	if old.Summary != "banana" {
+		t.Errorf("UpdateSummary() modified the previous document: %q", old.Summary)
+	}
+	query, _ := embedder.Embed(context.Background(), []string{"cherry"})
+	hits, err := x.Search(query[0], 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := docIds(hits); !slices.Equal(got, []int{2}) {
+		t.Errorf("Search() after UpdateSummary() = %v, want [2]", got)
+	}
+	if _, err := x.UpdateSummary(9, "apple", vector[0]); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("UpdateSummary(9) error = %v", err)
+	}
+}
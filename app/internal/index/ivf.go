@@ -0,0 +1,88 @@
+package index
+
+import (
+	"errors"
+	"slices"
+)
+
+// 倒排文件（IVF）近似检索：按 k-means 将摘要向量分为 nlist 个簇，查询时只扫描中心与查询最相似的 nprobe 个簇。
+// 目前只用于基准测试，评估近似检索相对精确检索的延迟和召回率
+type IVF struct {
+	x       *Index
+	centers [][]float32
+	// 簇 -> 其中的向量在索引中的下标
+	lists [][]int
+}
+
+// 对索引的摘要向量聚类，建立 IVF。nlist 不大于 1 时只有一个簇，等同于精确检索
+func NewIVF(x *Index, nlist int) *IVF {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	assign := KMeans(x.vectors, nlist)
+	n := 0
+	for _, c := range assign {
+		n = max(n, c+1)
+	}
+	ivf := &IVF{x: x, centers: make([][]float32, n), lists: make([][]int, n)}
+	for i, c := range assign {
+		ivf.lists[c] = append(ivf.lists[c], i)
+	}
+	// 簇中心取单位向量之和的方向，与 KMeans 一致
+	for c, list := range ivf.lists {
+		var sum []float32
+		for _, i := range list {
+			v := normalize(x.vectors[i])
+			if sum == nil {
+				sum = make([]float32, len(v))
+			}
+			for d := range min(len(sum), len(v)) {
+				sum[d] += v[d]
+			}
+		}
+		ivf.centers[c] = normalize(sum)
+	}
+	return ivf
+}
+
+// 簇的数量
+func (ivf *IVF) Lists() int {
+	return len(ivf.lists)
+}
+
+// 在与查询最相似的 nprobe 个簇中按索引的相似度度量检索
+func (ivf *IVF) Search(query []float32, topN, nprobe int) ([]Hit, error) {
+	normA := norm(query)
+	if normA <= 0 {
+		return nil, errors.New("embedding is zero")
+	}
+	q := normalize(query)
+	order := make([]int, len(ivf.centers))
+	sims := make([]float32, len(ivf.centers))
+	for c, center := range ivf.centers {
+		order[c], sims[c] = c, dot(q, center)
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if sims[a] > sims[b] {
+			return -1
+		} else if sims[a] < sims[b] {
+			return 1
+		}
+		return 0
+	})
+
+	x := ivf.x
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	hits := []Hit{}
+	for _, c := range order[:min(max(nprobe, 1), len(order))] {
+		for _, i := range ivf.lists[c] {
+			score, err := similarity(x.metric, query, normA, x.vectors[i], x.norms[i])
+			if err != nil {
+				return nil, err
+			}
+			hits = append(hits, Hit{Doc: x.docs[i], Score: score})
+		}
+	}
+	return MergeHits(hits, topN), nil
+}
@@ -0,0 +1,90 @@
+package index
+
+import (
+	"os"
+)
+
+// 索引中的向量统计
+type Stats struct {
+	Documents int `json:"documents"`
+	Chunks    int `json:"chunks"`
+	Dimension int `json:"dimension"`
+}
+
+func (x *Index) Stats() Stats {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	stats := Stats{Documents: len(x.docs)}
+	if len(x.vectors) > 0 {
+		stats.Dimension = len(x.vectors[0])
+	}
+	for _, chunks := range x.chunks {
+		stats.Chunks += len(chunks)
+	}
+	return stats
+}
+
+// 快照文件的概要信息
+type SnapshotInfo struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Model     string `json:"model"`
+	Metric    string `json:"metric,omitempty"`
+	Entries   int    `json:"entries"`
+	Chunks    int    `json:"chunks"`
+	Dimension int    `json:"dimension"`
+	Encrypted bool   `json:"encrypted"`
+	DocIds    []int  `json:"-"`
+}
+
+// 读取快照文件的模型、维度和条目数
+func InspectSnapshot(path string, key []byte) (*SnapshotInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := readSnapshot(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SnapshotInfo{
+		Path:      path,
+		Size:      stat.Size(),
+		Model:     snap.Model,
+		Metric:    snap.Metric,
+		Entries:   len(snap.Entries),
+		Encrypted: snap.encrypted,
+		DocIds:    make([]int, len(snap.Entries)),
+	}
+	for i, entry := range snap.Entries {
+		info.DocIds[i] = entry.DocId
+		info.Chunks += len(entry.Chunks)
+		if info.Dimension == 0 {
+			info.Dimension = len(entry.Vector)
+		}
+	}
+	return info, nil
+}
+
+// 删除快照中 keep 返回 false 的文档条目，返回删除的条目数；没有需要删除的条目时不改写文件
+func PruneSnapshot(path string, key []byte, keep func(docId int) bool) (int, error) {
+	snap, err := readSnapshot(path, key)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := snap.Entries[:0]
+	for _, entry := range snap.Entries {
+		if keep(entry.DocId) {
+			entries = append(entries, entry)
+		}
+	}
+	removed := len(snap.Entries) - len(entries)
+	if removed == 0 {
+		return 0, nil
+	}
+	snap.Entries = entries
+	return removed, writeSnapshot(path, key, snap)
+}
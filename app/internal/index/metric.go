@@ -0,0 +1,49 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// 向量相似度度量，应与向量化模型训练时使用的度量一致
+const (
+	MetricCosine    = "cosine"
+	MetricDot       = "dot"
+	MetricEuclidean = "euclidean"
+)
+
+func validateMetric(metric string) error {
+	switch metric {
+	case MetricCosine, MetricDot, MetricEuclidean:
+		return nil
+	}
+	return fmt.Errorf("unknown similarity metric %q", metric)
+}
+
+// 计算相似度，值越大越相似；欧氏距离取负值
+func similarity(metric string, a []float32, normA float32, b []float32, normB float32) (float32, error) {
+	switch metric {
+	case MetricDot:
+		return dotProduct(a, b)
+	case MetricEuclidean:
+		if len(a) != len(b) {
+			return 0, errors.New("vector length mismatch")
+		}
+		var sum float32
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return -float32(math.Sqrt(float64(sum))), nil
+	default:
+		if normA <= 0 || normB <= 0 {
+			return 0, errors.New("embedding is zero")
+		}
+		dot, err := dotProduct(a, b)
+		if err != nil {
+			return 0, err
+		}
+		return dot / normA / normB, nil
+	}
+}
@@ -0,0 +1,176 @@
+package index
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 快照格式版本，格式或片段的切分方式变化时递增
+const SnapshotVersion = 3
+
+// 索引快照，保存每篇文档的哈希和向量，启动时若文档未变化可跳过向量化
+type snapshot struct {
+	Version   int
+	Model     string
+	Metric    string
+	Chunks    bool
+	ChunkSize int
+	// 相邻片段重叠的字符数
+	ChunkOverlap int
+	Entries      []snapshotEntry
+	// 读取时文件是否加密，不写入快照
+	encrypted bool
+}
+
+type snapshotEntry struct {
+	DocId int
+	Hash  string
+	// 摘要文本的哈希，文档内容变化而摘要未变时可复用摘要向量
+	SummaryHash string
+	Vector      []float32
+	Chunks      []snapshotChunk
+}
+
+type snapshotChunk struct {
+	Text   string
+	Start  int
+	End    int
+	Vector []float32
+}
+
+// 将索引写入快照文件，先写临时文件再重命名，避免写入中断导致快照损坏
+func (x *Index) save(opts BuildOptions) error {
+	snap := snapshot{
+		Version:      SnapshotVersion,
+		Model:        opts.Model,
+		Metric:       opts.Metric,
+		Chunks:       opts.Chunks,
+		ChunkSize:    opts.ChunkSize,
+		ChunkOverlap: opts.ChunkOverlap,
+		Entries:      make([]snapshotEntry, len(x.docs)),
+	}
+	for i, doc := range x.docs {
+		entry := snapshotEntry{
+			DocId:       doc.DocId,
+			Hash:        doc.Hash,
+			SummaryHash: textHash(doc.Summary),
+			Vector:      x.vectors[i],
+		}
+		if x.chunks != nil {
+			for _, c := range x.chunks[i] {
+				entry.Chunks = append(entry.Chunks, snapshotChunk{Text: c.text, Start: c.span.Start, End: c.span.End, Vector: c.vector})
+			}
+		}
+		snap.Entries[i] = entry
+	}
+
+	return writeSnapshot(opts.Snapshot, opts.Key, &snap)
+}
+
+// 写入快照，key 不为空时以 AES-GCM 加密整个文件
+func writeSnapshot(path string, key []byte, snap *snapshot) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(snap)
+	if err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if key != nil {
+		data, err = encrypt(key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// 读取快照，加密的快照需要提供密钥，未加密的快照可直接读取
+func readSnapshot(path string, key []byte) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, encrypted, err := decrypt(key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap snapshot
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&snap)
+	if err != nil {
+		return nil, err
+	}
+	snap.encrypted = encrypted
+	return &snap, nil
+}
+
+// 从快照恢复向量，快照与当前文档、配置不一致时返回 false
+func (x *Index) restore(snap *snapshot, opts BuildOptions) (bool, error) {
+	if snap.Version != SnapshotVersion || snap.Model != opts.Model ||
+		snap.Chunks != opts.Chunks || (opts.Chunks && (snap.ChunkSize != opts.ChunkSize || snap.ChunkOverlap != opts.ChunkOverlap)) {
+		fmt.Println("snapshot config changed, rebuilding index")
+		return false, nil
+	}
+	// 度量不影响向量本身，变化时无需重建
+	if snap.Metric != opts.Metric {
+		fmt.Printf("snapshot metric %q replaced by %q\n", snap.Metric, opts.Metric)
+	}
+	if len(snap.Entries) != len(x.docs) {
+		fmt.Println("snapshot documents changed, updating index")
+		return false, nil
+	}
+
+	vectors := make([][]float32, len(x.docs))
+	var chunks [][]chunk
+	if opts.Chunks {
+		chunks = make([][]chunk, len(x.docs))
+	}
+	for _, entry := range snap.Entries {
+		idx, ok := x.ids[entry.DocId]
+		if !ok || x.docs[idx].Hash != entry.Hash {
+			fmt.Printf("snapshot doc %d changed, updating index\n", entry.DocId)
+			return false, nil
+		}
+		vectors[idx] = entry.Vector
+		if opts.Chunks {
+			for _, c := range entry.Chunks {
+				chunks[idx] = append(chunks[idx], chunk{text: c.Text, span: Span{c.Start, c.End}, vector: c.Vector, norm: norm(c.Vector)})
+			}
+		}
+	}
+
+	err := x.setVectors(vectors)
+	if err != nil {
+		return false, err
+	}
+	x.chunks = chunks
+
+	// 开启加密后，将原有的明文快照改写为加密快照
+	if opts.Key != nil && !snap.encrypted {
+		err = x.save(opts)
+		if err != nil {
+			return false, err
+		}
+		fmt.Printf("snapshot %s encrypted\n", opts.Snapshot)
+	}
+	return true, nil
+}
@@ -0,0 +1,71 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"rag_app/internal/provider"
+)
+
+// 文档摘要的稀疏向量，与稠密向量检索配合使用，提升对罕见词的召回
+type Sparse struct {
+	mu      sync.RWMutex
+	ids     map[int]int
+	vectors []provider.SparseVector
+}
+
+// 计算全部文档摘要的稀疏向量
+func NewSparse(ctx context.Context, docs []*Document, embedder provider.SparseEmbedder) (*Sparse, error) {
+	x := &Sparse{
+		ids:     make(map[int]int, len(docs)),
+		vectors: make([]provider.SparseVector, 0, len(docs)),
+	}
+	for i := 0; i < len(docs); i += embedBatchSize {
+		end := min(i+embedBatchSize, len(docs))
+		summaries := make([]string, 0, end-i)
+		for _, doc := range docs[i:end] {
+			summaries = append(summaries, doc.Summary)
+		}
+		vectors, err := embedder.EmbedSparse(ctx, summaries)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(summaries) {
+			return nil, fmt.Errorf("sparse embedding length mismatch")
+		}
+		for j, doc := range docs[i:end] {
+			x.ids[doc.DocId] = len(x.vectors)
+			x.vectors = append(x.vectors, vectors[j])
+		}
+	}
+	return x, nil
+}
+
+// 文档摘要与查询稀疏向量的点积，文档不存在时为 0
+func (x *Sparse) Score(docId int, query provider.SparseVector) float32 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	idx, ok := x.ids[docId]
+	if !ok {
+		return 0
+	}
+	return x.vectors[idx].Dot(query)
+}
+
+// 以 稠密分数 + weight × 稀疏分数 重新计算召回结果的分数，返回前 topN 条
+func (x *Sparse) Rescore(hits []Hit, query provider.SparseVector, weight float32, topN int) []Hit {
+	for i := range hits {
+		hits[i].Score += weight * x.Score(hits[i].Doc.DocId, query)
+	}
+	return MergeHits(hits, topN)
+}
+
+// 替换单篇文档摘要的稀疏向量
+func (x *Sparse) Update(docId int, vector provider.SparseVector) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if idx, ok := x.ids[docId]; ok {
+		x.vectors[idx] = vector
+	}
+}
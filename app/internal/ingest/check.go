@@ -0,0 +1,89 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+
+	"rag_app/internal/convert"
+	"rag_app/internal/index"
+)
+
+// 检查摘要清单及其引用的文件：清单可读、文档 ID 唯一、每篇文档的 markdown 文件存在且元数据有效。
+// 与 Load 不同，遇到问题时继续检查其余文档，返回能正常加载的文档和发现的全部问题。
+// 超出大小上限的文档被拒绝时报告为错误，被拆分时报告为警告
+func Check(markdownDir, summaryFile string, limit SizeLimit, converters *convert.Registry) ([]*index.Document, []index.Problem) {
+	problems := []index.Problem{}
+	add := func(check, path string, docId int, err error) {
+		problems = append(problems, index.Problem{
+			Severity: index.SeverityError,
+			Check:    check,
+			Path:     path,
+			DocId:    docId,
+			Message:  err.Error(),
+		})
+	}
+
+	titles, err := loadTitles(markdownDir)
+	if err != nil {
+		add("manifest_unreadable", markdownDir, 0, err)
+	}
+	metas, err := loadMetadata(markdownDir)
+	if err != nil {
+		add("manifest_unreadable", markdownDir, 0, err)
+	}
+	entries, err := readSummaries(summaryFile)
+	if err != nil {
+		add("manifest_unreadable", summaryFile, 0, err)
+		return nil, problems
+	}
+	sources, err := sourceFiles(markdownDir)
+	if err != nil {
+		add("manifest_unreadable", markdownDir, 0, err)
+	}
+
+	docs := []*index.Document{}
+	seen := make(map[int]bool, len(entries))
+	for _, entry := range entries {
+		if seen[entry.DocId] {
+			add("duplicate_doc_id", summaryFile, entry.DocId, fmt.Errorf("doc id appears more than once"))
+			continue
+		}
+		seen[entry.DocId] = true
+
+		path := markdownFile(markdownDir, entry.DocId)
+		content, err := readContent(markdownDir, entry.DocId, sources, converters)
+		if errors.Is(err, errConvert) {
+			add("convert_failed", sources[entry.DocId], entry.DocId, err)
+			continue
+		} else if err != nil {
+			add("file_missing", path, entry.DocId, err)
+			continue
+		}
+		doc, err := newDocument(entry, content, titles, metas)
+		if err != nil {
+			add("invalid_metadata", markdownDir, entry.DocId, err)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	if err := limit.validate(); err != nil {
+		add("invalid_config", summaryFile, 0, err)
+		return docs, problems
+	}
+	docs, reports := applySizeLimit(docs, limit)
+	for _, report := range reports {
+		severity := index.SeverityError
+		if len(report.Parts) > 0 {
+			severity = index.SeverityWarning
+		}
+		problems = append(problems, index.Problem{
+			Severity: severity,
+			Check:    "doc_too_large",
+			Path:     markdownFile(markdownDir, report.DocId),
+			DocId:    report.DocId,
+			Message:  report.String(),
+		})
+	}
+	return docs, problems
+}
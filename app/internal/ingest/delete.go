@@ -0,0 +1,124 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 从源目录中删除文档：先整体替换摘要清单，去掉这些文档的记录，再删除其 markdown 文件和元数据。
+// 摘要清单决定加载哪些文档，替换是原子的；之后的清理失败只会留下不再被加载的文件
+func Delete(markdownDir, summaryFile string, docIds []int) error {
+	if len(docIds) == 0 {
+		return nil
+	}
+	buf, err := os.ReadFile(summaryFile)
+	if err != nil {
+		return err
+	}
+	// 按行过滤，保留其余记录的原始内容和格式
+	lines := strings.SplitAfter(string(buf), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		docId, ok := entryDocId(line, isJSONL(summaryFile))
+		if ok && slices.Contains(docIds, docId) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	err = writeFileAtomic(summaryFile, []byte(strings.Join(kept, "")))
+	if err != nil {
+		return err
+	}
+
+	// 其他格式的源文件一并删除
+	sources, err := sourceFiles(markdownDir)
+	if err != nil {
+		return err
+	}
+	for _, docId := range docIds {
+		for _, path := range []string{markdownFile(markdownDir, docId), sources[docId]} {
+			if path == "" {
+				continue
+			}
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return deleteMetadata(markdownDir, docIds)
+}
+
+// 摘要清单中一行记录的文档 ID，空行或无法解析的行返回 false
+func entryDocId(line string, jsonl bool) (int, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return 0, false
+	}
+	if jsonl {
+		var entry Entry
+		if json.Unmarshal([]byte(line), &entry) != nil {
+			return 0, false
+		}
+		return entry.DocId, true
+	}
+	id, _, ok := strings.Cut(line, ":")
+	if !ok {
+		return 0, false
+	}
+	docId, err := strconv.Atoi(strings.TrimSpace(id))
+	return docId, err == nil
+}
+
+// 从 metadata.json 中删除文档的元数据，文件不存在或不含这些文档时不做修改
+func deleteMetadata(markdownDir string, docIds []int) error {
+	path := filepath.Join(markdownDir, "metadata.json")
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(buf, &raw)
+	if err != nil {
+		return fmt.Errorf("metadata.json: %w", err)
+	}
+	n := len(raw)
+	for _, docId := range docIds {
+		delete(raw, strconv.Itoa(docId))
+	}
+	if len(raw) == n {
+		return nil
+	}
+	buf, err = json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(buf, '\n'))
+}
+
+// 先写临时文件再重命名，避免写入中断导致清单损坏
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
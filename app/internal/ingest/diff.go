@@ -0,0 +1,144 @@
+package ingest
+
+import (
+	"strings"
+	"time"
+
+	"rag_app/internal/index"
+)
+
+// 两次加载之间的文档变化
+type DiffReport struct {
+	Added   []DocChange `json:"added"`
+	Updated []DocChange `json:"updated"`
+	Removed []DocChange `json:"removed"`
+}
+
+type DocChange struct {
+	DocId int    `json:"doc_id"`
+	Title string `json:"title"`
+	// 摘要变化时给出变化前后的摘要
+	SummaryBefore string `json:"summary_before,omitempty"`
+	SummaryAfter  string `json:"summary_after,omitempty"`
+	// 内容是否变化
+	ContentChanged bool `json:"content_changed,omitempty"`
+}
+
+// 比较当前文档和新加载的文档，按内容哈希判断是否更新
+func Diff(current, next []*index.Document) *DiffReport {
+	report := &DiffReport{
+		Added:   []DocChange{},
+		Updated: []DocChange{},
+		Removed: []DocChange{},
+	}
+
+	old := make(map[int]*index.Document, len(current))
+	for _, doc := range current {
+		old[doc.DocId] = doc
+	}
+
+	seen := make(map[int]bool, len(next))
+	for _, doc := range next {
+		seen[doc.DocId] = true
+		prev, ok := old[doc.DocId]
+		if !ok {
+			report.Added = append(report.Added, DocChange{
+				DocId:        doc.DocId,
+				Title:        doc.Title,
+				SummaryAfter: doc.Summary,
+			})
+			continue
+		}
+		if prev.Hash == doc.Hash {
+			continue
+		}
+
+		change := DocChange{
+			DocId:          doc.DocId,
+			Title:          doc.Title,
+			ContentChanged: prev.Content != doc.Content,
+		}
+		if prev.Summary != doc.Summary {
+			change.SummaryBefore = prev.Summary
+			change.SummaryAfter = doc.Summary
+		}
+		report.Updated = append(report.Updated, change)
+	}
+
+	for _, doc := range current {
+		if !seen[doc.DocId] {
+			report.Removed = append(report.Removed, DocChange{
+				DocId:         doc.DocId,
+				Title:         doc.Title,
+				SummaryBefore: doc.Summary,
+			})
+		}
+	}
+
+	return report
+}
+
+// 文档重新加载后的一个版本变化，updated 时附带内容的逐行差异
+type DocVersion struct {
+	DocId     int       `json:"doc_id"`
+	Title     string    `json:"title"`
+	ChangedAt time.Time `json:"changed_at"`
+	// added、updated 或 removed
+	Change     string `json:"change"`
+	HashBefore string `json:"hash_before,omitempty"`
+	HashAfter  string `json:"hash_after,omitempty"`
+	// 摘要变化时给出变化前后的摘要
+	SummaryBefore string `json:"summary_before,omitempty"`
+	SummaryAfter  string `json:"summary_after,omitempty"`
+	// 内容变化了而摘要未变，摘要可能需要重新生成
+	SummaryStale bool `json:"summary_stale"`
+	// 统一格式的内容差异
+	ContentDiff  string `json:"content_diff,omitempty"`
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+}
+
+// 内容差异中每处变化前后保留的行数
+const diffContextLines = 3
+
+// 与 Diff 相同的比较方式，为每篇新增、更新和删除的文档生成版本记录
+func Versions(current, next []*index.Document, now time.Time) []*DocVersion {
+	old := make(map[int]*index.Document, len(current))
+	for _, doc := range current {
+		old[doc.DocId] = doc
+	}
+	versions := []*DocVersion{}
+	seen := make(map[int]bool, len(next))
+	for _, doc := range next {
+		seen[doc.DocId] = true
+		prev, ok := old[doc.DocId]
+		if ok && prev.Hash == doc.Hash {
+			continue
+		}
+		v := &DocVersion{DocId: doc.DocId, Title: doc.Title, ChangedAt: now, HashAfter: doc.Hash, SummaryAfter: doc.Summary}
+		if !ok {
+			v.Change = "added"
+			v.LinesAdded = strings.Count(doc.Content, "\n") + 1
+			versions = append(versions, v)
+			continue
+		}
+		v.Change = "updated"
+		v.HashBefore = prev.Hash
+		if prev.Summary != doc.Summary {
+			v.SummaryBefore = prev.Summary
+		} else {
+			v.SummaryAfter = ""
+		}
+		v.ContentDiff, v.LinesAdded, v.LinesRemoved = UnifiedDiff(prev.Content, doc.Content, diffContextLines)
+		v.SummaryStale = prev.Content != doc.Content && prev.Summary == doc.Summary
+		versions = append(versions, v)
+	}
+	for _, doc := range current {
+		if !seen[doc.DocId] {
+			v := &DocVersion{DocId: doc.DocId, Title: doc.Title, ChangedAt: now, Change: "removed", HashBefore: doc.Hash, SummaryBefore: doc.Summary}
+			v.LinesRemoved = strings.Count(doc.Content, "\n") + 1
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
@@ -0,0 +1,128 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+
+	"rag_app/internal/convert"
+	"rag_app/internal/index"
+)
+
+// 从摘要文件和 markdown 目录加载文档，内容超出 limit 的文档按配置拒绝或拆分。
+// converters 不为空时，以文档 ID 命名的其他格式源文件先由其转换为 markdown
+func Load(markdownDir, summaryFile string, limit SizeLimit, converters *convert.Registry) ([]*index.Document, error) {
+	err := limit.validate()
+	if err != nil {
+		return nil, err
+	}
+	titles, err := loadTitles(markdownDir)
+	if err != nil {
+		return nil, err
+	}
+
+	metas, err := loadMetadata(markdownDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readSummaries(summaryFile)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := sourceFiles(markdownDir)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []*index.Document{}
+	for _, entry := range entries {
+		content, err := readContent(markdownDir, entry.DocId, sources, converters)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := newDocument(entry, content, titles, metas)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+
+		fmt.Printf("doc %d: %s\n", doc.DocId, doc.Title)
+	}
+
+	docs, reports := applySizeLimit(docs, limit)
+	for _, report := range reports {
+		fmt.Println(report)
+	}
+	return docs, nil
+}
+
+// 文档内容所在的 markdown 文件
+func markdownFile(markdownDir string, docId int) string {
+	return fmt.Sprintf("%s/%d.md", markdownDir, docId)
+}
+
+// 由摘要清单中的记录、文档内容、文件清单中的标题和元数据组成文档
+func newDocument(entry *Entry, content string, titles map[int]string, metas map[int]*DocumentMeta) (*index.Document, error) {
+	docId := entry.DocId
+	doc := &index.Document{
+		DocId:   docId,
+		Title:   entry.Title,
+		Content: content,
+		Summary: entry.Summary,
+		Hash:    index.HashDocument(entry.Summary, content),
+	}
+	if title, ok := titles[docId]; ok && doc.Title == "" {
+		doc.Title = title
+	}
+	if meta, ok := metas[docId]; ok {
+		if meta.ExpiresAt != "" {
+			var err error
+			doc.ExpiresAt, err = parseExpiry(meta.ExpiresAt)
+			if err != nil {
+				return nil, fmt.Errorf("doc %d: invalid expires_at: %w", docId, err)
+			}
+		}
+		doc.Collection = meta.Collection
+		doc.URL = meta.URL
+		doc.Blocked = meta.Blocked
+		doc.Tags = meta.Tags
+		doc.Source = meta.Source
+		if meta.Date != "" {
+			var err error
+			doc.Date, err = ParseDate(meta.Date)
+			if err != nil {
+				return nil, fmt.Errorf("doc %d: invalid date: %w", docId, err)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// 读取文件清单中的原始文件名作为文档标题
+func loadTitles(markdownDir string) (map[int]string, error) {
+	files, err := readFiles(markdownDir)
+	if err != nil {
+		return nil, err
+	}
+
+	titles := make(map[int]string)
+	for _, f := range files {
+		titles[f.DocId] = trimFileSuffix(f.Filename)
+	}
+	return titles, nil
+}
+
+func trimFileSuffix(title string) string {
+	for _, suffix := range []string{
+		".pdf",
+		".doc",
+		".docx",
+		".xls",
+		".xlsx",
+		".ppt",
+		".pptx",
+	} {
+		title = strings.TrimSuffix(title, suffix)
+	}
+	return title
+}
@@ -0,0 +1,153 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 逐行比较的编辑操作
+type lineOp struct {
+	kind byte // ' '、'-' 或 '+'
+	text string
+	// 在原文和新文中的行号，从 0 开始
+	a, b int
+}
+
+// 差分允许的最大编辑数，超出时视为整体替换，避免大文档完全改写时耗费过多内存
+const maxDiffEdits = 2000
+
+// Myers 差分算法，返回把 a 变为 b 的逐行编辑序列
+func diffLines(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[d] 保存第 d 步开始时 k 在 [-d-1, d+1] 范围内的 v
+	trace := [][]int{}
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, d)
+			}
+		}
+	}
+	return replaceAll(a, b)
+}
+
+func backtrack(trace [][]int, a, b []string, d int) []lineOp {
+	ops := []lineOp{}
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, lineOp{' ', a[x], x, y})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, lineOp{'+', b[y], x, y})
+		} else {
+			x--
+			ops = append(ops, lineOp{'-', a[x], x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, lineOp{' ', a[x], x, y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// 删除全部原文再加入全部新文
+func replaceAll(a, b []string) []lineOp {
+	ops := make([]lineOp, 0, len(a)+len(b))
+	for i, line := range a {
+		ops = append(ops, lineOp{'-', line, i, 0})
+	}
+	for j, line := range b {
+		ops = append(ops, lineOp{'+', line, len(a), j})
+	}
+	return ops
+}
+
+// 按行比较两段文本，返回统一格式（unified diff）的差异和增删的行数，每处变化前后保留 context 行
+func UnifiedDiff(before, after string, context int) (string, int, int) {
+	if before == after {
+		return "", 0, 0
+	}
+	ops := diffLines(strings.Split(before, "\n"), strings.Split(after, "\n"))
+	added, removed := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// 一个区块从变化前 context 行开始，到最后一处相距不超过 2*context 行的变化之后 context 行结束
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+		end = min(end+context+1, len(ops))
+
+		aStart, bStart, aLen, bLen := ops[start].a, ops[start].b, 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart+1, aLen, bStart+1, bLen)
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.String(), added, removed
+}
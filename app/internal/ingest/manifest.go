@@ -0,0 +1,270 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rag_app/internal/logging"
+)
+
+// 单行最大长度，摘要较长时默认的 64KB 不够用
+const maxLineSize = 4 * 1024 * 1024
+
+// 摘要清单中的一条记录，JSONL 格式下每行一个 JSON 对象
+type Entry struct {
+	DocId   int    `json:"doc_id"`
+	Title   string `json:"title,omitempty"`
+	Summary string `json:"summary"`
+}
+
+// 文件清单中的一条记录
+type FileEntry struct {
+	DocId    int    `json:"doc_id"`
+	Filename string `json:"filename"`
+}
+
+// 带行号的解析错误
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// 是否为 JSONL 格式的清单文件
+func isJSONL(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".jsonl")
+}
+
+// 读取摘要清单，按扩展名区分 JSONL 格式和旧的 `id:摘要` 格式
+func readSummaries(path string) ([]*Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if isJSONL(path) {
+		return readJSONL(file, path, func(e *Entry) int { return e.DocId })
+	}
+
+	entries := []*Entry{}
+	err = scanLegacy(file, path, func(line, docId int, value string) error {
+		entries = append(entries, &Entry{DocId: docId, Summary: value})
+		return nil
+	})
+	return entries, err
+}
+
+// 读取文件清单，优先使用 files.jsonl，不存在时回退到 files.txt，均不存在时返回空表
+func readFiles(markdownDir string) ([]*FileEntry, error) {
+	path := filepath.Join(markdownDir, "files.jsonl")
+	file, err := os.Open(path)
+	if err == nil {
+		defer file.Close()
+		return readJSONL(file, path, func(e *FileEntry) int { return e.DocId })
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	path = filepath.Join(markdownDir, "files.txt")
+	file, err = os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []*FileEntry{}
+	err = scanLegacy(file, path, func(line, docId int, value string) error {
+		entries = append(entries, &FileEntry{DocId: docId, Filename: value})
+		return nil
+	})
+	return entries, err
+}
+
+// 逐行解析 JSONL 清单，每条记录须有正的 doc_id，且同一文件内不能重复
+func readJSONL[T any](r io.Reader, name string, docId func(*T) int) ([]*T, error) {
+	entries := []*T{}
+	// doc_id -> 首次出现的行号
+	seen := map[int]int{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		entry := new(T)
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(entry); err != nil {
+			return nil, &ParseError{File: name, Line: line, Err: err}
+		}
+		err := checkDocId(seen, docId(entry), line)
+		if err != nil {
+			return nil, &ParseError{File: name, Line: line, Err: err}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ParseError{File: name, Line: line + 1, Err: err}
+	}
+	return entries, nil
+}
+
+// 检查 doc_id 为正数且未在 seen 中出现过，seen 记录每个 doc_id 首次出现的行号
+func checkDocId(seen map[int]int, id, line int) error {
+	if id <= 0 {
+		return errors.New("missing or invalid doc_id")
+	}
+	if first, ok := seen[id]; ok {
+		return fmt.Errorf("duplicate doc_id %d, first on line %d", id, first)
+	}
+	seen[id] = line
+	return nil
+}
+
+// 解析旧的 `id:内容` 格式，空行会被忽略，缺少分隔符的行给出警告后跳过。
+// fn 返回的错误附上行号后返回
+func scanLegacy(r io.Reader, name string, fn func(line, docId int, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		strs := strings.SplitN(text, ":", 2)
+		if len(strs) != 2 {
+			logging.Warnf("%s:%d: missing ':' separator, line skipped\n", name, line)
+			continue
+		}
+		docId, err := strconv.Atoi(strings.TrimSpace(strs[0]))
+		if err != nil {
+			return &ParseError{File: name, Line: line, Err: fmt.Errorf("invalid doc id %q", strs[0])}
+		}
+		if err := fn(line, docId, strs[1]); err != nil {
+			return &ParseError{File: name, Line: line, Err: err}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &ParseError{File: name, Line: line + 1, Err: err}
+	}
+	return nil
+}
+
+// 将旧格式的 summary.txt 和 files.txt 转换为 JSONL 清单，标题合并到每条记录中。
+// 按与 JSONL 清单相同的规则检查 doc_id，避免写出无法加载的清单
+func Migrate(markdownDir, summaryFile string, w io.Writer) error {
+	entries, err := readMigrationSummaries(summaryFile)
+	if err != nil {
+		return err
+	}
+	titles, err := loadTitles(markdownDir)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, entry := range entries {
+		if entry.Title == "" {
+			entry.Title = titles[entry.DocId]
+		}
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取待迁移的摘要清单，旧格式中非正数或重复的 doc_id 报告所在行
+func readMigrationSummaries(path string) ([]*Entry, error) {
+	if isJSONL(path) {
+		return readSummaries(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []*Entry{}
+	seen := map[int]int{}
+	err = scanLegacy(file, path, func(line, docId int, value string) error {
+		if err := checkDocId(seen, docId, line); err != nil {
+			return err
+		}
+		entries = append(entries, &Entry{DocId: docId, Summary: value})
+		return nil
+	})
+	return entries, err
+}
+
+// 替换摘要清单中一篇文档的摘要，其余行保持原样，整体原子替换文件。
+// JSONL 格式保留记录中的标题；旧格式每行一条记录，摘要不能包含换行
+func UpdateSummary(summaryFile string, docId int, summary string) error {
+	jsonl := isJSONL(summaryFile)
+	if !jsonl && strings.ContainsAny(summary, "\r\n") {
+		return errors.New("summary must be a single line in the legacy summary format")
+	}
+	buf, err := os.ReadFile(summaryFile)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(buf), "\n")
+	found := false
+	for i, line := range lines {
+		id, ok := entryDocId(line, jsonl)
+		if !ok || id != docId {
+			continue
+		}
+		if !jsonl {
+			lines[i] = fmt.Sprintf("%d:%s\n", docId, summary)
+		} else {
+			var entry Entry
+			err = json.Unmarshal([]byte(line), &entry)
+			if err != nil {
+				return &ParseError{File: summaryFile, Line: i + 1, Err: err}
+			}
+			entry.Summary = summary
+			var b strings.Builder
+			enc := json.NewEncoder(&b)
+			enc.SetEscapeHTML(false)
+			err = enc.Encode(&entry)
+			if err != nil {
+				return err
+			}
+			lines[i] = b.String()
+		}
+		found = true
+		break
+	}
+	if !found {
+		return fmt.Errorf("%s: doc_id %d not found", summaryFile, docId)
+	}
+	return writeFileAtomic(summaryFile, []byte(strings.Join(lines, "")))
+}
@@ -0,0 +1,148 @@
+package ingest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadJSONL(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		line    int
+		message string
+	}{
+		{"valid", "{\"doc_id\": 1, \"summary\": \"a\"}\n\n{\"doc_id\": 2, \"summary\": \"b\"}\n", 2, 0, ""},
+		{"invalid json", "{\"doc_id\": 1, \"summary\": \"a\"}\n{\"doc_id\": 2,\n", 0, 2, "unexpected EOF"},
+		{"unknown field", "{\"doc_id\": 1, \"summry\": \"a\"}\n", 0, 1, "unknown field"},
+		{"missing doc_id", "{\"summary\": \"a\"}\n", 0, 1, "missing or invalid doc_id"},
+		{"duplicate doc_id", "{\"doc_id\": 3, \"summary\": \"a\"}\n\n{\"doc_id\": 4, \"summary\": \"b\"}\n{\"doc_id\": 3, \"summary\": \"c\"}\n", 0, 4, "duplicate doc_id 3, first on line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := readJSONL(strings.NewReader(tt.input), "summary.jsonl", func(e *Entry) int { return e.DocId })
+			if tt.line == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != tt.want {
+					t.Errorf("got %d entries, want %d", len(entries), tt.want)
+				}
+				return
+			}
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("readJSONL() error = %v, want a ParseError", err)
+			}
+			if parseErr.Line != tt.line || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("readJSONL() error = %v, want line %d with %q", err, tt.line, tt.message)
+			}
+		})
+	}
+}
+
+func TestUpdateSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		input   string
+		docId   int
+		summary string
+		want    string
+		wantErr string
+	}{
+		{
+			"jsonl keeps title and other lines", "summary.jsonl",
+			"{\"doc_id\": 1, \"summary\": \"a\"}\n{\"doc_id\": 2, \"title\": \"T\", \"summary\": \"b\"}\n",
+			2, "new <b>", "{\"doc_id\": 1, \"summary\": \"a\"}\n{\"doc_id\":2,\"title\":\"T\",\"summary\":\"new <b>\"}\n", "",
+		},
+		{
+			"jsonl multi-line summary", "summary.jsonl",
+			"{\"doc_id\": 1, \"summary\": \"a\"}", 1, "x\ny", "{\"doc_id\":1,\"summary\":\"x\\ny\"}\n", "",
+		},
+		{"legacy", "summary.txt", "1:a\n2:b\n", 2, "new", "1:a\n2:new\n", ""},
+		{"legacy multi-line summary", "summary.txt", "2:b\n", 2, "x\ny", "", "single line"},
+		{"not found", "summary.txt", "1:a\n", 2, "new", "", "doc_id 2 not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := os.WriteFile(path, []byte(tt.input), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = UpdateSummary(path, tt.docId, tt.summary)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("UpdateSummary() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != tt.want {
+				t.Errorf("summary file = %q, want %q", buf, tt.want)
+			}
+			entries, err := readSummaries(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entries[len(entries)-1].Summary != tt.summary {
+				t.Errorf("reloaded summary = %q, want %q", entries[len(entries)-1].Summary, tt.summary)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		line    int
+		message string
+	}{
+		{"valid", "1:a\n\n2:b\n", 0, ""},
+		{"zero doc_id", "1:a\n0:b\n", 2, "missing or invalid doc_id"},
+		{"negative doc_id", "-3:a\n", 1, "missing or invalid doc_id"},
+		{"duplicate doc_id", "3:a\n4:b\n\n3:c\n", 4, "duplicate doc_id 3, first on line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			summaryFile := filepath.Join(dir, "summary.txt")
+			err := os.WriteFile(summaryFile, []byte(tt.input), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			err = Migrate(dir, summaryFile, &out)
+			if tt.line == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 迁移结果可以作为 JSONL 清单加载
+				_, err = readJSONL(strings.NewReader(out.String()), "summary.jsonl", func(e *Entry) int { return e.DocId })
+				if err != nil {
+					t.Errorf("migrated manifest does not load: %v", err)
+				}
+				return
+			}
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Migrate() error = %v, want a ParseError", err)
+			}
+			if parseErr.Line != tt.line || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("Migrate() error = %v, want line %d with %q", err, tt.line, tt.message)
+			}
+		})
+	}
+}
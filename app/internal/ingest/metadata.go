@@ -0,0 +1,70 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 文档元数据，保存在 MARKDOWN_DIR/metadata.json 中，以文档ID为键
+type DocumentMeta struct {
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Blocked    bool   `json:"blocked,omitempty"`
+	// 标签和来源（如导入的连接器），用于按条件批量删除
+	Tags   []string `json:"tags,omitempty"`
+	Source string   `json:"source,omitempty"`
+	// 文档日期，RFC3339 或 YYYY-MM-DD
+	Date string `json:"date,omitempty"`
+}
+
+// 读取文档元数据文件，文件不存在时返回空表
+func loadMetadata(markdownDir string) (map[int]*DocumentMeta, error) {
+	metas := make(map[int]*DocumentMeta)
+
+	buf, err := os.ReadFile(fmt.Sprintf("%s/metadata.json", markdownDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return metas, nil
+		}
+		return nil, err
+	}
+
+	var raw map[string]*DocumentMeta
+	err = json.Unmarshal(buf, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("metadata.json: %w", err)
+	}
+	for k, v := range raw {
+		docId, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("metadata.json: invalid doc id %q", k)
+		}
+		metas[docId] = v
+	}
+
+	return metas, nil
+}
+
+// 解析文档日期，支持 RFC3339 和 YYYY-MM-DD 两种格式
+func ParseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
+
+// 解析过期时间，支持 RFC3339 和 YYYY-MM-DD 两种格式，后者在当天结束时过期
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rag_app/internal/convert"
+)
+
+// 源文件转换失败
+var errConvert = errors.New("convert")
+
+// markdown 目录中非 markdown 格式的源文件，以文档 ID 命名，如 12.docx。返回文档 ID -> 文件路径
+func sourceFiles(markdownDir string) (map[int]string, error) {
+	entries, err := os.ReadDir(markdownDir)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[int]string)
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		if e.IsDir() || ext == "" || ext == ".md" || strings.Contains(name, ".tmp") {
+			continue
+		}
+		docId, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+		if err != nil || docId <= 0 {
+			continue
+		}
+		if other, ok := sources[docId]; ok {
+			return nil, fmt.Errorf("doc %d: more than one source file: %s, %s", docId, filepath.Base(other), name)
+		}
+		sources[docId] = filepath.Join(markdownDir, name)
+	}
+	return sources, nil
+}
+
+// 读取文档内容。有源文件且有对应的转换器时，在 markdown 文件不存在或早于源文件时重新转换，
+// 转换结果写回 markdown 文件，之后加载时直接读取；没有转换器时仍读取 markdown 文件
+func readContent(markdownDir string, docId int, sources map[int]string, converters *convert.Registry) (string, error) {
+	path := markdownFile(markdownDir, docId)
+	source, ok := sources[docId]
+	if !ok || converters == nil {
+		buf, err := os.ReadFile(path)
+		return string(buf), err
+	}
+
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(path); err == nil && !info.ModTime().Before(sourceInfo.ModTime()) {
+		buf, err := os.ReadFile(path)
+		return string(buf), err
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return "", err
+	}
+	if !converters.Supports(source, data) {
+		buf, err := os.ReadFile(path)
+		return string(buf), err
+	}
+	content, err := converters.Convert(context.Background(), filepath.Base(source), data)
+	if err != nil {
+		return "", fmt.Errorf("doc %d: %w %s: %w", docId, errConvert, source, err)
+	}
+	err = writeFileAtomic(path, []byte(content))
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("doc %d: converted %s\n", docId, filepath.Base(source))
+	return content, nil
+}
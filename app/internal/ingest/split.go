@@ -0,0 +1,136 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"rag_app/internal/index"
+)
+
+// 超出 DOC_MAX_BYTES 的文档的处理方式
+const (
+	// 不加入索引
+	OversizeReject = "reject"
+	// 在标题或段落边界拆分为多篇文档
+	OversizeSplit = "split"
+)
+
+// 文档内容的大小上限，MaxBytes 为 0 时不限制
+type SizeLimit struct {
+	MaxBytes int
+	Oversize string
+}
+
+func (l SizeLimit) validate() error {
+	if l.MaxBytes < 0 {
+		return fmt.Errorf("DOC_MAX_BYTES must not be negative")
+	}
+	if l.Oversize != OversizeReject && l.Oversize != OversizeSplit {
+		return fmt.Errorf("invalid DOC_OVERSIZE %q, expected %s or %s", l.Oversize, OversizeReject, OversizeSplit)
+	}
+	return nil
+}
+
+// 超出上限的文档及其处理结果
+type oversized struct {
+	DocId int
+	Bytes int
+	// 拆分后各部分的文档 ID，被拒绝时为空
+	Parts []int
+}
+
+func (o oversized) String() string {
+	if len(o.Parts) == 0 {
+		return fmt.Sprintf("doc %d: %d bytes exceeds DOC_MAX_BYTES, rejected", o.DocId, o.Bytes)
+	}
+	return fmt.Sprintf("doc %d: %d bytes exceeds DOC_MAX_BYTES, split into %d parts %v", o.DocId, o.Bytes, len(o.Parts), o.Parts)
+}
+
+// 处理内容超出上限的文档。拆分时第一部分沿用原文档 ID，其余部分的 ID 从全部文档的最大 ID 之后
+// 按文档顺序分配，语料不变时每次加载得到相同的 ID
+func applySizeLimit(docs []*index.Document, limit SizeLimit) ([]*index.Document, []oversized) {
+	if limit.MaxBytes == 0 {
+		return docs, nil
+	}
+	nextId := 0
+	for _, doc := range docs {
+		nextId = max(nextId, doc.DocId+1)
+	}
+
+	result := make([]*index.Document, 0, len(docs))
+	reports := []oversized{}
+	for _, doc := range docs {
+		if len(doc.Content) <= limit.MaxBytes {
+			result = append(result, doc)
+			continue
+		}
+		report := oversized{DocId: doc.DocId, Bytes: len(doc.Content)}
+		if limit.Oversize == OversizeReject {
+			reports = append(reports, report)
+			continue
+		}
+		contents := splitContent(doc.Content, limit.MaxBytes)
+		for i, content := range contents {
+			part := *doc
+			part.SplitFrom = doc.DocId
+			if i > 0 {
+				part.DocId = nextId
+				nextId++
+			}
+			part.Content = content
+			part.Title = fmt.Sprintf("%s（%d/%d）", doc.Title, i+1, len(contents))
+			part.Summary = fmt.Sprintf("%s\n\n第%d/%d部分", doc.Summary, i+1, len(contents))
+			if heading := firstHeading(content); heading != "" {
+				part.Summary += "：" + heading
+			}
+			part.Hash = index.HashDocument(part.Summary, part.Content)
+			result = append(result, &part)
+			report.Parts = append(report.Parts, part.DocId)
+		}
+		reports = append(reports, report)
+	}
+	return result, reports
+}
+
+// 将内容拆分为不超过 maxBytes 字节的部分，依次优先在标题、空行、换行处断开，都没有时在字符边界断开
+func splitContent(content string, maxBytes int) []string {
+	parts := []string{}
+	for len(content) > maxBytes {
+		window := content[:maxBytes]
+		cut := 0
+		if i := strings.LastIndex(window, "\n#"); i >= maxBytes/2 {
+			cut = i + 1
+		} else if i := strings.LastIndex(window, "\n\n"); i >= maxBytes/4 {
+			cut = i + 2
+		} else if i := strings.LastIndex(window, "\n"); i > 0 {
+			cut = i + 1
+		} else {
+			cut = maxBytes
+			for cut > 0 && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(content)
+			}
+		}
+		parts = append(parts, content[:cut])
+		content = content[cut:]
+	}
+	if content != "" {
+		parts = append(parts, content)
+	}
+	return parts
+}
+
+// 内容中第一个 markdown 标题的文字
+func firstHeading(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "#") {
+			if heading := strings.TrimSpace(strings.TrimLeft(line, "#")); heading != "" {
+				return heading
+			}
+		}
+	}
+	return ""
+}
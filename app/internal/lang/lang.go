@@ -0,0 +1,107 @@
+package lang
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 检测出的文本类别
+const (
+	Chinese = "zh"
+	English = "en"
+	Code    = "code"
+)
+
+// 代码中常见、自然语言中少见的符号
+const codeSymbols = "{}()[];=<>_$#|&*/\\"
+
+// 粗略判断文本的语言：包含代码块或代码符号占比较高时视为代码，
+// 汉字占字母类字符 20% 以上时视为中文，否则视为英文
+func Detect(s string) string {
+	if strings.Contains(s, "```") {
+		return Code
+	}
+
+	han, letters, symbols, visible := 0, 0, 0, 0
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		visible++
+		switch {
+		case unicode.Is(unicode.Han, r):
+			han++
+			letters++
+		case unicode.IsLetter(r):
+			letters++
+		case strings.ContainsRune(codeSymbols, r):
+			symbols++
+		}
+	}
+
+	if visible > 0 && symbols*10 >= visible && han*5 < letters {
+		return Code
+	}
+	if letters > 0 && han*5 >= letters {
+		return Chinese
+	}
+	return English
+}
+
+// 将文本切分为检索用的词项：连续的字母数字按词切分并转为小写，
+// 汉字等无空格分隔的字符按单字和相邻二字切分
+func Terms(s string) []string {
+	return terms(s, bigrams)
+}
+
+// 按单字和相邻二字切分一段连续的汉字
+func bigrams(run []rune) []string {
+	terms := make([]string, 0, len(run)*2)
+	for i, r := range run {
+		terms = append(terms, string(r))
+		if i > 0 {
+			terms = append(terms, string(run[i-1:i+1]))
+		}
+	}
+	return terms
+}
+
+// 连续的字母数字按词切分并转为小写，连续的汉字等无空格分隔的字符交给 cut 切分
+func terms(s string, cut func(run []rune) []string) []string {
+	terms := []string{}
+	word := []rune{}
+	run := []rune{}
+	flush := func() {
+		if len(word) > 0 {
+			terms = append(terms, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+		if len(run) > 0 {
+			terms = append(terms, cut(run)...)
+			run = run[:0]
+		}
+	}
+	for _, r := range s {
+		switch {
+		case isIdeograph(r):
+			if len(word) > 0 {
+				flush()
+			}
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(run) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+func isIdeograph(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
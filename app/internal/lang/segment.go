@@ -0,0 +1,139 @@
+package lang
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 基于词典的中文分词，与 jieba 的精确模式相同：按词典构建所有可能成词的有向无环图，
+// 再以动态规划求词频乘积最大的切分路径。未登录的字单独成词
+type Segmenter struct {
+	// 词 -> 词频，词的前缀也在其中，词频为 0
+	freq     map[string]int
+	logTotal float64
+	maxLen   int
+}
+
+// 从 jieba 格式的词典加载分词器，每行为「词 词频 [词性]」，词频缺省为 1
+func LoadSegmenter(path string) (*Segmenter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		freq := 1
+		if len(fields) > 1 {
+			freq, err = strconv.Atoi(fields[1])
+			if err != nil || freq < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid frequency %q", path, line, fields[1])
+			}
+		}
+		words[fields[0]] = freq
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewSegmenter(words), nil
+}
+
+// 以词及其词频创建分词器
+func NewSegmenter(words map[string]int) *Segmenter {
+	s := &Segmenter{freq: make(map[string]int, len(words)*2)}
+	total := 0
+	for word, freq := range words {
+		runes := []rune(strings.ToLower(word))
+		if len(runes) == 0 {
+			continue
+		}
+		s.freq[string(runes)] = freq
+		total += freq
+		s.maxLen = max(s.maxLen, len(runes))
+		for i := 1; i < len(runes); i++ {
+			if _, ok := s.freq[string(runes[:i])]; !ok {
+				s.freq[string(runes[:i])] = 0
+			}
+		}
+	}
+	s.logTotal = math.Log(float64(max(total, 1)))
+	return s
+}
+
+// 切分一段连续的汉字
+func (s *Segmenter) Cut(text []rune) []string {
+	n := len(text)
+	// route[i] 为从位置 i 到末尾的最大对数概率及第一个词的结束位置
+	type step struct {
+		logProb float64
+		end     int
+	}
+	route := make([]step, n+1)
+	for i := n - 1; i >= 0; i-- {
+		route[i] = step{math.Inf(-1), i + 1}
+		for j := i + 1; j <= min(n, i+max(s.maxLen, 1)); j++ {
+			freq, ok := s.freq[string(text[i:j])]
+			if !ok {
+				break
+			}
+			if freq == 0 && j > i+1 {
+				continue
+			}
+			p := math.Log(float64(max(freq, 1))) - s.logTotal + route[j].logProb
+			if p > route[i].logProb {
+				route[i] = step{p, j}
+			}
+		}
+		// 未登录的字单独成词
+		if math.IsInf(route[i].logProb, -1) {
+			route[i] = step{-s.logTotal + route[i+1].logProb, i + 1}
+		}
+	}
+
+	words := []string{}
+	for i := 0; i < n; i = route[i].end {
+		words = append(words, string(text[i:route[i].end]))
+	}
+	return words
+}
+
+// 与 jieba 的搜索引擎模式相同：在精确切分的结果之外，长词中包含的词典内二字词和三字词也作为词项，
+// 使查询中的短词能匹配文档中的长词
+func (s *Segmenter) cutForSearch(text []rune) []string {
+	terms := []string{}
+	for _, word := range s.Cut(text) {
+		runes := []rune(word)
+		for _, size := range []int{2, 3} {
+			if len(runes) <= size {
+				continue
+			}
+			for i := 0; i+size <= len(runes); i++ {
+				if s.freq[string(runes[i:i+size])] > 0 {
+					terms = append(terms, string(runes[i:i+size]))
+				}
+			}
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// 将文本切分为检索用的词项，汉字按词典分词；s 为 nil 时与 Terms 相同，按单字和相邻二字切分
+func (s *Segmenter) Terms(text string) []string {
+	if s == nil {
+		return Terms(text)
+	}
+	return terms(text, s.cutForSearch)
+}
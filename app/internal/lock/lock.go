@@ -0,0 +1,45 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 多个副本之间互斥的分布式锁，用于保证同一时间只有一个副本重建索引
+type Locker interface {
+	// 尝试获取锁，已被其他持有者占用时返回 ErrHeld
+	TryLock(ctx context.Context, name string) (Lease, error)
+	// 锁服务地址，用于日志，不含密码
+	String() string
+}
+
+// 已获取的锁，持有期间在后台续期
+type Lease interface {
+	// 释放锁，锁已过期被他人获取时不影响对方
+	Release(ctx context.Context) error
+	// 持有期间失去锁（续期失败直至过期，或锁所在的连接断开）时关闭，
+	// 持有者应停止受锁保护的操作，因为其他副本可能已经获取了锁
+	Lost() <-chan struct{}
+}
+
+var ErrHeld = errors.New("lock is held by another replica")
+
+// 锁服务的类型
+const (
+	BackendRedis    = "redis"
+	BackendPostgres = "postgres"
+)
+
+// 按类型创建分布式锁。redis 通过 RESP 协议直接访问，不需要额外的客户端；
+// postgres 使用会话级 advisory lock，database/sql 驱动需要在构建时注册
+func Open(backend, url, driver string, ttl time.Duration) (Locker, error) {
+	switch backend {
+	case BackendRedis:
+		return newRedisLocker(url, ttl)
+	case BackendPostgres:
+		return openPostgresLocker(driver, url)
+	}
+	return nil, fmt.Errorf("unknown lock backend %q, expected %s or %s", backend, BackendRedis, BackendPostgres)
+}
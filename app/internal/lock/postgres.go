@@ -0,0 +1,111 @@
+package lock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"time"
+
+	"rag_app/internal/logging"
+)
+
+// 基于 PostgreSQL 会话级 advisory lock 的锁。锁绑定在一个专用连接上，
+// 持有者崩溃或连接断开时由数据库自动释放，不需要续期
+type postgresLocker struct {
+	db     *sql.DB
+	driver string
+}
+
+func openPostgresLocker(driver, dsn string) (*postgresLocker, error) {
+	if driver == "" {
+		driver = "postgres"
+	}
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("sql driver %q is not registered, available: %v", driver, sql.Drivers())
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresLocker{db: db, driver: driver}, nil
+}
+
+func (l *postgresLocker) String() string {
+	return "postgres (" + l.driver + ")"
+}
+
+// 锁名哈希为 advisory lock 使用的 64 位整数
+func advisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+func (l *postgresLocker) TryLock(ctx context.Context, name string) (Lease, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := advisoryKey(name)
+	var ok bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, ErrHeld
+	}
+	lease := &postgresLease{name: name, conn: conn, key: key, done: make(chan struct{}), stopped: make(chan struct{}), lost: make(chan struct{})}
+	go lease.watch()
+	return lease, nil
+}
+
+// 检查锁所在连接的间隔
+const postgresCheckInterval = 10 * time.Second
+
+type postgresLease struct {
+	name    string
+	conn    *sql.Conn
+	key     int64
+	done    chan struct{}
+	stopped chan struct{}
+	lost    chan struct{}
+}
+
+// 定期检查连接，连接断开时数据库已释放锁，关闭 lost 并停止检查
+func (l *postgresLease) watch() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(postgresCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), postgresCheckInterval)
+			err := l.conn.PingContext(ctx)
+			cancel()
+			if err != nil {
+				logging.Errorf("lock %s: lost, connection failed: %v\n", l.name, err)
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+func (l *postgresLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *postgresLease) Release(ctx context.Context) error {
+	close(l.done)
+	<-l.stopped
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	return err
+}
@@ -0,0 +1,268 @@
+package lock
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"rag_app/internal/logging"
+)
+
+// 只在仍由自己持有时续期或删除锁，避免误操作已过期后被其他副本获取的锁
+const (
+	redisRefreshScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	redisReleaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+// 基于 Redis SET NX PX 的锁。持有者以随机令牌标识，每隔 ttl/3 续期，
+// 持有者崩溃后锁在 ttl 之后自动过期
+type redisLocker struct {
+	addr     string
+	password string
+	db       int
+	tls      bool
+	ttl      time.Duration
+}
+
+// 解析 redis://[:password@]host:port[/db]，rediss:// 使用 TLS
+func newRedisLocker(rawUrl string, ttl time.Duration) (*redisLocker, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("%s: redis url must be redis:// or rediss://", u.Redacted())
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lock ttl must be positive")
+	}
+	l := &redisLocker{addr: u.Host, tls: u.Scheme == "rediss", ttl: ttl}
+	if u.Port() == "" {
+		l.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		l.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		l.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid redis db %q", u.Redacted(), db)
+		}
+	}
+	return l, nil
+}
+
+func (l *redisLocker) String() string {
+	return "redis://" + l.addr
+}
+
+func (l *redisLocker) TryLock(ctx context.Context, name string) (Lease, error) {
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token := randomToken()
+	reply, err := conn.do(ctx, "SET", name, token, "NX", "PX", strconv.FormatInt(l.ttl.Milliseconds(), 10))
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	if reply == nil {
+		conn.close()
+		return nil, ErrHeld
+	}
+
+	lease := &redisLease{locker: l, conn: conn, name: name, token: token, done: make(chan struct{}), stopped: make(chan struct{}), lost: make(chan struct{})}
+	go lease.refresh()
+	return lease, nil
+}
+
+func (l *redisLocker) dial(ctx context.Context) (*redisConn, error) {
+	var c net.Conn
+	var err error
+	if l.tls {
+		c, err = (&tls.Dialer{}).DialContext(ctx, "tcp", l.addr)
+	} else {
+		c, err = (&net.Dialer{}).DialContext(ctx, "tcp", l.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: c, r: bufio.NewReader(c)}
+	if l.password != "" {
+		if _, err := conn.do(ctx, "AUTH", l.password); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	if l.db != 0 {
+		if _, err := conn.do(ctx, "SELECT", strconv.Itoa(l.db)); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+type redisLease struct {
+	locker *redisLocker
+	// 续期使用的连接，命令失败后丢弃，下次续期时重新建立
+	conn    *redisConn
+	name    string
+	token   string
+	done    chan struct{}
+	stopped chan struct{}
+	lost    chan struct{}
+}
+
+// 定期续期。命令失败时连接的读写状态不可知，丢弃连接并在下次续期时重连；
+// 锁已被他人持有，或距上次成功续期超过 ttl 锁已过期时，关闭 lost 并停止续期
+func (l *redisLease) refresh() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.locker.ttl / 3)
+	defer ticker.Stop()
+	refreshed := time.Now()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.locker.ttl/3)
+			n, err := l.extend(ctx)
+			cancel()
+			switch {
+			case err != nil && time.Since(refreshed) >= l.locker.ttl:
+				logging.Errorf("lock %s: lost, not refreshed within %s: %v\n", l.name, l.locker.ttl, err)
+			case err != nil:
+				logging.Warnf("lock %s: refresh failed: %v\n", l.name, err)
+				continue
+			case n == 0:
+				logging.Errorf("lock %s: lost, held by another owner\n", l.name)
+			default:
+				refreshed = time.Now()
+				continue
+			}
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// 续期一次，返回 1 表示仍由自己持有。没有可用连接时先重连，命令失败时丢弃连接
+func (l *redisLease) extend(ctx context.Context) (int64, error) {
+	if l.conn == nil {
+		conn, err := l.locker.dial(ctx)
+		if err != nil {
+			return 0, err
+		}
+		l.conn = conn
+	}
+	reply, err := l.conn.do(ctx, "EVAL", redisRefreshScript, "1", l.name, l.token, strconv.FormatInt(l.locker.ttl.Milliseconds(), 10))
+	if err != nil {
+		l.conn.close()
+		l.conn = nil
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return n, nil
+}
+
+func (l *redisLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *redisLease) Release(ctx context.Context) error {
+	close(l.done)
+	<-l.stopped
+	if l.conn == nil {
+		conn, err := l.locker.dial(ctx)
+		if err != nil {
+			return err
+		}
+		l.conn = conn
+	}
+	defer l.conn.close()
+	_, err := l.conn.do(ctx, "EVAL", redisReleaseScript, "1", l.name, l.token)
+	return err
+}
+
+// 单个 Redis 连接，命令串行执行
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// 发送一条命令并读取回复：简单字符串和批量字符串返回 string，整数返回 int64，空回复返回 nil
+func (c *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Time{})
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.conn.Write([]byte(b.String()))
+	if err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '_':
+		return nil, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (c *redisConn) close() {
+	c.conn.Close()
+}
+
+func randomToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
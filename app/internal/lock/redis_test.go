@@ -0,0 +1,127 @@
+package lock
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 只实现 SET 和 EVAL 的 Redis 服务：第一个连接在 SET 之后断开，EVAL 返回 eval 的值
+type fakeRedis struct {
+	listener net.Listener
+	conns    atomic.Int32
+	eval     atomic.Int64
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener}
+	r.eval.Store(1)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn, r.conns.Add(1) == 1)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn, dropAfterSet bool) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			fmt.Fprint(conn, "+OK\r\n")
+			if dropAfterSet {
+				return
+			}
+		case "EVAL":
+			fmt.Fprintf(conn, ":%d\r\n", r.eval.Load())
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command %s\r\n", args[0])
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	_, err := fmt.Fscanf(r, "*%d\r\n", &n)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		_, err = fmt.Fscanf(r, "$%d\r\n", &size)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestRedisLeaseRedialsAfterError(t *testing.T) {
+	server := newFakeRedis(t)
+	locker, err := newRedisLocker("redis://"+server.listener.Addr().String(), 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := locker.TryLock(context.Background(), "reindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一次续期因连接断开失败，之后重连续期成功，锁不应丢失
+	deadline := time.After(time.Second)
+	for server.conns.Load() < 2 {
+		select {
+		case <-lease.Lost():
+			t.Fatal("lease lost after a single failed refresh")
+		case <-deadline:
+			t.Fatal("lease did not redial after the connection dropped")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	select {
+	case <-lease.Lost():
+		t.Fatal("lease lost after redialing")
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	// 锁被他人持有时关闭 Lost
+	server.eval.Store(0)
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Lost() not closed after the lock was taken over")
+	}
+	err = lease.Release(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sync/atomic"
+)
+
+// 日志级别，从低到高
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+var levels = []string{LevelDebug, LevelInfo, LevelWarn, LevelError}
+
+// 当前级别在 levels 中的下标，低于该级别的日志不输出
+var level atomic.Int32
+
+// 调试日志的抽样比例，以 float64 的位表示保存
+var sampleRate atomic.Uint64
+
+func init() {
+	level.Store(1)
+	sampleRate.Store(math.Float64bits(1))
+}
+
+// 运行时的日志设置
+type Settings struct {
+	Level string `json:"level"`
+	// 调试日志的抽样比例，0~1
+	DebugSampleRate float64 `json:"debug_sample_rate"`
+}
+
+// 当前的日志设置
+func Current() Settings {
+	return Settings{
+		Level:           levels[level.Load()],
+		DebugSampleRate: math.Float64frombits(sampleRate.Load()),
+	}
+}
+
+// 设置日志级别
+func SetLevel(name string) error {
+	i := slices.Index(levels, name)
+	if i < 0 {
+		return fmt.Errorf("invalid log level %q, expected one of %v", name, levels)
+	}
+	level.Store(int32(i))
+	return nil
+}
+
+// 设置调试日志的抽样比例，如逐条候选文档的分数等量大的日志只按该比例输出
+func SetDebugSampleRate(rate float64) error {
+	if rate < 0 || rate > 1 || math.IsNaN(rate) {
+		return fmt.Errorf("invalid debug sample rate %g, expected 0~1", rate)
+	}
+	sampleRate.Store(math.Float64bits(rate))
+	return nil
+}
+
+func enabled(i int32) bool {
+	return level.Load() <= i
+}
+
+// 是否输出调试日志，计算日志参数本身开销较大时先调用以跳过
+func DebugEnabled() bool {
+	return enabled(0)
+}
+
+// 调试日志，按抽样比例输出
+func Debugf(format string, args ...any) {
+	if !enabled(0) {
+		return
+	}
+	if rate := math.Float64frombits(sampleRate.Load()); rate < 1 && rand.Float64() >= rate {
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+func Infof(format string, args ...any) {
+	if enabled(1) {
+		fmt.Printf(format, args...)
+	}
+}
+
+func Warnf(format string, args ...any) {
+	if enabled(2) {
+		fmt.Printf("warning: "+format, args...)
+	}
+}
+
+func Errorf(format string, args ...any) {
+	fmt.Printf(format, args...)
+}
+
+// 设置日志级别和调试日志的抽样比例，任一无效时都不修改
+func Configure(name string, rate float64) error {
+	if !slices.Contains(levels, name) {
+		return fmt.Errorf("invalid log level %q, expected one of %v", name, levels)
+	}
+	err := SetDebugSampleRate(rate)
+	if err != nil {
+		return err
+	}
+	return SetLevel(name)
+}
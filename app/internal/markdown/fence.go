@@ -0,0 +1,83 @@
+package markdown
+
+import "strings"
+
+// 围栏块在文本中的位置，以字符（rune）为单位，左闭右开，包含开始和结束的围栏行。
+// 切分或截断文本时不应落在围栏块内部，否则代码块和公式在渲染时错乱
+type Fence struct {
+	Start int
+	End   int
+	// 结束围栏，如 ```、~~~、$$ 或 \]
+	Closer string
+	// 没有结束围栏时延伸到文本末尾
+	Closed bool
+}
+
+// 找出文本中的代码块（``` 或 ~~~）和独立成行的公式块（$$ 或 \[ \]），按位置排序
+func Fences(s string) []Fence {
+	fences := []Fence{}
+	var open *Fence
+	pos := 0
+	for _, line := range strings.SplitAfter(s, "\n") {
+		lineStart := pos
+		n := len([]rune(line))
+		pos += n
+		end := lineStart + len([]rune(strings.TrimRight(line, "\n")))
+		trimmed := strings.TrimSpace(line)
+
+		if open != nil {
+			if closes(trimmed, open.Closer) {
+				open.End, open.Closed = end, true
+				fences = append(fences, *open)
+				open = nil
+			}
+			continue
+		}
+		if closer := opener(trimmed); closer != "" {
+			open = &Fence{Start: lineStart, Closer: closer}
+		}
+	}
+	if open != nil {
+		open.End = pos
+		fences = append(fences, *open)
+	}
+	return fences
+}
+
+// 围栏的开始行返回对应的结束围栏，否则返回空。同一行内开始并结束的公式不算围栏块
+func opener(line string) string {
+	for _, c := range []string{"`", "~"} {
+		if strings.HasPrefix(line, c+c+c) {
+			return strings.Repeat(c, len(line)-len(strings.TrimLeft(line, c)))
+		}
+	}
+	if strings.HasPrefix(line, "$$") && (line == "$$" || !strings.HasSuffix(line, "$$")) {
+		return "$$"
+	}
+	if strings.HasPrefix(line, `\[`) && !strings.HasSuffix(line, `\]`) {
+		return `\]`
+	}
+	return ""
+}
+
+// 代码块的结束行只含不短于开始标记的同一字符，公式块以结束标记结尾即可
+func closes(line, closer string) bool {
+	if closer == "$$" || closer == `\]` {
+		return strings.HasSuffix(line, closer)
+	}
+	c := closer[:1]
+	return strings.HasPrefix(line, closer) && strings.Trim(line, c) == ""
+}
+
+// 返回包含位置 pos 的围栏块，pos 恰好位于围栏块的开头或结尾时不算在内部
+func At(fences []Fence, pos int) (Fence, bool) {
+	for _, f := range fences {
+		if f.Start < pos && pos < f.End {
+			return f, true
+		}
+		if f.Start >= pos {
+			break
+		}
+	}
+	return Fence{}, false
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 以 Prometheus 文本格式输出的指标
+type Collector interface {
+	Write(w io.Writer)
+}
+
+// 指标集合，按注册顺序输出
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// 以 Prometheus 文本格式（0.0.4）输出全部指标
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.Write(w)
+	}
+}
+
+// 文本格式的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 带标签的直方图
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// 创建直方图，buckets 为升序的上界，+Inf 自动补上
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+}
+
+// 记录一个观测值，values 与创建时的标签一一对应
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := strings.Join(values, "\x00")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		labels := formatLabels(h.labels, s.values)
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, joinLabels(labels, `le="`+formatFloat(upper)+`"`), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, joinLabels(labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(labels), s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + "=" + strconv.Quote(value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
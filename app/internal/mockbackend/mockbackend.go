@@ -0,0 +1,273 @@
+package mockbackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 向量维度
+const Dim = 64
+
+// 模拟的 OpenAI 兼容后端，提供 chat/completions、embeddings 和 rerank 接口，
+// 输出结果是确定性的，便于端到端验证整条流水线
+type Server struct {
+	*httptest.Server
+
+	// 流式回答内容，按块依次输出
+	Answer []string
+	// 流式输出每块之前的等待时间
+	ChunkDelay time.Duration
+	// 请求中带有工具定义、允许调用工具且最后一条消息不是工具结果时，以这些工具调用代替回答
+	ToolCalls []openai.ToolCall
+	// GET /v1/models 列出的模型
+	Models []string
+
+	mu       sync.Mutex
+	requests []openai.ChatCompletionRequest
+}
+
+func New() *Server {
+	s := &Server{
+		Answer: []string{"这是", "模拟的", "回答。"},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.chatHandler)
+	mux.HandleFunc("GET /v1/models", s.modelsHandler)
+	mux.HandleFunc("POST /v1/embeddings", s.embeddingsHandler)
+	mux.HandleFunc("POST /v1/rerank", s.rerankHandler)
+	mux.HandleFunc("POST /embed_sparse", s.sparseHandler)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// OpenAI 兼容的接口地址
+func (s *Server) BaseUrl() string {
+	return s.URL + "/v1"
+}
+
+// 返回收到的全部对话请求
+func (s *Server) ChatRequests() []openai.ChatCompletionRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *Server) modelsHandler(w http.ResponseWriter, r *http.Request) {
+	list := openai.ModelsList{Models: []openai.Model{}}
+	for _, id := range s.Models {
+		list.Models = append(list.Models, openai.Model{ID: id, Object: "model", OwnedBy: "mock"})
+	}
+	writeJSON(w, list)
+}
+
+func (s *Server) chatHandler(w http.ResponseWriter, r *http.Request) {
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	// 非流式请求用于问题提取，直接返回最后一条用户消息
+	if !req.Stream {
+		content := ""
+		for _, msg := range req.Messages {
+			if msg.Role == openai.ChatMessageRoleUser {
+				content = msg.Content
+			}
+		}
+		writeJSON(w, openai.ChatCompletionResponse{
+			ID:     "mock",
+			Object: "chat.completion",
+			Model:  req.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: strings.TrimSpace(content),
+				},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	last := req.Messages[len(req.Messages)-1]
+	if len(s.ToolCalls) > 0 && len(req.Tools) > 0 && req.ToolChoice != "none" && last.Role != openai.ChatMessageRoleTool {
+		for i, call := range s.ToolCalls {
+			index := i
+			call.Index = &index
+			chunk := openai.ChatCompletionStreamResponse{
+				ID:     "mock",
+				Object: "chat.completion.chunk",
+				Model:  req.Model,
+				Choices: []openai.ChatCompletionStreamChoice{{
+					Delta: openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{call}},
+				}},
+			}
+			if i == len(s.ToolCalls)-1 {
+				chunk.Choices[0].FinishReason = openai.FinishReasonToolCalls
+			}
+			buf, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", buf)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return
+	}
+	for i, delta := range s.Answer {
+		if s.ChunkDelay > 0 {
+			time.Sleep(s.ChunkDelay)
+		}
+		chunk := openai.ChatCompletionStreamResponse{
+			ID:     "mock",
+			Object: "chat.completion.chunk",
+			Model:  req.Model,
+			Choices: []openai.ChatCompletionStreamChoice{{
+				Delta: openai.ChatCompletionStreamChoiceDelta{Content: delta},
+			}},
+		}
+		if i == 0 {
+			chunk.Choices[0].Delta.Role = openai.ChatMessageRoleAssistant
+		}
+		if i == len(s.Answer)-1 {
+			chunk.Choices[0].FinishReason = openai.FinishReasonStop
+		}
+		buf, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", buf)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func (s *Server) embeddingsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Input []string `json:"input"`
+		Model string   `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := openai.EmbeddingResponse{
+		Object: "list",
+		Model:  openai.EmbeddingModel(req.Model),
+	}
+	for i, input := range req.Input {
+		res.Data = append(res.Data, openai.Embedding{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: Embed(input),
+		})
+	}
+	writeJSON(w, res)
+}
+
+// text-embeddings-inference 兼容的稀疏向量接口，以字符码点为词表 ID、出现次数为权重
+func (s *Server) sparseHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Inputs []string `json:"inputs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type value struct {
+		Index int     `json:"index"`
+		Value float32 `json:"value"`
+	}
+	res := make([][]value, len(req.Inputs))
+	for i, input := range req.Inputs {
+		counts := map[rune]float32{}
+		for _, r := range input {
+			counts[r]++
+		}
+		res[i] = []value{}
+		for r, n := range counts {
+			res[i] = append(res[i], value{Index: int(r), Value: n})
+		}
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) rerankHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string   `json:"query"`
+		Documents []string `json:"documents"`
+		TopN      int      `json:"top_n"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type result struct {
+		Index          int     `json:"index"`
+		RelevanceScore float32 `json:"relevance_score"`
+	}
+	results := make([]result, len(req.Documents))
+	for i, doc := range req.Documents {
+		results[i] = result{Index: i, RelevanceScore: overlap(req.Query, doc)}
+	}
+	slices.SortStableFunc(results, func(a, b result) int {
+		if a.RelevanceScore > b.RelevanceScore {
+			return -1
+		} else if a.RelevanceScore < b.RelevanceScore {
+			return 1
+		}
+		return 0
+	})
+	if req.TopN > 0 && req.TopN < len(results) {
+		results = results[:req.TopN]
+	}
+	writeJSON(w, map[string]any{"results": results})
+}
+
+// 按字符哈希计算的确定性向量，含有相同字符的文本相似度更高
+func Embed(text string) []float32 {
+	v := make([]float32, Dim)
+	v[0] = 1
+	for _, r := range text {
+		h := fnv.New32a()
+		h.Write([]byte(string(r)))
+		v[h.Sum32()%Dim] += 1
+	}
+	return v
+}
+
+// 查询中的字符在文档中出现的比例
+func overlap(query, doc string) float32 {
+	runes := []rune(query)
+	if len(runes) == 0 {
+		return 0
+	}
+	n := 0
+	for _, r := range runes {
+		if strings.ContainsRune(doc, r) {
+			n++
+		}
+	}
+	return float32(n) / float32(len(runes))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
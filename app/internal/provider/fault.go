@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"rag_app/internal/logging"
+)
+
+// 可注入故障的上游调用类型
+const (
+	FaultEmbed  = "embed"
+	FaultRerank = "rerank"
+	FaultLLM    = "llm"
+)
+
+var faultTargets = []string{FaultEmbed, FaultRerank, FaultLLM}
+
+// 故障注入设置：按比例延迟或失败上游的向量化、重排序和生成调用，用于在预发环境验证降级行为
+type Faults struct {
+	Targets []string
+	// 失败的比例，0~1
+	ErrorRate float64
+	// 失败时返回的 HTTP 状态码，0 表示模拟连接错误
+	ErrorStatus int
+	// 延迟的比例，0~1
+	DelayRate float64
+	Delay     time.Duration
+}
+
+var faults atomic.Pointer[Faults]
+
+// 注入的连接错误
+var ErrInjectedFault = errors.New("injected fault")
+
+// 设置故障注入，两个比例都为 0 时关闭
+func SetFaults(f *Faults) error {
+	for _, t := range f.Targets {
+		if !slices.Contains(faultTargets, t) {
+			return fmt.Errorf("invalid fault target %q, expected one of %v", t, faultTargets)
+		}
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.DelayRate < 0 || f.DelayRate > 1 {
+		return errors.New("fault rates must be between 0 and 1")
+	}
+	if f.ErrorRate == 0 && f.DelayRate == 0 {
+		faults.Store(nil)
+		return nil
+	}
+	fmt.Printf("fault injection enabled: targets=%v error_rate=%g error_status=%d delay_rate=%g delay=%s\n",
+		f.Targets, f.ErrorRate, f.ErrorStatus, f.DelayRate, f.Delay)
+	faults.Store(f)
+	return nil
+}
+
+// 按请求路径判断上游调用类型，预热、稀疏向量化等其他请求返回空字符串
+func faultTarget(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/embeddings"):
+		return FaultEmbed
+	case strings.HasSuffix(path, "/rerank"):
+		return FaultRerank
+	case strings.HasSuffix(path, "/completions"), strings.HasSuffix(path, "/api/chat"), strings.HasSuffix(path, "/generate"):
+		return FaultLLM
+	}
+	return ""
+}
+
+type faultTransport struct {
+	base http.RoundTripper
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f := faults.Load()
+	if f == nil {
+		return t.base.RoundTrip(req)
+	}
+	target := faultTarget(req.URL.Path)
+	if target == "" || !slices.Contains(f.Targets, target) {
+		return t.base.RoundTrip(req)
+	}
+
+	if f.DelayRate > 0 && rand.Float64() < f.DelayRate {
+		logging.Infof("fault injected: %s delayed %s (request %s)\n", target, f.Delay, RequestId(req.Context()))
+		err := sleep(req.Context(), f.Delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		logging.Infof("fault injected: %s failed with %s (request %s)\n", target, faultName(f.ErrorStatus), RequestId(req.Context()))
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		if f.ErrorStatus == 0 {
+			return nil, ErrInjectedFault
+		}
+		body := fmt.Sprintf(`{"error":{"message":"injected fault","type":"fault_injection","code":%d}}`, f.ErrorStatus)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", f.ErrorStatus, http.StatusText(f.ErrorStatus)),
+			StatusCode:    f.ErrorStatus,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+func faultName(status int) string {
+	if status == 0 {
+		return "connection error"
+	}
+	return fmt.Sprintf("status %d", status)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,213 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 上游的流式响应格式
+const (
+	FormatOpenAI     = "openai"
+	FormatAzure      = "azure"
+	FormatOpenRouter = "openrouter"
+	FormatOllama     = "ollama"
+	FormatVLLM       = "vllm"
+)
+
+// 流式生成的响应，Recv 返回 OpenAI 兼容的 chunk JSON，结束时返回 io.EOF
+type ChatStream interface {
+	Recv() ([]byte, error)
+	Close() error
+}
+
+// 流式生成接口
+type Generator interface {
+	Stream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error)
+}
+
+// OpenAI 兼容的生成实现，直接透传上游的 chunk
+type OpenAIGenerator struct {
+	client  *openai.Client
+	baseUrl string
+	token   string
+}
+
+func NewOpenAIGenerator(baseUrl, token string) *OpenAIGenerator {
+	return NewClientGenerator(ClientOptions{BaseUrl: baseUrl, Token: token})
+}
+
+// 创建 OpenAI 系列后端（OpenAI 兼容、Azure OpenAI、OpenRouter）的生成实现
+func NewClientGenerator(opts ClientOptions) *OpenAIGenerator {
+	baseUrl := opts.BaseUrl
+	if opts.Format == FormatOpenRouter && baseUrl == "" {
+		baseUrl = openRouterBaseUrl
+	}
+	return &OpenAIGenerator{
+		client:  NewClient(opts),
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		token:   opts.Token,
+	}
+}
+
+func (g *OpenAIGenerator) Stream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error) {
+	request.Stream = true
+	stream, err := g.client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return &openaiStream{stream}, nil
+}
+
+type openaiStream struct {
+	*openai.ChatCompletionStream
+}
+
+func (s *openaiStream) Recv() ([]byte, error) {
+	return s.RecvRaw()
+}
+
+// 模型别名：将客户端请求的模型名映射到具体的上游后端、模型和响应格式
+type ModelAlias struct {
+	Model   string `json:"model"`
+	BaseUrl string `json:"base_url"`
+	Token   string `json:"token"`
+	Format  string `json:"format"`
+	// Azure OpenAI 的 api-version 和部署名
+	APIVersion string `json:"api_version"`
+	Deployment string `json:"deployment"`
+	// 附加的请求头，仅 openai、azure 和 openrouter 格式支持
+	Headers map[string]string `json:"headers"`
+}
+
+// 按模型别名选择生成后端，未配置别名的模型使用默认后端
+type GeneratorRouter struct {
+	fallback Generator
+	aliases  map[string]*ModelAlias
+	backends map[string]Generator
+}
+
+// 创建生成路由，aliasesFile 为 JSON 文件，键为别名；为空时所有模型均使用默认后端
+func NewGeneratorRouter(fallback Generator, aliasesFile string) (*GeneratorRouter, error) {
+	r := &GeneratorRouter{
+		fallback: fallback,
+		aliases:  make(map[string]*ModelAlias),
+		backends: make(map[string]Generator),
+	}
+	if aliasesFile == "" {
+		return r, nil
+	}
+
+	buf, err := os.ReadFile(aliasesFile)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &r.aliases)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", aliasesFile, err)
+	}
+
+	for name, alias := range r.aliases {
+		if alias.Model == "" {
+			alias.Model = name
+		}
+		if len(alias.Headers) > 0 && (alias.Format == FormatOllama || alias.Format == FormatVLLM) {
+			return nil, fmt.Errorf("%s: alias %q: headers are not supported with format %q", aliasesFile, name, alias.Format)
+		}
+		switch alias.Format {
+		case "", FormatOpenAI, FormatAzure, FormatOpenRouter:
+			if alias.Format == FormatAzure && alias.BaseUrl == "" {
+				return nil, fmt.Errorf("%s: alias %q: base_url is required with format %q", aliasesFile, name, alias.Format)
+			}
+			r.backends[name] = NewClientGenerator(ClientOptions{
+				Format:     alias.Format,
+				BaseUrl:    alias.BaseUrl,
+				Token:      alias.Token,
+				APIVersion: alias.APIVersion,
+				Deployment: alias.Deployment,
+				Headers:    alias.Headers,
+			})
+		case FormatOllama:
+			r.backends[name] = NewOllamaGenerator(alias.BaseUrl, alias.Token)
+		case FormatVLLM:
+			r.backends[name] = NewVLLMGenerator(alias.BaseUrl, alias.Token)
+		default:
+			return nil, fmt.Errorf("%s: alias %q: unknown format %q", aliasesFile, name, alias.Format)
+		}
+	}
+
+	return r, nil
+}
+
+// 模型名是否配置了别名
+func (r *GeneratorRouter) HasAlias(model string) bool {
+	_, ok := r.aliases[model]
+	return ok
+}
+
+// 全部别名，按名称排序
+func (r *GeneratorRouter) Aliases() []string {
+	names := make([]string, 0, len(r.aliases))
+	for name := range r.aliases {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// 返回模型对应的后端和上游模型名
+func (r *GeneratorRouter) Resolve(model string) (Generator, string) {
+	if alias, ok := r.aliases[model]; ok {
+		return r.backends[model], alias.Model
+	}
+	return r.fallback, model
+}
+
+type streamChunk struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []streamChoice `json:"choices"`
+}
+
+type streamChoice struct {
+	Index        int         `json:"index"`
+	Delta        streamDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type streamDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// 构造 OpenAI 兼容的流式 chunk，finishReason 为空表示未结束
+func NewChunk(id, model, content, finishReason string) ([]byte, error) {
+	choice := streamChoice{
+		Delta: streamDelta{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: content,
+		},
+	}
+	if finishReason != "" {
+		choice.FinishReason = &finishReason
+	}
+	return json.Marshal(&streamChunk{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []streamChoice{choice},
+	})
+}
+
+func NewChunkId() string {
+	return fmt.Sprintf("chatcmpl-lento-%d", time.Now().UnixNano())
+}
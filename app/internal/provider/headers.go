@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 所有上游请求（生成、向量化、重排序）共用的 HTTP 客户端，附加配置的请求头、当前请求的 X-Request-ID 和 traceparent，
+// 并按配置注入故障
+var HTTPClient = &http.Client{Transport: &headerTransport{base: &faultTransport{base: http.DefaultTransport}}}
+
+var upstreamHeaders atomic.Pointer[http.Header]
+
+// 设置附加到所有上游请求的请求头，userAgent 不为空时覆盖默认的 User-Agent
+func SetUpstreamHeaders(headers map[string]string, userAgent string) {
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	if userAgent != "" {
+		h.Set("User-Agent", userAgent)
+	}
+	upstreamHeaders.Store(&h)
+}
+
+type ctxKey int
+
+const (
+	requestIdKey ctxKey = iota
+	traceKey
+)
+
+// 在上下文中记录请求 ID，经由该上下文发出的上游请求携带 X-Request-ID
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+type headerTransport struct {
+	base http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers := upstreamHeaders.Load()
+	id := RequestId(req.Context())
+	trace := TraceFrom(req.Context())
+	if headers == nil && id == "" && trace == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if headers != nil {
+		for k, v := range *headers {
+			// 不覆盖各后端自身的鉴权信息
+			if k == "Authorization" && req.Header.Get(k) != "" {
+				continue
+			}
+			req.Header[k] = v
+		}
+	}
+	if id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	if trace != nil {
+		req.Header.Set("traceparent", trace.Traceparent())
+		if trace.State != "" {
+			req.Header.Set("tracestate", trace.State)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// 创建使用公共 HTTP 客户端的 OpenAI 兼容客户端
+func NewOpenAIClient(baseUrl, token string) *openai.Client {
+	return NewClient(ClientOptions{BaseUrl: baseUrl, Token: token})
+}
+
+// OpenAI 系列客户端的构造参数
+type ClientOptions struct {
+	// openai（默认）、azure 或 openrouter
+	Format  string
+	BaseUrl string
+	Token   string
+	// Azure OpenAI 的 api-version 查询参数，为空时使用客户端库的默认版本
+	APIVersion string
+	// Azure OpenAI 的部署名，为空时与模型名相同
+	Deployment string
+	// 附加到该后端请求的请求头，如 OpenRouter 的 HTTP-Referer 和 X-Title
+	Headers map[string]string
+}
+
+// OpenRouter 的默认接口地址
+const openRouterBaseUrl = "https://openrouter.ai/api/v1"
+
+// 按后端类型创建客户端：Azure OpenAI 使用部署名拼接地址并以 api-key 头鉴权，
+// OpenRouter 与 OpenAI 兼容，只是默认地址不同
+func NewClient(opts ClientOptions) *openai.Client {
+	var config openai.ClientConfig
+	switch opts.Format {
+	case FormatAzure:
+		config = openai.DefaultAzureConfig(opts.Token, opts.BaseUrl)
+		if opts.APIVersion != "" {
+			config.APIVersion = opts.APIVersion
+		}
+		config.AzureModelMapperFunc = func(model string) string {
+			if opts.Deployment != "" {
+				return opts.Deployment
+			}
+			return model
+		}
+	case FormatOpenRouter:
+		config = openai.DefaultConfig(opts.Token)
+		config.BaseURL = opts.BaseUrl
+		if config.BaseURL == "" {
+			config.BaseURL = openRouterBaseUrl
+		}
+	default:
+		config = openai.DefaultConfig(opts.Token)
+		config.BaseURL = opts.BaseUrl
+	}
+	config.HTTPClient = HTTPClient
+	if len(opts.Headers) > 0 {
+		h := http.Header{}
+		for k, v := range opts.Headers {
+			h.Set(k, v)
+		}
+		config.HTTPClient = &http.Client{Transport: &backendHeaderTransport{base: HTTPClient.Transport, headers: h}}
+	}
+	return openai.NewClientWithConfig(config)
+}
+
+// 附加单个后端专属请求头的传输层，在公共请求头之前设置，公共请求头中的同名项优先
+type backendHeaderTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *backendHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header[k] = v
+	}
+	return t.base.RoundTrip(req)
+}
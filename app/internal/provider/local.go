@@ -0,0 +1,13 @@
+package provider
+
+// 本地向量化模型的配置，由 EMB_PROVIDER=local 启用
+type LocalEmbedderOptions struct {
+	// ONNX 模型文件，如导出的 bge-m3 model.onnx
+	Model string
+	// 与模型配套的 tokenizer.json
+	Tokenizer string
+	// onnxruntime 动态库路径，为空时使用系统默认路径
+	Library string
+	// 单条输入的最大 token 数，超出部分截断
+	MaxTokens int
+}
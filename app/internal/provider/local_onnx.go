@@ -0,0 +1,146 @@
+//go:build onnx
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/sugarme/tokenizer"
+	"github.com/sugarme/tokenizer/pretrained"
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// 在进程内通过 onnxruntime 运行的向量化模型，取 [CLS] 位置的隐藏状态作为向量
+type LocalEmbedder struct {
+	tokenizer *tokenizer.Tokenizer
+	session   *ort.DynamicAdvancedSession
+	maxTokens int
+}
+
+var (
+	ortOnce sync.Once
+	ortErr  error
+
+	// 模型加载开销较大，相同配置的本地模型在重新加载索引时复用
+	localMu        sync.Mutex
+	localEmbedders = map[LocalEmbedderOptions]*LocalEmbedder{}
+)
+
+func NewLocalEmbedder(opts LocalEmbedderOptions) (Embedder, error) {
+	localMu.Lock()
+	defer localMu.Unlock()
+	if e, ok := localEmbedders[opts]; ok {
+		return e, nil
+	}
+
+	ortOnce.Do(func() {
+		if opts.Library != "" {
+			ort.SetSharedLibraryPath(opts.Library)
+		}
+		ortErr = ort.InitializeEnvironment()
+	})
+	if ortErr != nil {
+		return nil, fmt.Errorf("onnxruntime: %w", ortErr)
+	}
+
+	tk, err := pretrained.FromFile(opts.Tokenizer)
+	if err != nil {
+		return nil, fmt.Errorf("load tokenizer %s: %w", opts.Tokenizer, err)
+	}
+	session, err := ort.NewDynamicAdvancedSession(opts.Model,
+		[]string{"input_ids", "attention_mask"}, []string{"last_hidden_state"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("load model %s: %w", opts.Model, err)
+	}
+
+	e := &LocalEmbedder{
+		tokenizer: tk,
+		session:   session,
+		maxTokens: opts.MaxTokens,
+	}
+	localEmbedders[opts] = e
+	return e, nil
+}
+
+func (e *LocalEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	if len(input) == 0 {
+		return nil, errors.New("input is empty")
+	}
+
+	res := make([][]float32, len(input))
+	for i, text := range input {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		v, err := e.embedOne(text)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+func (e *LocalEmbedder) embedOne(text string) ([]float32, error) {
+	enc, err := e.tokenizer.EncodeSingle(text, true)
+	if err != nil {
+		return nil, err
+	}
+	ids := enc.Ids
+	// 超长时保留开头部分和结尾的特殊 token
+	if e.maxTokens > 1 && len(ids) > e.maxTokens {
+		ids = append(ids[:e.maxTokens-1:e.maxTokens-1], ids[len(ids)-1])
+	}
+
+	n := int64(len(ids))
+	inputIds := make([]int64, n)
+	mask := make([]int64, n)
+	for i, id := range ids {
+		inputIds[i] = int64(id)
+		mask[i] = 1
+	}
+
+	idsTensor, err := ort.NewTensor(ort.NewShape(1, n), inputIds)
+	if err != nil {
+		return nil, err
+	}
+	defer idsTensor.Destroy()
+	maskTensor, err := ort.NewTensor(ort.NewShape(1, n), mask)
+	if err != nil {
+		return nil, err
+	}
+	defer maskTensor.Destroy()
+
+	outputs := []ort.Value{nil}
+	err = e.session.Run([]ort.Value{idsTensor, maskTensor}, outputs)
+	if err != nil {
+		return nil, err
+	}
+	defer outputs[0].Destroy()
+
+	hidden, ok := outputs[0].(*ort.Tensor[float32])
+	if !ok {
+		return nil, errors.New("unexpected model output type")
+	}
+	shape := hidden.GetShape()
+	if len(shape) != 3 || shape[1] < 1 {
+		return nil, fmt.Errorf("unexpected model output shape %v", shape)
+	}
+	// 与 bge 系列模型一致，对向量做 L2 归一化
+	v := append([]float32(nil), hidden.GetData()[:shape[2]]...)
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum > 0 {
+		n := float32(math.Sqrt(sum))
+		for i := range v {
+			v[i] /= n
+		}
+	}
+	return v, nil
+}
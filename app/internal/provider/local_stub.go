@@ -0,0 +1,10 @@
+//go:build !onnx
+
+package provider
+
+import "errors"
+
+// 未使用 onnx 构建标签编译时不包含 onnxruntime 依赖
+func NewLocalEmbedder(opts LocalEmbedderOptions) (Embedder, error) {
+	return nil, errors.New("local embedding requires building with -tags onnx")
+}
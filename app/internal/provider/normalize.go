@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 修正不规范上游的流式 chunk：补全缺失的 id、object 和首个 delta 的 role，
+// 丢弃既无内容也无结束原因的空 delta，以及第一个结束 chunk 之后重复的 chunk（单独的 usage chunk 除外）。
+// 其余字段原样保留
+func NormalizeStream(stream ChatStream) ChatStream {
+	return &normalizedStream{ChatStream: stream}
+}
+
+type normalizedStream struct {
+	ChatStream
+	id       string
+	model    string
+	roleSent bool
+	finished bool
+}
+
+func (s *normalizedStream) Recv() ([]byte, error) {
+	for {
+		buf, err := s.ChatStream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		out, keep, err := s.normalize(buf)
+		if err != nil {
+			// 无法解析的 chunk 原样透传，由客户端自行处理
+			fmt.Printf("normalize stream: %v\n", err)
+			return buf, nil
+		}
+		if keep {
+			return out, nil
+		}
+	}
+}
+
+func (s *normalizedStream) normalize(buf []byte) ([]byte, bool, error) {
+	var chunk map[string]any
+	err := json.Unmarshal(buf, &chunk)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if id, _ := chunk["id"].(string); id != "" {
+		if s.id == "" {
+			s.id = id
+		}
+	} else {
+		if s.id == "" {
+			s.id = NewChunkId()
+		}
+		chunk["id"] = s.id
+	}
+	if model, _ := chunk["model"].(string); model != "" {
+		s.model = model
+	} else if s.model != "" {
+		chunk["model"] = s.model
+	}
+	chunk["object"] = "chat.completion.chunk"
+
+	choices, _ := chunk["choices"].([]any)
+	if len(choices) == 0 {
+		// 不含 choices 的 chunk（如单独的 usage）原样保留
+		out, err := json.Marshal(chunk)
+		return out, true, err
+	}
+
+	if s.finished {
+		return nil, false, nil
+	}
+
+	choice, _ := choices[0].(map[string]any)
+	if choice == nil {
+		return nil, false, fmt.Errorf("invalid choice")
+	}
+	delta, _ := choice["delta"].(map[string]any)
+	if delta == nil {
+		delta = map[string]any{}
+		choice["delta"] = delta
+	}
+	reason, _ := choice["finish_reason"].(string)
+	// 只有空内容或重复 role 的 delta；工具调用、推理内容等其他字段不为空时保留
+	empty := reason == "" && (s.roleSent || delta["role"] == nil)
+	for k, v := range delta {
+		if k != "role" && v != nil && v != "" {
+			empty = false
+		}
+	}
+	if empty {
+		return nil, false, nil
+	}
+
+	if !s.roleSent {
+		delta["role"] = openai.ChatMessageRoleAssistant
+		s.roleSent = true
+	}
+	if reason != "" {
+		s.finished = true
+	}
+
+	out, err := json.Marshal(chunk)
+	return out, true, err
+}
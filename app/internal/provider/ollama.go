@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Ollama 原生接口的生成实现，将 /api/chat 的 NDJSON 流转换为 OpenAI 兼容的 chunk
+type OllamaGenerator struct {
+	baseUrl string
+	token   string
+}
+
+func NewOllamaGenerator(baseUrl, token string) *OllamaGenerator {
+	return &OllamaGenerator{baseUrl: strings.TrimSuffix(baseUrl, "/"), token: token}
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	Error      string        `json:"error"`
+}
+
+func (g *OllamaGenerator) Stream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error) {
+	req := ollamaChatRequest{
+		Model:  request.Model,
+		Stream: true,
+	}
+	for _, msg := range request.Messages {
+		req.Messages = append(req.Messages, ollamaMessage{Role: msg.Role, Content: msg.Content})
+	}
+	options := map[string]any{}
+	if request.Temperature != 0 {
+		options["temperature"] = request.Temperature
+	}
+	if request.TopP != 0 {
+		options["top_p"] = request.TopP
+	}
+	if request.MaxTokens != 0 {
+		options["num_predict"] = request.MaxTokens
+	}
+	if request.Seed != nil {
+		options["seed"] = *request.Seed
+	}
+	if len(options) > 0 {
+		req.Options = options
+	}
+
+	resp, err := postJSON(ctx, g.baseUrl+"/api/chat", g.token, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1024*1024)
+	return &ollamaStream{
+		body:    resp.Body,
+		scanner: scanner,
+		id:      NewChunkId(),
+		model:   request.Model,
+	}, nil
+}
+
+type ollamaStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	id      string
+	model   string
+	done    bool
+}
+
+func (s *ollamaStream) Recv() ([]byte, error) {
+	for !s.done && s.scanner.Scan() {
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg ollamaChatResponse
+		err := json.Unmarshal(line, &msg)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Error != "" {
+			return nil, errors.New(msg.Error)
+		}
+
+		finishReason := ""
+		if msg.Done {
+			s.done = true
+			finishReason = string(openai.FinishReasonStop)
+			if msg.DoneReason == "length" {
+				finishReason = string(openai.FinishReasonLength)
+			}
+		}
+		return NewChunk(s.id, s.model, msg.Message.Content, finishReason)
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (s *ollamaStream) Close() error {
+	return s.body.Close()
+}
+
+// 发送 JSON 请求，非 200 响应视为错误
+func postJSON(ctx context.Context, url, token string, v any) (*http.Response, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
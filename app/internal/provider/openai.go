@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 基于 OpenAI 兼容接口的向量化实现
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+func NewOpenAIEmbedder(baseUrl, token, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		client: NewOpenAIClient(baseUrl, token),
+		model:  model,
+	}
+}
+
+// 计算输入语料的embedding值
+func (e *OpenAIEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	if len(input) == 0 {
+		return nil, errors.New("input is empty")
+	}
+
+	response, err := e.client.CreateEmbeddings(
+		ctx,
+		openai.EmbeddingRequestStrings{
+			Input: input,
+			Model: openai.EmbeddingModel(e.model),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) != len(input) {
+		return nil, errors.New("embedding length mismatch")
+	}
+
+	res := make([][]float32, len(input))
+	for _, v := range response.Data {
+		if v.Index < 0 || v.Index >= len(res) {
+			return nil, errors.New("embedding index out of range")
+		}
+		res[v.Index] = v.Embedding
+	}
+
+	return res, nil
+}
+
+type RerankRequest struct {
+	Model     string   `json:"model"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+type RerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float32 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// 基于 `/rerank` 接口的重排序实现
+type HTTPReranker struct {
+	baseUrl string
+	token   string
+	model   string
+}
+
+// 重排序模型名
+func (r *HTTPReranker) Model() string {
+	return r.model
+}
+
+func NewHTTPReranker(baseUrl, token, model string) *HTTPReranker {
+	return &HTTPReranker{
+		baseUrl: baseUrl,
+		token:   token,
+		model:   model,
+	}
+}
+
+// 调用重排序模型
+func (r *HTTPReranker) Rerank(ctx context.Context, query string, documents []string, topN int) ([]RerankResult, error) {
+	buf, err := json.Marshal(&RerankRequest{
+		Model:     r.model,
+		Query:     query,
+		Documents: documents,
+		TopN:      topN,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseUrl+"/rerank", bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.token)
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg RerankResponse
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]RerankResult, 0, len(msg.Results))
+	for _, v := range msg.Results {
+		if v.Index < 0 || v.Index >= len(documents) {
+			return nil, errors.New("rerank index out of range")
+		}
+		res = append(res, RerankResult{Index: v.Index, Score: v.RelevanceScore})
+	}
+
+	return res, nil
+}
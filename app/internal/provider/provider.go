@@ -0,0 +1,30 @@
+package provider
+
+import "context"
+
+// 文本向量化接口
+type Embedder interface {
+	Embed(ctx context.Context, input []string) ([][]float32, error)
+}
+
+type RerankResult struct {
+	Index int
+	Score float32
+}
+
+// 重排序接口，返回按相关性从高到低排列的前 topN 个结果
+type Reranker interface {
+	Rerank(ctx context.Context, query string, documents []string, topN int) ([]RerankResult, error)
+}
+
+// 不调用重排序模型，按输入顺序返回前 topN 个结果，用于未配置重排序模型时
+type PassthroughReranker struct{}
+
+func (PassthroughReranker) Rerank(ctx context.Context, query string, documents []string, topN int) ([]RerankResult, error) {
+	n := min(topN, len(documents))
+	res := make([]RerankResult, n)
+	for i := range res {
+		res[i] = RerankResult{Index: i}
+	}
+	return res, nil
+}
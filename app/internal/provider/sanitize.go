@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 可以按模型去除的请求参数，键为请求体中的字段名
+var sanitizers = map[string]func(request *openai.ChatCompletionRequest){
+	"seed":                func(r *openai.ChatCompletionRequest) { r.Seed = nil },
+	"stream_options":      func(r *openai.ChatCompletionRequest) { r.StreamOptions = nil },
+	"response_format":     func(r *openai.ChatCompletionRequest) { r.ResponseFormat = nil },
+	"temperature":         func(r *openai.ChatCompletionRequest) { r.Temperature = 0 },
+	"top_p":               func(r *openai.ChatCompletionRequest) { r.TopP = 0 },
+	"n":                   func(r *openai.ChatCompletionRequest) { r.N = 0 },
+	"stop":                func(r *openai.ChatCompletionRequest) { r.Stop = nil },
+	"presence_penalty":    func(r *openai.ChatCompletionRequest) { r.PresencePenalty = 0 },
+	"frequency_penalty":   func(r *openai.ChatCompletionRequest) { r.FrequencyPenalty = 0 },
+	"logit_bias":          func(r *openai.ChatCompletionRequest) { r.LogitBias = nil },
+	"logprobs":            func(r *openai.ChatCompletionRequest) { r.LogProbs, r.TopLogProbs = false, 0 },
+	"top_logprobs":        func(r *openai.ChatCompletionRequest) { r.TopLogProbs = 0 },
+	"user":                func(r *openai.ChatCompletionRequest) { r.User = "" },
+	"tool_choice":         func(r *openai.ChatCompletionRequest) { r.ToolChoice = nil },
+	"parallel_tool_calls": func(r *openai.ChatCompletionRequest) { r.ParallelToolCalls = nil },
+	"store":               func(r *openai.ChatCompletionRequest) { r.Store = false },
+	"metadata":            func(r *openai.ChatCompletionRequest) { r.Metadata = nil },
+	"reasoning_effort":    func(r *openai.ChatCompletionRequest) { r.ReasoningEffort = "" },
+	// 去除时改用 max_tokens，保留长度限制
+	"max_completion_tokens": func(r *openai.ChatCompletionRequest) {
+		if r.MaxTokens == 0 {
+			r.MaxTokens = r.MaxCompletionTokens
+		}
+		r.MaxCompletionTokens = 0
+	},
+}
+
+// 检查参数名是否都可以去除
+func CheckUnsupportedParams(params []string) error {
+	for _, name := range params {
+		if _, ok := sanitizers[name]; !ok {
+			names := make([]string, 0, len(sanitizers))
+			for name := range sanitizers {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return fmt.Errorf("unknown parameter %q, expected one of %s", name, strings.Join(names, " "))
+		}
+	}
+	return nil
+}
+
+// 包装生成后端，每次调用上游前去除后端不支持的参数，避免上游以 400 拒绝请求。
+// params 为空时原样返回
+func SanitizeGenerator(generator Generator, params []string) Generator {
+	if len(params) == 0 {
+		return generator
+	}
+	return &sanitizedGenerator{Generator: generator, params: params}
+}
+
+type sanitizedGenerator struct {
+	Generator
+	params []string
+}
+
+func (g *sanitizedGenerator) Stream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error) {
+	for _, name := range g.params {
+		if sanitize, ok := sanitizers[name]; ok {
+			sanitize(&request)
+		}
+	}
+	return g.Generator.Stream(ctx, request)
+}
@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// 稀疏向量：词表 ID -> 词权重，如 bge-m3 输出的词法权重
+type SparseVector map[int]float32
+
+// 稀疏向量化接口
+type SparseEmbedder interface {
+	EmbedSparse(ctx context.Context, input []string) ([]SparseVector, error)
+}
+
+// 计算两个稀疏向量的点积
+func (v SparseVector) Dot(other SparseVector) float32 {
+	if len(other) < len(v) {
+		v, other = other, v
+	}
+	var sum float32
+	for k, w := range v {
+		sum += w * other[k]
+	}
+	return sum
+}
+
+// text-embeddings-inference 兼容的稀疏向量化实现，调用其 /embed_sparse 接口
+type HTTPSparseEmbedder struct {
+	url   string
+	token string
+}
+
+func NewHTTPSparseEmbedder(url, token string) *HTTPSparseEmbedder {
+	return &HTTPSparseEmbedder{url: url, token: token}
+}
+
+type sparseRequest struct {
+	Inputs   []string `json:"inputs"`
+	Truncate bool     `json:"truncate"`
+}
+
+type sparseValue struct {
+	Index int     `json:"index"`
+	Value float32 `json:"value"`
+}
+
+func (e *HTTPSparseEmbedder) EmbedSparse(ctx context.Context, input []string) ([]SparseVector, error) {
+	buf, err := json.Marshal(&sparseRequest{Inputs: input, Truncate: true})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg [][]sparseValue
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) != len(input) {
+		return nil, errors.New("sparse embedding length mismatch")
+	}
+
+	vectors := make([]SparseVector, len(msg))
+	for i, values := range msg {
+		v := make(SparseVector, len(values))
+		for _, value := range values {
+			v[value.Index] = value.Value
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
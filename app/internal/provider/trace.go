@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// W3C Trace Context 中的调用链上下文。网关作为调用方链路中的一个 span，
+// 上游请求以网关的 span 为父节点，便于调用方在自己的链路追踪系统中关联 lento 的活动
+type Trace struct {
+	TraceId string
+	// 网关自身的 span ID
+	SpanId string
+	// 调用方的 span ID
+	ParentId string
+	Flags    string
+	// 原样传递的 tracestate
+	State string
+}
+
+// 解析调用方的 traceparent 请求头（00-<trace-id>-<parent-id>-<flags>）并为网关生成新的 span ID，
+// 格式不合法或 ID 全为零时返回 false
+func ParseTraceparent(traceparent, tracestate string) (*Trace, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || parts[0] == "ff" || !isHex(parts[0], 2) || (parts[0] == "00" && len(parts) != 4) {
+		return nil, false
+	}
+	traceId, parentId, flags := parts[1], parts[2], parts[3]
+	if !isHex(traceId, 32) || !isHex(parentId, 16) || !isHex(flags, 2) ||
+		traceId == strings.Repeat("0", 32) || parentId == strings.Repeat("0", 16) {
+		return nil, false
+	}
+	return &Trace{TraceId: traceId, SpanId: newSpanId(), ParentId: parentId, Flags: flags, State: tracestate}, true
+}
+
+// 以网关的 span 为父节点的 traceparent，附加到上游请求和响应中
+func (t *Trace) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceId, t.SpanId, t.Flags)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newSpanId() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// 在上下文中记录调用链，经由该上下文发出的上游请求携带 traceparent 和 tracestate
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey, trace)
+}
+
+// 上下文中的调用链，调用方未传入 traceparent 时返回 nil
+func TraceFrom(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey).(*Trace)
+	return trace
+}
+
+// 上下文中调用链的 trace ID，没有时为空
+func TraceId(ctx context.Context) string {
+	if trace := TraceFrom(ctx); trace != nil {
+		return trace.TraceId
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// vLLM 原生 /generate 接口的生成实现。该接口按 \0 分隔输出累积的完整文本，
+// 这里将其转换为增量的 OpenAI 兼容 chunk
+type VLLMGenerator struct {
+	baseUrl string
+	token   string
+}
+
+func NewVLLMGenerator(baseUrl, token string) *VLLMGenerator {
+	return &VLLMGenerator{baseUrl: strings.TrimSuffix(baseUrl, "/"), token: token}
+}
+
+type vllmGenerateRequest struct {
+	Prompt      string   `json:"prompt"`
+	Stream      bool     `json:"stream"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+type vllmGenerateResponse struct {
+	Text []string `json:"text"`
+}
+
+// 原生接口不套用对话模板，按角色逐条拼接消息作为提示词。最后一条是助手消息时为预填充，由模型接着生成
+func vllmPrompt(messages []openai.ChatCompletionMessage) string {
+	var sb strings.Builder
+	for i, msg := range messages {
+		if i == len(messages)-1 && msg.Role == openai.ChatMessageRoleAssistant {
+			fmt.Fprintf(&sb, "%s: %s", msg.Role, msg.Content)
+			return sb.String()
+		}
+		fmt.Fprintf(&sb, "%s: %s\n\n", msg.Role, msg.Content)
+	}
+	sb.WriteString("assistant: ")
+	return sb.String()
+}
+
+func (g *VLLMGenerator) Stream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error) {
+	req := vllmGenerateRequest{
+		Prompt:    vllmPrompt(request.Messages),
+		Stream:    true,
+		MaxTokens: request.MaxTokens,
+		Seed:      request.Seed,
+	}
+	if request.Temperature != 0 {
+		req.Temperature = &request.Temperature
+	}
+	if request.TopP != 0 {
+		req.TopP = &request.TopP
+	}
+
+	resp, err := postJSON(ctx, g.baseUrl+"/generate", g.token, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 4*1024*1024)
+	scanner.Split(splitNull)
+	return &vllmStream{
+		body:    resp.Body,
+		scanner: scanner,
+		id:      NewChunkId(),
+		model:   request.Model,
+		prompt:  req.Prompt,
+	}, nil
+}
+
+type vllmStream struct {
+	body     io.ReadCloser
+	scanner  *bufio.Scanner
+	id       string
+	model    string
+	prompt   string
+	sent     int
+	finished bool
+}
+
+func (s *vllmStream) Recv() ([]byte, error) {
+	for s.scanner.Scan() {
+		part := bytes.TrimSpace(s.scanner.Bytes())
+		if len(part) == 0 {
+			continue
+		}
+
+		var msg vllmGenerateResponse
+		err := json.Unmarshal(part, &msg)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.Text) == 0 {
+			continue
+		}
+
+		// 输出的文本包含提示词，去掉提示词和已发送的部分得到增量
+		text := strings.TrimPrefix(msg.Text[0], s.prompt)
+		if len(text) <= s.sent {
+			continue
+		}
+		delta := text[s.sent:]
+		s.sent = len(text)
+		return NewChunk(s.id, s.model, delta, "")
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !s.finished {
+		s.finished = true
+		return NewChunk(s.id, s.model, "", string(openai.FinishReasonStop))
+	}
+	return nil, io.EOF
+}
+
+func (s *vllmStream) Close() error {
+	return s.body.Close()
+}
+
+// 按 \0 分隔的 bufio.SplitFunc
+func splitNull(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
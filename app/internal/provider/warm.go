@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// 可预热连接的生成后端。预热请求建立的 TCP/TLS 连接回到公共 HTTP 客户端的连接池，
+// 随后的生成请求直接复用，省去握手时间
+type Warmer interface {
+	Warm(ctx context.Context) error
+}
+
+// 向上游发送一个轻量的 GET 请求并读完响应体，使连接可以复用；只要建立了连接就不关心响应状态
+func warmConnection(ctx context.Context, url, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	return err
+}
+
+func (g *OpenAIGenerator) Warm(ctx context.Context) error {
+	return warmConnection(ctx, g.baseUrl+"/models", g.token)
+}
+
+func (g *OllamaGenerator) Warm(ctx context.Context) error {
+	return warmConnection(ctx, g.baseUrl+"/api/version", g.token)
+}
+
+func (g *VLLMGenerator) Warm(ctx context.Context) error {
+	return warmConnection(ctx, g.baseUrl+"/health", g.token)
+}
@@ -0,0 +1,69 @@
+package retrieval
+
+import (
+	"sync"
+	"time"
+)
+
+// 延迟滑动平均的平滑系数
+const latencyAlpha = 0.2
+
+// 根据后端延迟和并发请求数自适应调整候选数量，负载越高候选集越小，
+// 取值范围为 [min, 配置值]
+type adaptiveTopN struct {
+	mu          sync.Mutex
+	target      time.Duration
+	maxInflight int
+	inflight    int
+	latency     time.Duration
+}
+
+func newAdaptiveTopN(target time.Duration, maxInflight int) *adaptiveTopN {
+	return &adaptiveTopN{target: target, maxInflight: maxInflight}
+}
+
+// 开始一次检索，返回本次使用的候选数量
+func (a *adaptiveTopN) acquire(topEmb, minEmb, topRerank, minRerank int) (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inflight++
+
+	// 负载压力取延迟和并发两者中较大的一个，压力不超过 1 时使用配置值
+	pressure := 0.0
+	if a.target > 0 {
+		pressure = float64(a.latency) / float64(a.target)
+	}
+	if a.maxInflight > 0 {
+		pressure = max(pressure, float64(a.inflight)/float64(a.maxInflight))
+	}
+	if pressure <= 1 {
+		return topEmb, topRerank
+	}
+
+	scale := 1 / pressure
+	return scaleTopN(topEmb, minEmb, scale), scaleTopN(topRerank, minRerank, scale)
+}
+
+// 结束一次检索，记录召回和重排序的耗时
+func (a *adaptiveTopN) release(elapsed time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inflight--
+	if elapsed <= 0 {
+		return
+	}
+	if a.latency == 0 {
+		a.latency = elapsed
+	} else {
+		a.latency = time.Duration(latencyAlpha*float64(elapsed) + (1-latencyAlpha)*float64(a.latency))
+	}
+}
+
+func scaleTopN(top, low int, scale float64) int {
+	if low <= 0 || low > top {
+		low = min(1, top)
+	}
+	return low + int(float64(top-low)*scale)
+}
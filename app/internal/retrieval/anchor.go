@@ -0,0 +1,126 @@
+package retrieval
+
+import (
+	"strings"
+	"unicode"
+
+	"rag_app/internal/index"
+)
+
+// 引用在源文档中的位置，前端据此跳转到具体章节
+type Anchor struct {
+	// 最相关片段所在章节的标题路径，从一级标题开始
+	Headings []string `json:"headings,omitempty"`
+	// 最相关片段在 markdown 源文件中的起止行号，从 1 开始
+	StartLine int `json:"start_line"`
+	EndLine   int `json:"end_line"`
+	// 所在章节标题的 GitHub 风格锚点，文档没有标题时为空
+	Fragment string `json:"fragment,omitempty"`
+}
+
+// 选用文档中与问题最相似的片段的位置，未建立片段索引的文档不在其中
+func (p *Pipeline) citationAnchors(docs []*index.Document, queries [][]float32) map[int]*Anchor {
+	if queries == nil {
+		return nil
+	}
+	anchors := make(map[int]*Anchor, len(docs))
+	for _, doc := range docs {
+		route, query := p.routeOf(doc.DocId, queries)
+		if route == nil {
+			continue
+		}
+		spans := route.store.TopChunks(doc, query, 1)
+		if len(spans) == 0 {
+			continue
+		}
+		anchors[doc.DocId] = anchorOf(doc.Content, spans[0])
+	}
+	return anchors
+}
+
+// 计算片段的行号范围及其所在章节。片段以标题开头时该标题即所在章节，代码块中的 # 不视为标题
+func anchorOf(content string, span index.Span) *Anchor {
+	runes := []rune(content)
+	before := string(runes[:span.Start])
+	chunk := strings.TrimRight(string(runes[span.Start:span.End]), "\n")
+	a := &Anchor{StartLine: strings.Count(before, "\n") + 1}
+	a.EndLine = a.StartLine + strings.Count(chunk, "\n")
+
+	// 扫描到片段的第一行为止，维护各级标题
+	lines := strings.Split(content, "\n")
+	levels := [6]string{}
+	fenced := false
+	for _, line := range lines[:min(a.StartLine, len(lines))] {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		level, text := headingOf(trimmed)
+		if level == 0 {
+			continue
+		}
+		levels[level-1] = text
+		for i := level; i < len(levels); i++ {
+			levels[i] = ""
+		}
+	}
+	for _, h := range levels {
+		if h != "" {
+			a.Headings = append(a.Headings, h)
+		}
+	}
+	if len(a.Headings) > 0 {
+		a.Fragment = slugify(a.Headings[len(a.Headings)-1])
+	}
+	return a
+}
+
+// ATX 风格标题的级别和文字，不是标题时级别为 0
+func headingOf(line string) (int, string) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ' && line[level] != '\t') {
+		return 0, ""
+	}
+	text := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(line[level:]), "#"))
+	if text == "" {
+		return 0, ""
+	}
+	return level, text
+}
+
+// 按 GitHub 的规则生成标题锚点：转为小写，去掉标点，空格换成连字符
+func slugify(heading string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(heading) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// 带章节锚点的文档链接，文档没有链接、链接已含锚点或没有章节时返回空
+func deepLink(url string, anchor *Anchor) string {
+	if url == "" || anchor == nil || anchor.Fragment == "" || strings.Contains(url, "#") {
+		return ""
+	}
+	return url + "#" + anchor.Fragment
+}
+
+// 引用的跳转地址，优先使用带章节锚点的链接
+func (c Citation) Href() string {
+	if c.Link != "" {
+		return c.Link
+	}
+	return c.URL
+}
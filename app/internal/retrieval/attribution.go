@@ -0,0 +1,117 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"rag_app/internal/index"
+)
+
+// 回答中一句话的来源归属：与之最相似的文档片段及相似度
+type Attribution struct {
+	Sentence string  `json:"sentence"`
+	Score    float32 `json:"score"`
+	DocId    int     `json:"doc_id"`
+	// 对应文档在引用列表中的序号，从 1 开始
+	Citation int `json:"citation"`
+}
+
+// 逐句计算回答与检索到的文档片段之间的向量相似度，供界面标出缺乏依据的句子
+func (p *Pipeline) Attribute(ctx context.Context, answer string, docs []*index.Document) ([]Attribution, error) {
+	if p.embedder == nil {
+		return nil, errors.New("attribution requires an embedding model")
+	}
+	sentences := splitSentences(answer)
+	if len(sentences) == 0 || len(docs) == 0 {
+		return []Attribution{}, nil
+	}
+
+	var texts []string
+	var owners []int
+	for i, doc := range docs {
+		for _, text := range index.SplitChunks(doc.Content, p.cfg.ChunkSize) {
+			texts = append(texts, text)
+			owners = append(owners, i)
+		}
+	}
+	if len(texts) == 0 {
+		return []Attribution{}, nil
+	}
+
+	embs, err := p.embedder.Embed(ctx, append(sentences, texts...))
+	if err != nil {
+		return nil, err
+	}
+	if len(embs) != len(sentences)+len(texts) {
+		return nil, fmt.Errorf("embedding length mismatch")
+	}
+	sentenceEmbs, chunkEmbs := embs[:len(sentences)], embs[len(sentences):]
+
+	attributions := make([]Attribution, len(sentences))
+	for i, sentence := range sentences {
+		best, bestScore := 0, float32(math.Inf(-1))
+		for j, emb := range chunkEmbs {
+			score := cosine(sentenceEmbs[i], emb)
+			if score > bestScore {
+				best, bestScore = j, score
+			}
+		}
+		doc := docs[owners[best]]
+		attributions[i] = Attribution{
+			Sentence: sentence,
+			Score:    bestScore,
+			DocId:    doc.DocId,
+			Citation: owners[best] + 1,
+		}
+	}
+	return attributions, nil
+}
+
+// 按中英文句末标点和换行切分句子，忽略不含文字的片段
+func splitSentences(text string) []string {
+	var sentences []string
+	var sb strings.Builder
+	flush := func() {
+		s := strings.TrimSpace(sb.String())
+		sb.Reset()
+		if strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			sentences = append(sentences, s)
+		}
+	}
+	runes := []rune(text)
+	for i, r := range runes {
+		if r == '\n' {
+			flush()
+			continue
+		}
+		sb.WriteRune(r)
+		switch r {
+		case '。', '！', '？', '；', '!', '?':
+			flush()
+		case '.':
+			// 仅在其后为空白或文本结束时视为句号，避免切开小数和网址
+			if i+1 == len(runes) || unicode.IsSpace(runes[i+1]) {
+				flush()
+			}
+		}
+	}
+	flush()
+	return sentences
+}
+
+func cosine(a, b []float32) float32 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / math.Sqrt(na*nb))
+}
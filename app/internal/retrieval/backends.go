@@ -0,0 +1,112 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"rag_app/internal/provider"
+)
+
+// 单个后端检查的超时时间
+const backendCheckTimeout = 10 * time.Second
+
+// 一个后端的连通性检查结果
+type BackendCheck struct {
+	// llm、embedding、rerank 或 sparse
+	Backend string `json:"backend"`
+	Model   string `json:"model,omitempty"`
+	// 使用该后端的模型别名或集合，为空表示全局配置
+	Scope string `json:"scope,omitempty"`
+	Ok    bool   `json:"ok"`
+	// 模型是否出现在后端的模型列表中，后端不支持列出模型时为空
+	Listed    *bool   `json:"listed,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// 以一个极小的请求检查后端，记录耗时和错误
+func RunBackendCheck(ctx context.Context, check BackendCheck, call func(ctx context.Context) error) BackendCheck {
+	ctx, cancel := context.WithTimeout(ctx, backendCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	err := call(ctx)
+	check.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	check.Ok = err == nil
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// 并发检查流水线使用的向量化、重排序和稀疏向量后端
+func (p *Pipeline) CheckBackends(ctx context.Context) []BackendCheck {
+	type task struct {
+		check BackendCheck
+		call  func(ctx context.Context) error
+	}
+	tasks := []task{}
+	for _, route := range p.routes {
+		if route.embedder == nil {
+			continue
+		}
+		embedder := route.embedder
+		tasks = append(tasks, task{
+			check: BackendCheck{Backend: "embedding", Model: route.model, Scope: strings.Join(route.collections, ",")},
+			call: func(ctx context.Context) error {
+				vectors, err := embedder.Embed(ctx, []string{"ping"})
+				if err == nil && (len(vectors) != 1 || len(vectors[0]) == 0) {
+					err = errors.New("empty embedding")
+				}
+				return err
+			},
+		})
+	}
+	rerankCheck := func(scope string, reranker provider.Reranker) {
+		// 未配置重排序模型时不检查
+		r, ok := reranker.(*provider.HTTPReranker)
+		if !ok {
+			return
+		}
+		tasks = append(tasks, task{
+			check: BackendCheck{Backend: "rerank", Model: r.Model(), Scope: scope},
+			call: func(ctx context.Context) error {
+				_, err := reranker.Rerank(ctx, "ping", []string{"ping"}, 1)
+				return err
+			},
+		})
+	}
+	rerankCheck("", p.reranker)
+	collections := []string{}
+	for name := range p.collectionRerankers {
+		collections = append(collections, name)
+	}
+	slices.Sort(collections)
+	for _, name := range collections {
+		rerankCheck(name, p.collectionRerankers[name])
+	}
+	if p.sparseEmbedder != nil {
+		tasks = append(tasks, task{
+			check: BackendCheck{Backend: "sparse"},
+			call: func(ctx context.Context) error {
+				_, err := p.sparseEmbedder.EmbedSparse(ctx, []string{"ping"})
+				return err
+			},
+		})
+	}
+
+	checks := make([]BackendCheck, len(tasks))
+	var wg sync.WaitGroup
+	for i, t := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks[i] = RunBackendCheck(ctx, t.check, t.call)
+		}()
+	}
+	wg.Wait()
+	return checks
+}
@@ -0,0 +1,201 @@
+package retrieval
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rag_app/internal/index"
+)
+
+// 向量检索基准测试的参数
+type BenchOptions struct {
+	// 合成查询的条数
+	Queries int
+	// 并发执行查询的协程数
+	Concurrency int
+	// 测试的召回数量
+	TopEmb []int
+	// IVF 的簇数，为 0 时取文档数的平方根
+	Lists int
+	// IVF 每次查询扫描的簇数
+	Probes []int
+	// 合成查询相对文档摘要向量的噪声幅度，为噪声向量与原向量的长度之比
+	Noise float64
+}
+
+// 一种检索方式在一个召回数量下的测试结果
+type BenchResult struct {
+	// exact 或 ivf
+	Method string `json:"method"`
+	TopEmb int    `json:"top_emb"`
+	Probes int    `json:"nprobe,omitempty"`
+	// 每秒完成的查询数
+	QPS   float64 `json:"qps"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	// 与精确检索结果的平均重合比例，精确检索为 1
+	Recall float64 `json:"recall"`
+}
+
+// 一个向量化模型的索引的测试结果
+type BenchIndex struct {
+	Model      string        `json:"model"`
+	Documents  int           `json:"documents"`
+	Dimensions int           `json:"dimensions"`
+	Lists      int           `json:"nlist"`
+	Results    []BenchResult `json:"results"`
+}
+
+type BenchReport struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Queries     int          `json:"queries"`
+	Concurrency int          `json:"concurrency"`
+	Indexes     []BenchIndex `json:"indexes"`
+}
+
+// 对各向量化模型的索引分别测试精确检索和 IVF 近似检索的吞吐量、延迟分位数和召回率。
+// 查询向量由随机选取的文档摘要向量加上高斯噪声合成，不调用向量化服务，结果只反映向量检索本身的开销
+func (p *Pipeline) BenchmarkSearch(opts BenchOptions) (*BenchReport, error) {
+	if len(p.routes) == 0 {
+		return nil, errors.New("retrieval benchmark requires dense retrieval")
+	}
+	if opts.Queries <= 0 || opts.Concurrency <= 0 || len(opts.TopEmb) == 0 {
+		return nil, errors.New("queries, concurrency and top_emb must be positive")
+	}
+
+	report := &BenchReport{GeneratedAt: time.Now().UTC(), Queries: opts.Queries, Concurrency: opts.Concurrency, Indexes: []BenchIndex{}}
+	for _, route := range p.routes {
+		x, ok := route.store.(*index.Index)
+		if !ok {
+			continue
+		}
+		_, vectors := x.Vectors()
+		if len(vectors) == 0 {
+			continue
+		}
+		queries := syntheticQueries(vectors, opts.Queries, opts.Noise)
+		lists := opts.Lists
+		if lists <= 0 {
+			lists = int(math.Ceil(math.Sqrt(float64(len(vectors)))))
+		}
+		ivf := index.NewIVF(x, lists)
+		bench := BenchIndex{Model: route.model, Documents: len(vectors), Dimensions: len(vectors[0]), Lists: ivf.Lists(), Results: []BenchResult{}}
+
+		for _, topN := range opts.TopEmb {
+			exact, result, err := runBench(queries, opts.Concurrency, func(q []float32) ([]index.Hit, error) {
+				return x.Search(q, topN, nil)
+			})
+			if err != nil {
+				return nil, err
+			}
+			result.Method, result.TopEmb, result.Recall = "exact", topN, 1
+			bench.Results = append(bench.Results, result)
+
+			for _, nprobe := range opts.Probes {
+				approx, result, err := runBench(queries, opts.Concurrency, func(q []float32) ([]index.Hit, error) {
+					return ivf.Search(q, topN, nprobe)
+				})
+				if err != nil {
+					return nil, err
+				}
+				result.Method, result.TopEmb, result.Probes = "ivf", topN, nprobe
+				result.Recall = benchRecall(exact, approx)
+				bench.Results = append(bench.Results, result)
+			}
+		}
+		report.Indexes = append(report.Indexes, bench)
+	}
+	return report, nil
+}
+
+// 以固定种子合成查询向量：随机选取文档摘要向量，加上长度为其 noise 倍的高斯噪声
+func syntheticQueries(vectors [][]float32, n int, noise float64) [][]float32 {
+	rng := rand.New(rand.NewPCG(1, uint64(len(vectors))))
+	queries := make([][]float32, n)
+	for i := range queries {
+		v := vectors[rng.IntN(len(vectors))]
+		var sum float64
+		for _, f := range v {
+			sum += float64(f) * float64(f)
+		}
+		scale := noise * math.Sqrt(sum/float64(max(len(v), 1)))
+		q := make([]float32, len(v))
+		for d, f := range v {
+			q[d] = f + float32(rng.NormFloat64()*scale)
+		}
+		queries[i] = q
+	}
+	return queries
+}
+
+// 并发执行全部查询，返回各查询的结果和统计
+func runBench(queries [][]float32, concurrency int, search func(q []float32) ([]index.Hit, error)) ([][]index.Hit, BenchResult, error) {
+	hits := make([][]index.Hit, len(queries))
+	latencies := make([]time.Duration, len(queries))
+	errs := make([]error, len(queries))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range min(concurrency, len(queries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(queries) {
+					return
+				}
+				t := time.Now()
+				hits[i], errs[i] = search(queries[i])
+				latencies[i] = time.Since(t)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if err := errors.Join(errs...); err != nil {
+		return nil, BenchResult{}, err
+	}
+
+	slices.Sort(latencies)
+	return hits, BenchResult{
+		QPS:   float64(len(queries)) / elapsed.Seconds(),
+		P50Ms: percentileMs(latencies, 0.50),
+		P95Ms: percentileMs(latencies, 0.95),
+		P99Ms: percentileMs(latencies, 0.99),
+	}, nil
+}
+
+// 已排序的耗时的分位数（最近秩），单位为毫秒
+func percentileMs(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := max(int(math.Ceil(q*float64(len(sorted))))-1, 0)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// 近似结果中出现在精确结果里的文档比例，按查询平均
+func benchRecall(exact, approx [][]index.Hit) float64 {
+	total := 0.0
+	for i := range exact {
+		if len(exact[i]) == 0 {
+			total++
+			continue
+		}
+		found := 0
+		for _, hit := range approx[i] {
+			if slices.ContainsFunc(exact[i], func(h index.Hit) bool { return h.Doc.DocId == hit.Doc.DocId }) {
+				found++
+			}
+		}
+		total += float64(found) / float64(len(exact[i]))
+	}
+	return total / float64(len(exact))
+}
@@ -0,0 +1,147 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"rag_app/internal/index"
+)
+
+// 被屏蔽的文档
+type BlockEntry struct {
+	DocId     int       `json:"doc_id"`
+	Reason    string    `json:"reason,omitempty"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// 文档屏蔽列表，列表中的文档即使仍在源目录中也不会被检索到。
+// 配置了文件时每次修改立即写入，重建索引和重启后依然生效
+type Blocklist struct {
+	mu      sync.RWMutex
+	path    string
+	entries map[int]*BlockEntry
+}
+
+// 加载屏蔽列表，path 为空时仅保存在内存中
+func LoadBlocklist(path string) (*Blocklist, error) {
+	b := &Blocklist{path: path, entries: make(map[int]*BlockEntry)}
+	if path == "" {
+		return b, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+	var list []*BlockEntry
+	err = json.Unmarshal(buf, &list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, e := range list {
+		b.entries[e.DocId] = e
+	}
+	return b, nil
+}
+
+// 文档是否被屏蔽，b 为 nil 时总是返回 false
+func (b *Blocklist) Contains(docId int) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.entries[docId]
+	return ok
+}
+
+// 文档或其拆分自的原文档是否被屏蔽
+func (b *Blocklist) ContainsDocument(doc *index.Document) bool {
+	return b.Contains(doc.DocId) || (doc.SplitFrom != 0 && b.Contains(doc.SplitFrom))
+}
+
+func (b *Blocklist) List() []*BlockEntry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	list := make([]*BlockEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		list = append(list, e)
+	}
+	slices.SortFunc(list, func(x, y *BlockEntry) int { return x.DocId - y.DocId })
+	return list
+}
+
+// 屏蔽文档，已屏蔽时更新原因
+func (b *Blocklist) Add(docId int, reason string) (*BlockEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := &BlockEntry{DocId: docId, Reason: reason, BlockedAt: time.Now()}
+	prev := b.entries[docId]
+	b.entries[docId] = e
+	err := b.save()
+	if err != nil {
+		if prev != nil {
+			b.entries[docId] = prev
+		} else {
+			delete(b.entries, docId)
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+// 取消屏蔽，文档不在列表中时返回 false
+func (b *Blocklist) Remove(docId int) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev, ok := b.entries[docId]
+	if !ok {
+		return false, nil
+	}
+	delete(b.entries, docId)
+	err := b.save()
+	if err != nil {
+		b.entries[docId] = prev
+		return false, err
+	}
+	return true, nil
+}
+
+// 写入文件，先写临时文件再重命名；调用方需持有写锁
+func (b *Blocklist) save() error {
+	if b.path == "" {
+		return nil
+	}
+	list := make([]*BlockEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		list = append(list, e)
+	}
+	slices.SortFunc(list, func(x, y *BlockEntry) int { return x.DocId - y.DocId })
+	buf, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), b.path)
+}
@@ -0,0 +1,73 @@
+package retrieval
+
+import (
+	"slices"
+
+	"rag_app/internal/config"
+	"rag_app/internal/convert"
+	"rag_app/internal/index"
+	"rag_app/internal/ingest"
+)
+
+// 索引一致性检查的结果
+type CheckReport struct {
+	// 没有 error 级别的问题，strict 模式下还要求没有 warning
+	OK        bool            `json:"ok"`
+	Documents int             `json:"documents"`
+	Snapshots []string        `json:"snapshots"`
+	Errors    int             `json:"errors"`
+	Warnings  int             `json:"warnings"`
+	Problems  []index.Problem `json:"problems"`
+}
+
+// 离线检查文档清单和持久化的索引快照，不调用向量化服务，可用作部署前的检查
+func CheckIndex(cfg *config.Config, strict bool) (*CheckReport, error) {
+	converters, err := convert.Load(cfg.ConvertersFile)
+	if err != nil {
+		return nil, err
+	}
+	docs, problems := ingest.Check(cfg.MarkdownDir, cfg.SummaryFile, ingest.SizeLimit{MaxBytes: cfg.DocMaxBytes, Oversize: cfg.DocOversize}, converters)
+	report := &CheckReport{Documents: len(docs), Snapshots: []string{}, Problems: problems}
+
+	// BM25 模式和未配置快照时没有持久化的向量
+	if cfg.RetrievalMode == ModeDense && cfg.IndexSnapshot != "" {
+		key, err := loadSnapshotKey(cfg)
+		if err != nil {
+			return nil, err
+		}
+		groups, models, err := groupByModel(cfg, docs)
+		if err != nil {
+			return nil, err
+		}
+		for _, model := range models {
+			opts := index.BuildOptions{
+				Chunks:       cfg.RerankOn == RerankOnChunk,
+				ChunkSize:    cfg.ChunkSize,
+				ChunkOverlap: cfg.ChunkOverlap,
+				Model:        model,
+				Snapshot:     routeSnapshot(cfg.IndexSnapshot, model, len(models)),
+				Key:          key,
+			}
+			report.Snapshots = append(report.Snapshots, opts.Snapshot)
+			report.Problems = append(report.Problems, index.CheckSnapshot(groups[model], opts)...)
+		}
+	}
+
+	for _, problem := range report.Problems {
+		if problem.Severity == index.SeverityError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+	slices.SortStableFunc(report.Problems, func(a, b index.Problem) int {
+		if a.Severity == b.Severity {
+			return 0
+		} else if a.Severity == index.SeverityError {
+			return -1
+		}
+		return 1
+	})
+	report.OK = report.Errors == 0 && (!strict || report.Warnings == 0)
+	return report, nil
+}
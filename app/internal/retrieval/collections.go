@@ -0,0 +1,123 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"rag_app/internal/config"
+	"rag_app/internal/provider"
+)
+
+// 集合的向量化和重排序模型，未设置的项使用全局配置；后端地址为空时使用 EMB_BASE_URL
+type CollectionModels struct {
+	EmbeddingModel   string `json:"embedding_model"`
+	EmbeddingBaseUrl string `json:"embedding_base_url"`
+	EmbeddingToken   string `json:"embedding_token"`
+	RerankModel      string `json:"rerank_model"`
+	RerankBaseUrl    string `json:"rerank_base_url"`
+	RerankToken      string `json:"rerank_token"`
+}
+
+// 从 JSON 文件加载集合名到模型配置的映射。向量化模型按模型名共用子索引，
+// 因此同一模型只能对应一个后端
+func loadCollectionModels(cfg *config.Config) (map[string]*CollectionModels, error) {
+	collections := make(map[string]*CollectionModels)
+	path := cfg.CollectionModelsFile
+	if path == "" {
+		return collections, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &collections)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	backends := map[string]*CollectionModels{}
+	for name, cm := range collections {
+		if cm.EmbeddingModel == "" {
+			continue
+		}
+		if cfg.EmbProvider == EmbProviderLocal {
+			return nil, fmt.Errorf("%s: collection %q: embedding_model is not supported with EMB_PROVIDER=%s", path, name, EmbProviderLocal)
+		}
+		if cm.EmbeddingModel == cfg.ModelEmb && cm.EmbeddingBaseUrl != "" {
+			return nil, fmt.Errorf("%s: collection %q: MODEL_EMB %s cannot use another backend", path, name, cm.EmbeddingModel)
+		}
+		if other, ok := backends[cm.EmbeddingModel]; ok &&
+			(other.EmbeddingBaseUrl != cm.EmbeddingBaseUrl || other.EmbeddingToken != cm.EmbeddingToken) {
+			return nil, fmt.Errorf("%s: embedding model %s is configured with different backends", path, cm.EmbeddingModel)
+		}
+		backends[cm.EmbeddingModel] = cm
+	}
+	return collections, nil
+}
+
+// 创建子索引的向量化实现，集合为该模型指定了后端时使用该后端
+func newRouteEmbedder(cfg *config.Config, collections map[string]*CollectionModels, model string) (provider.Embedder, error) {
+	for _, cm := range collections {
+		if cm.EmbeddingModel == model && cm.EmbeddingBaseUrl != "" {
+			return provider.NewOpenAIEmbedder(cm.EmbeddingBaseUrl, cm.EmbeddingToken, model), nil
+		}
+	}
+	return newEmbedder(cfg, model)
+}
+
+// 指定了向量化模型的集合，按名称排序
+func collectionsOf(collections map[string]*CollectionModels, model string) []string {
+	names := []string{}
+	for name, cm := range collections {
+		if cm.EmbeddingModel == model {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// 为指定了重排序模型的集合创建重排序实现
+func newCollectionRerankers(cfg *config.Config, collections map[string]*CollectionModels) map[string]provider.Reranker {
+	rerankers := map[string]provider.Reranker{}
+	for name, cm := range collections {
+		if cm.RerankModel == "" {
+			continue
+		}
+		baseUrl, token := cfg.EmbBaseUrl, cfg.EmbToken
+		if cm.RerankBaseUrl != "" {
+			baseUrl, token = cm.RerankBaseUrl, cm.RerankToken
+		}
+		rerankers[name] = provider.NewHTTPReranker(baseUrl, token, cm.RerankModel)
+	}
+	return rerankers
+}
+
+// 检索指定集合时使用该集合的重排序模型；不限集合时各集合的分数不可比，使用全局重排序模型
+func (p *Pipeline) rerankerFor(collection string) provider.Reranker {
+	if r, ok := p.collectionRerankers[collection]; ok {
+		return r
+	}
+	return p.reranker
+}
+
+// 检索指定集合时只需要该集合所用模型的子索引，返回 nil 表示需要全部子索引
+func (p *Pipeline) routesFor(collection string) []bool {
+	if collection == "" || len(p.routes) <= 1 {
+		return nil
+	}
+	needed := make([]bool, len(p.routes))
+	found := false
+	for i, route := range p.routes {
+		if slices.Contains(route.collections, collection) {
+			needed[i], found = true, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return needed
+}
@@ -0,0 +1,149 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"rag_app/internal/index"
+	"rag_app/internal/logging"
+	"rag_app/internal/tokens"
+)
+
+// 对比模式下单次最多对比的文档数
+const MaxCompareDocs = 5
+
+// 对比模式下每篇文档放入提示词的片段数，PROMPT_CHUNKS 更大时使用后者
+const compareChunks = 3
+
+// 对比模式下未配置上下文上限时，每篇文档内容的 token 上限
+const compareDocTokens = 2000
+
+// 要对比的文档不存在、有歧义或数量不对，属于请求错误
+var ErrCompareDocuments = errors.New("invalid documents to compare")
+
+// 按文档 ID 和标题查找要对比的文档。标题先按全文匹配（不区分大小写），
+// 没有时按包含匹配，匹配到多篇时报错。不可检索的文档视为不存在
+func (p *Pipeline) ResolveDocuments(ids []int, titles []string, collection string, blocklist *Blocklist) ([]int, error) {
+	now := time.Now()
+	visible := func(doc *index.Document) bool {
+		return !doc.Expired(now) && !doc.Blocked && !blocklist.ContainsDocument(doc) &&
+			(collection == "" || doc.Collection == collection)
+	}
+
+	docIds := []int{}
+	add := func(docId int) {
+		if !slices.Contains(docIds, docId) {
+			docIds = append(docIds, docId)
+		}
+	}
+	for _, id := range ids {
+		doc, ok := p.store.Get(id)
+		if !ok || !visible(doc) {
+			return nil, fmt.Errorf("%w: document %d not found", ErrCompareDocuments, id)
+		}
+		add(id)
+	}
+
+	docs := p.store.Documents()
+	for _, title := range titles {
+		var exact, partial []*index.Document
+		for _, doc := range docs {
+			if !visible(doc) {
+				continue
+			}
+			if strings.EqualFold(doc.Title, title) {
+				exact = append(exact, doc)
+			} else if strings.Contains(strings.ToLower(doc.Title), strings.ToLower(title)) {
+				partial = append(partial, doc)
+			}
+		}
+		matches := exact
+		if len(matches) == 0 {
+			matches = partial
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("%w: no document titled %q", ErrCompareDocuments, title)
+		case 1:
+			add(matches[0].DocId)
+		default:
+			found := []string{}
+			for _, doc := range matches[:min(len(matches), 5)] {
+				found = append(found, fmt.Sprintf("%d「%s」", doc.DocId, doc.Title))
+			}
+			return nil, fmt.Errorf("%w: title %q matches %d documents: %s", ErrCompareDocuments, title, len(matches), strings.Join(found, ", "))
+		}
+	}
+
+	if len(docIds) < 2 || len(docIds) > MaxCompareDocs {
+		return nil, fmt.Errorf("%w: compare needs 2 to %d distinct documents, got %d", ErrCompareDocuments, MaxCompareDocs, len(docIds))
+	}
+	return docIds, nil
+}
+
+// 对比模式：不做召回和重排序，直接从每篇指定文档中取与问题最相关的片段。
+// 上下文 token 上限在文档间均分，保证每篇文档都出现在提示词中
+func (p *Pipeline) compare(ctx context.Context, req *Request, question string) (*Result, error) {
+	logging.Infof("compare docs: %v\n", req.CompareDocIds)
+	timings := []Timing{}
+	docs := []*index.Document{}
+	for _, docId := range req.CompareDocIds {
+		doc, ok := p.store.Get(docId)
+		if !ok {
+			return nil, fmt.Errorf("%w: document %d not found", ErrCompareDocuments, docId)
+		}
+		docs = append(docs, doc)
+	}
+
+	var queries [][]float32
+	if p.lexical == nil {
+		start := time.Now()
+		var err error
+		queries, err = p.embedQuery(ctx, question, "")
+		if err != nil {
+			return nil, err
+		}
+		timings = append(timings, Timing{Name: "embed", Duration: time.Since(start)})
+	}
+
+	policy := p.policyFor(req.Model)
+	budget := compareDocTokens
+	if policy.MaxContextTokens > 0 {
+		budget = policy.MaxContextTokens / len(docs)
+	}
+	contents := make(map[int]string, len(docs))
+	candidates := make([]Candidate, len(docs))
+	for i, doc := range docs {
+		candidates[i] = Candidate{DocId: doc.DocId, Title: doc.Title, Selected: true}
+		content := doc.Content
+		if p.lexical != nil {
+			if chunk, ok := p.lexical.BestChunkText(doc, question); ok {
+				content = chunk
+			}
+		} else if route, query := p.routeOf(doc.DocId, queries); route != nil {
+			if spans := route.store.TopChunks(doc, query, max(p.cfg.PromptChunks, compareChunks)); len(spans) > 0 {
+				runes := []rune(doc.Content)
+				parts := []string{}
+				for _, s := range index.MergeSpans(spans) {
+					parts = append(parts, string(runes[s.Start:s.End]))
+				}
+				content = strings.Join(parts, "\n……\n")
+			}
+		}
+		contents[doc.DocId] = tokens.Truncate(content, budget)
+	}
+
+	content, _, _ := formatDocuments(docs, contents, 0, nil)
+	return &Result{
+		Content:    content,
+		DocIds:     slices.Clone(req.CompareDocIds),
+		Docs:       docs,
+		Timings:    timings,
+		Candidates: candidates,
+		contents:   contents,
+	}, nil
+}
@@ -0,0 +1,25 @@
+package retrieval
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 流水线各阶段的错误，以 errors.Is 判断失败的阶段，原始错误仍可以 errors.Is / errors.As 取得
+var (
+	// 从对话中提取检索问题失败
+	ErrRewriteFailed = errors.New("question rewrite failed")
+	// 向量化后端调用失败
+	ErrEmbeddingBackend = errors.New("embedding backend failed")
+	// 重排序后端调用失败，且未开启降级
+	ErrRerankBackend = errors.New("rerank backend failed")
+	// 未检索到相关文档，仅在开启 NO_DOCS_ERROR 时返回
+	ErrNoRelevantDocs = errors.New("no relevant documents")
+	// 提示词超出模型的上下文窗口
+	ErrContextTooLarge = errors.New("context too large")
+)
+
+// 标记错误所属的阶段
+func stageError(stage, err error) error {
+	return fmt.Errorf("%w: %w", stage, err)
+}
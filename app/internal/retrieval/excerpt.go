@@ -0,0 +1,68 @@
+package retrieval
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"rag_app/internal/index"
+	"rag_app/internal/tokens"
+)
+
+// 返回按与问题的相关性排序的文档片段，用于单篇文档超出上下文上限时节选。
+// 未建立片段索引的文档按 CHUNK_SIZE 切分，按在文档中的顺序返回
+func (p *Pipeline) chunkRanker(queries [][]float32) func(doc *index.Document) []index.Span {
+	return func(doc *index.Document) []index.Span {
+		if queries != nil {
+			if route, query := p.routeOf(doc.DocId, queries); route != nil {
+				if spans := route.store.TopChunks(doc, query, math.MaxInt); len(spans) > 0 {
+					return spans
+				}
+			}
+		}
+		return index.SplitSpans(doc.Content, p.cfg.ChunkSize, 0)
+	}
+}
+
+// 在 maxTokens 以内以摘要和最相关的完整片段代替全文，片段按在文档中的位置排列。
+// 连摘要都放不下时返回 false
+func excerptDocument(doc *index.Document, spans []index.Span, maxTokens int) (string, bool) {
+	var b strings.Builder
+	b.WriteString("（文档过长，以下为摘要和与问题最相关的节选）\n")
+	if doc.Summary != "" {
+		b.WriteString("摘要：" + doc.Summary + "\n")
+	}
+	b.WriteString("节选：\n")
+	budget := maxTokens - tokens.Estimate(b.String())
+	if budget <= 0 {
+		return "", false
+	}
+
+	runes := []rune(doc.Content)
+	chosen := []index.Span{}
+	for _, s := range spans {
+		n := tokens.Estimate(string(runes[s.Start:s.End])) + 1
+		if n <= budget {
+			chosen = append(chosen, s)
+			budget -= n
+		}
+	}
+	parts := []string{}
+	for _, s := range index.MergeSpans(chosen) {
+		parts = append(parts, string(runes[s.Start:s.End]))
+	}
+	b.WriteString(strings.Join(parts, "\n……\n"))
+	return b.String(), true
+}
+
+// 第一篇文档被节选时返回其 ID
+func excerptedIds(docs []*index.Document, excerpted bool) []int {
+	if !excerpted || len(docs) == 0 {
+		return nil
+	}
+	return []int{docs[0].DocId}
+}
+
+func excerptWarning(doc *index.Document, maxTokens int) string {
+	return fmt.Sprintf("doc %d exceeds context limit of %d tokens, replaced by its summary and most relevant chunks", doc.DocId, maxTokens)
+}
@@ -0,0 +1,114 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// 术语表：内部缩写及其全称，在向量化和重排序之前补充到问题中
+type glossary struct {
+	terms []glossaryTerm
+}
+
+type glossaryTerm struct {
+	term      string
+	lower     string
+	expansion string
+}
+
+// 从 JSON 文件加载术语表，如 {"OKR": "目标与关键成果", "SRE": "站点可靠性工程"}
+func loadGlossary(path string) (*glossary, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]string{}
+	err = json.Unmarshal(buf, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	g := &glossary{}
+	for term, expansion := range entries {
+		if term == "" || expansion == "" {
+			continue
+		}
+		g.terms = append(g.terms, glossaryTerm{term: term, lower: strings.ToLower(term), expansion: expansion})
+	}
+	// 按术语排序，保证扩展结果稳定
+	slices.SortFunc(g.terms, func(a, b glossaryTerm) int {
+		return strings.Compare(a.term, b.term)
+	})
+	return g, nil
+}
+
+// 在问题末尾追加其中出现的术语的全称，未命中任何术语时原样返回
+func (g *glossary) expand(question string) string {
+	if g == nil {
+		return question
+	}
+
+	lower := strings.ToLower(question)
+	expansions := []string{}
+	for _, t := range g.terms {
+		if containsTerm(lower, t.lower) && !strings.Contains(question, t.expansion) {
+			expansions = append(expansions, fmt.Sprintf("%s：%s", t.term, t.expansion))
+		}
+	}
+	if len(expansions) == 0 {
+		return question
+	}
+	return question + "（" + strings.Join(expansions, "；") + "）"
+}
+
+// 判断文本中是否包含术语；字母数字组成的术语要求前后不紧邻字母数字，避免匹配到单词内部
+func containsTerm(text, term string) bool {
+	for start := 0; ; {
+		i := strings.Index(text[start:], term)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(term)
+		if boundary(text, i, term, true) && boundary(text, end, term, false) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func boundary(text string, pos int, term string, before bool) bool {
+	var edge, neighbor rune
+	if before {
+		edge = []rune(term)[0]
+		if pos == 0 {
+			return true
+		}
+		r := []rune(text[:pos])
+		neighbor = r[len(r)-1]
+	} else {
+		r := []rune(term)
+		edge = r[len(r)-1]
+		if pos >= len(text) {
+			return true
+		}
+		neighbor = []rune(text[pos:])[0]
+	}
+	if !isWordRune(edge) {
+		return true
+	}
+	return !isWordRune(neighbor)
+}
+
+// 拉丁字母和数字，汉字之间没有空格分隔，不参与边界判断
+func isWordRune(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
@@ -0,0 +1,296 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"rag_app/internal/index"
+)
+
+// 问题归一化步骤
+const (
+	// 全角字母、数字和空格转为半角，中文标点保持不变
+	NormalizeWidth = "width"
+	// 繁体字转为简体字
+	NormalizeT2S = "t2s"
+	// 按纠错词表和语料词频纠正拼写
+	NormalizeSpelling = "spelling"
+)
+
+// 按语料词频纠正的拉丁单词的最小长度，更短的单词编辑距离为 1 的候选太多，容易误纠
+const minSpellingWord = 5
+
+// 常用繁体字及其简体字，每项为「繁简」两个字。只收录一一对应的字，一繁对多简的字（如「著」「乾」）不做转换
+const t2sPairs = `這这 個个 們们 來来 時时 說说 會会 對对 為为 國国 學学 後后 發发 開开 關关 問问 題题 當当 過过 還还
+進进 種种 樣样 經经 現现 實实 點点 動动 機机 體体 從从 長长 義义 電电 無无 與与 將将 應应 業业 產产
+書书 務务 號号 頭头 員员 見见 話话 語语 認认 識识 讀读 寫写 網网 絡络 線线 請请 謝谢 錯错 誤误 設设
+計计 費费 資资 訊讯 數数 據据 庫库 檔档 詢询 檢检 處处 統统 軟软 證证 驗验 權权 帳账 戶户 價价 買买
+賣卖 錢钱 單单 記记 錄录 項项 態态 變变 換换 轉转 離离 難难 歡欢 樂乐 聽听 聲声 視视 覺觉 親亲 東东
+車车 門门 間间 閱阅 隊队 陽阳 陰阴 雙双 雲云 雜杂 響响 頁页 順顺 須须 預预 領领 風风 飛飞 飯饭 馬马
+驅驱 鳥鸟 麼么 齊齐 歲岁 歷历 氣气 漢汉 灣湾 爭争 畫画 盡尽 監监 確确 礎础 禮礼 穩稳 積积 簡简 類类
+紅红 約约 級级 紀纪 納纳 純纯 紙纸 組组 細细 終终 結结 給给 絕绝 維维 緒绪 編编 緩缓 練练 總总 績绩
+續续 罰罚 習习 聯联 職职 腦脑 興兴 舉举 舊旧 藝艺 莊庄 葉叶 蘭兰 虛虚 補补 裝装 製制 複复 覽览 觀观
+規规 討讨 訓训 託托 訪访 許许 評评 試试 詳详 誌志 調调 談谈 論论 諮谘 講讲 謂谓 議议 護护 讓让 財财
+貨货 質质 購购 貼贴 貸贷 賬账 賽赛 贊赞 趨趋 跡迹 躍跃 軍军 載载 輕轻 輔辅 輸输 農农 連连 週周 運运
+達达 遠远 適适 遷迁 選选 遺遗 邊边 鄉乡 醫医 釋释 鐘钟 鑰钥 閉闭 閒闲 際际 隨随 險险 隱隐 雖虽 雞鸡
+靈灵 靜静 韓韩 頻频 顏颜 額额 顧顾 顯显 飲饮 館馆 髮发 鬥斗 魚鱼 黨党 齒齿 龍龙 廣广 張张 彈弹 復复
+憶忆 懷怀 戰战 戲戏 拋抛 掃扫 損损 擇择 擊击 擔担 擴扩 攝摄 敗败 斷断 曉晓 標标 樹树 橋桥 歐欧 殘残
+決决 沒没 況况 測测 湯汤 滿满 潔洁 濟济 災灾 烏乌 煙烟 熱热 營营 爾尔 牆墙 獨独 獎奖 環环 異异 療疗
+盜盗 礙碍 節节 範范 築筑 糧粮 緊紧 罷罢 聖圣 肅肃 腳脚 膚肤 臨临 華华 萬万 蓋盖 蘇苏 蟲虫 衛卫 衝冲
+裡里 觸触 訂订 診诊 該该 詞词 誰谁 課课 諾诺 謀谋 譯译 豐丰 貝贝 負负 責责 貴贵 賀贺 賓宾 賴赖 贏赢
+趕赶 軌轨 較较 輛辆 辦办 遞递 遊游 邏逻 郵邮 鄰邻 鋼钢 鍵键 鎖锁 鏈链 鏡镜 鐵铁 閃闪 頂顶 頓顿 頸颈
+願愿 鬆松 麵面 黃黄 優优 儲储 兒儿 內内 兩两 冊册 凍冻 則则 劃划 劇剧 劑剂 勞劳 勢势 勵励 區区 協协
+卻却 厲厉 參参 叢丛 啟启 喚唤 嚴严 團团 圍围 圖图 圓圆 執执 堅坚 報报 場场 塊块 塵尘 壓压 壞坏 夢梦
+夠够 奪夺 奮奋 媽妈 孫孙 寧宁 審审 寬宽 導导 屆届 屬属 島岛 帶带 幣币 幫帮 廢废 廳厅 強强 歸归 彙汇
+徑径 徵征 憂忧 慣惯 慮虑 憲宪 萊莱 裏里 釐厘 錶表 閘闸 儀仪 億亿 傳传 傷伤 僅仅 僱雇 倉仓 側侧 偵侦
+備备 傑杰 債债 償偿 創创 剛刚 勝胜 匯汇 啞哑 喪丧 嗎吗 噴喷 嘆叹 壽寿 奧奥 婦妇 嬰婴 寶宝 專专 尋寻
+層层 廠厂 彌弥 徹彻 愛爱 慶庆 憑凭 懶懒 戀恋 攜携 擬拟 擺摆 敵敌 斂敛 樓楼 殺杀 殼壳 滅灭 滬沪 漲涨
+潛潜 澤泽 濃浓 爐炉 犧牺 狀状 獵猎 瑣琐 畢毕 疊叠 瘋疯 癒愈 盤盘 眾众 矯矫 碼码 磚砖 禍祸 稅税 窮穷
+竊窃 競竞 筆笔 籃篮 籤签 緣缘 縣县 縮缩 繩绳 繼继 纜缆 罈坛 羅罗 聞闻 聰聪 膽胆 艦舰 莖茎 薦荐 藥药
+蘋苹 蝦虾 術术 襲袭 覓觅 訴诉 詐诈 誠诚 誕诞 誇夸 誘诱 諸诸 謊谎 讚赞 豬猪 貓猫 貿贸 賃赁 賠赔 賺赚
+贈赠 踐践 蹤踪 軸轴 輪轮 轎轿 辭辞 遜逊 鄭郑 釣钓 鈔钞 鉛铅 銀银 銷销 鋒锋 錦锦 鍋锅 鎮镇 鏟铲 鑄铸
+閣阁 闊阔 陣阵 陳陈 陸陆 隸隶 雛雏 霧雾 韻韵 頒颁 頗颇 顆颗 颱台 飄飘 餅饼 餓饿 駐驻 駕驾 騎骑 騙骗
+驚惊 骯肮 鬧闹 鮮鲜 鴨鸭 鵝鹅 鹽盐 麥麦 黴霉 齡龄 龜龟`
+
+// 问题归一化：全角转半角、繁体转简体、拼写纠错，在向量化和重排序之前执行
+type normalizer struct {
+	width bool
+	// 繁体字 -> 简体字，未开启时为 nil
+	t2s map[rune]rune
+	// 未开启拼写纠错时 spelling 为 false
+	spelling bool
+	// 纠错词表：拉丁单词按整词、不区分大小写匹配，其余按原文替换，较长的优先
+	wordFixes   map[string]string
+	phraseFixes []phraseFix
+	// 语料中拉丁单词（小写）的出现次数，用于编辑距离为 1 的纠错
+	vocabulary map[string]int
+}
+
+type phraseFix struct {
+	typo, fix string
+}
+
+// 归一化补充文件，如 {"t2s": {"臺": "台"}, "corrections": {"kubernetse": "kubernetes", "登陆": "登录"}}
+type normalizeFile struct {
+	T2S         map[string]string `json:"t2s"`
+	Corrections map[string]string `json:"corrections"`
+}
+
+// 按 QUERY_NORMALIZE 创建归一化，未配置任何步骤时返回 nil。拼写纠错的词频来自索引中的标题、摘要和内容
+func loadNormalizer(steps []string, path string, docs []*index.Document) (*normalizer, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	var file normalizeFile
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(buf, &file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	n := &normalizer{}
+	for _, step := range steps {
+		switch step {
+		case NormalizeWidth:
+			n.width = true
+		case NormalizeT2S:
+			n.t2s = builtinT2S()
+			for from, to := range file.T2S {
+				if utf8.RuneCountInString(from) != 1 || utf8.RuneCountInString(to) != 1 {
+					return nil, fmt.Errorf("%s: t2s entry %q: %q must map a single character", path, from, to)
+				}
+				f, _ := utf8.DecodeRuneInString(from)
+				t, _ := utf8.DecodeRuneInString(to)
+				n.t2s[f] = t
+			}
+		case NormalizeSpelling:
+			n.spelling = true
+			n.wordFixes = map[string]string{}
+			for typo, fix := range file.Corrections {
+				if typo == "" || fix == "" {
+					continue
+				}
+				if isLatinWord(typo) {
+					n.wordFixes[strings.ToLower(typo)] = fix
+				} else {
+					n.phraseFixes = append(n.phraseFixes, phraseFix{typo: typo, fix: fix})
+				}
+			}
+			slices.SortFunc(n.phraseFixes, func(a, b phraseFix) int {
+				if d := len(b.typo) - len(a.typo); d != 0 {
+					return d
+				}
+				return strings.Compare(a.typo, b.typo)
+			})
+			n.vocabulary = buildVocabulary(docs)
+		default:
+			return nil, fmt.Errorf("invalid QUERY_NORMALIZE: %q", step)
+		}
+	}
+	return n, nil
+}
+
+func builtinT2S() map[rune]rune {
+	table := map[rune]rune{}
+	for _, pair := range strings.Fields(t2sPairs) {
+		r := []rune(pair)
+		if len(r) == 2 && r[0] != r[1] {
+			table[r[0]] = r[1]
+		}
+	}
+	return table
+}
+
+// 统计文档中拉丁单词的出现次数
+func buildVocabulary(docs []*index.Document) map[string]int {
+	vocabulary := map[string]int{}
+	for _, doc := range docs {
+		for _, text := range []string{doc.Title, doc.Summary, doc.Content} {
+			for _, word := range latinWords(text) {
+				vocabulary[strings.ToLower(word)]++
+			}
+		}
+	}
+	return vocabulary
+}
+
+// 依次执行配置的归一化步骤，未开启时原样返回
+func (n *normalizer) normalize(question string) string {
+	if n == nil {
+		return question
+	}
+	if n.width {
+		question = halfWidth(question)
+	}
+	if n.t2s != nil {
+		question = strings.Map(func(r rune) rune {
+			if s, ok := n.t2s[r]; ok {
+				return s
+			}
+			return r
+		}, question)
+	}
+	if n.spelling {
+		question = n.correct(question)
+	}
+	return question
+}
+
+// 全角字母、数字转为半角，全角空格转为普通空格
+func halfWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '０' && r <= '９', r >= 'Ａ' && r <= 'Ｚ', r >= 'ａ' && r <= 'ｚ':
+			return r - 0xFEE0
+		}
+		return r
+	}, s)
+}
+
+// 先按纠错词表替换，再将语料中未出现的拉丁单词纠正为编辑距离为 1、出现最多的词
+func (n *normalizer) correct(question string) string {
+	for _, f := range n.phraseFixes {
+		question = strings.ReplaceAll(question, f.typo, f.fix)
+	}
+
+	var out strings.Builder
+	rest := question
+	for len(rest) > 0 {
+		start := strings.IndexFunc(rest, isWordRune)
+		if start < 0 {
+			out.WriteString(rest)
+			break
+		}
+		out.WriteString(rest[:start])
+		rest = rest[start:]
+		end := strings.IndexFunc(rest, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		out.WriteString(n.correctWord(rest[:end]))
+		rest = rest[end:]
+	}
+	return out.String()
+}
+
+func (n *normalizer) correctWord(word string) string {
+	lower := strings.ToLower(word)
+	if fix, ok := n.wordFixes[lower]; ok {
+		return fix
+	}
+	if len(lower) < minSpellingWord || !isLatinWord(lower) || n.vocabulary[lower] > 0 {
+		return word
+	}
+	best, count := "", 0
+	for _, candidate := range edits1(lower) {
+		c := n.vocabulary[candidate]
+		if c > count || (c == count && c > 0 && candidate < best) {
+			best, count = candidate, c
+		}
+	}
+	if count == 0 {
+		return word
+	}
+	// 保留首字母大写或全大写的写法
+	switch {
+	case word == strings.ToUpper(word):
+		return strings.ToUpper(best)
+	case word[0] >= 'A' && word[0] <= 'Z':
+		return strings.ToUpper(best[:1]) + best[1:]
+	}
+	return best
+}
+
+// 编辑距离为 1 的全部小写拼写：删除、相邻交换、替换和插入一个字母
+func edits1(word string) []string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	edits := []string{}
+	for i := 0; i <= len(word); i++ {
+		left, right := word[:i], word[i:]
+		if right != "" {
+			edits = append(edits, left+right[1:])
+			for _, c := range letters {
+				if byte(c) != right[0] {
+					edits = append(edits, left+string(c)+right[1:])
+				}
+			}
+		}
+		if len(right) > 1 {
+			edits = append(edits, left+right[1:2]+right[:1]+right[2:])
+		}
+		for _, c := range letters {
+			edits = append(edits, left+string(c)+right)
+		}
+	}
+	return edits
+}
+
+// 文本中连续的拉丁字母组成的单词
+func latinWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+}
+
+func isLatinWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
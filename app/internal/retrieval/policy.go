@@ -0,0 +1,79 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"rag_app/internal/provider"
+)
+
+// 默认策略的键，未单独配置的模型使用该策略
+const defaultPolicyKey = "*"
+
+// 按目标模型限制提示词中的文档数量和上下文长度，0 表示不限制。
+// ContextWindow 为模型的上下文窗口，生成前据此检查整个提示词的长度
+type ModelPolicy struct {
+	MaxDocs          int `json:"max_docs"`
+	MaxContextTokens int `json:"max_context_tokens"`
+	ContextWindow    int `json:"context_window"`
+	// 模型是否支持工具调用、图片输入和 json_schema 响应格式，未配置时视为支持，不做校验
+	Tools      *bool `json:"tools"`
+	Vision     *bool `json:"vision"`
+	JSONSchema *bool `json:"json_schema"`
+	// 上游不接受的请求参数，如 seed、stream_options，调用上游前去除
+	UnsupportedParams []string `json:"unsupported_params"`
+}
+
+// 模型是否具备某项能力，未配置时视为具备
+func Supports(capability *bool) bool {
+	return capability == nil || *capability
+}
+
+// 从 JSON 文件加载模型策略表，键为模型名
+func loadModelPolicies(path string) (map[string]*ModelPolicy, error) {
+	policies := make(map[string]*ModelPolicy)
+	if path == "" {
+		return policies, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &policies)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for model, policy := range policies {
+		err = provider.CheckUnsupportedParams(policy.UnsupportedParams)
+		if err != nil {
+			return nil, fmt.Errorf("%s: model %q: unsupported_params: %w", path, model, err)
+		}
+	}
+	return policies, nil
+}
+
+// 返回模型对应的策略，未配置时返回不限制的策略
+func (p *Pipeline) policyFor(model string) *ModelPolicy {
+	if policy, ok := p.policies[model]; ok {
+		return policy
+	}
+	if policy, ok := p.policies[defaultPolicyKey]; ok {
+		return policy
+	}
+	return &ModelPolicy{}
+}
+
+// 返回模型对应的策略，用于在请求到达上游之前按模型能力校验
+func (p *Pipeline) ModelPolicy(model string) ModelPolicy {
+	return *p.policyFor(model)
+}
+
+// 返回模型的上下文窗口，策略未配置时使用 CONTEXT_WINDOW，0 表示不检查
+func (p *Pipeline) ContextWindow(model string) int {
+	if window := p.policyFor(model).ContextWindow; window > 0 {
+		return window
+	}
+	return p.cfg.ContextWindow
+}
@@ -0,0 +1,167 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"rag_app/internal/index"
+)
+
+// 建立索引的进度阶段，除以下阶段外还有 index.ProgressSnapshot、index.ProgressSummaries 和 index.ProgressChunks
+const (
+	// 文档加载完成
+	ProgressLoaded = "loaded"
+	// 索引建立完成
+	ProgressIndexed = "indexed"
+)
+
+// 建立索引的进度
+type ProgressEvent struct {
+	Stage string `json:"stage"`
+	// 正在向量化的模型，与模型无关的阶段为空
+	Model string `json:"model,omitempty"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+type progressKey struct{}
+
+// 在上下文中设置进度回调，NewFromConfig 建立索引时通过它报告进度
+func WithProgress(ctx context.Context, fn func(ProgressEvent)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+type previousKey struct{}
+
+// 在上下文中设置当前的流水线，NewFromConfig 重建索引时复用其中未变化的摘要和片段的向量
+func WithPrevious(ctx context.Context, p *Pipeline) context.Context {
+	return context.WithValue(ctx, previousKey{}, p)
+}
+
+// 上下文中的流水线里使用该向量化模型的索引，没有时返回 nil
+func previousIndex(ctx context.Context, model string) *index.Index {
+	p, ok := ctx.Value(previousKey{}).(*Pipeline)
+	if !ok || p == nil {
+		return nil
+	}
+	for _, route := range p.routes {
+		if x, ok := route.store.(*index.Index); ok && route.model == model {
+			return x
+		}
+	}
+	return nil
+}
+
+func reportProgress(ctx context.Context, event ProgressEvent) {
+	if fn, ok := ctx.Value(progressKey{}).(func(ProgressEvent)); ok {
+		fn(event)
+	}
+}
+
+// 进度日志的最小间隔
+const progressLogInterval = 10 * time.Second
+
+// 建立索引的进度及估算的剩余时间。剩余时间只针对当前阶段，按当前阶段已完成的速度估算
+type ProgressStatus struct {
+	ProgressEvent
+	Running   bool      `json:"running"`
+	StartedAt time.Time `json:"started_at"`
+	Elapsed   string    `json:"elapsed"`
+	Percent   float64   `json:"percent"`
+	// 当前阶段每秒完成的条数
+	Rate       float64 `json:"rate"`
+	ETA        string  `json:"eta,omitempty"`
+	ETASeconds float64 `json:"eta_seconds,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// 跟踪一次索引重建的进度，计算速度和剩余时间，并定期打印日志，
+// 便于判断耗时很长的重建是卡住了还是仅仅比较慢
+type ProgressTracker struct {
+	mu      sync.Mutex
+	name    string
+	start   time.Time
+	last    ProgressEvent
+	running bool
+	end     time.Time
+	err     string
+	// 当前阶段第一次报告的时间和完成数
+	stageAt   time.Time
+	stageDone int
+	lastLog   time.Time
+}
+
+func NewProgressTracker(name string) *ProgressTracker {
+	return &ProgressTracker{name: name, start: time.Now(), running: true}
+}
+
+// 记录一次进度，返回带速度和剩余时间的进度
+func (t *ProgressTracker) Observe(event ProgressEvent) ProgressStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if event.Stage != t.last.Stage || event.Model != t.last.Model {
+		t.stageAt, t.stageDone = now, event.Done
+		t.lastLog = time.Time{}
+	}
+	t.last = event
+	status := t.status(now)
+	if now.Sub(t.lastLog) >= progressLogInterval || event.Done == event.Total {
+		t.lastLog = now
+		line := fmt.Sprintf("%s progress: %s", t.name, event.Stage)
+		if event.Model != "" {
+			line += fmt.Sprintf(" (%s)", event.Model)
+		}
+		line += fmt.Sprintf(" %d/%d (%.1f%%)", event.Done, event.Total, status.Percent)
+		if status.ETA != "" {
+			line += fmt.Sprintf(", %.1f/s, eta %s", status.Rate, status.ETA)
+		}
+		fmt.Println(line)
+	}
+	return status
+}
+
+// 标记重建结束，err 不为空表示失败
+func (t *ProgressTracker) Finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = false
+	t.end = time.Now()
+	if err != nil {
+		t.err = err.Error()
+	}
+}
+
+// 返回当前进度
+func (t *ProgressTracker) Status() ProgressStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status(time.Now())
+}
+
+func (t *ProgressTracker) status(now time.Time) ProgressStatus {
+	if !t.running {
+		now = t.end
+	}
+	status := ProgressStatus{
+		ProgressEvent: t.last,
+		Running:       t.running,
+		StartedAt:     t.start,
+		Elapsed:       now.Sub(t.start).Round(time.Second).String(),
+		Error:         t.err,
+	}
+	if t.last.Total > 0 {
+		status.Percent = float64(t.last.Done) * 100 / float64(t.last.Total)
+	}
+	elapsed := now.Sub(t.stageAt).Seconds()
+	if done := t.last.Done - t.stageDone; done > 0 && elapsed > 0 {
+		status.Rate = float64(done) / elapsed
+		remaining := float64(t.last.Total-t.last.Done) / status.Rate
+		status.ETASeconds = remaining
+		status.ETA = time.Duration(remaining * float64(time.Second)).Round(time.Second).String()
+	}
+	return status
+}
@@ -0,0 +1,88 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/index"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+)
+
+// 单篇文档相关性判断的超时时间
+const relevanceTimeout = 15 * time.Second
+
+// 用非推理模型逐篇判断文档是否与问题相关，过滤掉向量召回和重排序都误判的文档
+type relevanceChecker struct {
+	client *openai.Client
+	model  string
+}
+
+func newRelevanceChecker(baseUrl, token, model string) *relevanceChecker {
+	return &relevanceChecker{
+		client: provider.NewOpenAIClient(baseUrl, token),
+		model:  model,
+	}
+}
+
+// 返回判断为相关的文档下标，保持原有顺序。单篇判断失败时保留该文档
+func (r *relevanceChecker) filter(ctx context.Context, question string, docs []*index.Document) []int {
+	relevant := make([]bool, len(docs))
+	var wg sync.WaitGroup
+	for i, doc := range docs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := r.check(ctx, question, doc)
+			if err != nil {
+				logging.Warnf("relevance check doc %d: %v\n", doc.DocId, err)
+				ok = true
+			}
+			relevant[i] = ok
+		}()
+	}
+	wg.Wait()
+
+	kept := []int{}
+	for i, ok := range relevant {
+		if ok {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+func (r *relevanceChecker) check(ctx context.Context, question string, doc *index.Document) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, relevanceTimeout)
+	defer cancel()
+
+	response, err := r.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: r.model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "判断给出的文档是否有助于回答用户的问题，只回答“是”或“否”。",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("问题：%s\n\n文档标题：%s\n文档摘要：%s", question, doc.Title, doc.Summary),
+			},
+		},
+		MaxTokens: 4,
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(response.Choices) == 0 {
+		return false, errors.New("empty response")
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(response.Choices[0].Message.Content))
+	return !strings.HasPrefix(answer, "否") && !strings.HasPrefix(answer, "no"), nil
+}
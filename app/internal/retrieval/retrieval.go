@@ -0,0 +1,789 @@
+package retrieval
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"rag_app/internal/config"
+	"rag_app/internal/convert"
+	"rag_app/internal/index"
+	"rag_app/internal/ingest"
+	"rag_app/internal/lang"
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+	"rag_app/internal/tokens"
+)
+
+// 重排序所用的文本
+const (
+	RerankOnSummary = "summary"
+	RerankOnChunk   = "chunk"
+)
+
+// 召回方式
+const (
+	// 向量检索
+	ModeDense = "dense"
+	// 纯词法的 BM25 检索，不依赖向量化后端
+	ModeBM25 = "bm25"
+)
+
+// 流水线阶段，用于向调用方报告进度
+const (
+	StageRewriting  = "rewriting"
+	StageRetrieving = "retrieving"
+	StageReranking  = "reranking"
+	StageGenerating = "generating"
+)
+
+// 检索流水线：向量召回 + 重排序
+type Pipeline struct {
+	cfg      *config.Config
+	store    index.Store
+	embedder provider.Embedder
+	reranker provider.Reranker
+	// 集合名 -> 该集合指定的重排序模型
+	collectionRerankers map[string]provider.Reranker
+	policies            map[string]*ModelPolicy
+	adaptive            *adaptiveTopN
+	// 按语言划分的向量化模型及子索引，未配置路由时只有默认模型一项
+	routes []*embRoute
+	// BM25 模式下的词法索引，此时 routes 为空
+	lexical *index.BM25
+	// 重排序后的相关性复核，未开启时为 nil
+	relevance *relevanceChecker
+	// 术语表，未配置时为 nil
+	glossary *glossary
+	// 问题归一化，未配置时为 nil
+	normalizer *normalizer
+	// 快照加密密钥，未配置时为 nil
+	snapshotKey []byte
+	// 稀疏向量索引及其向量化后端，未配置时为 nil
+	sparse         *index.Sparse
+	sparseEmbedder provider.SparseEmbedder
+	// 缓存的索引版本，更新摘要后清空
+	indexVersion atomic.Pointer[string]
+	// 流水线及其索引的建立时间
+	builtAt time.Time
+}
+
+type Request struct {
+	Question string
+	// 同一会话中此前引用过的文档，会作为候选一并参与重排序
+	MemDocIds []int
+	// 仅检索指定集合中的文档，为空时不限制
+	Collection string
+	// 仅检索来源在列表中的文档，为空时不限制
+	Sources []string
+	// 生成回答所用的模型，用于查找提示词的文档数量和长度限制
+	Model string
+	// 阶段回调，可为空
+	OnStage func(stage string)
+	// 屏蔽列表，可为空
+	Blocklist *Blocklist
+	// 确定性模式：不按负载调整召回数量，重排序分数相同时按文档 ID 排序，保证结果可复现
+	Deterministic bool
+	// 对比模式：直接对比这些文档，不做召回和重排序
+	CompareDocIds []int
+	// 不按术语表扩展问题
+	NoExpansion bool
+	// HyDE：以模型生成的假设性回答代替问题召回文档，重排序仍使用问题；为空时以问题召回
+	Hypothetical string
+	// 每篇文档放入提示词的片段数，为 0 时使用 PROMPT_CHUNKS，小于 0 时放入全文
+	PromptChunks int
+}
+
+// 文档是否不参与本次检索：已过期、被屏蔽，或不在请求限定的集合和来源中
+func (req *Request) excludes(doc *index.Document, now time.Time) bool {
+	return doc.Expired(now) || doc.Blocked || req.Blocklist.ContainsDocument(doc) ||
+		(req.Collection != "" && doc.Collection != req.Collection) ||
+		(len(req.Sources) > 0 && !slices.Contains(req.Sources, doc.Source))
+}
+
+type Result struct {
+	// 拼接后的文档内容，用于构造提示词
+	Content string
+	// 最终选用的文档ID，按相关性排序
+	DocIds []int
+	// 最终选用的文档
+	Docs []*index.Document
+	// 各阶段耗时
+	Timings []Timing
+	// 参与重排序的全部候选文档及其分数，用于诊断
+	Candidates []Candidate
+	// 重排序失败，按召回分数排序
+	RerankFallback bool
+	// 检索过程中的降级等提示，用于诊断
+	Warnings []string
+	// 本次检索实际生效的数量限制，用于诊断
+	Limits Limits
+	// 本身超出上下文上限、以摘要和最相关片段代替全文的文档 ID
+	Excerpted []int
+	// 归一化后的问题，未开启归一化或问题未变化时为空
+	Normalized string
+	// 文档 ID -> 代替全文放入提示词的片段，用于重新拼接
+	contents map[int]string
+	// 超长文档的片段排序，用于重新拼接
+	rankChunks func(doc *index.Document) []index.Span
+	// 文档 ID -> 引用的章节位置
+	anchors map[int]*Anchor
+}
+
+// 检索时实际生效的数量限制。召回和重排序数量可能被自适应调整，与配置不同
+type Limits struct {
+	TopEmb           int `json:"top_emb"`
+	TopRerank        int `json:"top_rerank"`
+	MaxDocs          int `json:"max_docs,omitempty"`
+	MaxContextTokens int `json:"max_context_tokens,omitempty"`
+	// 召回分数的含义：向量相似度度量，或 BM25 模式下的 bm25
+	RecallMetric string `json:"recall_metric"`
+}
+
+type Candidate struct {
+	DocId int    `json:"doc_id"`
+	Title string `json:"title,omitempty"`
+	// 向量或 BM25 召回的分数，来自会话记忆的文档为 0
+	RecallScore float32 `json:"recall_score"`
+	// 重排序分数，未进入重排序结果时为空
+	RerankScore *float32 `json:"rerank_score,omitempty"`
+	// 是否最终被选入提示词
+	Selected bool `json:"selected"`
+}
+
+// 返回最高的重排序分数，没有重排序结果时返回 false
+func (r *Result) TopRerankScore() (float32, bool) {
+	top, ok := float32(0), false
+	for _, c := range r.Candidates {
+		if c.RerankScore != nil && (!ok || *c.RerankScore > top) {
+			top, ok = *c.RerankScore, true
+		}
+	}
+	return top, ok
+}
+
+// 引用的文档信息
+type Citation struct {
+	DocId int    `json:"doc_id"`
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+	// 最相关片段在文档中的位置，未建立片段索引时为空
+	Anchor *Anchor `json:"anchor,omitempty"`
+	// 跳转到最相关章节的链接，由 URL 和章节锚点组成
+	Link string `json:"link,omitempty"`
+	// 召回和重排序分数，来自会话记忆或重排序降级时为空
+	RecallScore *float32 `json:"recall_score,omitempty"`
+	RerankScore *float32 `json:"rerank_score,omitempty"`
+}
+
+// 返回最终选用文档的引用信息
+func (r *Result) Citations() []Citation {
+	citations := make([]Citation, len(r.Docs))
+	for i, doc := range r.Docs {
+		anchor := r.anchors[doc.DocId]
+		citations[i] = Citation{DocId: doc.DocId, Title: doc.Title, URL: doc.URL, Anchor: anchor, Link: deepLink(doc.URL, anchor)}
+		for _, c := range r.Candidates {
+			if c.DocId != doc.DocId {
+				continue
+			}
+			if c.RecallScore != 0 {
+				score := c.RecallScore
+				citations[i].RecallScore = &score
+			}
+			citations[i].RerankScore = c.RerankScore
+		}
+	}
+	return citations
+}
+
+type Timing struct {
+	Name     string
+	Duration time.Duration
+}
+
+func New(cfg *config.Config, store index.Store, embedder provider.Embedder, reranker provider.Reranker) (*Pipeline, error) {
+	policies, err := loadModelPolicies(cfg.ModelPoliciesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	glossary, err := loadGlossary(cfg.GlossaryFile)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizer, err := loadNormalizer(cfg.QueryNormalize, cfg.QueryNormalizeFile, store.Documents())
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pipeline{
+		cfg:        cfg,
+		store:      store,
+		embedder:   embedder,
+		reranker:   reranker,
+		policies:   policies,
+		routes:     []*embRoute{{model: cfg.ModelEmb, embedder: embedder, store: store, snapshot: cfg.IndexSnapshot}},
+		glossary:   glossary,
+		normalizer: normalizer,
+		builtAt:    time.Now(),
+	}
+	if cfg.RelevanceCheck {
+		p.relevance = newRelevanceChecker(cfg.LlmBaseUrl, cfg.LlmToken, cfg.ModelWithoutThinking)
+	}
+	if cfg.AdaptiveTopN {
+		p.adaptive = newAdaptiveTopN(cfg.AdaptiveLatency, cfg.AdaptiveMaxInflight)
+	}
+	return p, nil
+}
+
+// 按配置加载文档，其他格式的源文件由内置和 CONVERTERS_FILE 中的转换器转换为 markdown
+func LoadDocuments(cfg *config.Config) ([]*index.Document, error) {
+	converters, err := convert.Load(cfg.ConvertersFile)
+	if err != nil {
+		return nil, err
+	}
+	return ingest.Load(cfg.MarkdownDir, cfg.SummaryFile, ingest.SizeLimit{MaxBytes: cfg.DocMaxBytes, Oversize: cfg.DocOversize}, converters)
+}
+
+// 按配置加载文档、建立索引并创建检索流水线
+func NewFromConfig(ctx context.Context, cfg *config.Config) (*Pipeline, error) {
+	docs, err := LoadDocuments(cfg)
+	if err != nil {
+		return nil, err
+	}
+	reportProgress(ctx, ProgressEvent{Stage: ProgressLoaded, Done: len(docs), Total: len(docs)})
+
+	var reranker provider.Reranker = provider.PassthroughReranker{}
+	if cfg.ModelRerank != "" {
+		reranker = provider.NewHTTPReranker(cfg.EmbBaseUrl, cfg.EmbToken, cfg.ModelRerank)
+	}
+
+	switch cfg.RerankOn {
+	case RerankOnSummary, RerankOnChunk:
+	default:
+		return nil, fmt.Errorf("invalid RERANK_ON: %q", cfg.RerankOn)
+	}
+
+	switch cfg.RetrievalMode {
+	case ModeDense:
+	case ModeBM25:
+		var seg *lang.Segmenter
+		if cfg.SegmentDict != "" {
+			seg, err = lang.LoadSegmenter(cfg.SegmentDict)
+			if err != nil {
+				return nil, err
+			}
+		}
+		store, err := index.NewBM25(docs, cfg.ChunkSize, seg)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("total %d documents (bm25)\n", store.Len())
+		reportProgress(ctx, ProgressEvent{Stage: ProgressIndexed, Done: store.Len(), Total: len(docs)})
+		p, err := New(cfg, store, nil, reranker)
+		if err != nil {
+			return nil, err
+		}
+		p.routes = nil
+		p.lexical = store
+		return p, nil
+	default:
+		return nil, fmt.Errorf("invalid RETRIEVAL_MODE: %q", cfg.RetrievalMode)
+	}
+
+	embedder, err := newEmbedder(cfg, cfg.ModelEmb)
+	if err != nil {
+		return nil, err
+	}
+	key, err := loadSnapshotKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	store, routes, err := buildRoutes(ctx, cfg, docs, index.BuildOptions{
+		Chunks:       cfg.RerankOn == RerankOnChunk,
+		ChunkSize:    cfg.ChunkSize,
+		ChunkOverlap: cfg.ChunkOverlap,
+		Model:        cfg.ModelEmb,
+		Snapshot:     cfg.IndexSnapshot,
+		Metric:       cfg.SimilarityMetric,
+		Key:          key,
+		ReadOnly:     cfg.ReadOnly,
+		SnapshotOnly: snapshotOnly(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("total %d documents\n", store.Len())
+	reportProgress(ctx, ProgressEvent{Stage: ProgressIndexed, Done: store.Len(), Total: len(docs)})
+
+	p, err := New(cfg, store, embedder, reranker)
+	if err != nil {
+		return nil, err
+	}
+	p.routes = routes
+	p.snapshotKey = key
+	collections, err := loadCollectionModels(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.collectionRerankers = newCollectionRerankers(cfg, collections)
+
+	if cfg.EmbSparseUrl != "" {
+		p.sparseEmbedder = provider.NewHTTPSparseEmbedder(cfg.EmbSparseUrl, cfg.EmbToken)
+		p.sparse, err = index.NewSparse(ctx, docs, p.sparseEmbedder)
+		if err != nil {
+			return nil, fmt.Errorf("sparse embedding: %w", err)
+		}
+		fmt.Printf("total %d sparse vectors\n", len(docs))
+	}
+	return p, nil
+}
+
+// 读取快照加密密钥，INDEX_ENCRYPTION_KEY_FILE 用于从挂载的密钥文件（如 KMS 注入）读取
+func loadSnapshotKey(cfg *config.Config) ([]byte, error) {
+	s := cfg.IndexEncryptionKey
+	if cfg.IndexEncryptionKeyFile != "" {
+		buf, err := os.ReadFile(cfg.IndexEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s = string(buf)
+	}
+	key, err := index.ParseKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("INDEX_ENCRYPTION_KEY: %w", err)
+	}
+	return key, nil
+}
+
+func (p *Pipeline) Store() index.Store {
+	return p.store
+}
+
+// 更新单篇文档的摘要，只重新计算该文档摘要的向量
+func (p *Pipeline) UpdateSummary(ctx context.Context, docId int, summary string) (*index.Document, error) {
+	defer p.indexVersion.Store(nil)
+	if p.lexical != nil {
+		return p.lexical.UpdateSummary(docId, summary, nil)
+	}
+	route, _ := p.routeOf(docId, nil)
+	if route == nil {
+		return nil, index.ErrDocumentNotFound
+	}
+	vectors, err := route.embedder.Embed(ctx, []string{summary})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, errors.New("embedding length mismatch")
+	}
+	var sparse []provider.SparseVector
+	if p.sparse != nil {
+		sparse, err = p.sparseEmbedder.EmbedSparse(ctx, []string{summary})
+		if err != nil {
+			return nil, err
+		}
+		if len(sparse) != 1 {
+			return nil, errors.New("sparse embedding length mismatch")
+		}
+	}
+	doc, err := p.store.UpdateSummary(docId, summary, vectors[0])
+	if err != nil {
+		return nil, err
+	}
+	if p.sparse != nil {
+		p.sparse.Update(docId, sparse[0])
+	}
+	return doc, nil
+}
+
+// 检索与问题相关的文档
+func (p *Pipeline) Run(ctx context.Context, req *Request) (*Result, error) {
+	normalized := p.normalizer.normalize(req.Question)
+	result, err := p.run(ctx, req, normalized)
+	if result != nil && normalized != req.Question {
+		result.Normalized = normalized
+	}
+	return result, err
+}
+
+func (p *Pipeline) run(ctx context.Context, req *Request, normalized string) (*Result, error) {
+	question := normalized
+	if !req.NoExpansion {
+		question = p.glossary.expand(normalized)
+	}
+	onStage := req.OnStage
+	logging.Infof("question: %s\n", question)
+	recall := question
+	if req.Hypothetical != "" {
+		recall = req.Hypothetical
+		logging.Debugf("hypothetical answer: %s\n", recall)
+	}
+	if onStage == nil {
+		onStage = func(string) {}
+	}
+
+	onStage(StageRetrieving)
+	if len(req.CompareDocIds) > 0 {
+		return p.compare(ctx, req, question)
+	}
+
+	topEmb, topRerank := p.cfg.TopEmb, p.cfg.TopRerank
+	if p.adaptive != nil && !req.Deterministic {
+		topEmb, topRerank = p.adaptive.acquire(topEmb, p.cfg.TopEmbMin, topRerank, p.cfg.TopRerankMin)
+		defer func(start time.Time) {
+			p.adaptive.release(time.Since(start))
+		}(time.Now())
+		if topEmb != p.cfg.TopEmb {
+			logging.Debugf("adaptive top n: emb=%d rerank=%d\n", topEmb, topRerank)
+		}
+	}
+
+	policy := p.policyFor(req.Model)
+	limits := Limits{
+		TopEmb:           topEmb,
+		TopRerank:        topRerank,
+		MaxDocs:          policy.MaxDocs,
+		MaxContextTokens: policy.MaxContextTokens,
+		RecallMetric:     p.cfg.SimilarityMetric,
+	}
+	if p.lexical != nil {
+		limits.RecallMetric = "bm25"
+	} else if p.sparse != nil {
+		limits.RecallMetric += "+sparse"
+	}
+	timings := []Timing{}
+	now := time.Now()
+	exclude := func(doc *index.Document) bool {
+		return req.excludes(doc, now)
+	}
+
+	var hits []index.Hit
+	var queries [][]float32
+	var err error
+	start := time.Now()
+	if p.lexical != nil {
+		hits = p.lexical.SearchText(recall, topEmb, exclude)
+		timings = append(timings, Timing{Name: "bm25", Duration: time.Since(start)})
+	} else {
+		queries, err = p.embedQuery(ctx, recall, req.Collection)
+		if err != nil {
+			return nil, err
+		}
+		timings = append(timings, Timing{Name: "embed", Duration: time.Since(start)})
+
+		if p.sparse != nil {
+			// 稠密分数对全部文档计算，再加上稀疏分数后取前 topEmb 条，避免只在稀疏分数上突出的文档被提前截断
+			hits, err = p.search(queries, p.store.Len(), exclude)
+			if err != nil {
+				return nil, err
+			}
+			start = time.Now()
+			sparse, err := p.sparseEmbedder.EmbedSparse(ctx, []string{recall})
+			if err != nil {
+				return nil, stageError(ErrEmbeddingBackend, err)
+			}
+			if len(sparse) != 1 {
+				return nil, errors.New("sparse embedding length mismatch")
+			}
+			hits = p.sparse.Rescore(hits, sparse[0], float32(p.cfg.SparseWeight), topEmb)
+			timings = append(timings, Timing{Name: "sparse", Duration: time.Since(start)})
+		} else {
+			hits, err = p.search(queries, topEmb, exclude)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	docs := []*index.Document{}
+	docIds := []int{}
+	for _, hit := range hits {
+		docs = append(docs, hit.Doc)
+		docIds = append(docIds, hit.Doc.DocId)
+	}
+	if p.lexical != nil {
+		logging.Infof("similar docs (bm25): %v\n", docIds)
+	} else {
+		logging.Infof("similar docs (embedding): %v\n", docIds)
+	}
+
+	for _, docId := range req.MemDocIds {
+		doc, ok := p.store.Get(docId)
+		if !ok || slices.Contains(docIds, docId) || exclude(doc) {
+			continue
+		}
+		docs = append(docs, doc)
+		docIds = append(docIds, docId)
+	}
+	if len(docIds) > len(hits) {
+		logging.Infof("similar docs (session memory): %v\n", docIds[len(hits):])
+	}
+
+	if len(docs) == 0 {
+		if p.cfg.NoDocsError {
+			return nil, ErrNoRelevantDocs
+		}
+		return &Result{Content: "未检索到相关文档。", Timings: timings, Limits: limits}, nil
+	}
+
+	candidates := make([]Candidate, len(docs))
+	for i, doc := range docs {
+		candidates[i] = Candidate{DocId: doc.DocId, Title: doc.Title}
+		if i < len(hits) {
+			candidates[i].RecallScore = hits[i].Score
+		}
+	}
+
+	onStage(StageReranking)
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		texts[i] = doc.Summary
+		if p.cfg.RerankOn == RerankOnChunk {
+			if p.lexical != nil {
+				if chunk, ok := p.lexical.BestChunkText(doc, question); ok {
+					texts[i] = chunk
+				}
+			} else if route, query := p.routeOf(doc.DocId, queries); route != nil {
+				if chunk, ok := route.store.BestChunk(doc, query); ok {
+					texts[i] = chunk
+				}
+			}
+		}
+	}
+	start = time.Now()
+	var warnings []string
+	rerankFallback := false
+	resRerank, err := p.rerankerFor(req.Collection).Rerank(ctx, question, texts, topRerank)
+	if err != nil {
+		if p.cfg.RerankRequired {
+			return nil, stageError(ErrRerankBackend, err)
+		}
+		// 重排序后端不可用时按召回顺序降级，不让整个请求失败
+		logging.Warnf("rerank failed, falling back to recall order: %v\n", err)
+		warnings = append(warnings, fmt.Sprintf("rerank failed, documents ordered by recall score: %v", err))
+		rerankFallback = true
+		resRerank = make([]provider.RerankResult, min(topRerank, len(docs)))
+		for i := range resRerank {
+			resRerank[i] = provider.RerankResult{Index: i, Score: candidates[i].RecallScore}
+		}
+	}
+	timings = append(timings, Timing{Name: "rerank", Duration: time.Since(start)})
+	if req.Deterministic {
+		slices.SortStableFunc(resRerank, func(a, b provider.RerankResult) int {
+			if a.Score != b.Score {
+				return cmp.Compare(b.Score, a.Score)
+			}
+			return docs[a.Index].DocId - docs[b.Index].DocId
+		})
+	}
+	for _, v := range resRerank {
+		if !rerankFallback && v.Index >= 0 && v.Index < len(candidates) {
+			score := v.Score
+			candidates[v.Index].RerankScore = &score
+		}
+	}
+	if logging.DebugEnabled() {
+		for _, c := range candidates {
+			if c.RerankScore != nil {
+				logging.Debugf("candidate doc %d: recall=%.4f rerank=%.4f\n", c.DocId, c.RecallScore, *c.RerankScore)
+			} else {
+				logging.Debugf("candidate doc %d: recall=%.4f\n", c.DocId, c.RecallScore)
+			}
+		}
+	}
+
+	if policy.MaxDocs > 0 && len(resRerank) > policy.MaxDocs {
+		resRerank = resRerank[:policy.MaxDocs]
+	}
+
+	selected := []*index.Document{}
+	docIdsRerank := []int{}
+	for _, v := range resRerank {
+		selected = append(selected, docs[v.Index])
+		docIdsRerank = append(docIdsRerank, docs[v.Index].DocId)
+	}
+	logging.Infof("similar docs (rerank): %v\n", docIdsRerank)
+
+	if p.relevance != nil && len(selected) > 0 {
+		start = time.Now()
+		kept := p.relevance.filter(ctx, question, selected)
+		timings = append(timings, Timing{Name: "relevance", Duration: time.Since(start)})
+		if len(kept) < len(selected) {
+			filtered := make([]*index.Document, len(kept))
+			filteredIds := make([]int, len(kept))
+			for i, k := range kept {
+				filtered[i], filteredIds[i] = selected[k], docIdsRerank[k]
+			}
+			selected, docIdsRerank = filtered, filteredIds
+			logging.Infof("similar docs (relevance): %v\n", docIdsRerank)
+		}
+		if len(selected) == 0 {
+			if p.cfg.NoDocsError {
+				return nil, ErrNoRelevantDocs
+			}
+			return &Result{Content: "未检索到相关文档。", Timings: timings, Candidates: candidates, RerankFallback: rerankFallback, Warnings: warnings, Limits: limits}, nil
+		}
+	}
+
+	promptChunks := p.cfg.PromptChunks
+	if req.PromptChunks != 0 {
+		promptChunks = req.PromptChunks
+	}
+	contents := p.promptContents(selected, queries, promptChunks)
+	rankChunks := p.chunkRanker(queries)
+	content, n, excerpted := formatDocuments(selected, contents, policy.MaxContextTokens, rankChunks)
+	selected, docIdsRerank = selected[:n], docIdsRerank[:n]
+	if excerpted {
+		warnings = append(warnings, excerptWarning(selected[0], policy.MaxContextTokens))
+	}
+	for i := range candidates {
+		candidates[i].Selected = slices.Contains(docIdsRerank, candidates[i].DocId)
+	}
+
+	return &Result{
+		Content:        content,
+		DocIds:         docIdsRerank,
+		Docs:           selected,
+		Timings:        timings,
+		Candidates:     candidates,
+		RerankFallback: rerankFallback,
+		Warnings:       warnings,
+		Limits:         limits,
+		Excerpted:      excerptedIds(selected, excerpted),
+		contents:       contents,
+		rankChunks:     rankChunks,
+		anchors:        p.citationAnchors(selected, queries),
+	}, nil
+}
+
+// 按更小的上下文 token 上限重新拼接文档内容，超出的文档被丢弃，只剩一篇时截断其内容。
+// 结果可能被合并的请求共享，因此返回新的结果而不修改原结果；无法再缩减时返回 false
+func (r *Result) Refit(maxTokens int) (*Result, bool) {
+	if len(r.Docs) == 0 || maxTokens <= 0 {
+		return r, false
+	}
+	content, n, excerpted := formatDocuments(r.Docs, r.contents, maxTokens, r.rankChunks)
+	fitted := *r
+	fitted.Content = content
+	fitted.Docs, fitted.DocIds = r.Docs[:n], r.DocIds[:n]
+	fitted.Excerpted = excerptedIds(fitted.Docs, excerpted)
+	fitted.Candidates = slices.Clone(r.Candidates)
+	for i := range fitted.Candidates {
+		fitted.Candidates[i].Selected = slices.Contains(fitted.DocIds, fitted.Candidates[i].DocId)
+	}
+	fitted.Warnings = append(slices.Clip(r.Warnings), fmt.Sprintf("prompt over context window, context reduced to %d tokens and %d docs", maxTokens, n))
+	if excerpted {
+		fitted.Warnings = append(fitted.Warnings, excerptWarning(fitted.Docs[0], maxTokens))
+	}
+	return &fitted, true
+}
+
+// 以指定的文档代替选中的文档，按原来的上下文 token 上限重新拼接内容，超出的文档被丢弃。
+// 与 Refit 相同，返回新的结果而不修改原结果；新添加的文档不在 Candidates 中
+func (r *Result) WithDocs(docs []*index.Document) *Result {
+	content, n, excerpted := formatDocuments(docs, r.contents, r.Limits.MaxContextTokens, r.rankChunks)
+	if n == 0 {
+		content = "未检索到相关文档。"
+	}
+	replaced := *r
+	replaced.Content = content
+	replaced.Docs = docs[:n]
+	replaced.DocIds = make([]int, n)
+	for i, doc := range replaced.Docs {
+		replaced.DocIds[i] = doc.DocId
+	}
+	replaced.Excerpted = excerptedIds(replaced.Docs, excerpted)
+	replaced.Candidates = slices.Clone(r.Candidates)
+	for i := range replaced.Candidates {
+		replaced.Candidates[i].Selected = slices.Contains(replaced.DocIds, replaced.Candidates[i].DocId)
+	}
+	if excerpted {
+		replaced.Warnings = append(slices.Clip(r.Warnings), excerptWarning(replaced.Docs[0], r.Limits.MaxContextTokens))
+	}
+	return &replaced
+}
+
+// n 大于 0 时（PROMPT_CHUNKS 或请求指定），每篇文档只取与问题最相似的 n 个片段放入提示词。
+// 相邻片段有重叠时按位置合并，避免重叠部分在提示词中重复出现。
+// 返回文档 ID -> 提示词中的内容，未建立片段索引的文档不在其中，仍使用全文
+func (p *Pipeline) promptContents(docs []*index.Document, queries [][]float32, n int) map[int]string {
+	if n <= 0 || queries == nil {
+		return nil
+	}
+	contents := make(map[int]string, len(docs))
+	for _, doc := range docs {
+		route, query := p.routeOf(doc.DocId, queries)
+		if route == nil {
+			continue
+		}
+		spans := route.store.TopChunks(doc, query, n)
+		if len(spans) == 0 {
+			continue
+		}
+		runes := []rune(doc.Content)
+		parts := []string{}
+		for _, s := range index.MergeSpans(spans) {
+			parts = append(parts, string(runes[s.Start:s.End]))
+		}
+		contents[doc.DocId] = strings.Join(parts, "\n……\n")
+	}
+	return contents
+}
+
+// 拼接文档内容，contents 中有的文档使用其中的内容代替全文。
+// maxTokens 大于 0 时按估算的 token 数限制总长度，超出部分的文档被丢弃，
+// 第一篇文档本身超长时以其摘要和 rankChunks 排序的最相关片段代替，rankChunks 为空时截断其内容
+// 返回拼接结果、实际使用的文档数和第一篇文档是否被节选
+func formatDocuments(docs []*index.Document, contents map[int]string, maxTokens int, rankChunks func(*index.Document) []index.Span) (string, int, bool) {
+	blocks := []string{}
+	used := 0
+	excerpted := false
+	for i, doc := range docs {
+		logging.Debugf("doc %d|%s:\n%s\n", doc.DocId, doc.Title, doc.Summary)
+		block := fmt.Sprintf("第%d篇文档", i+1)
+		if len(doc.Title) > 0 {
+			block += fmt.Sprintf("，标题为「%s」", doc.Title)
+		}
+		if len(doc.URL) > 0 {
+			block += fmt.Sprintf("，链接为 %s", doc.URL)
+		}
+		block += "：\n\n"
+		content, ok := contents[doc.DocId]
+		if !ok {
+			content = doc.Content
+		}
+
+		if maxTokens > 0 {
+			n := tokens.Estimate(block) + tokens.Estimate(content)
+			if used+n > maxTokens {
+				if i > 0 {
+					logging.Infof("context limit %d tokens reached, %d docs dropped\n", maxTokens, len(docs)-i)
+					break
+				}
+				budget := maxTokens - tokens.Estimate(block)
+				var ok bool
+				if rankChunks != nil {
+					content, ok = excerptDocument(doc, rankChunks(doc), budget)
+					excerpted = ok
+				}
+				if !ok {
+					content = tokens.Truncate(content, budget)
+				}
+				n = maxTokens
+			}
+			used += n
+		}
+		blocks = append(blocks, block+content+"\n\n")
+	}
+
+	return fmt.Sprintf("检索到以下%d篇文档：\n\n", len(blocks)) + strings.Join(blocks, ""), len(blocks), excerpted
+}
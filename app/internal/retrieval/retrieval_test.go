@@ -0,0 +1,143 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"rag_app/internal/config"
+	"rag_app/internal/index"
+	"rag_app/internal/mockbackend"
+)
+
+func TestRequestExcludes(t *testing.T) {
+	now := time.Now()
+	blocklist, err := LoadBlocklist("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = blocklist.Add(7, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  Request
+		doc  index.Document
+		want bool
+	}{
+		{"plain", Request{}, index.Document{DocId: 1}, false},
+		{"not yet expired", Request{}, index.Document{DocId: 1, ExpiresAt: now.Add(time.Hour)}, false},
+		{"expired", Request{}, index.Document{DocId: 1, ExpiresAt: now.Add(-time.Hour)}, true},
+		{"expires now", Request{}, index.Document{DocId: 1, ExpiresAt: now}, true},
+		{"blocked in metadata", Request{}, index.Document{DocId: 1, Blocked: true}, true},
+		{"blocklist", Request{Blocklist: blocklist}, index.Document{DocId: 7}, true},
+		{"split from blocklisted", Request{Blocklist: blocklist}, index.Document{DocId: 8, SplitFrom: 7}, true},
+		{"blocklist without the doc", Request{Blocklist: blocklist}, index.Document{DocId: 1}, false},
+		{"other collection", Request{Collection: "hr"}, index.Document{DocId: 1, Collection: "it"}, true},
+		{"same collection", Request{Collection: "hr"}, index.Document{DocId: 1, Collection: "hr"}, false},
+		{"other source", Request{Sources: []string{"wiki"}}, index.Document{DocId: 1, Source: "drive"}, true},
+		{"listed source", Request{Sources: []string{"wiki"}}, index.Document{DocId: 1, Source: "wiki"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.excludes(&tt.doc, now); got != tt.want {
+				t.Errorf("excludes() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// 在临时目录写入语料，以模拟后端建立检索流水线
+func testPipeline(t *testing.T) *Pipeline {
+	t.Helper()
+	backend := mockbackend.New()
+	t.Cleanup(backend.Close)
+
+	dir := t.TempDir()
+	mdDir := filepath.Join(dir, "markdown")
+	err := os.MkdirAll(mdDir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"summary.txt":            "1:annual leave policy\n\n2:annual leave policy archive\n\n3:annual leave policy draft\n",
+		"markdown/files.txt":     "1:Leave\n2:Leave (old)\n3:Leave (draft)\n",
+		"markdown/1.md":          "# Leave\nannual leave policy",
+		"markdown/2.md":          "# Leave (old)\nannual leave policy archive",
+		"markdown/3.md":          "# Leave (draft)\nannual leave policy draft",
+		"markdown/metadata.json": `{"2": {"expires_at": "2000-01-01"}, "3": {"blocked": true}}`,
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("LLM_BASE_URL", backend.BaseUrl())
+	t.Setenv("EMB_BASE_URL", backend.BaseUrl())
+	t.Setenv("SUMMARY_FILE", filepath.Join(dir, "summary.txt"))
+	t.Setenv("MARKDOWN_DIR", mdDir)
+	t.Setenv("INDEX_SNAPSHOT", "")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewFromConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRunFiltersExpiredAndBlocked(t *testing.T) {
+	p := testPipeline(t)
+	result, err := p.Run(context.Background(), &Request{Question: "annual leave policy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range result.Candidates {
+		if c.DocId != 1 {
+			t.Errorf("candidate %d should have been excluded", c.DocId)
+		}
+	}
+	if !slices.Equal(result.DocIds, []int{1}) {
+		t.Errorf("DocIds = %v, want [1]", result.DocIds)
+	}
+
+	// 运行时加入屏蔽列表的文档同样被排除
+	blocklist, err := LoadBlocklist("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = blocklist.Add(1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = p.Run(context.Background(), &Request{Question: "annual leave policy", Blocklist: blocklist})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.DocIds) != 0 {
+		t.Errorf("DocIds = %v, want none", result.DocIds)
+	}
+}
+
+func TestResolveDocumentsFiltersExpiredAndBlocked(t *testing.T) {
+	p := testPipeline(t)
+	for _, docId := range []int{2, 3} {
+		t.Run(fmt.Sprint(docId), func(t *testing.T) {
+			_, err := p.ResolveDocuments([]int{1, docId}, nil, "", nil)
+			if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("document %d not found", docId)) {
+				t.Errorf("ResolveDocuments() error = %v, want doc %d not found", err, docId)
+			}
+		})
+	}
+}
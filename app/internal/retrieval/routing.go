@@ -0,0 +1,236 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"rag_app/internal/config"
+	"rag_app/internal/index"
+	"rag_app/internal/lang"
+	"rag_app/internal/provider"
+)
+
+// 向量化路由：一个向量化模型及其对应的子索引
+type embRoute struct {
+	model    string
+	embedder provider.Embedder
+	store    index.Store
+	// 子索引的快照文件，未配置快照时为空
+	snapshot string
+	// 指定使用该模型的集合
+	collections []string
+}
+
+// 从 JSON 文件加载语言到向量化模型的映射，如 {"zh": "bge-m3", "code": "jina-embeddings-v2-base-code"}
+func loadEmbeddingRoutes(path string) (map[string]string, error) {
+	routes := make(map[string]string)
+	if path == "" {
+		return routes, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &routes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return routes, nil
+}
+
+// 向量化后端
+const (
+	EmbProviderOpenAI = "openai"
+	EmbProviderLocal  = "local"
+)
+
+// 按 EMB_PROVIDER 创建向量化实现；本地模式只加载一个 ONNX 模型，忽略模型名
+func newEmbedder(cfg *config.Config, model string) (provider.Embedder, error) {
+	switch cfg.EmbProvider {
+	case EmbProviderOpenAI:
+		return provider.NewOpenAIEmbedder(cfg.EmbBaseUrl, cfg.EmbToken, model), nil
+	case EmbProviderLocal:
+		return provider.NewLocalEmbedder(provider.LocalEmbedderOptions{
+			Model:     cfg.EmbOnnxModel,
+			Tokenizer: cfg.EmbOnnxTokenizer,
+			Library:   cfg.EmbOnnxLibrary,
+			MaxTokens: cfg.EmbOnnxMaxTokens,
+		})
+	}
+	return nil, fmt.Errorf("invalid EMB_PROVIDER: %q", cfg.EmbProvider)
+}
+
+// 将文档分配到不同的向量化模型，返回各模型的文档及模型的构建顺序。
+// 集合指定了向量化模型时使用该模型，否则按摘要语言路由，未配置路由的语言使用默认向量化模型
+func groupByModel(cfg *config.Config, docs []*index.Document) (map[string][]*index.Document, []string, error) {
+	langModels, err := loadEmbeddingRoutes(cfg.EmbRoutesFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(langModels) > 0 && cfg.EmbProvider == EmbProviderLocal {
+		return nil, nil, fmt.Errorf("EMB_ROUTES_FILE is not supported with EMB_PROVIDER=%s", EmbProviderLocal)
+	}
+	collections, err := loadCollectionModels(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := map[string][]*index.Document{}
+	for _, doc := range docs {
+		if cm, ok := collections[doc.Collection]; ok && cm.EmbeddingModel != "" {
+			groups[cm.EmbeddingModel] = append(groups[cm.EmbeddingModel], doc)
+			continue
+		}
+		model, ok := langModels[lang.Detect(doc.Summary)]
+		if !ok {
+			model = cfg.ModelEmb
+		}
+		groups[model] = append(groups[model], doc)
+	}
+
+	// 默认模型排在最前，其余按模型名排序，保证构建顺序稳定
+	models := []string{}
+	for model := range groups {
+		if model != cfg.ModelEmb {
+			models = append(models, model)
+		}
+	}
+	slices.Sort(models)
+	if _, ok := groups[cfg.ModelEmb]; ok || len(models) == 0 {
+		models = slices.Insert(models, 0, cfg.ModelEmb)
+	}
+	return groups, models, nil
+}
+
+// 模型子索引的快照文件，只有一个模型时直接使用 INDEX_SNAPSHOT
+func routeSnapshot(snapshot, model string, models int) string {
+	if snapshot != "" && models > 1 {
+		return snapshot + "." + strings.ReplaceAll(model, "/", "_")
+	}
+	return snapshot
+}
+
+// 为每个向量化模型建立子索引
+func buildRoutes(ctx context.Context, cfg *config.Config, docs []*index.Document, opts index.BuildOptions) (index.Store, []*embRoute, error) {
+	groups, models, err := groupByModel(cfg, docs)
+	if err != nil {
+		return nil, nil, err
+	}
+	collections, err := loadCollectionModels(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	routes := []*embRoute{}
+	parts := []index.Store{}
+	for _, model := range models {
+		embedder, err := newRouteEmbedder(cfg, collections, model)
+		if err != nil {
+			return nil, nil, err
+		}
+		partOpts := opts
+		partOpts.Model = model
+		partOpts.Progress = func(stage string, done, total int) {
+			reportProgress(ctx, ProgressEvent{Stage: stage, Model: model, Done: done, Total: total})
+		}
+		partOpts.Snapshot = routeSnapshot(opts.Snapshot, model, len(models))
+		partOpts.Previous = previousIndex(ctx, model)
+		store, err := index.Build(ctx, groups[model], embedder, partOpts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("embedding model %s: %w", model, err)
+		}
+		fmt.Printf("embedding model %s: %d documents\n", model, store.Len())
+		routes = append(routes, &embRoute{
+			model:       model,
+			embedder:    embedder,
+			store:       store,
+			snapshot:    partOpts.Snapshot,
+			collections: collectionsOf(collections, model),
+		})
+		parts = append(parts, store)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], routes, nil
+	}
+	store, err := index.NewComposite(parts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, routes, nil
+}
+
+// 用各向量化模型分别计算问题的向量，返回值与 routes 一一对应。
+// 检索指定集合时跳过不含该集合文档的子索引，其向量为 nil
+func (p *Pipeline) embedQuery(ctx context.Context, question, collection string) ([][]float32, error) {
+	queries := make([][]float32, len(p.routes))
+	errs := make([]error, len(p.routes))
+	needed := p.routesFor(collection)
+	var wg sync.WaitGroup
+	for i, route := range p.routes {
+		if needed != nil && !needed[i] {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			embs, err := route.embedder.Embed(ctx, []string{question})
+			if err == nil && len(embs) != 1 {
+				err = fmt.Errorf("embedding model %s: embedding length mismatch", route.model)
+			}
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			queries[i] = embs[0]
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, stageError(ErrEmbeddingBackend, err)
+		}
+	}
+	return queries, nil
+}
+
+// 在各子索引中分别检索并按分数合并结果
+func (p *Pipeline) search(queries [][]float32, topN int, exclude func(doc *index.Document) bool) ([]index.Hit, error) {
+	if len(p.routes) == 1 {
+		return p.routes[0].store.Search(queries[0], topN, exclude)
+	}
+
+	hits := []index.Hit{}
+	for i, route := range p.routes {
+		if queries[i] == nil {
+			continue
+		}
+		h, err := route.store.Search(queries[i], topN, exclude)
+		if err != nil {
+			return nil, fmt.Errorf("embedding model %s: %w", route.model, err)
+		}
+		hits = append(hits, h...)
+	}
+	return index.MergeHits(hits, topN), nil
+}
+
+// 返回文档所在子索引，以及该子索引模型下问题的向量
+func (p *Pipeline) routeOf(docId int, queries [][]float32) (*embRoute, []float32) {
+	for i, route := range p.routes {
+		if _, ok := route.store.Get(docId); ok {
+			var query []float32
+			if queries != nil {
+				query = queries[i]
+			}
+			return route, query
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package retrieval
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"rag_app/internal/logging"
+	"rag_app/internal/provider"
+)
+
+// 影子检索的超时时间
+const shadowTimeout = 60 * time.Second
+
+// 按影子流量配置创建备选流水线，与主流水线共用索引和向量化后端；未开启时返回 nil
+func (p *Pipeline) Shadow() *Pipeline {
+	sc := p.cfg.Shadow
+	if sc.SampleRate <= 0 {
+		return nil
+	}
+
+	cfg := *p.cfg
+	if sc.TopEmb > 0 {
+		cfg.TopEmb = sc.TopEmb
+	}
+	if sc.TopRerank > 0 {
+		cfg.TopRerank = sc.TopRerank
+	}
+	if sc.RerankOn != "" {
+		cfg.RerankOn = sc.RerankOn
+	}
+	// 影子流量指定了重排序模型时所有集合都使用该模型
+	reranker, collectionRerankers := p.reranker, p.collectionRerankers
+	if sc.ModelRerank != "" {
+		collectionRerankers = nil
+		cfg.ModelRerank = sc.ModelRerank
+		reranker = provider.NewHTTPReranker(cfg.EmbBaseUrl, cfg.EmbToken, cfg.ModelRerank)
+	}
+
+	return &Pipeline{
+		cfg:            &cfg,
+		store:          p.store,
+		embedder:       p.embedder,
+		reranker:       reranker,
+		policies:       p.policies,
+		adaptive:       p.adaptive,
+		routes:         p.routes,
+		snapshotKey:    p.snapshotKey,
+		sparse:         p.sparse,
+		sparseEmbedder: p.sparseEmbedder,
+		lexical:        p.lexical,
+		relevance:      p.relevance,
+		glossary:       p.glossary,
+		normalizer:     p.normalizer,
+
+		collectionRerankers: collectionRerankers,
+	}
+}
+
+// 按抽样比例异步运行影子检索，并记录与主检索结果的差异
+func (p *Pipeline) RunShadow(req *Request, primary *Result) {
+	if rand.Float64() >= p.cfg.Shadow.SampleRate {
+		return
+	}
+
+	shadowReq := *req
+	shadowReq.OnStage = nil
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+		defer cancel()
+
+		start := time.Now()
+		res, err := p.Run(ctx, &shadowReq)
+		if err != nil {
+			logging.Warnf("shadow retrieval error: %v\n", err)
+			return
+		}
+
+		overlap := 0
+		for _, docId := range res.DocIds {
+			if slices.Contains(primary.DocIds, docId) {
+				overlap++
+			}
+		}
+		logging.Infof("shadow retrieval: question=%q primary=%v shadow=%v overlap=%d elapsed=%s\n",
+			req.Question, primary.DocIds, res.DocIds, overlap, time.Since(start))
+	}()
+}
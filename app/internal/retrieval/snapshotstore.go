@@ -0,0 +1,184 @@
+package retrieval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"rag_app/internal/backup"
+	"rag_app/internal/config"
+	"rag_app/internal/index"
+)
+
+// 对象存储中最新发布的快照清单，快照文件全部上传后最后写入
+const snapshotManifestKey = "index/latest.json"
+
+type snapshotManifest struct {
+	PublishedAt  time.Time      `json:"published_at"`
+	IndexVersion string         `json:"index_version"`
+	Files        []snapshotFile `json:"files"`
+}
+
+type snapshotFile struct {
+	// 快照文件名，与 INDEX_SNAPSHOT 所在目录中的文件名相同
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// 按 SNAPSHOT_STORE_URL 打开发布索引快照的对象存储，未配置时返回 nil
+func OpenSnapshotStore(cfg *config.Config) (backup.Store, error) {
+	if cfg.SnapshotStore.Url == "" {
+		return nil, nil
+	}
+	if cfg.IndexSnapshot == "" {
+		return nil, errors.New("SNAPSHOT_STORE_URL requires INDEX_SNAPSHOT")
+	}
+	store, err := backup.Open(cfg.SnapshotStore.Url, backup.S3Options{
+		Endpoint:        cfg.SnapshotStore.S3Endpoint,
+		Region:          cfg.SnapshotStore.S3Region,
+		AccessKeyId:     cfg.SnapshotStore.S3AccessKeyId,
+		SecretAccessKey: cfg.SnapshotStore.S3SecretAccessKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SNAPSHOT_STORE_URL: %w", err)
+	}
+	return store, nil
+}
+
+// 将最新发布的快照下载到 INDEX_SNAPSHOT 所在目录，与本地文件相同的跳过。
+// 从未发布过快照时返回 false
+func PullSnapshots(ctx context.Context, store backup.Store, snapshot string) (bool, error) {
+	buf, err := store.Get(ctx, snapshotManifestKey)
+	if errors.Is(err, backup.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var manifest snapshotManifest
+	err = json.Unmarshal(buf, &manifest)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", snapshotManifestKey, err)
+	}
+
+	dir := filepath.Dir(snapshot)
+	for _, f := range manifest.Files {
+		path := filepath.Join(dir, filepath.Base(f.Name))
+		if local, err := os.ReadFile(path); err == nil && sha256Hex(local) == f.Sha256 {
+			continue
+		}
+		data, err := store.Get(ctx, "index/"+f.Name)
+		if err != nil {
+			return false, err
+		}
+		if sha256Hex(data) != f.Sha256 {
+			return false, fmt.Errorf("snapshot %s: checksum mismatch", f.Name)
+		}
+		err = writeFileAtomic(path, data)
+		if err != nil {
+			return false, err
+		}
+		fmt.Printf("snapshot %s pulled from %s (%d bytes)\n", path, store, len(data))
+	}
+	fmt.Printf("snapshots of index %s published at %s are up to date\n", manifest.IndexVersion, manifest.PublishedAt.Format(time.RFC3339))
+	return true, nil
+}
+
+// 上传各子索引的快照文件，最后写入清单。与已发布的快照相同时不上传，返回 false；
+// 快照不完整（启动时只用已有向量建立）时不发布
+func (p *Pipeline) PublishSnapshots(ctx context.Context, store backup.Store) (bool, error) {
+	if p.Missing() > 0 {
+		return false, fmt.Errorf("index is missing %d documents, not published", p.Missing())
+	}
+	manifest := snapshotManifest{PublishedAt: time.Now().UTC(), IndexVersion: p.IndexVersion()}
+	files := map[string][]byte{}
+	for _, route := range p.routes {
+		if route.snapshot == "" {
+			continue
+		}
+		data, err := os.ReadFile(route.snapshot)
+		if err != nil {
+			return false, err
+		}
+		name := filepath.Base(route.snapshot)
+		files[name] = data
+		manifest.Files = append(manifest.Files, snapshotFile{Name: name, Size: len(data), Sha256: sha256Hex(data)})
+	}
+	if len(manifest.Files) == 0 {
+		return false, nil
+	}
+
+	// 多个副本启动时会发布相同的快照，已发布时跳过
+	if buf, err := store.Get(ctx, snapshotManifestKey); err == nil {
+		var published snapshotManifest
+		if json.Unmarshal(buf, &published) == nil && slices.Equal(published.Files, manifest.Files) {
+			return false, nil
+		}
+	}
+	for _, f := range manifest.Files {
+		err := store.Put(ctx, "index/"+f.Name, files[f.Name])
+		if err != nil {
+			return false, err
+		}
+	}
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	return true, store.Put(ctx, snapshotManifestKey, buf)
+}
+
+// 启动时只用快照中的向量建立、因缺少向量而未加入索引的文档数
+func (p *Pipeline) Missing() int {
+	n := 0
+	for _, route := range p.routes {
+		if x, ok := route.store.(*index.Index); ok {
+			n += x.Missing()
+		}
+	}
+	return n
+}
+
+type snapshotOnlyKey struct{}
+
+// 在上下文中标记只使用快照中的向量建立索引，用于副本快速启动，缺少向量的文档之后再补齐
+func WithSnapshotOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, snapshotOnlyKey{}, true)
+}
+
+func snapshotOnly(ctx context.Context) bool {
+	v, _ := ctx.Value(snapshotOnlyKey{}).(bool)
+	return v
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// 先写临时文件再重命名，避免下载中断导致本地快照损坏
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,137 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"rag_app/internal/index"
+	"rag_app/internal/provider"
+)
+
+// 生成主题标签时每个簇最多提供给模型的文档数
+const topicLabelDocs = 8
+
+// 主题地图生成的进度阶段
+const (
+	ProgressClustering = "clustering"
+	ProgressLabeling   = "labeling"
+)
+
+// 一组内容相近的文档
+type Topic struct {
+	Label string `json:"label"`
+	// 所属向量化模型，不同模型的向量分别聚类
+	Model  string   `json:"model"`
+	Size   int      `json:"size"`
+	DocIds []int    `json:"doc_ids"`
+	Titles []string `json:"titles"`
+}
+
+// 按摘要向量聚类得到的语料主题分布
+type TopicMap struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Documents   int       `json:"documents"`
+	Topics      []Topic   `json:"topics"`
+}
+
+// 对各向量化模型的文档摘要向量做 k-means 聚类，并调用模型为每个簇生成主题标签。
+// k 为 0 时按文档数自动选择
+func (p *Pipeline) BuildTopicMap(ctx context.Context, k int) (*TopicMap, error) {
+	if len(p.routes) == 0 {
+		return nil, errors.New("topic map requires dense retrieval")
+	}
+
+	topics := []Topic{}
+	total := 0
+	for _, route := range p.routes {
+		x, ok := route.store.(*index.Index)
+		if !ok {
+			continue
+		}
+		docs, vectors := x.Vectors()
+		total += len(docs)
+		n := k
+		if n <= 0 {
+			n = int(math.Ceil(math.Sqrt(float64(len(docs)) / 2)))
+		}
+		assign := index.KMeans(vectors, n)
+		reportProgress(ctx, ProgressEvent{Stage: ProgressClustering, Model: route.model, Done: len(docs), Total: len(docs)})
+		clusters := map[int][]*index.Document{}
+		for i, doc := range docs {
+			clusters[assign[i]] = append(clusters[assign[i]], doc)
+		}
+		for _, members := range clusters {
+			topic := Topic{Model: route.model, Size: len(members)}
+			for _, doc := range members {
+				topic.DocIds = append(topic.DocIds, doc.DocId)
+				topic.Titles = append(topic.Titles, doc.Title)
+			}
+			topics = append(topics, topic)
+		}
+	}
+
+	// 大的主题排在前面
+	slices.SortStableFunc(topics, func(a, b Topic) int {
+		if a.Size != b.Size {
+			return b.Size - a.Size
+		}
+		return a.DocIds[0] - b.DocIds[0]
+	})
+
+	client := provider.NewOpenAIClient(p.cfg.LlmBaseUrl, p.cfg.LlmToken)
+	for i := range topics {
+		label, err := p.labelTopic(ctx, client, &topics[i])
+		if err != nil {
+			// 标签生成失败不影响聚类结果，以首篇文档标题代替
+			fmt.Printf("label topic %d: %v\n", i, err)
+			label = topics[i].Titles[0]
+		}
+		topics[i].Label = label
+		reportProgress(ctx, ProgressEvent{Stage: ProgressLabeling, Done: i + 1, Total: len(topics)})
+	}
+
+	return &TopicMap{GeneratedAt: time.Now(), Documents: total, Topics: topics}, nil
+}
+
+func (p *Pipeline) labelTopic(ctx context.Context, client *openai.Client, topic *Topic) (string, error) {
+	var sb strings.Builder
+	for _, docId := range topic.DocIds[:min(len(topic.DocIds), topicLabelDocs)] {
+		doc, ok := p.store.Get(docId)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&sb, "- %s：%s\n", doc.Title, doc.Summary)
+	}
+
+	response, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: p.cfg.ModelWithoutThinking,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "以下是同一类别的若干文档的标题和摘要，请用不超过十个字概括它们的共同主题，只输出主题。",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: sb.String(),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("empty response")
+	}
+	label := strings.TrimSpace(response.Choices[0].Message.Content)
+	if label == "" {
+		return "", errors.New("empty label")
+	}
+	return label, nil
+}
@@ -0,0 +1,151 @@
+package retrieval
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"rag_app/internal/index"
+)
+
+// 按向量化模型统计的向量
+type ModelVectors struct {
+	Model string `json:"model"`
+	// 指定使用该模型的集合
+	Collections []string `json:"collections,omitempty"`
+	index.Stats
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// 快照文件及其与当前索引的差异
+type SnapshotReport struct {
+	*index.SnapshotInfo
+	// 是否为当前某个向量化模型使用的快照
+	InUse bool `json:"in_use"`
+	// 文档已删除、不再被当前索引使用的条目数，未被使用的快照全部计入
+	Orphaned int    `json:"orphaned"`
+	Error    string `json:"error,omitempty"`
+}
+
+type VectorReport struct {
+	Models    []ModelVectors   `json:"models"`
+	Snapshots []SnapshotReport `json:"snapshots"`
+}
+
+// 统计各向量化模型的向量以及磁盘上的快照文件
+func (p *Pipeline) VectorReport() *VectorReport {
+	report := &VectorReport{Models: []ModelVectors{}, Snapshots: []SnapshotReport{}}
+	for _, route := range p.routes {
+		mv := ModelVectors{Model: route.model, Collections: route.collections, Snapshot: route.snapshot}
+		if x, ok := route.store.(*index.Index); ok {
+			mv.Stats = x.Stats()
+		}
+		report.Models = append(report.Models, mv)
+	}
+
+	for _, path := range p.snapshotFiles() {
+		sr := SnapshotReport{SnapshotInfo: &index.SnapshotInfo{Path: path}}
+		info, err := index.InspectSnapshot(path, p.snapshotKey)
+		if err != nil {
+			sr.Error = err.Error()
+			report.Snapshots = append(report.Snapshots, sr)
+			continue
+		}
+		sr.SnapshotInfo = info
+		route := p.routeBySnapshot(path)
+		sr.InUse = route != nil
+		for _, docId := range info.DocIds {
+			if route == nil {
+				sr.Orphaned++
+			} else if _, ok := route.store.Get(docId); !ok {
+				sr.Orphaned++
+			}
+		}
+		report.Snapshots = append(report.Snapshots, sr)
+	}
+	return report
+}
+
+// 向量回收的结果
+type GCReport struct {
+	DryRun bool `json:"dry_run"`
+	// 删除的快照文件：已不再使用的模型的快照、写入中断残留的临时文件及无法读取的文件
+	RemovedFiles []string `json:"removed_files"`
+	// 各快照中删除的孤立条目数
+	PrunedEntries map[string]int `json:"pruned_entries"`
+}
+
+// 回收不再使用的快照文件和快照中已删除文档的向量
+func (p *Pipeline) CollectGarbage(dryRun bool) (*GCReport, error) {
+	report := &GCReport{DryRun: dryRun, RemovedFiles: []string{}, PrunedEntries: map[string]int{}}
+	for _, sr := range p.VectorReport().Snapshots {
+		if !sr.InUse || sr.Error != "" {
+			report.RemovedFiles = append(report.RemovedFiles, sr.Path)
+			if !dryRun {
+				err := os.Remove(sr.Path)
+				if err != nil {
+					return report, err
+				}
+			}
+			continue
+		}
+		if sr.Orphaned == 0 {
+			continue
+		}
+
+		report.PrunedEntries[sr.Path] = sr.Orphaned
+		if !dryRun {
+			route := p.routeBySnapshot(sr.Path)
+			_, err := index.PruneSnapshot(sr.Path, p.snapshotKey, func(docId int) bool {
+				_, ok := route.store.Get(docId)
+				return ok
+			})
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	if !dryRun {
+		fmt.Printf("vector gc: %d files removed, %d snapshots pruned\n", len(report.RemovedFiles), len(report.PrunedEntries))
+	}
+	return report, nil
+}
+
+// 列出 INDEX_SNAPSHOT 及其按模型划分的快照文件
+func (p *Pipeline) snapshotFiles() []string {
+	base := p.cfg.IndexSnapshot
+	if base == "" {
+		return nil
+	}
+	files := []string{}
+	if _, err := os.Stat(base); err == nil {
+		files = append(files, base)
+	}
+	matches, _ := filepath.Glob(escapeGlob(base) + ".*")
+	files = append(files, matches...)
+	slices.Sort(files)
+	return files
+}
+
+func (p *Pipeline) routeBySnapshot(path string) *embRoute {
+	for _, route := range p.routes {
+		if route.snapshot == path {
+			return route
+		}
+	}
+	return nil
+}
+
+// 转义路径中的通配符
+func escapeGlob(path string) string {
+	escaped := []rune{}
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, r)
+	}
+	return string(escaped)
+}
@@ -0,0 +1,35 @@
+package retrieval
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"rag_app/internal/index"
+)
+
+// 索引版本：快照格式版本加上文档、向量化模型和切分配置的指纹，
+// 重建索引或更新摘要后随之改变，用于将回答与产生它的索引对应起来
+func (p *Pipeline) IndexVersion() string {
+	if v := p.indexVersion.Load(); v != nil {
+		return *v
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%d|%d|%s\n", p.cfg.RetrievalMode, p.cfg.RerankOn, p.cfg.ChunkSize, p.cfg.ChunkOverlap, p.cfg.SimilarityMetric)
+	for _, route := range p.routes {
+		fmt.Fprintf(h, "model %s\n", route.model)
+	}
+	for _, doc := range p.store.Documents() {
+		fmt.Fprintf(h, "%d|%s|%s\n", doc.DocId, doc.Hash, doc.Summary)
+	}
+	v := fmt.Sprintf("v%d-%s", index.SnapshotVersion, hex.EncodeToString(h.Sum(nil)[:6]))
+	p.indexVersion.Store(&v)
+	return v
+}
+
+// 流水线及其索引的建立时间，重建索引后更新
+func (p *Pipeline) BuiltAt() time.Time {
+	return p.builtAt
+}
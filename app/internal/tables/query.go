@@ -0,0 +1,400 @@
+package tables
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 模型查询数据表的工具
+const ToolName = "query_table"
+
+// 模型生成的结构化查询，相当于受限的 SELECT 语句：只能读取一张表，
+// 条件之间为 AND，聚合时按 group_by 分组
+type Query struct {
+	Table      string      `json:"table"`
+	Columns    []string    `json:"columns"`
+	Where      []Condition `json:"where"`
+	GroupBy    []string    `json:"group_by"`
+	Aggregates []Aggregate `json:"aggregates"`
+	OrderBy    string      `json:"order_by"`
+	Desc       bool        `json:"desc"`
+	Limit      int         `json:"limit"`
+}
+
+type Condition struct {
+	Column string `json:"column"`
+	Op     string `json:"op"`
+	// 字符串或数字，op 为 in 时为数组
+	Value any `json:"value"`
+}
+
+type Aggregate struct {
+	Func string `json:"func"`
+	// count 时可以为空，表示统计行数
+	Column string `json:"column"`
+}
+
+var (
+	conditionOps  = []string{"=", "!=", ">", ">=", "<", "<=", "contains", "in"}
+	aggregateFunc = []string{"count", "sum", "avg", "min", "max"}
+)
+
+// 工具参数的 JSON Schema，表名限定为集合中的表
+func (c *Collection) ToolParameters() json.RawMessage {
+	names := []string{}
+	for _, t := range c.Tables {
+		names = append(names, t.Name)
+	}
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"table":   map[string]any{"type": "string", "enum": names},
+			"columns": map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "返回的列，为空时返回全部列；聚合查询时不可用"},
+			"where": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"column": map[string]any{"type": "string"},
+						"op":     map[string]any{"type": "string", "enum": conditionOps},
+						"value":  map[string]any{"description": "字符串或数字，op 为 in 时为数组"},
+					},
+					"required": []string{"column", "op", "value"},
+				},
+				"description": "过滤条件，同时满足",
+			},
+			"group_by": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"aggregates": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"func":   map[string]any{"type": "string", "enum": aggregateFunc},
+						"column": map[string]any{"type": "string", "description": "count 时可省略"},
+					},
+					"required": []string{"func"},
+				},
+			},
+			"order_by": map[string]any{"type": "string", "description": "结果中的列名，聚合列写作 func(column)，如 sum(amount)、count(*)"},
+			"desc":     map[string]any{"type": "boolean"},
+			"limit":    map[string]any{"type": "integer"},
+		},
+		"required": []string{"table"},
+	}
+	buf, _ := json.Marshal(schema)
+	return buf
+}
+
+// 解析、校验并执行模型生成的查询，结果以表格文本返回，最多 maxRows 行。
+// 查询不合法时返回的错误可以直接交给模型修正
+func (c *Collection) Execute(arguments string, maxRows int) (string, error) {
+	var q Query
+	err := json.Unmarshal([]byte(arguments), &q)
+	if err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	t, err := c.validate(&q)
+	if err != nil {
+		return "", err
+	}
+
+	rows := [][]string{}
+	for _, row := range t.Rows {
+		if t.matches(row, q.Where) {
+			rows = append(rows, row)
+		}
+	}
+	columns := t.Columns
+	if len(q.GroupBy) > 0 || len(q.Aggregates) > 0 {
+		columns, rows = t.aggregate(rows, q.GroupBy, q.Aggregates)
+	} else if len(q.Columns) > 0 {
+		columns, rows = t.project(rows, q.Columns)
+	}
+
+	if q.OrderBy != "" {
+		i := slices.IndexFunc(columns, func(col Column) bool { return col.Name == q.OrderBy })
+		if i < 0 {
+			return "", fmt.Errorf("order_by: column %q is not in the result", q.OrderBy)
+		}
+		slices.SortStableFunc(rows, func(a, b []string) int {
+			r := compare(a[i], b[i])
+			if q.Desc {
+				return -r
+			}
+			return r
+		})
+	}
+
+	limit := maxRows
+	if q.Limit > 0 && q.Limit < limit {
+		limit = q.Limit
+	}
+	var b strings.Builder
+	total := len(rows)
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	writeRows(&b, columns, rows)
+	if total > len(rows) {
+		fmt.Fprintf(&b, "共%d行，只返回前%d行\n", total, len(rows))
+	} else {
+		fmt.Fprintf(&b, "共%d行\n", total)
+	}
+	return b.String(), nil
+}
+
+func (c *Collection) validate(q *Query) (*Table, error) {
+	if q.Table == "" {
+		return nil, errors.New("table is required")
+	}
+	t := c.Table(q.Table)
+	if t == nil {
+		return nil, fmt.Errorf("unknown table %q", q.Table)
+	}
+	known := func(field, name string) error {
+		if t.column(name) < 0 {
+			return fmt.Errorf("%s: unknown column %q in table %s", field, name, t.Name)
+		}
+		return nil
+	}
+	for _, name := range q.Columns {
+		if err := known("columns", name); err != nil {
+			return nil, err
+		}
+	}
+	for _, cond := range q.Where {
+		if err := known("where", cond.Column); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(conditionOps, cond.Op) {
+			return nil, fmt.Errorf("where: unknown op %q, expected one of %s", cond.Op, strings.Join(conditionOps, " "))
+		}
+		_, isList := cond.Value.([]any)
+		if cond.Op == "in" && !isList {
+			return nil, fmt.Errorf("where: value of op in on %s must be an array", cond.Column)
+		}
+		if cond.Op != "in" && isList {
+			return nil, fmt.Errorf("where: value of op %q on %s must not be an array", cond.Op, cond.Column)
+		}
+	}
+	if (len(q.GroupBy) > 0 || len(q.Aggregates) > 0) && len(q.Columns) > 0 {
+		return nil, errors.New("columns cannot be used with group_by or aggregates")
+	}
+	for _, name := range q.GroupBy {
+		if err := known("group_by", name); err != nil {
+			return nil, err
+		}
+	}
+	for _, agg := range q.Aggregates {
+		if !slices.Contains(aggregateFunc, agg.Func) {
+			return nil, fmt.Errorf("aggregates: unknown func %q, expected one of %s", agg.Func, strings.Join(aggregateFunc, " "))
+		}
+		if agg.Column == "" || agg.Column == "*" {
+			if agg.Func != "count" {
+				return nil, fmt.Errorf("aggregates: %s requires a column", agg.Func)
+			}
+			continue
+		}
+		if err := known("aggregates", agg.Column); err != nil {
+			return nil, err
+		}
+		if (agg.Func == "sum" || agg.Func == "avg") && t.Columns[t.column(agg.Column)].Type != TypeNumber {
+			return nil, fmt.Errorf("aggregates: %s(%s) requires a number column", agg.Func, agg.Column)
+		}
+	}
+	if q.Limit < 0 {
+		return nil, errors.New("limit must not be negative")
+	}
+	return t, nil
+}
+
+func (t *Table) matches(row []string, where []Condition) bool {
+	for _, cond := range where {
+		cell := strings.TrimSpace(row[t.column(cond.Column)])
+		if !match(cell, cond.Op, cond.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func match(cell, op string, value any) bool {
+	switch op {
+	case "in":
+		for _, v := range value.([]any) {
+			if compare(cell, valueString(v)) == 0 {
+				return true
+			}
+		}
+		return false
+	case "contains":
+		return strings.Contains(strings.ToLower(cell), strings.ToLower(valueString(value)))
+	}
+	r := compare(cell, valueString(value))
+	switch op {
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	}
+	return false
+}
+
+// 两边都是数字时按数值比较，否则按字符串比较
+func compare(a, b string) int {
+	x, okA := number(a)
+	y, okB := number(b)
+	if okA && okB {
+		return cmp.Compare(x, y)
+	}
+	return strings.Compare(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+func valueString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func (t *Table) project(rows [][]string, names []string) ([]Column, [][]string) {
+	columns := make([]Column, len(names))
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		indexes[i] = t.column(name)
+		columns[i] = t.Columns[indexes[i]]
+	}
+	projected := make([][]string, len(rows))
+	for r, row := range rows {
+		projected[r] = make([]string, len(indexes))
+		for i, j := range indexes {
+			projected[r][i] = row[j]
+		}
+	}
+	return columns, projected
+}
+
+// 按 groupBy 分组计算聚合值，分组按首次出现的顺序排列
+func (t *Table) aggregate(rows [][]string, groupBy []string, aggregates []Aggregate) ([]Column, [][]string) {
+	columns := []Column{}
+	for _, name := range groupBy {
+		columns = append(columns, t.Columns[t.column(name)])
+	}
+	for _, agg := range aggregates {
+		column := agg.Column
+		if column == "" {
+			column = "*"
+		}
+		columns = append(columns, Column{Name: agg.Func + "(" + column + ")", Type: TypeNumber})
+	}
+
+	keys := []string{}
+	groups := map[string][][]string{}
+	for _, row := range rows {
+		values := []string{}
+		for _, name := range groupBy {
+			values = append(values, row[t.column(name)])
+		}
+		key := strings.Join(values, "\x00")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+	// 没有分组时即使没有匹配的行也返回一行聚合值
+	if len(groupBy) == 0 && len(keys) == 0 {
+		keys = append(keys, "")
+	}
+
+	result := [][]string{}
+	for _, key := range keys {
+		group := groups[key]
+		out := []string{}
+		if len(groupBy) > 0 {
+			out = append(out, strings.Split(key, "\x00")...)
+		}
+		for _, agg := range aggregates {
+			out = append(out, t.aggregateValue(group, agg))
+		}
+		result = append(result, out)
+	}
+	return columns, result
+}
+
+func (t *Table) aggregateValue(rows [][]string, agg Aggregate) string {
+	if agg.Column == "" || agg.Column == "*" {
+		return strconv.Itoa(len(rows))
+	}
+	i := t.column(agg.Column)
+	values := []string{}
+	for _, row := range rows {
+		if v := strings.TrimSpace(row[i]); v != "" {
+			values = append(values, v)
+		}
+	}
+	switch agg.Func {
+	case "count":
+		return strconv.Itoa(len(values))
+	case "min", "max":
+		if len(values) == 0 {
+			return ""
+		}
+		if agg.Func == "min" {
+			return slices.MinFunc(values, compare)
+		}
+		return slices.MaxFunc(values, compare)
+	}
+	sum := 0.0
+	for _, v := range values {
+		f, _ := number(v)
+		sum += f
+	}
+	if agg.Func == "avg" {
+		if len(values) == 0 {
+			return ""
+		}
+		sum /= float64(len(values))
+	}
+	return strconv.FormatFloat(sum, 'f', -1, 64)
+}
+
+// 以 Markdown 表格输出
+func writeRows(b *strings.Builder, columns []Column, rows [][]string) {
+	escape := func(s string) string {
+		return strings.ReplaceAll(strings.ReplaceAll(s, "|", `\|`), "\n", " ")
+	}
+	b.WriteString("|")
+	for _, col := range columns {
+		fmt.Fprintf(b, " %s |", escape(col.Name))
+	}
+	b.WriteString("\n|")
+	for range columns {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+	for _, row := range rows {
+		b.WriteString("|")
+		for _, cell := range row {
+			fmt.Fprintf(b, " %s |", escape(cell))
+		}
+		b.WriteString("\n")
+	}
+}
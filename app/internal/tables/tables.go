@@ -0,0 +1,186 @@
+package tables
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 表格文件的配置，文件路径相对于 TABLES_FILE 所在目录
+type TableConfig struct {
+	Name        string `json:"name"`
+	File        string `json:"file"`
+	Description string `json:"description"`
+}
+
+// 以表格为数据来源的集合：集合名 -> 其中的数据表
+type Catalog map[string]*Collection
+
+// 一个集合中的数据表，检索时由模型生成结构化查询，而不是向量检索
+type Collection struct {
+	Name   string
+	Tables []*Table
+}
+
+// 从 CSV/TSV 文件加载的数据表，全部行保存在内存中
+type Table struct {
+	Name        string
+	Description string
+	Columns     []Column
+	Rows        [][]string
+}
+
+type Column struct {
+	Name string `json:"name"`
+	// 全部非空值都是数字时为 number，否则为 string
+	Type string `json:"type"`
+}
+
+// 列类型
+const (
+	TypeString = "string"
+	TypeNumber = "number"
+)
+
+// 从 JSON 文件加载表格集合：{"集合名": [{"name": "表名", "file": "orders.csv", "description": "说明"}]}，
+// path 为空时返回 nil
+func Load(path string) (Catalog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configs := map[string][]TableConfig{}
+	err = json.Unmarshal(buf, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	catalog := Catalog{}
+	for name, tables := range configs {
+		if len(tables) == 0 {
+			return nil, fmt.Errorf("%s: collection %q has no tables", path, name)
+		}
+		collection := &Collection{Name: name}
+		for _, tc := range tables {
+			if tc.Name == "" {
+				return nil, fmt.Errorf("%s: collection %q: table name is empty", path, name)
+			}
+			if collection.Table(tc.Name) != nil {
+				return nil, fmt.Errorf("%s: collection %q: duplicate table %q", path, name, tc.Name)
+			}
+			file := tc.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(path), file)
+			}
+			table, err := loadTable(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: collection %q: %w", path, name, err)
+			}
+			table.Name, table.Description = tc.Name, tc.Description
+			collection.Tables = append(collection.Tables, table)
+			fmt.Printf("table %s/%s: %d columns, %d rows\n", name, tc.Name, len(table.Columns), len(table.Rows))
+		}
+		catalog[name] = collection
+	}
+	return catalog, nil
+}
+
+// 集合不是表格集合时返回 nil
+func (c Catalog) Collection(name string) *Collection {
+	if name == "" {
+		return nil
+	}
+	return c[name]
+}
+
+func (c *Collection) Table(name string) *Table {
+	i := slices.IndexFunc(c.Tables, func(t *Table) bool { return t.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return c.Tables[i]
+}
+
+// 读取 CSV 文件，.tsv 文件以制表符分隔。第一行为列名
+func loadTable(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		r.Comma = '\t'
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: missing header", path)
+	}
+
+	t := &Table{Rows: records[1:]}
+	for i, name := range records[0] {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if name == "" {
+			return nil, fmt.Errorf("%s: column %d has no name", path, i+1)
+		}
+		if t.column(name) >= 0 {
+			return nil, fmt.Errorf("%s: duplicate column %q", path, name)
+		}
+		t.Columns = append(t.Columns, Column{Name: name, Type: TypeNumber})
+	}
+	for i := range t.Columns {
+		for _, row := range t.Rows {
+			if _, ok := number(row[i]); !ok && strings.TrimSpace(row[i]) != "" {
+				t.Columns[i].Type = TypeString
+				break
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *Table) column(name string) int {
+	return slices.IndexFunc(t.Columns, func(c Column) bool { return c.Name == name })
+}
+
+func number(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f, err == nil
+}
+
+// 放入提示词的表结构说明，每张表附带前几行示例
+func (c *Collection) Describe(sampleRows int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "可查询以下%d张数据表，需要数据时调用 %s 工具查询，根据查询结果回答：\n\n", len(c.Tables), ToolName)
+	for _, t := range c.Tables {
+		fmt.Fprintf(&b, "表 %s", t.Name)
+		if t.Description != "" {
+			fmt.Fprintf(&b, "（%s）", t.Description)
+		}
+		fmt.Fprintf(&b, "，共%d行，列：", len(t.Rows))
+		for i, col := range t.Columns {
+			if i > 0 {
+				b.WriteString("、")
+			}
+			fmt.Fprintf(&b, "%s（%s）", col.Name, col.Type)
+		}
+		b.WriteString("\n")
+		if n := min(sampleRows, len(t.Rows)); n > 0 {
+			b.WriteString("示例：\n")
+			writeRows(&b, t.Columns, t.Rows[:n])
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
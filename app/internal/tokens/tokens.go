@@ -0,0 +1,72 @@
+package tokens
+
+import (
+	"strings"
+	"unicode"
+
+	"rag_app/internal/markdown"
+)
+
+// 粗略估算文本的 token 数：中日韩字符按每字 1 个 token 计，其余字符按每 4 个字符 1 个 token 计
+func Estimate(s string) int {
+	cjk, other := 0, 0
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// 截断文本使其估算的 token 数不超过 limit，尽量在换行处截断。
+// 截断位置落在代码块或公式块内时改在块之前截断，块之前的内容不足一半时在块内截断并补上结束围栏
+func Truncate(s string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	if Estimate(s) <= limit {
+		return s
+	}
+
+	runes := []rune(s)
+	cut := truncateAt(runes, limit)
+	f, ok := markdown.At(markdown.Fences(s), cut)
+	if !ok {
+		return string(runes[:cut])
+	}
+	if f.Start > cut/2 {
+		return string(runes[:f.Start])
+	}
+	closer := "\n" + f.Closer
+	cut = truncateAt(runes, limit-Estimate(closer))
+	// 只剩开始围栏时整块去掉
+	if cut <= f.Start || !strings.ContainsRune(string(runes[f.Start:cut]), '\n') {
+		return string(runes[:min(cut, f.Start)])
+	}
+	return string(runes[:cut]) + closer
+}
+
+// 估算的 token 数不超过 limit 的最长前缀的长度，尽量落在换行处
+func truncateAt(runes []rune, limit int) int {
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if Estimate(string(runes[:mid])) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	cut := lo
+	for i := lo - 1; i > lo/2; i-- {
+		if runes[i] == '\n' {
+			cut = i
+			break
+		}
+	}
+	return cut
+}
@@ -0,0 +1,31 @@
+package tokens
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"within the limit", "short", 5, "short"},
+		{"zero limit", "short", 0, ""},
+		{"cut before the fence", "some text\n```\ncode line\n```", 3, "some text"},
+		{"cut inside the fence is closed", "```python\nprint(1)\nprint(2)\nprint(3)\nprint(4)\n```\nafter", 6, "```python\nprint(1)\n```"},
+		{"cut inside an unterminated fence is closed", "```python\nprint(1)\nprint(2)\nprint(3)\nprint(4)", 6, "```python\nprint(1)\n```"},
+		{"only the opening fence fits", "```python\nprint(1)", 2, ""},
+		{"cut inside a math block", "$$\nx = 1\ny = 2\nz = 3\n$$", 4, "$$\nx = 1\n$$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Truncate(tt.s, tt.limit)
+			if got != tt.want {
+				t.Errorf("Truncate() = %q, want %q", got, tt.want)
+			}
+			if Estimate(got) > tt.limit {
+				t.Errorf("Truncate() = %q estimates %d tokens, over the limit %d", got, Estimate(got), tt.limit)
+			}
+		})
+	}
+}